@@ -19,17 +19,8 @@ var (
 // InitDB inicializa a conexão com o banco de dados
 func InitDB() {
 	once.Do(func() {
-		host := os.Getenv("DB_HOST")
-		port := os.Getenv("DB_PORT")
-		user := os.Getenv("DB_USER")
-		password := os.Getenv("DB_PASSWORD")
-		dbname := os.Getenv("DB_NAME")
-
-		psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-			host, port, user, password, dbname)
-
 		var err error
-		db, err = sql.Open("postgres", psqlInfo)
+		db, err = sql.Open("postgres", ConnString())
 		if err != nil {
 			log.Printf("Erro ao conectar ao banco de dados: %v", err)
 			dbConn = err
@@ -51,6 +42,19 @@ func InitDB() {
 	}
 }
 
+// ConnString monta a string de conexão a partir das variáveis de ambiente; também é usada pelas conexões
+// dedicadas ao LISTEN
+func ConnString() string {
+	host := os.Getenv("DB_HOST")
+	port := os.Getenv("DB_PORT")
+	user := os.Getenv("DB_USER")
+	password := os.Getenv("DB_PASSWORD")
+	dbname := os.Getenv("DB_NAME")
+
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		host, port, user, password, dbname)
+}
+
 // GetDB retorna a conexão com o banco de dados
 func GetDB() *sql.DB {
 	return db
@@ -1,16 +1,34 @@
 -- Extensão para UUID
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 
+-- Configurações da casa (linha única)
+CREATE TABLE household_settings (
+    id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+    timezone TEXT NOT NULL DEFAULT 'America/Sao_Paulo', -- fuso IANA em que as datas e recorrências são avaliadas
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO household_settings DEFAULT VALUES;
+
+-- Data atual no fuso da casa; substitui CURRENT_DATE, que usa o fuso da sessão do banco
+CREATE OR REPLACE FUNCTION household_today()
+RETURNS DATE AS $$
+    SELECT (CURRENT_TIMESTAMP AT TIME ZONE timezone)::date FROM household_settings;
+$$ LANGUAGE sql STABLE;
+
 -- Tabela de usuários
 CREATE TABLE users (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-    name TEXT NOT NULL
+    name TEXT NOT NULL,
+    pix_key TEXT, -- chave Pix usada para receber acertos entre moradores
+    pix_city TEXT,
+    deleted_at TIMESTAMP WITH TIME ZONE -- arquivamento (soft delete); NULL = ativo
 );
 
 -- Tabela de grupos de pagadores
 CREATE TABLE payer_groups (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-    name TEXT NOT NULL
+    name TEXT NOT NULL,
+    deleted_at TIMESTAMP WITH TIME ZONE
 );
 
 -- Tabela de membros dos grupos de pagadores
@@ -43,7 +61,8 @@ EXECUTE FUNCTION check_percentage_sum();
 CREATE TABLE finance_cc (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     name TEXT NOT NULL,
-    parent_id UUID REFERENCES finance_cc(id)
+    parent_id UUID REFERENCES finance_cc(id),
+    deleted_at TIMESTAMP WITH TIME ZONE
 );
 
 -- Tabela de moedas
@@ -51,7 +70,8 @@ CREATE TABLE finance_currency (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     name TEXT NOT NULL,
     symbol TEXT NOT NULL,
-    value DECIMAL(10,4) NOT NULL DEFAULT 1.0
+    value DECIMAL(10,4) NOT NULL DEFAULT 1.0,
+    deleted_at TIMESTAMP WITH TIME ZONE
 );
 
 -- Tabela de tarefas recorrentes
@@ -63,7 +83,14 @@ CREATE TABLE task_installments (
     recurrence_cron TEXT NOT NULL,
     subtasks JSONB,
     user_id UUID REFERENCES users(id),
-    payer_group_id UUID REFERENCES payer_groups(id)
+    payer_group_id UUID REFERENCES payer_groups(id),
+    rotation TEXT NOT NULL DEFAULT 'none' CHECK (rotation IN ('none', 'round_robin', 'weighted', 'least_recently_done', 'fairness')),
+    effort_points INTEGER NOT NULL DEFAULT 1 CHECK (effort_points >= 0),
+    estimated_minutes INTEGER NOT NULL DEFAULT 0 CHECK (estimated_minutes >= 0),
+    end_date DATE, -- última data em que a recorrência gera ocorrências
+    max_occurrences INTEGER CHECK (max_occurrences > 0), -- total de datas da recorrência, contadas desde start_date
+    duration_minutes INTEGER CHECK (duration_minutes > 0), -- duração de cada ocorrência na agenda
+    deleted_at TIMESTAMP WITH TIME ZONE
 );
 
 -- Tabela de ocorrências de tarefas
@@ -75,9 +102,75 @@ CREATE TABLE task_occurrences (
     user_id UUID REFERENCES users(id),
     payer_group_id UUID REFERENCES payer_groups(id),
     subtasks JSONB,
+    completed_by UUID REFERENCES users(id),
+    completed_at TIMESTAMP WITH TIME ZONE,
+    effort_points INTEGER NOT NULL DEFAULT 0,
+    state TEXT NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'in_progress', 'done', 'skipped', 'overdue', 'cancelled')),
+    state_changed_at TIMESTAMP WITH TIME ZONE,
+    state_changed_by UUID REFERENCES users(id),
+    original_date DATE, -- data prevista pela recorrência, quando a ocorrência foi remarcada
+    scheduled_at TIMESTAMP WITH TIME ZONE, -- início previsto (horário da expressão CRON)
+    duration_minutes INTEGER CHECK (duration_minutes > 0),
+    due_at TIMESTAMP WITH TIME ZONE, -- prazo; padrão: início + duração
+    CHECK (due_at IS NULL OR scheduled_at IS NULL OR due_at >= scheduled_at),
     UNIQUE(task_id, date)
 );
 
+-- Histórico de estados das ocorrências de tarefas
+CREATE TABLE task_occurrence_states (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    task_occurrence_id UUID NOT NULL REFERENCES task_occurrences(id) ON DELETE CASCADE,
+    from_state TEXT,
+    to_state TEXT NOT NULL,
+    changed_by UUID REFERENCES users(id),
+    changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Função que carimba a mudança de estado; se o estado não mudou, preserva quem mudou por último
+CREATE OR REPLACE FUNCTION stamp_task_occurrence_state()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        NEW.state_changed_at := COALESCE(NEW.state_changed_at, CURRENT_TIMESTAMP);
+    ELSIF NEW.state = OLD.state THEN
+        NEW.state_changed_at := OLD.state_changed_at;
+        NEW.state_changed_by := OLD.state_changed_by;
+    ELSE
+        NEW.state_changed_at := CURRENT_TIMESTAMP;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stamp_task_occurrence_state_trigger
+BEFORE INSERT OR UPDATE ON task_occurrences
+FOR EACH ROW
+EXECUTE FUNCTION stamp_task_occurrence_state();
+
+-- Função que registra cada mudança de estado no histórico. A restauração de backup
+-- (SET LOCAL casa360.outbox = 'off') grava o histórico do próprio arquivo.
+CREATE OR REPLACE FUNCTION log_task_occurrence_state()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF COALESCE(current_setting('casa360.outbox', true), '') = 'off' THEN
+        RETURN NEW;
+    END IF;
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO task_occurrence_states (task_occurrence_id, from_state, to_state, changed_by, changed_at)
+        VALUES (NEW.id, NULL, NEW.state, NEW.state_changed_by, NEW.state_changed_at);
+    ELSIF NEW.state <> OLD.state THEN
+        INSERT INTO task_occurrence_states (task_occurrence_id, from_state, to_state, changed_by, changed_at)
+        VALUES (NEW.id, OLD.state, NEW.state, NEW.state_changed_by, NEW.state_changed_at);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER log_task_occurrence_state_trigger
+AFTER INSERT OR UPDATE ON task_occurrences
+FOR EACH ROW
+EXECUTE FUNCTION log_task_occurrence_state();
+
 -- Tabela de finanças recorrentes
 CREATE TABLE finance_installments (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -91,7 +184,10 @@ CREATE TABLE finance_installments (
     user_id UUID REFERENCES users(id),
     payer_group_id UUID REFERENCES payer_groups(id),
     finance_cc_id UUID REFERENCES finance_cc(id),
-    currency_id UUID REFERENCES finance_currency(id)
+    currency_id UUID REFERENCES finance_currency(id),
+    pix_code TEXT, -- BR Code Pix "copia e cola" para pagamento
+    max_occurrences INTEGER CHECK (max_occurrences > 0),
+    deleted_at TIMESTAMP WITH TIME ZONE
 );
 
 -- Tabela de ocorrências financeiras
@@ -101,6 +197,9 @@ CREATE TABLE finance_occurrences (
     date DATE NOT NULL,
     amount DECIMAL(10,2) NOT NULL,
     status BOOLEAN DEFAULT false,
+    pix_code TEXT,
+    boleto_code TEXT, -- linha digitável do boleto
+    original_date DATE, -- data prevista pela recorrência, quando a ocorrência foi remarcada
     UNIQUE(finance_id, date)
 );
 
@@ -179,9 +278,11 @@ BEGIN
                 v_new_amount := v_new_amount - (v_transaction_amount * v_member.percentage / 100);
             END IF;
 
-            -- Insere novo registro na carteira
-            INSERT INTO finance_wallets (user_id, amount)
-            VALUES (v_member.user_id, v_new_amount);
+            -- Insere novo registro na carteira. O horário é o do relógio, e não o do início da transação:
+            -- pagar várias ocorrências na mesma transação (ex.: uma nota dividida entre centros de custo)
+            -- gera vários registros do mesmo morador, e cada um precisa vir depois do anterior
+            INSERT INTO finance_wallets (user_id, amount, created_at)
+            VALUES (v_member.user_id, v_new_amount, clock_timestamp());
         END LOOP;
     END IF;
 
@@ -202,16 +303,22 @@ SELECT
     fo.id,
     fo.date,
     fo.status,
+    CASE WHEN fo.status THEN 'done' WHEN fo.date < household_today() THEN 'overdue' ELSE 'pending' END as state,
     fi.title,
-    fi.description,
+    COALESCE(fi.description, '') as description,
     fi.type as finance_type,
     fo.amount,
     fc.symbol as currency_symbol,
     fc.value as currency_value,
     (fo.amount * fc.value) as amount_converted,
     fcc.name as cost_center,
-    pg.name as payer_group,
-    u.name as responsible_user
+    COALESCE(pg.name, '') as payer_group,
+    COALESCE(u.name, '') as responsible_user,
+    fi.payer_group_id,
+    fi.user_id,
+    null::timestamptz as scheduled_at,
+    null::integer as duration_minutes,
+    null::timestamptz as due_at
 FROM 
     finance_occurrences fo
     INNER JOIN finance_installments fi ON fo.finance_id = fi.id
@@ -225,21 +332,603 @@ SELECT
     to2.id,
     to2.date,
     to2.status,
+    to2.state,
     ti.title,
-    ti.description,
+    COALESCE(ti.description, '') as description,
     null as finance_type,
     null as amount,
     null as currency_symbol,
     null as currency_value,
     null as amount_converted,
     null as cost_center,
-    pg.name as payer_group,
-    u.name as responsible_user
+    COALESCE(pg.name, '') as payer_group,
+    COALESCE(u.name, '') as responsible_user,
+    to2.payer_group_id,
+    to2.user_id,
+    to2.scheduled_at,
+    to2.duration_minutes,
+    to2.due_at
 FROM 
     task_occurrences to2
     INNER JOIN task_installments ti ON to2.task_id = ti.id
-    LEFT JOIN payer_groups pg ON ti.payer_group_id = pg.id
-    LEFT JOIN users u ON ti.user_id = u.id;
+    LEFT JOIN payer_groups pg ON to2.payer_group_id = pg.id
+    LEFT JOIN users u ON to2.user_id = u.id;
+
+-- Tabela de importações de extratos bancários
+CREATE TABLE bank_imports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    format TEXT NOT NULL CHECK (format IN ('ofx', 'csv', 'cnab240', 'cnab400')),
+    filename TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tabela de lançamentos importados (staging para conciliação)
+CREATE TABLE bank_lines (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    bank_import_id UUID NOT NULL REFERENCES bank_imports(id) ON DELETE CASCADE,
+    date DATE NOT NULL,
+    amount DECIMAL(10,2) NOT NULL, -- negativo = débito, positivo = crédito
+    description TEXT NOT NULL DEFAULT '',
+    document TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'unmatched' CHECK (status IN ('unmatched', 'matched', 'confirmed')),
+    finance_occurrence_id UUID REFERENCES finance_occurrences(id)
+);
+
+-- Pedidos de troca de ocorrências de tarefas entre moradores
+CREATE TABLE task_swap_requests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    task_occurrence_id UUID NOT NULL REFERENCES task_occurrences(id) ON DELETE CASCADE,
+    requester_id UUID NOT NULL REFERENCES users(id),
+    target_user_id UUID NOT NULL REFERENCES users(id),
+    target_occurrence_id UUID REFERENCES task_occurrences(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'rejected', 'cancelled')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    CHECK (requester_id <> target_user_id)
+);
+
+-- Exceções da recorrência: datas previstas que foram puladas ou remarcadas e não devem ser geradas de novo
+CREATE TABLE occurrence_exceptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    task_id UUID REFERENCES task_installments(id) ON DELETE CASCADE,
+    finance_id UUID REFERENCES finance_installments(id) ON DELETE CASCADE,
+    original_date DATE NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('skipped', 'snoozed', 'rescheduled')),
+    new_date DATE,
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (num_nonnulls(task_id, finance_id) = 1),
+    CHECK ((action = 'skipped') = (new_date IS NULL))
+);
+
+-- Pausas da recorrência de uma tarefa ou finança (ex.: férias): nenhuma ocorrência é gerada no período
+CREATE TABLE template_pauses (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    task_id UUID REFERENCES task_installments(id) ON DELETE CASCADE,
+    finance_id UUID REFERENCES finance_installments(id) ON DELETE CASCADE,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (num_nonnulls(task_id, finance_id) = 1),
+    CHECK (end_date >= start_date)
+);
+
+-- Ausências de moradores: no período, o morador não recebe tarefas
+CREATE TABLE away_periods (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id),
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (end_date >= start_date)
+);
+
+-- Marca d'água da geração de ocorrências de cada tarefa ou finança: generated_until é a última data da
+-- recorrência já percorrida e generated_count, quantas datas foram contadas até ela (para max_occurrences).
+-- A geração continua depois dela; sem marca, percorre a recorrência desde start_date.
+CREATE TABLE occurrence_watermarks (
+    task_id UUID UNIQUE REFERENCES task_installments(id) ON DELETE CASCADE,
+    finance_id UUID UNIQUE REFERENCES finance_installments(id) ON DELETE CASCADE,
+    generated_until DATE NOT NULL,
+    generated_count INTEGER NOT NULL CHECK (generated_count > 0),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (num_nonnulls(task_id, finance_id) = 1)
+);
+
+-- Links de assinatura do calendário (ICS) de um morador ou grupo de pagadores. Quem tem o token lê o
+-- calendário sem autenticação; para revogar, basta remover o link.
+CREATE TABLE calendar_feeds (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    token TEXT NOT NULL UNIQUE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    payer_group_id UUID REFERENCES payer_groups(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (num_nonnulls(user_id, payer_group_id) = 1)
+);
+
+-- Preferências de notificação do morador: horário de silêncio, no fuso da casa
+CREATE TABLE notification_settings (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    quiet_hours_start TIME,
+    quiet_hours_end TIME,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((quiet_hours_start IS NULL) = (quiet_hours_end IS NULL))
+);
+
+-- Canais pelos quais o morador recebe notificações: e-mail, URL de webhook ou chat_id do Telegram
+CREATE TABLE notification_channels (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel TEXT NOT NULL CHECK (channel IN ('email', 'webhook', 'telegram')),
+    address TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, channel, address)
+);
+
+-- Regras de lembrete: quanto antes do início (ou depois do vencimento) avisar, para todos ou um morador
+CREATE TABLE notification_rules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    event TEXT NOT NULL CHECK (event IN ('task_due', 'task_overdue', 'finance_due', 'finance_overdue')),
+    offset_minutes INTEGER NOT NULL DEFAULT 0 CHECK (offset_minutes >= 0),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Notificações enviadas (ou que falharam), que evitam repetir um lembrete no mesmo canal
+CREATE TABLE notifications (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    rule_id UUID REFERENCES notification_rules(id) ON DELETE CASCADE,
+    channel_id UUID REFERENCES notification_channels(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    task_occurrence_id UUID REFERENCES task_occurrences(id) ON DELETE CASCADE,
+    finance_occurrence_id UUID REFERENCES finance_occurrences(id) ON DELETE CASCADE,
+    channel TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('sent', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 1,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Assinaturas de webhooks: os eventos escolhidos (vazio = todos) vão por POST para a URL, assinados
+-- com HMAC-SHA256 do segredo
+CREATE TABLE webhook_subscriptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT[] NOT NULL DEFAULT '{}',
+    description TEXT,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Caixa de saída transacional: os eventos do domínio são gravados pelos triggers na mesma transação
+-- da mudança, e só depois distribuídos às assinaturas
+CREATE TABLE outbox_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    seq BIGSERIAL NOT NULL UNIQUE,
+    event TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP WITH TIME ZONE -- quando as entregas foram criadas
+);
+
+-- Entregas de cada evento a cada assinatura, com o resultado da última tentativa
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    subscription_id UUID NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL REFERENCES outbox_events(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    response_status INTEGER,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE(subscription_id, event_id)
+);
+
+-- Grava um evento na caixa de saída. A restauração de backup desliga os eventos na própria transação
+-- (SET LOCAL casa360.outbox = 'off'), para não reenviar o histórico.
+CREATE OR REPLACE FUNCTION enqueue_event(p_event TEXT, p_payload JSONB)
+RETURNS VOID AS $$
+BEGIN
+    IF COALESCE(current_setting('casa360.outbox', true), '') <> 'off' THEN
+        INSERT INTO outbox_events (event, payload) VALUES (p_event, p_payload);
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- finance_occurrence.paid: a ocorrência financeira foi marcada como paga (antes do trigger das carteiras)
+CREATE OR REPLACE FUNCTION outbox_finance_occurrence()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.status = true AND (TG_OP = 'INSERT' OR OLD.status = false) THEN
+        PERFORM enqueue_event('finance_occurrence.paid', (
+            SELECT jsonb_build_object(
+                'id', NEW.id, 'finance_id', NEW.finance_id, 'title', fi.title, 'date', NEW.date, 'amount', NEW.amount,
+                'expense', fi.type, 'user_id', fi.user_id, 'payer_group_id', fi.payer_group_id)
+            FROM finance_installments fi
+            WHERE fi.id = NEW.finance_id));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER outbox_finance_occurrence_trigger
+AFTER INSERT OR UPDATE ON finance_occurrences
+FOR EACH ROW
+EXECUTE FUNCTION outbox_finance_occurrence();
+
+-- task_occurrence.completed: a ocorrência de tarefa passou para done
+CREATE OR REPLACE FUNCTION outbox_task_occurrence()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.state = 'done' AND (TG_OP = 'INSERT' OR OLD.state <> 'done') THEN
+        PERFORM enqueue_event('task_occurrence.completed', (
+            SELECT jsonb_build_object(
+                'id', NEW.id, 'task_id', NEW.task_id, 'title', ti.title, 'date', NEW.date, 'user_id', NEW.user_id,
+                'completed_by', NEW.completed_by, 'completed_at', NEW.completed_at, 'effort_points', NEW.effort_points)
+            FROM task_installments ti
+            WHERE ti.id = NEW.task_id));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER outbox_task_occurrence_trigger
+AFTER INSERT OR UPDATE ON task_occurrences
+FOR EACH ROW
+EXECUTE FUNCTION outbox_task_occurrence();
+
+-- wallet.changed: novo saldo na carteira do morador
+CREATE OR REPLACE FUNCTION outbox_finance_wallet()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM enqueue_event('wallet.changed', jsonb_build_object(
+        'id', NEW.id, 'user_id', NEW.user_id, 'amount', NEW.amount, 'created_at', NEW.created_at,
+        'previous_amount', COALESCE((
+            SELECT amount FROM finance_wallets
+            WHERE user_id = NEW.user_id AND created_at < NEW.created_at
+            ORDER BY created_at DESC
+            LIMIT 1), 0)));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER outbox_finance_wallet_trigger
+AFTER INSERT ON finance_wallets
+FOR EACH ROW
+EXECUTE FUNCTION outbox_finance_wallet();
+
+-- member.added e member.removed: entrada e saída de moradores dos grupos de pagadores
+CREATE OR REPLACE FUNCTION outbox_payer_group_member()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_member payer_group_members%ROWTYPE;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        v_member := NEW;
+    ELSE
+        v_member := OLD;
+    END IF;
+    PERFORM enqueue_event(CASE WHEN TG_OP = 'INSERT' THEN 'member.added' ELSE 'member.removed' END, jsonb_build_object(
+        'id', v_member.id, 'payer_group_id', v_member.payer_group_id, 'user_id', v_member.user_id,
+        'percentage', v_member.percentage));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER outbox_payer_group_member_trigger
+AFTER INSERT OR DELETE ON payer_group_members
+FOR EACH ROW
+EXECUTE FUNCTION outbox_payer_group_member();
+
+-- Jobs em segundo plano (regeneração de ocorrências), com o progresso gravado a cada modelo processado.
+-- heartbeat_at permite reconhecer jobs interrompidos pela queda do servidor.
+CREATE TABLE jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kind TEXT NOT NULL CHECK (kind IN ('task_occurrences', 'finance_occurrences')),
+    status TEXT NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'succeeded', 'failed', 'cancelled')),
+    total INTEGER NOT NULL DEFAULT 0, -- modelos a processar
+    processed INTEGER NOT NULL DEFAULT 0,
+    created INTEGER NOT NULL DEFAULT 0, -- ocorrências criadas
+    errors INTEGER NOT NULL DEFAULT 0,
+    cancel_requested BOOLEAN NOT NULL DEFAULT FALSE,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    heartbeat_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Mensagens de progresso dos jobs, na ordem em que foram geradas
+CREATE TABLE job_logs (
+    id BIGSERIAL PRIMARY KEY,
+    job_id UUID NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    level TEXT NOT NULL CHECK (level IN ('log', 'error', 'success')),
+    message TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Alterações recentes em tarefas, ocorrências e carteiras, publicadas em GET /events. O seq é o
+-- Last-Event-ID do stream; cada alteração avisa os servidores conectados por NOTIFY casa360_changes.
+CREATE TABLE change_events (
+    seq BIGSERIAL PRIMARY KEY,
+    entity TEXT NOT NULL, -- task, task_occurrence, finance_occurrence ou wallet
+    action TEXT NOT NULL CHECK (action IN ('insert', 'update', 'delete')),
+    entity_id UUID NOT NULL,
+    data JSONB NOT NULL, -- registro após a alteração (na remoção, o registro removido)
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Registra a alteração da linha; a entidade vem do argumento do trigger. Assim como a caixa de saída,
+-- fica desligada durante a restauração de backup.
+CREATE OR REPLACE FUNCTION record_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_row JSONB;
+    v_seq BIGINT;
+BEGIN
+    IF COALESCE(current_setting('casa360.outbox', true), '') = 'off' THEN
+        RETURN NULL;
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        v_row := to_jsonb(OLD);
+    ELSE
+        v_row := to_jsonb(NEW);
+    END IF;
+    INSERT INTO change_events (entity, action, entity_id, data)
+    VALUES (TG_ARGV[0], lower(TG_OP), (v_row->>'id')::uuid, v_row)
+    RETURNING seq INTO v_seq;
+    -- O aviso só é entregue na confirmação da transação
+    PERFORM pg_notify('casa360_changes', v_seq::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER record_task_change_trigger
+AFTER INSERT OR UPDATE OR DELETE ON task_installments
+FOR EACH ROW
+EXECUTE FUNCTION record_change('task');
+
+CREATE TRIGGER record_task_occurrence_change_trigger
+AFTER INSERT OR UPDATE OR DELETE ON task_occurrences
+FOR EACH ROW
+EXECUTE FUNCTION record_change('task_occurrence');
+
+CREATE TRIGGER record_finance_occurrence_change_trigger
+AFTER INSERT OR UPDATE OR DELETE ON finance_occurrences
+FOR EACH ROW
+EXECUTE FUNCTION record_change('finance_occurrence');
+
+CREATE TRIGGER record_wallet_change_trigger
+AFTER INSERT OR UPDATE OR DELETE ON finance_wallets
+FOR EACH ROW
+EXECUTE FUNCTION record_change('wallet');
+
+-- Descarta a marca d'água da geração quando datas já percorridas podem voltar a gerar ocorrências: a
+-- recorrência mudou, uma ocorrência foi removida ou uma exceção ou pausa deixou de valer. A próxima
+-- geração percorre a recorrência desde o início e cria só as datas que faltam.
+CREATE OR REPLACE FUNCTION invalidate_watermark()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_row JSONB := to_jsonb(OLD);
+BEGIN
+    CASE TG_TABLE_NAME
+    WHEN 'task_installments' THEN
+        DELETE FROM occurrence_watermarks WHERE task_id = OLD.id;
+    WHEN 'finance_installments' THEN
+        DELETE FROM occurrence_watermarks WHERE finance_id = OLD.id;
+    WHEN 'away_periods' THEN
+        -- As datas em que o responsável estava ausente podem ser geradas de novo
+        DELETE FROM occurrence_watermarks WHERE task_id IS NOT NULL;
+    ELSE
+        DELETE FROM occurrence_watermarks
+        WHERE task_id = (v_row->>'task_id')::uuid OR finance_id = (v_row->>'finance_id')::uuid;
+    END CASE;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER invalidate_task_watermark_trigger
+AFTER UPDATE OF start_date, recurrence_cron, end_date, max_occurrences ON task_installments
+FOR EACH ROW
+WHEN (OLD.start_date IS DISTINCT FROM NEW.start_date OR OLD.recurrence_cron IS DISTINCT FROM NEW.recurrence_cron
+    OR OLD.end_date IS DISTINCT FROM NEW.end_date OR OLD.max_occurrences IS DISTINCT FROM NEW.max_occurrences)
+EXECUTE FUNCTION invalidate_watermark();
+
+CREATE TRIGGER invalidate_finance_watermark_trigger
+AFTER UPDATE OF start_date, recurrence_days, end_date, max_occurrences ON finance_installments
+FOR EACH ROW
+WHEN (OLD.start_date IS DISTINCT FROM NEW.start_date OR OLD.recurrence_days IS DISTINCT FROM NEW.recurrence_days
+    OR OLD.end_date IS DISTINCT FROM NEW.end_date OR OLD.max_occurrences IS DISTINCT FROM NEW.max_occurrences)
+EXECUTE FUNCTION invalidate_watermark();
+
+CREATE TRIGGER invalidate_task_occurrence_watermark_trigger
+AFTER DELETE ON task_occurrences
+FOR EACH ROW
+EXECUTE FUNCTION invalidate_watermark();
+
+CREATE TRIGGER invalidate_finance_occurrence_watermark_trigger
+AFTER DELETE ON finance_occurrences
+FOR EACH ROW
+EXECUTE FUNCTION invalidate_watermark();
+
+CREATE TRIGGER invalidate_exception_watermark_trigger
+AFTER DELETE ON occurrence_exceptions
+FOR EACH ROW
+EXECUTE FUNCTION invalidate_watermark();
+
+CREATE TRIGGER invalidate_pause_watermark_trigger
+AFTER DELETE ON template_pauses
+FOR EACH ROW
+EXECUTE FUNCTION invalidate_watermark();
+
+CREATE TRIGGER invalidate_away_watermark_trigger
+AFTER DELETE ON away_periods
+FOR EACH ROW
+EXECUTE FUNCTION invalidate_watermark();
+
+-- Log de auditoria: cada alteração em usuários, grupos de pagadores e seus membros, finanças, tarefas,
+-- ocorrências e anexos, com o registro antes e depois. É gravado pelo trigger, na mesma transação da alteração.
+-- O autor vem de casa360.actor (SET LOCAL), definido pela API a partir do cabeçalho X-User-ID; sem ele,
+-- a alteração foi feita pelo sistema (geração de ocorrências, varredura de atrasadas).
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    entity TEXT NOT NULL, -- user, payer_group, payer_group_member, finance, finance_occurrence, task, task_occurrence ou attachment
+    entity_id UUID NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('insert', 'update', 'delete')),
+    actor_id UUID, -- sem chave estrangeira: o log não depende dos registros que descreve
+    before JSONB, -- registro antes da alteração (nulo na inclusão)
+    after JSONB, -- registro após a alteração (nulo na remoção)
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Registra a alteração da linha no log de auditoria; a entidade vem do argumento do trigger. Atualizações
+-- que não mudam nada ficam de fora. Assim como o feed de alterações, fica desligado durante a restauração
+-- de backup.
+CREATE OR REPLACE FUNCTION audit_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_before JSONB;
+    v_after JSONB;
+BEGIN
+    IF COALESCE(current_setting('casa360.outbox', true), '') = 'off' THEN
+        RETURN NULL;
+    END IF;
+    IF TG_OP <> 'INSERT' THEN
+        v_before := to_jsonb(OLD);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        v_after := to_jsonb(NEW);
+    END IF;
+    IF v_before = v_after THEN
+        RETURN NULL;
+    END IF;
+    INSERT INTO audit_log (entity, entity_id, action, actor_id, before, after)
+    VALUES (TG_ARGV[0], (COALESCE(v_after, v_before)->>'id')::uuid, lower(TG_OP),
+        NULLIF(current_setting('casa360.actor', true), '')::uuid, v_before, v_after);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_user_trigger
+AFTER INSERT OR UPDATE OR DELETE ON users
+FOR EACH ROW
+EXECUTE FUNCTION audit_change('user');
+
+CREATE TRIGGER audit_payer_group_trigger
+AFTER INSERT OR UPDATE OR DELETE ON payer_groups
+FOR EACH ROW
+EXECUTE FUNCTION audit_change('payer_group');
+
+CREATE TRIGGER audit_payer_group_member_trigger
+AFTER INSERT OR UPDATE OR DELETE ON payer_group_members
+FOR EACH ROW
+EXECUTE FUNCTION audit_change('payer_group_member');
+
+CREATE TRIGGER audit_finance_trigger
+AFTER INSERT OR UPDATE OR DELETE ON finance_installments
+FOR EACH ROW
+EXECUTE FUNCTION audit_change('finance');
+
+CREATE TRIGGER audit_finance_occurrence_trigger
+AFTER INSERT OR UPDATE OR DELETE ON finance_occurrences
+FOR EACH ROW
+EXECUTE FUNCTION audit_change('finance_occurrence');
+
+CREATE TRIGGER audit_task_trigger
+AFTER INSERT OR UPDATE OR DELETE ON task_installments
+FOR EACH ROW
+EXECUTE FUNCTION audit_change('task');
+
+CREATE TRIGGER audit_task_occurrence_trigger
+AFTER INSERT OR UPDATE OR DELETE ON task_occurrences
+FOR EACH ROW
+EXECUTE FUNCTION audit_change('task_occurrence');
+
+-- Anexos (comprovantes, notas fiscais e fotos) de uma ocorrência financeira, de uma transação ou de uma
+-- ocorrência de tarefa. O arquivo fica no armazenamento configurado (sistema de arquivos ou S3), na chave
+-- storage_key; a tabela guarda só os metadados. Os anexos não entram no backup.
+CREATE TABLE attachments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    finance_occurrence_id UUID REFERENCES finance_occurrences(id) ON DELETE CASCADE,
+    transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+    task_occurrence_id UUID REFERENCES task_occurrences(id) ON DELETE CASCADE,
+    storage_key TEXT NOT NULL UNIQUE,
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size BIGINT NOT NULL CHECK (size > 0),
+    sha256 TEXT NOT NULL,
+    uploaded_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (num_nonnulls(finance_occurrence_id, transaction_id, task_occurrence_id) = 1)
+);
+
+-- Arquivos de anexos removidos, a apagar do armazenamento. A remoção do anexo (inclusive em cascata, com a
+-- ocorrência) enfileira o arquivo aqui; a API o apaga logo em seguida e a rotina ATTACHMENT_PURGE_CRON
+-- repete o que tiver falhado.
+CREATE TABLE attachment_purges (
+    storage_key TEXT PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE OR REPLACE FUNCTION queue_attachment_purge()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO attachment_purges (storage_key) VALUES (OLD.storage_key)
+    ON CONFLICT DO NOTHING;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER queue_attachment_purge_trigger
+AFTER DELETE ON attachments
+FOR EACH ROW
+EXECUTE FUNCTION queue_attachment_purge();
+
+CREATE TRIGGER audit_attachment_trigger
+AFTER INSERT OR UPDATE OR DELETE ON attachments
+FOR EACH ROW
+EXECUTE FUNCTION audit_change('attachment');
+
+-- Notas fiscais eletrônicas (NF-e e NFC-e) importadas, uma vez cada, pela chave de acesso. A chave só é
+-- liberada ao desfazer a importação, que remove todos os lançamentos da nota de uma vez.
+CREATE TABLE invoice_imports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    access_key TEXT NOT NULL UNIQUE,
+    model TEXT NOT NULL CHECK (model IN ('55', '65')), -- 55 = NF-e, 65 = NFC-e
+    series TEXT NOT NULL DEFAULT '',
+    number TEXT NOT NULL DEFAULT '',
+    issuer_document TEXT NOT NULL DEFAULT '', -- CNPJ ou CPF do emitente
+    issuer_name TEXT NOT NULL DEFAULT '',
+    issued_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    total DECIMAL(10,2) NOT NULL,
+    invoice JSONB NOT NULL, -- a nota lida do XML, com os itens
+    imported_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Lançamentos de uma nota importada, um por centro de custo; o de posição 0 é o principal. created_finance
+-- indica a finança avulsa criada pela importação, removida junto ao desfazê-la. As ocorrências não podem
+-- ser removidas sozinhas (sem ON DELETE): só saem ao desfazer a importação.
+CREATE TABLE invoice_import_entries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    invoice_import_id UUID NOT NULL REFERENCES invoice_imports(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    finance_id UUID NOT NULL REFERENCES finance_installments(id),
+    finance_occurrence_id UUID NOT NULL UNIQUE REFERENCES finance_occurrences(id),
+    created_finance BOOLEAN NOT NULL,
+    items INTEGER[] NOT NULL DEFAULT '{}', -- números dos itens da nota (nItem)
+    amount DECIMAL(10,2) NOT NULL,
+    UNIQUE (invoice_import_id, position)
+);
 
 -- Índices para melhor performance
 CREATE INDEX idx_task_occurrences_date ON task_occurrences(date);
@@ -249,5 +938,44 @@ CREATE INDEX idx_finance_installments_start_date ON finance_installments(start_d
 CREATE INDEX idx_finance_installments_end_date ON finance_installments(end_date);
 CREATE INDEX idx_finance_wallets_user_created ON finance_wallets(user_id, created_at);
 CREATE INDEX idx_transactions_created ON transactions(created_at);
-
+CREATE INDEX idx_bank_lines_import ON bank_lines(bank_import_id);
+CREATE INDEX idx_bank_lines_occurrence ON bank_lines(finance_occurrence_id);
+-- Cada ocorrência é conciliada com um único lançamento
+CREATE UNIQUE INDEX idx_bank_lines_confirmed_occurrence ON bank_lines(finance_occurrence_id) WHERE status = 'confirmed';
+CREATE INDEX idx_task_occurrences_state_date ON task_occurrences(state, date);
+CREATE INDEX idx_task_occurrences_scheduled_at ON task_occurrences(scheduled_at) WHERE scheduled_at IS NOT NULL;
+CREATE INDEX idx_task_occurrence_states_occurrence ON task_occurrence_states(task_occurrence_id, changed_at);
+CREATE INDEX idx_task_occurrences_completed_by ON task_occurrences(completed_by, date);
+CREATE INDEX idx_task_swap_requests_requester ON task_swap_requests(requester_id);
+CREATE INDEX idx_task_swap_requests_target ON task_swap_requests(target_user_id);
+-- Apenas um pedido pendente por ocorrência
+CREATE UNIQUE INDEX idx_task_swap_requests_pending ON task_swap_requests(task_occurrence_id) WHERE status = 'pending';
+CREATE UNIQUE INDEX idx_occurrence_exceptions_task ON occurrence_exceptions(task_id, original_date) WHERE task_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_occurrence_exceptions_finance ON occurrence_exceptions(finance_id, original_date) WHERE finance_id IS NOT NULL;
+CREATE INDEX idx_template_pauses_task ON template_pauses(task_id) WHERE task_id IS NOT NULL;
+CREATE INDEX idx_template_pauses_finance ON template_pauses(finance_id) WHERE finance_id IS NOT NULL;
+CREATE INDEX idx_away_periods_user ON away_periods(user_id, start_date);
+CREATE INDEX idx_calendar_feeds_user ON calendar_feeds(user_id) WHERE user_id IS NOT NULL;
+CREATE INDEX idx_calendar_feeds_payer_group ON calendar_feeds(payer_group_id) WHERE payer_group_id IS NOT NULL;
+CREATE INDEX idx_notification_channels_user ON notification_channels(user_id);
+CREATE INDEX idx_notifications_user_created ON notifications(user_id, created_at);
+-- Um registro por lembrete, canal e ocorrência; as novas tentativas atualizam o mesmo registro
+CREATE UNIQUE INDEX idx_notifications_task ON notifications(rule_id, channel_id, task_occurrence_id) WHERE task_occurrence_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_notifications_finance ON notifications(rule_id, channel_id, finance_occurrence_id) WHERE finance_occurrence_id IS NOT NULL;
+CREATE INDEX idx_outbox_events_pending ON outbox_events(seq) WHERE processed_at IS NULL;
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_subscription ON webhook_deliveries(subscription_id, created_at);
+CREATE INDEX idx_change_events_created ON change_events(created_at);
+-- Apenas um job ativo de cada tipo
+CREATE UNIQUE INDEX idx_jobs_active ON jobs(kind) WHERE status IN ('queued', 'running');
+CREATE INDEX idx_jobs_created ON jobs(created_at);
+CREATE INDEX idx_job_logs_job ON job_logs(job_id, id);
+CREATE INDEX idx_audit_log_entity ON audit_log(entity, entity_id, id);
+CREATE INDEX idx_audit_log_actor ON audit_log(actor_id, id);
+CREATE INDEX idx_audit_log_created ON audit_log(created_at);
+CREATE INDEX idx_attachments_finance_occurrence ON attachments(finance_occurrence_id, created_at) WHERE finance_occurrence_id IS NOT NULL;
+CREATE INDEX idx_attachments_transaction ON attachments(transaction_id, created_at) WHERE transaction_id IS NOT NULL;
+CREATE INDEX idx_attachments_task_occurrence ON attachments(task_occurrence_id, created_at) WHERE task_occurrence_id IS NOT NULL;
+CREATE INDEX idx_invoice_imports_created ON invoice_imports(created_at);
+CREATE INDEX idx_invoice_import_entries_finance ON invoice_import_entries(finance_id);
 
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+// archivable é um registro que pode ser arquivado (soft delete) e restaurado
+type archivable interface {
+	Get() error
+	Archive(by *uuid.UUID) (*models.ArchiveResult, error)
+	Restore(by *uuid.UUID) error
+}
+
+// parseArchived lê o filtro de arquivamento das listagens: vazio (apenas ativos), true (apenas arquivados) ou all
+func parseArchived(c *gin.Context) (string, bool) {
+	archived := c.Query("archived")
+	if !models.ValidArchived(archived) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "archived deve ser true ou all"})
+		return "", false
+	}
+	return archived, true
+}
+
+func userRecord(id uuid.UUID) archivable       { return &models.User{ID: id} }
+func payerGroupRecord(id uuid.UUID) archivable { return &models.PayerGroup{ID: id} }
+func financeCCRecord(id uuid.UUID) archivable  { return &models.FinanceCC{ID: id} }
+func currencyRecord(id uuid.UUID) archivable   { return &models.FinanceCurrency{ID: id} }
+func taskRecord(id uuid.UUID) archivable       { return &models.TaskInstallment{ID: id} }
+func financeRecord(id uuid.UUID) archivable    { return &models.FinanceInstallment{ID: id} }
+
+// archiveRecord arquiva o registro do ID da rota. O DELETE responde 204; o POST .../archive
+// responde o registro arquivado e o que a cascata fez com os dependentes.
+func archiveRecord(c *gin.Context, load func(uuid.UUID) archivable, notFound string, noContent bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	record := load(id)
+	result, err := record.Archive(actor(c))
+	if err != nil {
+		var conflict *models.ArchiveConflictError
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		case errors.As(err, &conflict):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "reasons": conflict.Reasons})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if noContent {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if err := record.Get(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"record": record, "cascade": result})
+}
+
+// restoreRecord desfaz o arquivamento do registro do ID da rota e responde o registro restaurado
+func restoreRecord(c *gin.Context, load func(uuid.UUID) archivable, notFound string) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	record := load(id)
+	if err := record.Restore(actor(c)); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		case errors.Is(err, models.ErrNotArchived):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if err := record.Get(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, record)
+}
+
+// ArchiveUser arquiva um usuário que não participa de grupos nem responde por registros ativos
+func ArchiveUser(c *gin.Context) {
+	archiveRecord(c, userRecord, "Usuário não encontrado", false)
+}
+
+// RestoreUser restaura um usuário arquivado
+func RestoreUser(c *gin.Context) {
+	restoreRecord(c, userRecord, "Usuário não encontrado")
+}
+
+// ArchivePayerGroup arquiva um grupo de pagadores sem tarefas nem finanças ativas
+func ArchivePayerGroup(c *gin.Context) {
+	archiveRecord(c, payerGroupRecord, "Grupo não encontrado", false)
+}
+
+// RestorePayerGroup restaura um grupo de pagadores arquivado
+func RestorePayerGroup(c *gin.Context) {
+	restoreRecord(c, payerGroupRecord, "Grupo não encontrado")
+}
+
+// ArchiveFinanceCC arquiva um centro de custo sem finanças nem centros filhos ativos
+func ArchiveFinanceCC(c *gin.Context) {
+	archiveRecord(c, financeCCRecord, "Centro de custo não encontrado", false)
+}
+
+// RestoreFinanceCC restaura um centro de custo arquivado
+func RestoreFinanceCC(c *gin.Context) {
+	restoreRecord(c, financeCCRecord, "Centro de custo não encontrado")
+}
+
+// ArchiveFinanceCurrency arquiva uma moeda sem finanças ativas
+func ArchiveFinanceCurrency(c *gin.Context) {
+	archiveRecord(c, currencyRecord, "Moeda não encontrada", false)
+}
+
+// RestoreFinanceCurrency restaura uma moeda arquivada
+func RestoreFinanceCurrency(c *gin.Context) {
+	restoreRecord(c, currencyRecord, "Moeda não encontrada")
+}
+
+// ArchiveTask arquiva uma tarefa, removendo as ocorrências futuras em aberto
+func ArchiveTask(c *gin.Context) {
+	archiveRecord(c, taskRecord, "Tarefa não encontrada", false)
+}
+
+// RestoreTask restaura uma tarefa arquivada
+func RestoreTask(c *gin.Context) {
+	restoreRecord(c, taskRecord, "Tarefa não encontrada")
+}
+
+// ArchiveFinance arquiva uma finança, removendo as ocorrências futuras não pagas
+func ArchiveFinance(c *gin.Context) {
+	archiveRecord(c, financeRecord, "Finança não encontrada", false)
+}
+
+// RestoreFinance restaura uma finança arquivada
+func RestoreFinance(c *gin.Context) {
+	restoreRecord(c, financeRecord, "Finança não encontrada")
+}
\ No newline at end of file
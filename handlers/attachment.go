@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+	"github.com/pobruno/casa360/storage"
+)
+
+const (
+	// attachmentField é o campo do formulário multipart com o arquivo
+	attachmentField = "file"
+	// attachmentLinkTTL é a validade dos links de download gerados pelo armazenamento S3
+	attachmentLinkTTL = 15 * time.Minute
+	// multipartOverhead é a folga do corpo da requisição além do arquivo (cabeçalhos do multipart)
+	multipartOverhead = 64 << 10
+	maxFilenameLength = 255
+)
+
+// UploadFinanceOccurrenceAttachment anexa um arquivo a uma ocorrência financeira
+func UploadFinanceOccurrenceAttachment(c *gin.Context) {
+	id, ok := attachmentOwnerID(c, "id")
+	if !ok {
+		return
+	}
+	occurrence := models.FinanceOccurrence{ID: id}
+	if err := occurrence.Get(); err != nil {
+		respondOwnerError(c, err, "Ocorrência não encontrada")
+		return
+	}
+
+	uploadAttachment(c, &models.Attachment{FinanceOccurrenceID: &id})
+}
+
+// ListFinanceOccurrenceAttachments lista os anexos de uma ocorrência financeira
+func ListFinanceOccurrenceAttachments(c *gin.Context) {
+	id, ok := attachmentOwnerID(c, "id")
+	if !ok {
+		return
+	}
+	occurrence := models.FinanceOccurrence{ID: id}
+	if err := occurrence.Get(); err != nil {
+		respondOwnerError(c, err, "Ocorrência não encontrada")
+		return
+	}
+
+	attachments, err := models.ListFinanceOccurrenceAttachments(id)
+	respondAttachments(c, attachments, err)
+}
+
+// UploadTransactionAttachment anexa um arquivo (o comprovante) a uma transação de pagamento de uma ocorrência
+func UploadTransactionAttachment(c *gin.Context) {
+	transaction, ok := loadTransaction(c)
+	if !ok {
+		return
+	}
+
+	uploadAttachment(c, &models.Attachment{TransactionID: &transaction.ID})
+}
+
+// ListTransactionAttachments lista os anexos de uma transação de pagamento de uma ocorrência
+func ListTransactionAttachments(c *gin.Context) {
+	transaction, ok := loadTransaction(c)
+	if !ok {
+		return
+	}
+
+	attachments, err := models.ListTransactionAttachments(transaction.ID)
+	respondAttachments(c, attachments, err)
+}
+
+// UploadTaskOccurrenceAttachment anexa um arquivo (ex.: foto da tarefa feita) a uma ocorrência de tarefa
+func UploadTaskOccurrenceAttachment(c *gin.Context) {
+	id, ok := attachmentOwnerID(c, "id")
+	if !ok {
+		return
+	}
+	occurrence := models.TaskOccurrence{ID: id}
+	if err := occurrence.Get(); err != nil {
+		respondOwnerError(c, err, "Ocorrência não encontrada")
+		return
+	}
+
+	uploadAttachment(c, &models.Attachment{TaskOccurrenceID: &id})
+}
+
+// ListTaskOccurrenceAttachments lista os anexos de uma ocorrência de tarefa
+func ListTaskOccurrenceAttachments(c *gin.Context) {
+	id, ok := attachmentOwnerID(c, "id")
+	if !ok {
+		return
+	}
+	occurrence := models.TaskOccurrence{ID: id}
+	if err := occurrence.Get(); err != nil {
+		respondOwnerError(c, err, "Ocorrência não encontrada")
+		return
+	}
+
+	attachments, err := models.ListTaskOccurrenceAttachments(id)
+	respondAttachments(c, attachments, err)
+}
+
+// GetAttachment retorna os metadados de um anexo
+func GetAttachment(c *gin.Context) {
+	attachment, ok := loadAttachment(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, attachment)
+}
+
+// DownloadAttachment entrega o arquivo do anexo. No armazenamento S3, redireciona para um link temporário
+// do próprio bucket; no sistema de arquivos, o arquivo passa pela API.
+func DownloadAttachment(c *gin.Context) {
+	attachment, ok := loadAttachment(c)
+	if !ok {
+		return
+	}
+	store, ok := attachmentStore(c)
+	if !ok {
+		return
+	}
+
+	if linker, ok := store.(storage.Linker); ok {
+		link, err := linker.Link(attachment.StorageKey, attachment.Filename, attachment.ContentType, attachmentLinkTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Redirect(http.StatusFound, link)
+		return
+	}
+
+	file, err := store.Open(c.Request.Context(), attachment.StorageKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Arquivo do anexo não encontrado no armazenamento"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	// O arquivo é do usuário e sai da origem da API: sandbox impede que um XML com XHTML rode scripts nela
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, file, map[string]string{
+		"Content-Disposition":     storage.Disposition(attachment.Filename, attachment.ContentType),
+		"Content-Security-Policy": "sandbox",
+		"X-Content-Type-Options":  "nosniff",
+	})
+}
+
+// DeleteAttachment remove um anexo e o seu arquivo
+func DeleteAttachment(c *gin.Context) {
+	attachment, ok := loadAttachment(c)
+	if !ok {
+		return
+	}
+	store, ok := attachmentStore(c)
+	if !ok {
+		return
+	}
+
+	if err := attachment.Delete(c.Request.Context(), store, actor(c)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Anexo não encontrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// uploadAttachment lê o arquivo do campo file e o grava como anexo. O tipo é identificado pelo conteúdo;
+// arquivos acima do limite (ATTACHMENT_MAX_SIZE_MB) são recusados com 413 e tipos não aceitos, com 415.
+func uploadAttachment(c *gin.Context, attachment *models.Attachment) {
+	store, ok := attachmentStore(c)
+	if !ok {
+		return
+	}
+
+	maxSize := storage.MaxSize()
+	tooLarge := "O arquivo excede o limite de " + strconv.FormatInt(maxSize>>20, 10) + " MB"
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+	header, err := c.FormFile(attachmentField)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": tooLarge})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Envie o arquivo no campo " + attachmentField + " de um formulário multipart/form-data"})
+		return
+	}
+	if c.Request.MultipartForm != nil {
+		defer c.Request.MultipartForm.RemoveAll()
+	}
+	if header.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": tooLarge})
+		return
+	}
+	if header.Size == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "O arquivo está vazio"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	contentType, allowed := models.SniffAttachment(head[:n])
+	if !allowed {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Tipo de arquivo não aceito (" + contentType + "): envie uma imagem (JPEG, PNG, GIF, WebP ou HEIC), um PDF ou um XML"})
+		return
+	}
+
+	attachment.Filename = attachmentFilename(header.Filename)
+	attachment.ContentType = contentType
+	attachment.Size = header.Size
+	attachment.UploadedBy = actor(c)
+	body := io.MultiReader(bytes.NewReader(head[:n]), file)
+	if err := attachment.Create(c.Request.Context(), store, body); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Location", "/attachments/"+attachment.ID.String())
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// attachmentFilename limpa o nome enviado pelo cliente: sem diretórios, caracteres de controle ou nomes
+// longos demais
+func attachmentFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(name, ""))
+	name = strings.TrimSpace(name)
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "anexo"
+	}
+	return name
+}
+
+// attachmentStore retorna o armazenamento configurado, respondendo 500 se a configuração for inválida
+func attachmentStore(c *gin.Context) (storage.BlobStore, bool) {
+	store, err := storage.Default()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return store, true
+}
+
+// attachmentOwnerID lê o ID do registro dono dos anexos no parâmetro da rota
+func attachmentOwnerID(c *gin.Context, param string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param(param))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func respondOwnerError(c *gin.Context, err error, notFound string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func respondAttachments(c *gin.Context, attachments []models.Attachment, err error) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, attachments)
+}
+
+// loadTransaction busca a transação da rota, que deve ser da ocorrência financeira da rota
+func loadTransaction(c *gin.Context) (*models.Transaction, bool) {
+	occurrenceID, ok := attachmentOwnerID(c, "id")
+	if !ok {
+		return nil, false
+	}
+	id, ok := attachmentOwnerID(c, "transaction_id")
+	if !ok {
+		return nil, false
+	}
+	transaction := &models.Transaction{ID: id}
+	err := transaction.Get()
+	if err == nil && transaction.FinanceOccurrenceID != occurrenceID {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		respondOwnerError(c, err, "Transação não encontrada nesta ocorrência")
+		return nil, false
+	}
+	return transaction, true
+}
+
+// loadAttachment busca o anexo da rota, respondendo 400 ou 404 quando não encontra
+func loadAttachment(c *gin.Context) (*models.Attachment, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return nil, false
+	}
+	attachment := &models.Attachment{ID: id}
+	if err := attachment.Get(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Anexo não encontrado"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return attachment, true
+}
\ No newline at end of file
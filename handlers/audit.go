@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+// actorHeader identifica o morador que faz a requisição; as alterações dela ficam no log de auditoria
+// em nome dele
+const actorHeader = "X-User-ID"
+
+const actorKey = "actor"
+
+// Actor lê o cabeçalho X-User-ID de cada requisição. Um ID inválido ou de um usuário que não existe é
+// recusado com 400; sem o cabeçalho, as alterações ficam no log de auditoria sem autor.
+func Actor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value := c.GetHeader(actorHeader)
+		if value == "" {
+			c.Next()
+			return
+		}
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "ID inválido no cabeçalho " + actorHeader})
+			return
+		}
+		user := models.User{ID: id}
+		if err := user.Get(); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Usuário do cabeçalho " + actorHeader + " não encontrado"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set(actorKey, id)
+		c.Next()
+	}
+}
+
+// actor retorna o autor da requisição (cabeçalho X-User-ID), ou nil quando ele não foi informado
+func actor(c *gin.Context) *uuid.UUID {
+	if id, ok := c.Get(actorKey); ok {
+		by := id.(uuid.UUID)
+		return &by
+	}
+	return nil
+}
+
+// ListAudit lista o log de auditoria, do mais recente para o mais antigo (filtros: entity, entity_id,
+// actor_id, from, to, before_id e limit)
+func ListAudit(c *gin.Context) {
+	var f models.AuditFilter
+	if f.Entity = c.Query("entity"); f.Entity != "" && !models.ValidAuditEntity(f.Entity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "entity deve ser user, payer_group, payer_group_member, finance, finance_occurrence, task, task_occurrence ou attachment"})
+		return
+	}
+
+	ids := []struct {
+		param  string
+		target **uuid.UUID
+	}{
+		{"entity_id", &f.EntityID},
+		{"actor_id", &f.ActorID},
+	}
+	for _, i := range ids {
+		value := c.Query(i.param)
+		if value == "" {
+			continue
+		}
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido em " + i.param})
+			return
+		}
+		*i.target = &id
+	}
+
+	if value := c.Query("before_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before_id deve ser um número positivo"})
+			return
+		}
+		f.BeforeID = id
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > models.AuditMaxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit deve estar entre 1 e " + strconv.Itoa(models.AuditMaxLimit)})
+			return
+		}
+		f.Limit = limit
+	}
+
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	entries, err := models.ListAudit(f, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
\ No newline at end of file
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pobruno/casa360/models"
+)
+
+// ExportBackup gera o arquivo JSON versionado com todos os dados da casa
+func ExportBackup(c *gin.Context) {
+	backup, err := models.ExportBackup()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setDownloadHeaders(c, "casa360-backup-"+backup.CreatedAt.Format("20060102-150405")+".json", "application/json; charset=utf-8")
+	c.JSON(http.StatusOK, backup)
+}
+
+// RestoreBackup restaura um backup em um banco vazio, remapeando todos os IDs
+func RestoreBackup(c *gin.Context) {
+	var backup models.Backup
+	if err := c.ShouldBindJSON(&backup); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	started := time.Now()
+	result, err := models.RestoreBackup(&backup)
+	if err != nil {
+		var integrity *models.BackupIntegrityError
+		switch {
+		case errors.Is(err, models.ErrBackupVersion):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, models.ErrDatabaseNotEmpty):
+			c.JSON(http.StatusConflict, gin.H{"error": "A restauração só é permitida em um banco de dados vazio"})
+		case errors.As(err, &integrity):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Backup inconsistente", "problems": integrity.Problems})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"counts":      result.Counts,
+		"id_map":      result.IDMap,
+		"duration_ms": time.Since(started).Milliseconds(),
+	})
+}
\ No newline at end of file
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+// CreateBankImport recebe um arquivo de extrato (multipart), grava os lançamentos e executa a conciliação
+func CreateBankImport(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Arquivo não enviado"})
+		return
+	}
+
+	format := c.PostForm("format")
+
+	var mapping *models.CSVMapping
+	if format == models.BankFormatCSV {
+		mapping = &models.CSVMapping{}
+		if err := json.Unmarshal([]byte(c.PostForm("mapping")), mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Mapeamento de colunas inválido"})
+			return
+		}
+	}
+
+	windowDays, err := matchWindowDays(c.DefaultPostForm("window_days", ""))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Janela de dias inválida"})
+		return
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	parsed, err := models.ParseBankFile(format, f, mapping)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bankImport := models.BankImport{Format: format, Filename: file.Filename}
+	if err := bankImport.Create(parsed, windowDays); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, bankImport)
+}
+
+func ListBankImports(c *gin.Context) {
+	imports, err := models.ListBankImports()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, imports)
+}
+
+func GetBankImport(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	bankImport := models.BankImport{ID: id}
+	if err := bankImport.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Importação não encontrada"})
+		return
+	}
+
+	c.JSON(http.StatusOK, bankImport)
+}
+
+// MatchBankImport executa novamente a conciliação automática dos lançamentos pendentes
+func MatchBankImport(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	windowDays, err := matchWindowDays(c.Query("window_days"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Janela de dias inválida"})
+		return
+	}
+
+	matched, err := models.MatchBankImport(id, windowDays)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"matched": matched})
+}
+
+// ConfirmBankLine confirma a associação de um lançamento e marca a ocorrência como paga
+func ConfirmBankLine(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var input struct {
+		FinanceOccurrenceID *uuid.UUID `json:"finance_occurrence_id"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	line := models.BankLine{ID: id}
+	if err := line.Confirm(input.FinanceOccurrenceID, actor(c)); err != nil {
+		bankLineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, line)
+}
+
+// CreateFinanceFromBankLine cria uma finança avulsa a partir de um lançamento sem correspondência
+func CreateFinanceFromBankLine(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var finance models.FinanceInstallment
+	if err := c.ShouldBindJSON(&finance); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	line := models.BankLine{ID: id}
+	occurrence, err := line.CreateFinance(&finance, actor(c))
+	if err != nil {
+		bankLineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"bank_line":  line,
+		"finance":    finance,
+		"occurrence": occurrence,
+	})
+}
+
+func matchWindowDays(value string) (int, error) {
+	if value == "" {
+		return models.DefaultMatchWindowDays, nil
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return 0, errors.New("janela inválida")
+	}
+	return days, nil
+}
+
+func bankLineError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrBankLineConfirmed), errors.Is(err, models.ErrOccurrenceNotOpen),
+		errors.Is(err, models.ErrOccurrenceConfirmed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrBankLineNoMatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lançamento não encontrado"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
\ No newline at end of file
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+type boletoInput struct {
+	Code     string `json:"code" binding:"required"`
+	Autofill *bool  `json:"autofill"` // padrão true: preenche valor e data a partir do boleto
+}
+
+// ParseBoletoCode valida uma linha digitável ou código de barras sem gravá-lo
+func ParseBoletoCode(c *gin.Context) {
+	var input boletoInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	boleto, err := models.ParseBoleto(input.Code, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, boleto)
+}
+
+// SetFinanceOccurrenceBoleto anexa um boleto a uma ocorrência financeira, preenchendo valor e vencimento
+func SetFinanceOccurrenceBoleto(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var input boletoInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	occurrence := models.FinanceOccurrence{ID: id}
+	if err := occurrence.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+		return
+	}
+
+	boleto, err := models.ParseBoleto(input.Code, occurrence.Date)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// As divergências são calculadas contra o valor e a data anteriores ao preenchimento
+	expectedAmount, expectedDate := occurrence.Amount, occurrence.Date
+
+	// Uma ocorrência paga já tem transação e carteiras lançadas com o valor e a data atuais; só guarda o código
+	if (input.Autofill == nil || *input.Autofill) && !occurrence.Status {
+		if boleto.Amount != nil {
+			occurrence.Amount = *boleto.Amount
+		}
+		if boleto.DueDate != nil {
+			occurrence.Date = *boleto.DueDate
+		}
+	}
+
+	if err := occurrence.SetBoleto(&boleto.DigitableLine, actor(c)); err != nil {
+		switch {
+		case errors.Is(err, models.ErrOccurrenceDateTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": "Já existe uma ocorrência desta finança no vencimento do boleto"})
+		case errors.Is(err, models.ErrOccurrenceClosed):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	boletoResponse(c, &occurrence, boleto, expectedAmount, expectedDate)
+}
+
+// GetFinanceOccurrenceBoleto retorna o boleto anexado a uma ocorrência com as divergências encontradas
+func GetFinanceOccurrenceBoleto(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	occurrence := models.FinanceOccurrence{ID: id}
+	if err := occurrence.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+		return
+	}
+	if occurrence.BoletoCode == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência sem boleto"})
+		return
+	}
+
+	boleto, err := models.ParseBoleto(*occurrence.BoletoCode, occurrence.Date)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	boletoResponse(c, &occurrence, boleto, occurrence.Amount, occurrence.Date)
+}
+
+// DeleteFinanceOccurrenceBoleto remove o boleto de uma ocorrência, mantendo valor e data atuais
+func DeleteFinanceOccurrenceBoleto(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	occurrence := models.FinanceOccurrence{ID: id}
+	if err := occurrence.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+		return
+	}
+
+	if err := occurrence.SetBoleto(nil, actor(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// boletoResponse compara o boleto com o valor e a data esperados da ocorrência, que podem ter sido ajustados
+// de propósito em relação à finança; ao anexar, são os valores de antes do preenchimento automático
+func boletoResponse(c *gin.Context, occurrence *models.FinanceOccurrence, boleto *models.Boleto, expectedAmount float64, expectedDate time.Time) {
+	amountMismatch := boleto.Amount != nil && math.Abs(*boleto.Amount-expectedAmount) >= 0.005
+	dateMismatch := boleto.DueDate != nil && !boleto.DueDate.Equal(expectedDate)
+
+	c.JSON(http.StatusOK, gin.H{
+		"occurrence":        occurrence,
+		"boleto":            boleto,
+		"expected_amount":   expectedAmount,
+		"expected_due_date": expectedDate,
+		"amount_mismatch":   amountMismatch,
+		"due_date_mismatch": dateMismatch,
+	})
+}
\ No newline at end of file
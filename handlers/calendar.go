@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+// calendarStates traduz o estado da ocorrência para a descrição do evento
+var calendarStates = map[string]string{
+	models.TaskPending:    "pendente",
+	models.TaskInProgress: "em andamento",
+	models.TaskDone:       "concluída",
+	models.TaskSkipped:    "pulada",
+	models.TaskOverdue:    "atrasada",
+	models.TaskCancelled:  "cancelada",
+}
+
+// calendarFeedResponse é o link criado, com a URL pronta para assinar
+type calendarFeedResponse struct {
+	models.CalendarFeed
+	URL string `json:"url"`
+}
+
+// CreateUserCalendarFeed cria um link de calendário com as tarefas e finanças de um morador
+func CreateUserCalendarFeed(c *gin.Context) {
+	createCalendarFeed(c, func(id uuid.UUID) (*models.CalendarFeed, error) {
+		user := models.User{ID: id}
+		if err := user.Get(); err != nil {
+			return nil, err
+		}
+		return &models.CalendarFeed{UserID: &id}, nil
+	}, "Usuário não encontrado")
+}
+
+// CreatePayerGroupCalendarFeed cria um link de calendário com as tarefas e finanças de um grupo de pagadores
+func CreatePayerGroupCalendarFeed(c *gin.Context) {
+	createCalendarFeed(c, func(id uuid.UUID) (*models.CalendarFeed, error) {
+		group := models.PayerGroup{ID: id}
+		if err := group.Get(); err != nil {
+			return nil, err
+		}
+		return &models.CalendarFeed{PayerGroupID: &id}, nil
+	}, "Grupo de pagadores não encontrado")
+}
+
+func createCalendarFeed(c *gin.Context, load func(uuid.UUID) (*models.CalendarFeed, error), notFound string) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	feed, err := load(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		return
+	}
+	if err := feed.Create(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, calendarFeedResponse{CalendarFeed: *feed, URL: calendarURL(c, feed.Token)})
+}
+
+// ListUserCalendarFeeds lista os links de calendário de um morador
+func ListUserCalendarFeeds(c *gin.Context) {
+	listCalendarFeeds(c, models.ListUserCalendarFeeds)
+}
+
+// ListPayerGroupCalendarFeeds lista os links de calendário de um grupo de pagadores
+func ListPayerGroupCalendarFeeds(c *gin.Context) {
+	listCalendarFeeds(c, models.ListPayerGroupCalendarFeeds)
+}
+
+func listCalendarFeeds(c *gin.Context, list func(uuid.UUID) ([]models.CalendarFeed, error)) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	feeds, err := list(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := make([]calendarFeedResponse, len(feeds))
+	for i, feed := range feeds {
+		response[i] = calendarFeedResponse{CalendarFeed: feed, URL: calendarURL(c, feed.Token)}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteCalendarFeed revoga um link de calendário
+func DeleteCalendarFeed(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	feed := models.CalendarFeed{ID: id}
+	if err := feed.Delete(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link de calendário não encontrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// calendarURL monta a URL pública do calendário a partir do endereço usado na requisição
+func calendarURL(c *gin.Context, token string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s/calendar/%s.ics", scheme, c.Request.Host, token)
+}
+
+// GetCalendar publica em iCalendar as ocorrências do link: finanças como eventos de dia inteiro e tarefas como
+// eventos no horário agendado (ou de dia inteiro, sem horário). Com ?tasks=todo, as tarefas saem como VTODO.
+func GetCalendar(c *gin.Context) {
+	feed := models.CalendarFeed{Token: strings.TrimSuffix(c.Param("token"), ".ics")}
+	if err := feed.GetByToken(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Calendário não encontrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tasksAsTodo := false
+	switch c.DefaultQuery("tasks", "event") {
+	case "event":
+	case "todo":
+		tasksAsTodo = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tasks deve ser event ou todo"})
+		return
+	}
+
+	name, err := calendarName(feed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	settings, err := models.GetHouseholdSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := feed.Filter()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cal := &icsWriter{stamp: time.Now().UTC()}
+	cal.line("BEGIN:VCALENDAR")
+	cal.line("VERSION:2.0")
+	cal.line("PRODID:-//Casa 360//Calendario//PT-BR")
+	cal.line("CALSCALE:GREGORIAN")
+	cal.line("METHOD:PUBLISH")
+	cal.property("X-WR-CALNAME", "Casa 360 - "+name)
+	cal.property("X-WR-TIMEZONE", settings.Timezone)
+	cal.line("REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	cal.line("X-PUBLISHED-TTL:PT1H")
+
+	err = models.EachOccurrenceDashboard(filter, func(o models.OccurrenceDashboard) error {
+		// Datas puladas não acontecem; canceladas continuam publicadas para sumirem dos calendários já sincronizados
+		if o.State == models.TaskSkipped {
+			return nil
+		}
+		if o.OccurrenceType == "task" && tasksAsTodo {
+			cal.todo(o)
+		} else {
+			cal.event(o)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cal.line("END:VCALENDAR")
+
+	c.Header("Content-Disposition", `inline; filename="casa360.ics"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", cal.buf.Bytes())
+}
+
+// ImportCalendar recebe um arquivo .ics (multipart) e cria tarefas ou finanças a partir dos eventos recorrentes.
+// Com dry_run=true, apenas responde o que seria criado.
+func ImportCalendar(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Arquivo não enviado"})
+		return
+	}
+
+	opts := models.ICSImportOptions{Kind: c.DefaultPostForm("kind", models.ICSKindTask), DryRun: c.PostForm("dry_run") == "true", By: actor(c)}
+	if opts.Kind != models.ICSKindTask && opts.Kind != models.ICSKindFinance {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind deve ser task ou finance"})
+		return
+	}
+	if opts.UserID, err = uuid.Parse(c.PostForm("user_id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id inválido"})
+		return
+	}
+	if opts.PayerGroupID, err = uuid.Parse(c.PostForm("payer_group_id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payer_group_id inválido"})
+		return
+	}
+	user, group := models.User{ID: opts.UserID}, models.PayerGroup{ID: opts.PayerGroupID}
+	if user.Get() != nil || group.Get() != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Usuário ou grupo de pagadores não encontrado"})
+		return
+	}
+	if value := c.PostForm("finance_cc_id"); value != "" {
+		id, err := uuid.Parse(value)
+		cc := models.FinanceCC{ID: id}
+		if err != nil || cc.Get() != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Centro de custo não encontrado"})
+			return
+		}
+		opts.FinanceCCID = &id
+	}
+	if value := c.PostForm("currency_id"); value != "" {
+		id, err := uuid.Parse(value)
+		currency := models.FinanceCurrency{ID: id}
+		if err != nil || currency.Get() != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Moeda não encontrada"})
+			return
+		}
+		opts.CurrencyID = &id
+	}
+	if value := c.PostForm("amount"); value != "" {
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil || amount < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "amount inválido"})
+			return
+		}
+		opts.Amount = &amount
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	loc, err := models.Location()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := models.ParseICS(f, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := models.ImportICS(events, opts)
+	if err != nil {
+		if errors.Is(err, models.ErrICSFinanceDefaults) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	status := http.StatusCreated
+	if opts.DryRun {
+		status = http.StatusOK
+	}
+	c.JSON(status, result)
+}
+
+// calendarName é o nome do morador ou do grupo do link
+func calendarName(feed models.CalendarFeed) (string, error) {
+	if feed.UserID != nil {
+		user := models.User{ID: *feed.UserID}
+		err := user.Get()
+		return user.Name, err
+	}
+	group := models.PayerGroup{ID: *feed.PayerGroupID}
+	err := group.Get()
+	return group.Name, err
+}
+
+// icsWriter monta o iCalendar (RFC 5545): linhas terminadas em CRLF e dobradas em 75 bytes
+type icsWriter struct {
+	buf   bytes.Buffer
+	stamp time.Time
+}
+
+// line escreve uma linha de conteúdo, dobrando-a sem partir caracteres UTF-8
+func (w *icsWriter) line(s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.buf.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74 // a continuação começa com um espaço
+	}
+	w.buf.WriteString(s + "\r\n")
+}
+
+// property escreve uma propriedade de texto, escapando os caracteres especiais
+func (w *icsWriter) property(name, value string) {
+	value = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+	w.line(name + ":" + value)
+}
+
+func (w *icsWriter) common(o models.OccurrenceDashboard) {
+	w.line("UID:" + o.OccurrenceType + "-" + o.ID.String() + "@casa360")
+	w.line("DTSTAMP:" + icsTimestamp(w.stamp))
+	w.property("SUMMARY", calendarSummary(o))
+	w.property("DESCRIPTION", calendarDescription(o))
+	w.property("CATEGORIES", map[string]string{"task": "Tarefa", "finance": "Finança"}[o.OccurrenceType])
+}
+
+// event escreve a ocorrência como VEVENT
+func (w *icsWriter) event(o models.OccurrenceDashboard) {
+	w.line("BEGIN:VEVENT")
+	w.common(o)
+	if o.ScheduledAt != nil {
+		w.line("DTSTART:" + icsTimestamp(*o.ScheduledAt))
+		if o.DueAt != nil {
+			w.line("DTEND:" + icsTimestamp(*o.DueAt))
+		}
+	} else {
+		w.line("DTSTART;VALUE=DATE:" + o.Date.Format("20060102"))
+		w.line("DTEND;VALUE=DATE:" + o.Date.AddDate(0, 0, 1).Format("20060102"))
+	}
+	if o.State == models.TaskCancelled {
+		w.line("STATUS:CANCELLED")
+	} else {
+		w.line("STATUS:CONFIRMED")
+	}
+	w.line("TRANSP:TRANSPARENT")
+	w.line("END:VEVENT")
+}
+
+// todo escreve a ocorrência de tarefa como VTODO, com o prazo em DUE
+func (w *icsWriter) todo(o models.OccurrenceDashboard) {
+	w.line("BEGIN:VTODO")
+	w.common(o)
+	switch {
+	case o.ScheduledAt != nil && o.DueAt != nil:
+		w.line("DTSTART:" + icsTimestamp(*o.ScheduledAt))
+		w.line("DUE:" + icsTimestamp(*o.DueAt))
+	case o.ScheduledAt != nil:
+		w.line("DUE:" + icsTimestamp(*o.ScheduledAt))
+	default:
+		w.line("DUE;VALUE=DATE:" + o.Date.Format("20060102"))
+	}
+	switch o.State {
+	case models.TaskDone:
+		w.line("STATUS:COMPLETED")
+	case models.TaskInProgress:
+		w.line("STATUS:IN-PROCESS")
+	case models.TaskCancelled:
+		w.line("STATUS:CANCELLED")
+	default:
+		w.line("STATUS:NEEDS-ACTION")
+	}
+	w.line("END:VTODO")
+}
+
+func icsTimestamp(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// calendarSummary é o título do evento; nas finanças, acompanhado do valor
+func calendarSummary(o models.OccurrenceDashboard) string {
+	if o.Amount == nil {
+		return o.Title
+	}
+	return o.Title + " - " + calendarAmount(o)
+}
+
+func calendarAmount(o models.OccurrenceDashboard) string {
+	symbol := "R$"
+	if o.CurrencySymbol != nil {
+		symbol = *o.CurrencySymbol
+	}
+	return symbol + " " + models.FormatDecimalPtBR(*o.Amount, 2)
+}
+
+// calendarDescription reúne a descrição, o valor, os responsáveis e a situação da ocorrência
+func calendarDescription(o models.OccurrenceDashboard) string {
+	var lines []string
+	if o.Description != "" {
+		lines = append(lines, o.Description)
+	}
+	if o.Amount != nil {
+		kind := "Valor"
+		if o.FinanceType != nil {
+			kind = map[bool]string{true: "Despesa", false: "Receita"}[*o.FinanceType]
+		}
+		lines = append(lines, kind+": "+calendarAmount(o))
+	}
+	if o.ResponsibleUser != "" {
+		lines = append(lines, "Responsável: "+o.ResponsibleUser)
+	}
+	if o.PayerGroup != "" {
+		lines = append(lines, "Grupo: "+o.PayerGroup)
+	}
+	lines = append(lines, "Situação: "+calendarStates[o.State])
+	return strings.Join(lines, "\n")
+}
\ No newline at end of file
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pobruno/casa360/models"
+)
+
+// eventsHeartbeat é o intervalo dos comentários que mantêm a conexão aberta em proxies; a cada um, o banco
+// também é conferido, caso algum aviso tenha se perdido
+const eventsHeartbeat = 15 * time.Second
+
+// StreamEvents mantém um stream SSE com as alterações em tarefas, ocorrências e carteiras. Cada evento
+// "change" tem como id a posição no feed (models.ChangeCursor.Token): ao reconectar, o navegador envia o
+// Last-Event-ID e o stream continua de onde parou, reenviando o que veio depois de uma alteração que ainda
+// podia chegar atrasada. Quando essa posição já saiu do feed, um evento "reset" pede que o cliente
+// recarregue os dados.
+func StreamEvents(c *gin.Context) {
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	var lastSeq, gapSeq int64
+	if lastID != "" {
+		var err error
+		if lastSeq, gapSeq, err = models.ParseChangeToken(lastID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID inválido"})
+			return
+		}
+	}
+
+	// entities aceita várias entidades separadas por vírgula
+	var entities []string
+	if value := c.Query("entities"); value != "" {
+		for _, entity := range strings.Split(value, ",") {
+			entity = strings.TrimSpace(entity)
+			if !models.ValidChangeEntity(entity) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Entidade inválida em entities: " + entity})
+				return
+			}
+			entities = append(entities, entity)
+		}
+	}
+
+	// Registra o cliente antes de posicionar o cursor, para não perder avisos entre uma coisa e outra
+	wake, unsubscribe := models.Changes().Subscribe()
+	defer unsubscribe()
+
+	cursor, reset, err := models.NewChangeCursor(lastSeq, gapSeq, entities)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if reset {
+		fmt.Fprintf(w, "id: %s\nevent: reset\ndata: {}\n\n", cursor.Token())
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		// Envia tudo o que estiver pendente, em lotes
+		for {
+			events, err := cursor.Fetch()
+			if err != nil {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", strconv.Quote(err.Error()))
+				w.Flush()
+				return
+			}
+			for _, event := range events {
+				data, err := json.Marshal(event)
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(w, "id: %s\nevent: change\ndata: %s\n\n", event.Token, data)
+			}
+			w.Flush()
+			if len(events) == 0 {
+				break
+			}
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-wake:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			w.Flush()
+		}
+	}
+}
\ No newline at end of file
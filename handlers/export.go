@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+	"github.com/xuri/excelize/v2"
+)
+
+// Formatos e idioma das exportações
+const (
+	exportCSV  = "csv"
+	exportXLSX = "xlsx"
+	localePtBR = "pt-BR"
+)
+
+// exportDate marca colunas DATE, exportadas sem horário
+type exportDate time.Time
+
+// exportRate marca cotações de moeda, exportadas com quatro casas decimais
+type exportRate float64
+
+type exportColumn struct {
+	name  string // cabeçalho padrão, igual ao campo JSON
+	label string // cabeçalho em pt-BR
+}
+
+type exportResource struct {
+	sheet   string
+	sheetBR string
+	columns []exportColumn
+	each    func(filter models.ListFilter, emit func([]interface{}) error) error
+}
+
+var exportResources = map[string]exportResource{
+	"finances": {
+		sheet:   "Finances",
+		sheetBR: "Finanças",
+		columns: []exportColumn{
+			{"id", "ID"}, {"title", "Título"}, {"description", "Descrição"}, {"type", "Despesa"},
+			{"start_date", "Data inicial"}, {"end_date", "Data final"}, {"recurrence_days", "Recorrência (dias)"},
+			{"amount", "Valor"}, {"user_id", "Usuário"}, {"payer_group_id", "Grupo de pagadores"},
+			{"finance_cc_id", "Centro de custo"}, {"currency_id", "Moeda"}, {"pix_code", "Pix"},
+		},
+		each: func(filter models.ListFilter, emit func([]interface{}) error) error {
+			return models.EachFinanceInstallment(filter, func(fi models.FinanceInstallment) error {
+				var endDate interface{}
+				if fi.EndDate != nil {
+					endDate = exportDate(*fi.EndDate)
+				}
+				return emit([]interface{}{
+					fi.ID, fi.Title, fi.Description, fi.Type, exportDate(fi.StartDate), endDate, fi.RecurrenceDays,
+					fi.Amount, fi.UserID, fi.PayerGroupID, fi.FinanceCCID, fi.CurrencyID, fi.PixCode,
+				})
+			})
+		},
+	},
+	"finance-occurrences": {
+		sheet:   "Finance occurrences",
+		sheetBR: "Ocorrências financeiras",
+		columns: []exportColumn{
+			{"id", "ID"}, {"finance_id", "Finança"}, {"date", "Data"}, {"amount", "Valor"},
+			{"status", "Pago"}, {"pix_code", "Pix"}, {"boleto_code", "Boleto"},
+		},
+		each: func(filter models.ListFilter, emit func([]interface{}) error) error {
+			return models.EachFinanceOccurrence(filter, func(fo models.FinanceOccurrence) error {
+				return emit([]interface{}{
+					fo.ID, fo.FinanceID, exportDate(fo.Date), fo.Amount, fo.Status, fo.PixCode, fo.BoletoCode,
+				})
+			})
+		},
+	},
+	"transactions": {
+		sheet:   "Transactions",
+		sheetBR: "Transações",
+		columns: []exportColumn{
+			{"id", "ID"}, {"finance_occurrence_id", "Ocorrência"}, {"amount", "Valor"}, {"created_at", "Criada em"},
+		},
+		each: func(filter models.ListFilter, emit func([]interface{}) error) error {
+			return models.EachTransaction(filter, func(t models.Transaction) error {
+				return emit([]interface{}{t.ID, t.FinanceOccurrenceID, t.Amount, t.CreatedAt})
+			})
+		},
+	},
+	"wallets": {
+		sheet:   "Wallets",
+		sheetBR: "Carteiras",
+		columns: []exportColumn{
+			{"id", "ID"}, {"user_id", "Usuário"}, {"amount", "Saldo"}, {"created_at", "Criado em"},
+		},
+		each: func(filter models.ListFilter, emit func([]interface{}) error) error {
+			return models.EachWallet(filter, func(w models.FinanceWallet) error {
+				return emit([]interface{}{w.ID, w.UserID, w.Amount, w.CreatedAt})
+			})
+		},
+	},
+	"dashboard": {
+		sheet:   "Dashboard",
+		sheetBR: "Dashboard",
+		columns: []exportColumn{
+			{"occurrence_type", "Tipo"}, {"id", "ID"}, {"date", "Data"}, {"status", "Concluída"},
+			{"state", "Situação"}, {"title", "Título"}, {"description", "Descrição"}, {"finance_type", "Despesa"}, {"amount", "Valor"},
+			{"currency_symbol", "Moeda"}, {"currency_value", "Cotação"}, {"amount_converted", "Valor convertido"},
+			{"cost_center", "Centro de custo"}, {"payer_group", "Grupo de pagadores"}, {"responsible_user", "Responsável"},
+			{"payer_group_id", "ID do grupo"}, {"user_id", "ID do responsável"},
+		},
+		each: func(filter models.ListFilter, emit func([]interface{}) error) error {
+			return models.EachOccurrenceDashboard(filter, func(o models.OccurrenceDashboard) error {
+				var rate interface{}
+				if o.CurrencyValue != nil {
+					rate = exportRate(*o.CurrencyValue)
+				}
+				return emit([]interface{}{
+					o.OccurrenceType, o.ID, exportDate(o.Date), o.Status, o.State, o.Title, o.Description, o.FinanceType,
+					o.Amount, o.CurrencySymbol, rate, o.AmountConverted, o.CostCenter, o.PayerGroup,
+					o.ResponsibleUser, o.PayerGroupID, o.UserID,
+				})
+			})
+		},
+	},
+}
+
+// exportWriter escreve as linhas de uma exportação em um formato específico
+type exportWriter interface {
+	writeRow(values []interface{}) error
+	finish() error
+}
+
+// ExportResource exporta finanças, ocorrências, transações, carteiras ou o dashboard em CSV ou XLSX,
+// aceitando os mesmos filtros das listagens
+func ExportResource(c *gin.Context) {
+	name := c.Param("resource")
+	resource, ok := exportResources[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recurso de exportação não encontrado"})
+		return
+	}
+
+	format := c.DefaultQuery("format", exportCSV)
+	if format != exportCSV && format != exportXLSX {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Formato deve ser csv ou xlsx"})
+		return
+	}
+
+	ptBR := false
+	if locale := c.Query("locale"); locale != "" {
+		if !strings.EqualFold(strings.ReplaceAll(locale, "_", "-"), localePtBR) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Locale não suportado, use pt-BR"})
+			return
+		}
+		ptBR = true
+	}
+
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	header := make([]interface{}, len(resource.columns))
+	for i, column := range resource.columns {
+		header[i] = column.name
+		if ptBR {
+			header[i] = column.label
+		}
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102"), format)
+	var writer exportWriter
+	if format == exportXLSX {
+		sheet := resource.sheet
+		if ptBR {
+			sheet = resource.sheetBR
+		}
+		xw, err := newXLSXExport(c, filename, sheet, len(header), ptBR)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer xw.file.Close()
+		writer = xw
+	} else {
+		writer = newCSVExport(c, filename, ptBR)
+	}
+
+	err := writer.writeRow(header)
+	if err == nil {
+		err = resource.each(filter, writer.writeRow)
+	}
+	if err == nil {
+		err = writer.finish()
+	}
+	if err != nil {
+		if c.Writer.Written() {
+			// O download já começou; resta interromper a resposta
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Writer.Header().Del("Content-Type")
+		c.Writer.Header().Del("Content-Disposition")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func setDownloadHeaders(c *gin.Context, filename, contentType string) {
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+}
+
+// exportValue resolve ponteiros opcionais, devolvendo nil para valores ausentes
+func exportValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *string:
+		if v != nil {
+			return *v
+		}
+	case *float64:
+		if v != nil {
+			return *v
+		}
+	case *bool:
+		if v != nil {
+			return *v
+		}
+	case *uuid.UUID:
+		if v != nil {
+			return *v
+		}
+	case *time.Time:
+		if v != nil {
+			return *v
+		}
+	default:
+		return value
+	}
+	return nil
+}
+
+// escapeFormula prefixa com ' os textos que a planilha interpretaria como fórmula; títulos e descrições
+// vêm dos moradores e não podem executar nada ao abrir o arquivo
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// csvExport escreve direto na resposta; em pt-BR usa ";" como separador, vírgula decimal
+// e datas dd/mm/aaaa, e inclui o BOM para o Excel reconhecer o UTF-8
+type csvExport struct {
+	out  *bufio.Writer
+	w    *csv.Writer
+	ptBR bool
+}
+
+func newCSVExport(c *gin.Context, filename string, ptBR bool) *csvExport {
+	setDownloadHeaders(c, filename, "text/csv; charset=utf-8")
+	out := bufio.NewWriter(c.Writer)
+	w := csv.NewWriter(out)
+	if ptBR {
+		w.Comma = ';'
+		w.UseCRLF = true
+		out.WriteString("\ufeff")
+	}
+	return &csvExport{out: out, w: w, ptBR: ptBR}
+}
+
+func (e *csvExport) writeRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = e.format(exportValue(value))
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExport) format(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return escapeFormula(v)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return e.decimal(v, 2)
+	case exportRate:
+		return e.decimal(float64(v), 4)
+	case bool:
+		if !e.ptBR {
+			return strconv.FormatBool(v)
+		}
+		if v {
+			return "Sim"
+		}
+		return "Não"
+	case uuid.UUID:
+		return v.String()
+	case exportDate:
+		if e.ptBR {
+			return time.Time(v).Format("02/01/2006")
+		}
+		return time.Time(v).Format("2006-01-02")
+	case time.Time:
+		if e.ptBR {
+			return v.Format("02/01/2006 15:04:05")
+		}
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (e *csvExport) decimal(v float64, places int) string {
+	if !e.ptBR {
+		return strconv.FormatFloat(v, 'f', places, 64)
+	}
+	return models.FormatDecimalPtBR(v, places)
+}
+
+func (e *csvExport) finish() error {
+	e.w.Flush()
+	if err := e.w.Error(); err != nil {
+		return err
+	}
+	return e.out.Flush()
+}
+
+// xlsxExport grava as linhas com o StreamWriter do excelize, mantendo números e datas como
+// células nativas; o locale altera apenas cabeçalhos e os formatos de data
+type xlsxExport struct {
+	c        *gin.Context
+	filename string
+	file     *excelize.File
+	stream   *excelize.StreamWriter
+	row      int
+	header   int
+	money    int
+	rate     int
+	date     int
+	datetime int
+}
+
+func newXLSXExport(c *gin.Context, filename, sheet string, columns int, ptBR bool) (*xlsxExport, error) {
+	file := excelize.NewFile()
+	if err := file.SetSheetName("Sheet1", sheet); err != nil {
+		return nil, err
+	}
+	stream, err := file.NewStreamWriter(sheet)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SetColWidth(1, columns, 20); err != nil {
+		return nil, err
+	}
+
+	dateFormat, datetimeFormat := "yyyy-mm-dd", "yyyy-mm-dd hh:mm:ss"
+	if ptBR {
+		dateFormat, datetimeFormat = "dd/mm/yyyy", "dd/mm/yyyy hh:mm:ss"
+	}
+	moneyFormat, rateFormat := "#,##0.00", "#,##0.0000"
+
+	e := &xlsxExport{c: c, filename: filename, file: file, stream: stream}
+	styles := []struct {
+		target *int
+		style  *excelize.Style
+	}{
+		{&e.header, &excelize.Style{Font: &excelize.Font{Bold: true}}},
+		{&e.money, &excelize.Style{CustomNumFmt: &moneyFormat}},
+		{&e.rate, &excelize.Style{CustomNumFmt: &rateFormat}},
+		{&e.date, &excelize.Style{CustomNumFmt: &dateFormat}},
+		{&e.datetime, &excelize.Style{CustomNumFmt: &datetimeFormat}},
+	}
+	for _, s := range styles {
+		if *s.target, err = file.NewStyle(s.style); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *xlsxExport) writeRow(values []interface{}) error {
+	e.row++
+	cells := make([]interface{}, len(values))
+	for i, value := range values {
+		cell := excelize.Cell{}
+		switch v := exportValue(value).(type) {
+		case float64:
+			cell.StyleID, cell.Value = e.money, v
+		case exportRate:
+			cell.StyleID, cell.Value = e.rate, float64(v)
+		case exportDate:
+			cell.StyleID, cell.Value = e.date, time.Time(v)
+		case time.Time:
+			cell.StyleID, cell.Value = e.datetime, v
+		case uuid.UUID:
+			cell.Value = v.String()
+		case string:
+			cell.Value = escapeFormula(v)
+		default:
+			cell.Value = v
+		}
+		if e.row == 1 {
+			cell.StyleID = e.header
+		}
+		cells[i] = cell
+	}
+
+	axis, err := excelize.CoordinatesToCellName(1, e.row)
+	if err != nil {
+		return err
+	}
+	return e.stream.SetRow(axis, cells)
+}
+
+func (e *xlsxExport) finish() error {
+	if err := e.stream.Flush(); err != nil {
+		return err
+	}
+	setDownloadHeaders(e.c, e.filename, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	return e.file.Write(e.c.Writer)
+}
\ No newline at end of file
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+// parseListFilter lê os filtros comuns das listagens e exportações da query string.
+// Em caso de erro responde 400 e retorna false.
+func parseListFilter(c *gin.Context) (models.ListFilter, bool) {
+	var filter models.ListFilter
+
+	dates := []struct {
+		param  string
+		target **time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	}
+	for _, d := range dates {
+		value := c.Query(d.param)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Data inválida em " + d.param + ", use o formato AAAA-MM-DD"})
+			return filter, false
+		}
+		*d.target = &date
+	}
+	if filter.From != nil && filter.To != nil && filter.To.Before(*filter.From) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Período inválido: to anterior a from"})
+		return filter, false
+	}
+
+	bools := []struct {
+		param  string
+		target **bool
+	}{
+		{"status", &filter.Status},
+		{"finance_type", &filter.FinanceType},
+	}
+	for _, b := range bools {
+		value := c.Query(b.param)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Valor inválido em " + b.param + ", use true ou false"})
+			return filter, false
+		}
+		*b.target = &parsed
+	}
+
+	ids := []struct {
+		param  string
+		target **uuid.UUID
+	}{
+		{"finance_id", &filter.FinanceID},
+		{"user_id", &filter.UserID},
+		{"payer_group_id", &filter.PayerGroupID},
+		{"finance_cc_id", &filter.FinanceCCID},
+	}
+	for _, i := range ids {
+		value := c.Query(i.param)
+		if value == "" {
+			continue
+		}
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido em " + i.param})
+			return filter, false
+		}
+		*i.target = &id
+	}
+
+	switch filter.OccurrenceType = c.Query("occurrence_type"); filter.OccurrenceType {
+	case "", "finance", "task":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "occurrence_type deve ser finance ou task"})
+		return filter, false
+	}
+
+	if filter.Archived = c.Query("archived"); !models.ValidArchived(filter.Archived) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "archived deve ser true ou all"})
+		return filter, false
+	}
+
+	// state aceita vários estados separados por vírgula
+	if value := c.Query("state"); value != "" {
+		for _, state := range strings.Split(value, ",") {
+			state = strings.TrimSpace(state)
+			if !models.ValidTaskState(state) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Estado inválido em state: " + state})
+				return filter, false
+			}
+			filter.States = append(filter.States, state)
+		}
+	}
+
+	return filter, true
+}
\ No newline at end of file
@@ -1,10 +1,9 @@
 package handlers
 
 import (
-	"io"
+	"database/sql"
+	"errors"
 	"net/http"
-	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -28,7 +27,12 @@ func CreateFinanceCC(c *gin.Context) {
 }
 
 func ListFinanceCCs(c *gin.Context) {
-	ccs, err := models.ListFinanceCCs()
+	archived, ok := parseArchived(c)
+	if !ok {
+		return
+	}
+
+	ccs, err := models.ListFinanceCCs(archived)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -37,6 +41,11 @@ func ListFinanceCCs(c *gin.Context) {
 	c.JSON(http.StatusOK, ccs)
 }
 
+// DeleteFinanceCC arquiva o centro de custo (soft delete)
+func DeleteFinanceCC(c *gin.Context) {
+	archiveRecord(c, financeCCRecord, "Centro de custo não encontrado", true)
+}
+
 // Handlers para Moedas
 func CreateFinanceCurrency(c *gin.Context) {
 	var currency models.FinanceCurrency
@@ -54,7 +63,12 @@ func CreateFinanceCurrency(c *gin.Context) {
 }
 
 func ListFinanceCurrencies(c *gin.Context) {
-	currencies, err := models.ListFinanceCurrencies()
+	archived, ok := parseArchived(c)
+	if !ok {
+		return
+	}
+
+	currencies, err := models.ListFinanceCurrencies(archived)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -63,6 +77,11 @@ func ListFinanceCurrencies(c *gin.Context) {
 	c.JSON(http.StatusOK, currencies)
 }
 
+// DeleteFinanceCurrency arquiva a moeda (soft delete)
+func DeleteFinanceCurrency(c *gin.Context) {
+	archiveRecord(c, currencyRecord, "Moeda não encontrada", true)
+}
+
 // Handlers para Finanças
 func CreateFinance(c *gin.Context) {
 	var finance models.FinanceInstallment
@@ -71,7 +90,15 @@ func CreateFinance(c *gin.Context) {
 		return
 	}
 
-	if err := finance.Create(); err != nil {
+	if !validatePixCode(c, finance.PixCode) {
+		return
+	}
+
+	if !validateSeriesLimits(c, finance.StartDate, finance.EndDate, finance.MaxOccurrences) {
+		return
+	}
+
+	if err := finance.Create(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -80,7 +107,12 @@ func CreateFinance(c *gin.Context) {
 }
 
 func ListFinances(c *gin.Context) {
-	finances, err := models.ListFinanceInstallments()
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	finances, err := models.ListFinanceInstallments(filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -118,95 +150,43 @@ func UpdateFinance(c *gin.Context) {
 		return
 	}
 
-	finance.ID = id
-	if err := finance.Update(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !validateSeriesLimits(c, finance.StartDate, finance.EndDate, finance.MaxOccurrences) {
 		return
 	}
 
-	c.JSON(http.StatusOK, finance)
-}
-
-func DeleteFinance(c *gin.Context) {
-	id, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+	applyTo, occurrenceID, ok := parseApplyTo(c)
+	if !ok {
 		return
 	}
 
-	finance := models.FinanceInstallment{ID: id}
-	if err := finance.Delete(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	finance.ID = id
+	if applyTo != "" {
+		result, err := finance.UpdateApplying(applyTo, occurrenceID, actor(c))
+		if err != nil {
+			respondApplyError(c, err, "Finança não encontrada")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"finance": finance, "occurrences": result})
 		return
 	}
 
-	c.Status(http.StatusNoContent)
-}
-
-func UpdateFinanceOccurrences(c *gin.Context) {
-	finances, err := models.ListFinanceInstallments()
-	if err != nil {
+	if err := finance.Update(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	now := time.Now()
-	totalOcorrencias := 0
-
-	c.Stream(func(w io.Writer) bool {
-		for _, finance := range finances {
-			c.SSEvent("log", "Processando finança: "+finance.Title+" (ID: "+finance.ID.String()+")")
-
-			// Validar recurrence_days
-			if finance.RecurrenceDays <= 0 {
-				c.SSEvent("error", "Dias de recorrência inválidos para finança "+finance.Title+": "+string(finance.RecurrenceDays))
-				continue
-			}
-
-			// Definir data final como:
-			// 1. Data final da finança (se existir)
-			// 2. Data atual (se não existir data final)
-			endDate := now
-			if finance.EndDate != nil && finance.EndDate.Before(now) {
-				endDate = *finance.EndDate
-			}
-
-			// Começar da data de início
-			nextDate := finance.StartDate
-
-			// Gerar todas as ocorrências até a data final
-			for nextDate.Before(endDate) || nextDate.Equal(endDate) {
-				c.SSEvent("log", "Verificando data: "+nextDate.Format("2006-01-02")+" para finança: "+finance.Title)
-
-				occurrence := models.FinanceOccurrence{
-					FinanceID: finance.ID,
-					Date:      nextDate,
-					Amount:    finance.Amount,
-					Status:    false,
-				}
-
-				err := occurrence.Create()
-				if err != nil {
-					if !strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
-						c.SSEvent("error", "Erro ao criar ocorrência: "+err.Error())
-					} else {
-						c.SSEvent("log", "Ocorrência já existe para data: "+nextDate.Format("2006-01-02"))
-					}
-				} else {
-					totalOcorrencias++
-					c.SSEvent("success", "Ocorrência criada para data: "+nextDate.Format("2006-01-02"))
-				}
-
-				nextDate = nextDate.AddDate(0, 0, finance.RecurrenceDays)
-			}
-		}
+	c.JSON(http.StatusOK, finance)
+}
+
+// DeleteFinance arquiva a finança (soft delete), mantendo as ocorrências pagas
+func DeleteFinance(c *gin.Context) {
+	archiveRecord(c, financeRecord, "Finança não encontrada", true)
+}
 
-		c.SSEvent("complete", gin.H{
-			"message": "Processamento concluído",
-			"total_ocorrencias": totalOcorrencias,
-		})
-		return false
-	})
+// UpdateFinanceOccurrences enfileira a geração das ocorrências das finanças até hoje; o progresso é acompanhado
+// em GET /jobs/:id ou, opcionalmente, pelo próprio stream da resposta
+func UpdateFinanceOccurrences(c *gin.Context) {
+	submitJob(c, models.JobFinanceOccurrences)
 }
 
 // CreateFinanceOccurrence cria uma nova ocorrência financeira
@@ -217,7 +197,11 @@ func CreateFinanceOccurrence(c *gin.Context) {
 		return
 	}
 
-	if err := occurrence.Create(); err != nil {
+	if !validatePixCode(c, occurrence.PixCode) {
+		return
+	}
+
+	if err := occurrence.Create(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -240,7 +224,7 @@ func UpdateFinanceOccurrence(c *gin.Context) {
 	}
 
 	occurrence.ID = id
-	if err := occurrence.Update(); err != nil {
+	if err := occurrence.Update(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -257,8 +241,15 @@ func DeleteFinanceOccurrence(c *gin.Context) {
 	}
 
 	occurrence := models.FinanceOccurrence{ID: id}
-	if err := occurrence.Delete(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := occurrence.Delete(actor(c)); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+		case errors.Is(err, models.ErrOccurrencePaid), errors.Is(err, models.ErrInvoiceEntry):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
@@ -280,6 +271,10 @@ func GenerateFinanceOccurrences(c *gin.Context) {
 	}
 
 	if err := finance.GenerateOccurrences(); err != nil {
+		if errors.Is(err, models.ErrArchived) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+	"github.com/pobruno/casa360/storage"
+)
+
+// invoiceMaxSize é o tamanho máximo do XML de uma nota; notas com milhares de itens ficam bem abaixo dele
+const invoiceMaxSize = 5 << 20
+
+// ParseInvoice lê uma NF-e ou NFC-e (XML no campo file ou no corpo da requisição) sem gravá-la
+func ParseInvoice(c *gin.Context) {
+	invoice, _, ok := readInvoice(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
+
+// ImportInvoice lança uma NF-e ou NFC-e (multipart) como despesa: em uma finança existente (finance_id) ou
+// em uma finança avulsa, com os itens de splits em outros centros de custo. O XML fica anexado à ocorrência
+// principal, a não ser que attach=false.
+func ImportInvoice(c *gin.Context) {
+	opts := models.InvoiceImportOptions{Paid: c.PostForm("paid") == "true", By: actor(c)}
+
+	if value := c.PostForm("finance_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "finance_id inválido"})
+			return
+		}
+		finance := models.FinanceInstallment{ID: id}
+		if err := finance.Get(); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Finança não encontrada"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		opts.FinanceID = &id
+	} else {
+		var err error
+		finance := &opts.Finance
+		finance.Title = strings.TrimSpace(c.PostForm("title"))
+		finance.Description = strings.TrimSpace(c.PostForm("description"))
+		if finance.UserID, err = uuid.Parse(c.PostForm("user_id")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "user_id inválido"})
+			return
+		}
+		if finance.PayerGroupID, err = uuid.Parse(c.PostForm("payer_group_id")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "payer_group_id inválido"})
+			return
+		}
+		user, group := models.User{ID: finance.UserID}, models.PayerGroup{ID: finance.PayerGroupID}
+		if user.Get() != nil || group.Get() != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Usuário ou grupo de pagadores não encontrado"})
+			return
+		}
+		if finance.FinanceCCID, err = uuid.Parse(c.PostForm("finance_cc_id")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "finance_cc_id inválido"})
+			return
+		}
+		if !costCenterExists(c, finance.FinanceCCID) {
+			return
+		}
+		id, err := uuid.Parse(c.PostForm("currency_id"))
+		currency := models.FinanceCurrency{ID: id}
+		if err != nil || currency.Get() != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Moeda não encontrada"})
+			return
+		}
+		finance.CurrencyID = id
+	}
+
+	if value := c.PostForm("splits"); value != "" {
+		if err := json.Unmarshal([]byte(value), &opts.Splits); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "splits inválido: use [{\"finance_cc_id\": \"uuid\", \"items\": [1, 2]}]"})
+			return
+		}
+		for _, split := range opts.Splits {
+			if !costCenterExists(c, split.FinanceCCID) {
+				return
+			}
+		}
+	}
+
+	invoice, data, ok := readInvoice(c)
+	if !ok {
+		return
+	}
+
+	result, err := models.ImportInvoice(invoice, opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvoiceSplit), errors.Is(err, models.ErrInvoiceIncome):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Finança não encontrada"})
+		case errors.Is(err, models.ErrInvoiceImported), errors.Is(err, models.ErrInvoiceOccurrenceExists),
+			errors.Is(err, models.ErrArchived):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// A nota já está lançada; uma falha ao anexar o XML não desfaz a importação
+	if c.DefaultPostForm("attach", "true") != "false" {
+		store, err := storage.Default()
+		if err == nil {
+			err = result.Attach(c.Request.Context(), store, data, opts.By)
+		}
+		if err != nil {
+			log.Printf("Erro ao anexar o XML da nota %s: %v", invoice.AccessKey, err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// GetInvoiceImport busca uma nota importada com os seus lançamentos
+func GetInvoiceImport(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	result, err := models.GetInvoiceImport(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Importação não encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// UndoInvoiceImport desfaz a importação de uma nota, removendo todos os lançamentos dela
+func UndoInvoiceImport(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	if err := models.UndoInvoiceImport(id, actor(c)); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Importação não encontrada"})
+		case errors.Is(err, models.ErrOccurrencePaid):
+			c.JSON(http.StatusConflict, gin.H{"error": "A nota tem lançamentos pagos e não pode ser desfeita"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// readInvoice lê e interpreta o XML da nota, do campo file (multipart) ou do corpo da requisição
+func readInvoice(c *gin.Context) (*models.Invoice, []byte, bool) {
+	var body io.Reader
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile(attachmentField)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Arquivo não enviado"})
+			return nil, nil, false
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, nil, false
+		}
+		defer f.Close()
+		body = f
+	} else {
+		body = c.Request.Body
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, invoiceMaxSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	if len(data) > invoiceMaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "O XML da nota excede o limite de 5 MB"})
+		return nil, nil, false
+	}
+
+	invoice, err := models.ParseInvoice(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	return invoice, data, true
+}
+
+// costCenterExists confere o centro de custo, respondendo 400 quando ele não existe
+func costCenterExists(c *gin.Context, id uuid.UUID) bool {
+	cc := models.FinanceCC{ID: id}
+	if err := cc.Get(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Centro de custo não encontrado: " + id.String()})
+		return false
+	}
+	return true
+}
\ No newline at end of file
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+// jobPollInterval é de quanto em quanto tempo o stream de um job confere o progresso no banco
+const jobPollInterval = time.Second
+
+// submitJob enfileira a regeneração e responde 202 com o job (ou 200 com o job do mesmo tipo que já
+// estava em andamento). Com Accept: text/event-stream ou ?stream=true, acompanha o progresso pelo stream.
+func submitJob(c *gin.Context, kind string) {
+	job, created, err := models.SubmitJob(kind)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("stream") == "true" || strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		streamJob(c, job.ID, 0)
+		return
+	}
+
+	c.Header("Location", "/jobs/"+job.ID.String())
+	if created {
+		c.JSON(http.StatusAccepted, job)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// ListJobs lista os jobs (filtros: kind, status, from e to)
+func ListJobs(c *gin.Context) {
+	kind := c.Query("kind")
+	if kind != "" && !models.ValidJobKind(kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind deve ser task_occurrences ou finance_occurrences"})
+		return
+	}
+	status := c.Query("status")
+	if status != "" && !models.ValidJobStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status deve ser queued, running, succeeded, failed ou cancelled"})
+		return
+	}
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	jobs, err := models.ListJobs(kind, status, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+// loadJob busca o job da rota, respondendo 400 ou 404 quando não encontra
+func loadJob(c *gin.Context) (*models.Job, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return nil, false
+	}
+	job := &models.Job{ID: id}
+	if err := job.Get(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job não encontrado"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return job, true
+}
+
+// GetJob retorna a situação e o progresso de um job
+func GetJob(c *gin.Context) {
+	job, ok := loadJob(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob pede o cancelamento de um job em andamento
+func CancelJob(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	job := models.Job{ID: id}
+	if err := job.Cancel(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job não encontrado"})
+			return
+		}
+		if errors.Is(err, models.ErrJobFinished) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "job": job})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// StreamJob acompanha o progresso de um job por SSE. Cada mensagem tem como id a sua posição no log do
+// job: ao reconectar com Last-Event-ID, o stream continua de onde parou.
+func StreamJob(c *gin.Context) {
+	job, ok := loadJob(c)
+	if !ok {
+		return
+	}
+
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	var last int64
+	if lastID != "" {
+		id, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || id < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID inválido"})
+			return
+		}
+		last = id
+	}
+
+	streamJob(c, job.ID, last)
+}
+
+// streamJob envia as mensagens do job como eventos log, error e success e, quando ele termina, um evento
+// complete com o resultado. Desconectar apenas encerra o stream: o job continua rodando.
+func streamJob(c *gin.Context, id uuid.UUID, last int64) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	fmt.Fprint(w, "retry: 3000\n\n")
+	w.Flush()
+
+	poll := time.NewTicker(jobPollInterval)
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer poll.Stop()
+	defer heartbeat.Stop()
+	for {
+		// A situação é lida antes das mensagens: as de um job terminado já estão todas gravadas
+		job := models.Job{ID: id}
+		err := job.Get()
+		for err == nil {
+			var logs []models.JobLog
+			logs, err = models.ListJobLogs(id, last)
+			for _, l := range logs {
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", l.ID, l.Level, strings.ReplaceAll(l.Message, "\n", " "))
+				last = l.ID
+			}
+			w.Flush()
+			if len(logs) == 0 {
+				break
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", strconv.Quote(err.Error()))
+			w.Flush()
+			return
+		}
+
+		if job.Finished() {
+			message := "Processamento concluído"
+			switch job.Status {
+			case models.JobCancelled:
+				message = "Processamento cancelado"
+			case models.JobFailed:
+				message = "Processamento interrompido"
+			}
+			data, err := json.Marshal(gin.H{
+				"message":           message,
+				"total_ocorrencias": job.Created,
+				"job":               job,
+			})
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: complete\ndata: %s\n\n", data)
+			w.Flush()
+			return
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-poll.C:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			w.Flush()
+		}
+	}
+}
\ No newline at end of file
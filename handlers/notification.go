@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+	"github.com/pobruno/casa360/notify"
+)
+
+// loadUser valida o ID da rota e confirma que o morador existe
+func loadUser(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return id, false
+	}
+	user := models.User{ID: id}
+	if err := user.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuário não encontrado"})
+		return id, false
+	}
+	return id, true
+}
+
+// GetNotificationSettings retorna as preferências de notificação do morador
+func GetNotificationSettings(c *gin.Context) {
+	userID, ok := loadUser(c)
+	if !ok {
+		return
+	}
+
+	settings, err := models.GetNotificationSettings(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateNotificationSettings define o horário de silêncio do morador; sem os dois horários, ele é removido
+func UpdateNotificationSettings(c *gin.Context) {
+	userID, ok := loadUser(c)
+	if !ok {
+		return
+	}
+
+	var settings models.NotificationSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (settings.QuietHoursStart == nil) != (settings.QuietHoursEnd == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Informe quiet_hours_start e quiet_hours_end juntos"})
+		return
+	}
+	for _, value := range []*string{settings.QuietHoursStart, settings.QuietHoursEnd} {
+		if value == nil {
+			continue
+		}
+		if _, err := time.Parse("15:04", *value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Horário inválido: " + *value + ", use o formato HH:MM"})
+			return
+		}
+	}
+
+	settings.UserID = userID
+	if err := settings.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// CreateNotificationChannel cadastra um canal de notificação do morador
+func CreateNotificationChannel(c *gin.Context) {
+	userID, ok := loadUser(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Channel string `json:"channel" binding:"required"`
+		Address string `json:"address" binding:"required"`
+		Enabled *bool  `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.ValidNotificationChannel(input.Channel) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "channel deve ser email, webhook ou telegram"})
+		return
+	}
+
+	channel := models.NotificationChannel{
+		UserID:  userID,
+		Channel: input.Channel,
+		Address: strings.TrimSpace(input.Address),
+		Enabled: input.Enabled == nil || *input.Enabled,
+	}
+	if err := channel.Create(); err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			c.JSON(http.StatusConflict, gin.H{"error": "Este canal já está cadastrado para o morador"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, channel)
+}
+
+// ListNotificationChannels lista os canais de notificação do morador
+func ListNotificationChannels(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	channels, err := models.ListNotificationChannels(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, channels)
+}
+
+// loadNotificationChannel busca o canal da rota, respondendo 400 ou 404 quando não encontra
+func loadNotificationChannel(c *gin.Context) (*models.NotificationChannel, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return nil, false
+	}
+	channel := &models.NotificationChannel{ID: id}
+	if err := channel.Get(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Canal de notificação não encontrado"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return channel, true
+}
+
+// UpdateNotificationChannel altera o endereço ou ativa/desativa o canal
+func UpdateNotificationChannel(c *gin.Context) {
+	channel, ok := loadNotificationChannel(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Address *string `json:"address"`
+		Enabled *bool   `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Address != nil {
+		if strings.TrimSpace(*input.Address) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "address não pode ser vazio"})
+			return
+		}
+		channel.Address = strings.TrimSpace(*input.Address)
+	}
+	if input.Enabled != nil {
+		channel.Enabled = *input.Enabled
+	}
+
+	if err := channel.Update(); err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			c.JSON(http.StatusConflict, gin.H{"error": "Este canal já está cadastrado para o morador"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, channel)
+}
+
+// DeleteNotificationChannel remove o canal
+func DeleteNotificationChannel(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	channel := models.NotificationChannel{ID: id}
+	if err := channel.Delete(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Canal de notificação não encontrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// TestNotificationChannel envia uma mensagem de teste pelo canal. A resposta traz o envio registrado;
+// quando o canal recusa a mensagem, o status é 502 e o erro fica no registro.
+func TestNotificationChannel(c *gin.Context) {
+	channel, ok := loadNotificationChannel(c)
+	if !ok {
+		return
+	}
+
+	notification, err := channel.SendTest(c.Request.Context(), notify.Default())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if notification.Status != models.NotificationSent {
+		c.JSON(http.StatusBadGateway, notification)
+		return
+	}
+
+	c.JSON(http.StatusOK, notification)
+}
+
+// notificationRuleInput são os campos aceitos na criação e na alteração de uma regra
+type notificationRuleInput struct {
+	Event         *string    `json:"event"`
+	OffsetMinutes *int       `json:"offset_minutes"`
+	UserID        *uuid.UUID `json:"user_id"`
+	Enabled       *bool      `json:"enabled"`
+}
+
+// apply copia os campos enviados para a regra e a valida
+func (input notificationRuleInput) apply(c *gin.Context, rule *models.NotificationRule) bool {
+	if input.Event != nil {
+		rule.Event = *input.Event
+	}
+	if input.OffsetMinutes != nil {
+		rule.OffsetMinutes = *input.OffsetMinutes
+	}
+	if input.UserID != nil {
+		rule.UserID = input.UserID
+	}
+	if input.Enabled != nil {
+		rule.Enabled = *input.Enabled
+	}
+
+	if !models.ValidNotificationEvent(rule.Event) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "event deve ser task_due, task_overdue, finance_due ou finance_overdue"})
+		return false
+	}
+	if rule.OffsetMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset_minutes não pode ser negativo"})
+		return false
+	}
+	if rule.UserID != nil {
+		user := models.User{ID: *rule.UserID}
+		if err := user.Get(); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Usuário não encontrado"})
+			return false
+		}
+	}
+	return true
+}
+
+// CreateNotificationRule cria uma regra de lembrete, para um morador ou para todos
+func CreateNotificationRule(c *gin.Context) {
+	var input notificationRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := models.NotificationRule{Enabled: true}
+	if !input.apply(c, &rule) {
+		return
+	}
+	if err := rule.Create(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+// ListNotificationRules lista as regras de lembrete
+func ListNotificationRules(c *gin.Context) {
+	rules, err := models.ListNotificationRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// UpdateNotificationRule altera os campos enviados da regra. Para voltar a valer para todos os
+// moradores, envie "all_users": true.
+func UpdateNotificationRule(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	rule := models.NotificationRule{ID: id}
+	if err := rule.Get(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Regra de notificação não encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var input struct {
+		notificationRuleInput
+		AllUsers bool `json:"all_users"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.AllUsers {
+		if input.UserID != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Informe user_id ou all_users, não os dois"})
+			return
+		}
+		rule.UserID = nil
+	}
+	if !input.apply(c, &rule) {
+		return
+	}
+
+	if err := rule.Update(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteNotificationRule remove a regra
+func DeleteNotificationRule(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	rule := models.NotificationRule{ID: id}
+	if err := rule.Delete(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Regra de notificação não encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListNotifications lista os envios registrados (filtros: user_id, from e to sobre a data do envio)
+func ListNotifications(c *gin.Context) {
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	notifications, err := models.ListNotifications(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, notifications)
+}
+
+// DispatchNotifications executa uma rodada de envio dos lembretes devidos, sem esperar o job
+func DispatchNotifications(c *gin.Context) {
+	result, err := models.DispatchNotifications(c.Request.Context(), notify.Default())
+	if err != nil {
+		if errors.Is(err, models.ErrDispatchRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
\ No newline at end of file
@@ -8,9 +8,14 @@ import (
 	"github.com/pobruno/casa360/models"
 )
 
-// ListTaskOccurrences lista todas as ocorrências de tarefas
+// ListTaskOccurrences lista as ocorrências de tarefas, com filtros opcionais (inclusive por estado)
 func ListTaskOccurrences(c *gin.Context) {
-	occurrences, err := models.ListTaskOccurrences()
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	occurrences, err := models.ListTaskOccurrences(filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -19,9 +24,14 @@ func ListTaskOccurrences(c *gin.Context) {
 	c.JSON(http.StatusOK, occurrences)
 }
 
-// ListFinanceOccurrences lista todas as ocorrências financeiras
+// ListFinanceOccurrences lista as ocorrências financeiras, com filtros opcionais
 func ListFinanceOccurrences(c *gin.Context) {
-	occurrences, err := models.ListFinanceOccurrences()
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	occurrences, err := models.ListFinanceOccurrences(filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -30,9 +40,14 @@ func ListFinanceOccurrences(c *gin.Context) {
 	c.JSON(http.StatusOK, occurrences)
 }
 
-// ListOccurrencesDashboard retorna todas as ocorrências do dashboard
+// ListOccurrencesDashboard retorna as ocorrências do dashboard, com filtros opcionais
 func ListOccurrencesDashboard(c *gin.Context) {
-	occurrences, err := models.ListOccurrencesDashboard()
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	occurrences, err := models.ListOccurrencesDashboard(filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -63,6 +78,38 @@ func GetLastWallet(c *gin.Context) {
 	c.JSON(http.StatusOK, wallet)
 }
 
+// ListWallets retorna o histórico das carteiras, com filtros opcionais
+func ListWallets(c *gin.Context) {
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	wallets, err := models.ListWallets(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, wallets)
+}
+
+// ListAllTransactions retorna as transações de todas as ocorrências, com filtros opcionais
+func ListAllTransactions(c *gin.Context) {
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	transactions, err := models.ListTransactions(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, transactions)
+}
+
 // ListTransactions retorna todas as transações de uma ocorrência
 func ListTransactions(c *gin.Context) {
 	occurrenceID, err := uuid.Parse(c.Param("occurrence_id"))
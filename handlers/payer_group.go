@@ -15,7 +15,7 @@ func CreatePayerGroup(c *gin.Context) {
 		return
 	}
 
-	if err := group.Create(); err != nil {
+	if err := group.Create(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -24,7 +24,12 @@ func CreatePayerGroup(c *gin.Context) {
 }
 
 func ListPayerGroups(c *gin.Context) {
-	groups, err := models.ListPayerGroups()
+	archived, ok := parseArchived(c)
+	if !ok {
+		return
+	}
+
+	groups, err := models.ListPayerGroups(archived)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -63,7 +68,7 @@ func UpdatePayerGroup(c *gin.Context) {
 	}
 
 	group.ID = id
-	if err := group.Update(); err != nil {
+	if err := group.Update(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -71,20 +76,9 @@ func UpdatePayerGroup(c *gin.Context) {
 	c.JSON(http.StatusOK, group)
 }
 
+// DeletePayerGroup arquiva o grupo de pagadores (soft delete)
 func DeletePayerGroup(c *gin.Context) {
-	id, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
-		return
-	}
-
-	group := models.PayerGroup{ID: id}
-	if err := group.Delete(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.Status(http.StatusNoContent)
+	archiveRecord(c, payerGroupRecord, "Grupo não encontrado", true)
 }
 
 func CreatePayerGroupMember(c *gin.Context) {
@@ -101,7 +95,7 @@ func CreatePayerGroupMember(c *gin.Context) {
 	}
 
 	member.PayerGroupID = groupID
-	if err := member.Create(); err != nil {
+	if err := member.Create(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -133,10 +127,38 @@ func DeletePayerGroupMember(c *gin.Context) {
 	}
 
 	member := models.PayerGroupMember{ID: id}
-	if err := member.Delete(); err != nil {
+	if err := member.Delete(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.Status(http.StatusNoContent)
-} 
\ No newline at end of file
+} 
+
+// GetPayerGroupFairness compara o esforço concluído por cada morador com a parte esperada no período (from/to)
+func GetPayerGroupFairness(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	group := models.PayerGroup{ID: id}
+	if err := group.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Grupo não encontrado"})
+		return
+	}
+
+	report, err := models.GetFairnessReport(id, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
\ No newline at end of file
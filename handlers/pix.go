@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+type pixCodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type pixSettlementInput struct {
+	FromUserID  uuid.UUID `json:"from_user_id" form:"from_user_id"`
+	ToUserID    uuid.UUID `json:"to_user_id" form:"to_user_id" binding:"required"`
+	Amount      float64   `json:"amount" form:"amount" binding:"required"`
+	Description string    `json:"description" form:"description"`
+}
+
+// ParsePixCode decodifica e valida um BR Code Pix sem gravá-lo
+func ParsePixCode(c *gin.Context) {
+	var input pixCodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	br, err := models.ParseBRCode(input.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, br)
+}
+
+// SetFinancePix anexa um BR Code Pix a uma finança
+func SetFinancePix(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var input pixCodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	br, err := models.ParseBRCode(input.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	finance := models.FinanceInstallment{ID: id}
+	if err := finance.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Finança não encontrada"})
+		return
+	}
+
+	if err := finance.SetPixCode(&input.Code, actor(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"finance":         finance,
+		"pix":             br,
+		"amount_mismatch": pixAmountMismatch(br, finance.Amount),
+	})
+}
+
+// DeleteFinancePix remove o BR Code Pix de uma finança
+func DeleteFinancePix(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	finance := models.FinanceInstallment{ID: id}
+	if err := finance.SetPixCode(nil, actor(c)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Finança não encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// SetFinanceOccurrencePix anexa um BR Code Pix a uma ocorrência financeira
+func SetFinanceOccurrencePix(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var input pixCodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	br, err := models.ParseBRCode(input.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	occurrence := models.FinanceOccurrence{ID: id}
+	if err := occurrence.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+		return
+	}
+
+	if err := occurrence.SetPixCode(&input.Code, actor(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"occurrence":      occurrence,
+		"pix":             br,
+		"amount_mismatch": pixAmountMismatch(br, occurrence.Amount),
+	})
+}
+
+// DeleteFinanceOccurrencePix remove o BR Code Pix de uma ocorrência financeira
+func DeleteFinanceOccurrencePix(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	occurrence := models.FinanceOccurrence{ID: id}
+	if err := occurrence.SetPixCode(nil, actor(c)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetFinanceOccurrencePix retorna o BR Code da ocorrência (ou, na falta dele, o da finança)
+func GetFinanceOccurrencePix(c *gin.Context) {
+	occurrence, code, ok := occurrencePixCode(c)
+	if !ok {
+		return
+	}
+
+	br, err := models.ParseBRCode(code)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":            code,
+		"pix":             br,
+		"amount_mismatch": pixAmountMismatch(br, occurrence.Amount),
+	})
+}
+
+// GetFinanceOccurrencePixQR retorna o QR Code (PNG) do BR Code de uma ocorrência
+func GetFinanceOccurrencePixQR(c *gin.Context) {
+	_, code, ok := occurrencePixCode(c)
+	if !ok {
+		return
+	}
+
+	writeQRCode(c, code)
+}
+
+// CreatePixSettlement gera o BR Code para um morador pagar outro
+func CreatePixSettlement(c *gin.Context) {
+	var input pixSettlementInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	br, code, ok := pixSettlement(c, input)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"from_user_id": input.FromUserID,
+		"to_user_id":   input.ToUserID,
+		"amount":       input.Amount,
+		"code":         code,
+		"pix":          br,
+	})
+}
+
+// GetPixSettlementQR gera o QR Code (PNG) de um acerto entre moradores a partir da query string
+func GetPixSettlementQR(c *gin.Context) {
+	var input pixSettlementInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, code, ok := pixSettlement(c, input)
+	if !ok {
+		return
+	}
+
+	writeQRCode(c, code)
+}
+
+func pixSettlement(c *gin.Context, input pixSettlementInput) (*models.BRCode, string, bool) {
+	receiver := models.User{ID: input.ToUserID}
+	if err := receiver.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuário não encontrado"})
+		return nil, "", false
+	}
+
+	description := input.Description
+	if description == "" && input.FromUserID != uuid.Nil {
+		payer := models.User{ID: input.FromUserID}
+		if err := payer.Get(); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Usuário não encontrado"})
+			return nil, "", false
+		}
+		description = "Acerto " + payer.Name
+	}
+
+	br, code, err := models.NewSettlementBRCode(&receiver, input.Amount, description)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+	return br, code, true
+}
+
+func occurrencePixCode(c *gin.Context) (*models.FinanceOccurrence, string, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return nil, "", false
+	}
+
+	occurrence := models.FinanceOccurrence{ID: id}
+	if err := occurrence.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+		return nil, "", false
+	}
+
+	if occurrence.PixCode != nil {
+		return &occurrence, *occurrence.PixCode, true
+	}
+
+	finance := models.FinanceInstallment{ID: occurrence.FinanceID}
+	if err := finance.Get(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+	if finance.PixCode == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência sem BR Code Pix"})
+		return nil, "", false
+	}
+	return &occurrence, *finance.PixCode, true
+}
+
+func writeQRCode(c *gin.Context, code string) {
+	size, err := strconv.Atoi(c.DefaultQuery("size", "256"))
+	if err != nil || size < 64 || size > 1024 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tamanho inválido"})
+		return
+	}
+
+	png, err := qrcode.Encode(code, qrcode.Medium, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// validatePixCode valida o BR Code opcional enviado na criação de finanças e ocorrências
+func validatePixCode(c *gin.Context, code *string) bool {
+	if code == nil {
+		return true
+	}
+	if _, err := models.ParseBRCode(*code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// validatePixKey valida a chave Pix opcional de um usuário
+func validatePixKey(c *gin.Context, key *string) bool {
+	if key == nil {
+		return true
+	}
+	if _, err := models.PixKeyType(*key); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+func pixAmountMismatch(br *models.BRCode, expected float64) bool {
+	return br.Amount != nil && math.Abs(*br.Amount-expected) >= 0.005
+}
\ No newline at end of file
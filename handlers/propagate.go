@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+// parseApplyTo lê apply_to e occurrence_id da query string. Sem apply_to, o PUT altera só o modelo.
+func parseApplyTo(c *gin.Context) (string, *uuid.UUID, bool) {
+	applyTo := c.Query("apply_to")
+	if applyTo != "" && !models.ValidApplyTo(applyTo) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "apply_to inválido, use template, this, future ou unpaid"})
+		return "", nil, false
+	}
+
+	var occurrenceID *uuid.UUID
+	if value := c.Query("occurrence_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido em occurrence_id"})
+			return "", nil, false
+		}
+		occurrenceID = &id
+	}
+	return applyTo, occurrenceID, true
+}
+
+// respondApplyError traduz os erros da propagação de um modelo para as ocorrências
+func respondApplyError(c *gin.Context, err error, notFound string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+	case errors.Is(err, models.ErrApplyOccurrenceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrApplyOccurrenceRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrApplyRecurrence), errors.Is(err, models.ErrRotationWithoutMembers):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrOccurrenceClosed), errors.Is(err, models.ErrInvoiceEntry):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
\ No newline at end of file
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+// movableOccurrence é uma ocorrência (de tarefa ou financeira) que pode ser pulada ou remarcada
+type movableOccurrence interface {
+	Skip(changedBy, by *uuid.UUID) error
+	Snooze(days int, changedBy, by *uuid.UUID) error
+	Reschedule(date time.Time, changedBy, by *uuid.UUID) error
+}
+
+// moveInput é o corpo, opcional, das ações de pular, adiar e remarcar
+type moveInput struct {
+	UserID *uuid.UUID `json:"user_id"` // quem pulou ou remarcou (padrão: X-User-ID); o log de auditoria registra sempre o X-User-ID
+	Days   int        `json:"days"`    // adiar: dias a somar à data (padrão 1)
+	Date   string     `json:"date"`    // remarcar: nova data, YYYY-MM-DD
+}
+
+// SkipTaskOccurrence pula uma ocorrência de tarefa, sem que a data volte a ser gerada
+func SkipTaskOccurrence(c *gin.Context) {
+	moveOccurrence(c, taskOccurrence, models.ExceptionSkipped)
+}
+
+// SnoozeTaskOccurrence adia uma ocorrência de tarefa em alguns dias
+func SnoozeTaskOccurrence(c *gin.Context) {
+	moveOccurrence(c, taskOccurrence, models.ExceptionSnoozed)
+}
+
+// RescheduleTaskOccurrence remarca uma ocorrência de tarefa para outra data
+func RescheduleTaskOccurrence(c *gin.Context) {
+	moveOccurrence(c, taskOccurrence, models.ExceptionRescheduled)
+}
+
+// SkipFinanceOccurrence pula uma ocorrência financeira em aberto; ela é removida e a data não volta a ser gerada
+func SkipFinanceOccurrence(c *gin.Context) {
+	moveOccurrence(c, func(id uuid.UUID) (movableOccurrence, interface{}) {
+		return &models.FinanceOccurrence{ID: id}, nil
+	}, models.ExceptionSkipped)
+}
+
+// SnoozeFinanceOccurrence adia o vencimento de uma ocorrência financeira em alguns dias
+func SnoozeFinanceOccurrence(c *gin.Context) {
+	moveOccurrence(c, financeOccurrence, models.ExceptionSnoozed)
+}
+
+// RescheduleFinanceOccurrence remarca o vencimento de uma ocorrência financeira
+func RescheduleFinanceOccurrence(c *gin.Context) {
+	moveOccurrence(c, financeOccurrence, models.ExceptionRescheduled)
+}
+
+func taskOccurrence(id uuid.UUID) (movableOccurrence, interface{}) {
+	occurrence := &models.TaskOccurrence{ID: id}
+	return occurrence, occurrence
+}
+
+func financeOccurrence(id uuid.UUID) (movableOccurrence, interface{}) {
+	occurrence := &models.FinanceOccurrence{ID: id}
+	return occurrence, occurrence
+}
+
+// moveOccurrence valida o corpo e aplica a ação. load devolve a ocorrência e o valor a responder;
+// sem valor (ocorrência removida), a resposta é 204.
+func moveOccurrence(c *gin.Context, load func(uuid.UUID) (movableOccurrence, interface{}), action string) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var input moveInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if input.UserID != nil {
+		user := models.User{ID: *input.UserID}
+		if err := user.Get(); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Usuário não encontrado"})
+			return
+		}
+	}
+	by := actor(c)
+	changedBy := input.UserID
+	if changedBy == nil {
+		changedBy = by
+	}
+
+	occurrence, response := load(id)
+	switch action {
+	case models.ExceptionSkipped:
+		err = occurrence.Skip(changedBy, by)
+	case models.ExceptionSnoozed:
+		if input.Days < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days deve ser positivo"})
+			return
+		}
+		if input.Days == 0 {
+			input.Days = 1
+		}
+		err = occurrence.Snooze(input.Days, changedBy, by)
+	default:
+		date, parseErr := time.Parse("2006-01-02", input.Date)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Data inválida em date, use YYYY-MM-DD"})
+			return
+		}
+		err = occurrence.Reschedule(date, changedBy, by)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+		case errors.Is(err, models.ErrOccurrenceClosed), errors.Is(err, models.ErrOccurrenceDateTaken),
+			errors.Is(err, models.ErrInvalidTransition), errors.Is(err, models.ErrSubtasksAllDone),
+			errors.Is(err, models.ErrInvoiceEntry):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if response == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ListTaskExceptions lista as datas puladas ou remarcadas da recorrência de uma tarefa
+func ListTaskExceptions(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	task := models.TaskInstallment{ID: id}
+	if err := task.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tarefa não encontrada"})
+		return
+	}
+
+	exceptions, err := models.ListTaskExceptions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, exceptions)
+}
+
+// ListFinanceExceptions lista as datas puladas ou remarcadas da recorrência de uma finança
+func ListFinanceExceptions(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	finance := models.FinanceInstallment{ID: id}
+	if err := finance.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Finança não encontrada"})
+		return
+	}
+
+	exceptions, err := models.ListFinanceExceptions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, exceptions)
+}
+
+// DeleteOccurrenceException desfaz o pulo de uma data, que volta a ser gerada na próxima atualização
+func DeleteOccurrenceException(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	exception := models.OccurrenceException{ID: id}
+	if err := exception.Delete(); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Exceção não encontrada"})
+		case errors.Is(err, models.ErrExceptionNotSkipped):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
\ No newline at end of file
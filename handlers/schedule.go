@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+// periodInput é o corpo da criação de pausas e ausências
+type periodInput struct {
+	StartDate string  `json:"start_date" binding:"required"` // YYYY-MM-DD
+	EndDate   string  `json:"end_date" binding:"required"`   // YYYY-MM-DD
+	Reason    *string `json:"reason"`
+}
+
+// parse valida as datas do período. Em caso de erro responde 400 e retorna false.
+func (p periodInput) parse(c *gin.Context) (start, end time.Time, ok bool) {
+	start, err := time.Parse("2006-01-02", p.StartDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Data inválida em start_date, use YYYY-MM-DD"})
+		return start, end, false
+	}
+	end, err = time.Parse("2006-01-02", p.EndDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Data inválida em end_date, use YYYY-MM-DD"})
+		return start, end, false
+	}
+	if end.Before(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Período inválido: end_date anterior a start_date"})
+		return start, end, false
+	}
+	return start, end, true
+}
+
+// validateSeriesLimits confere a data final e o número máximo de ocorrências de uma tarefa ou finança
+func validateSeriesLimits(c *gin.Context, start time.Time, end *time.Time, max *int) bool {
+	if end != nil && end.Before(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A data final não pode ser anterior à data inicial"})
+		return false
+	}
+	if max != nil && *max <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_occurrences deve ser positivo"})
+		return false
+	}
+	return true
+}
+
+// CreateTaskPause pausa a geração de ocorrências de uma tarefa entre duas datas
+func CreateTaskPause(c *gin.Context) {
+	createPause(c, func(id uuid.UUID) (*models.TemplatePause, error) {
+		task := models.TaskInstallment{ID: id}
+		if err := task.Get(); err != nil {
+			return nil, err
+		}
+		return &models.TemplatePause{TaskID: &id}, nil
+	}, "Tarefa não encontrada")
+}
+
+// CreateFinancePause pausa a geração de ocorrências de uma finança entre duas datas
+func CreateFinancePause(c *gin.Context) {
+	createPause(c, func(id uuid.UUID) (*models.TemplatePause, error) {
+		finance := models.FinanceInstallment{ID: id}
+		if err := finance.Get(); err != nil {
+			return nil, err
+		}
+		return &models.TemplatePause{FinanceID: &id}, nil
+	}, "Finança não encontrada")
+}
+
+// createPause grava a pausa do modelo do ID da rota e responde quantas ocorrências em aberto foram removidas
+func createPause(c *gin.Context, load func(uuid.UUID) (*models.TemplatePause, error), notFound string) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var input periodInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start, end, ok := input.parse(c)
+	if !ok {
+		return
+	}
+
+	pause, err := load(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		return
+	}
+	pause.StartDate, pause.EndDate, pause.Reason = start, end, input.Reason
+
+	removed, err := pause.Create(actor(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"pause": pause, "removed_occurrences": removed})
+}
+
+// ListTaskPauses lista as pausas de uma tarefa
+func ListTaskPauses(c *gin.Context) {
+	listPauses(c, models.ListTaskPauses)
+}
+
+// ListFinancePauses lista as pausas de uma finança
+func ListFinancePauses(c *gin.Context) {
+	listPauses(c, models.ListFinancePauses)
+}
+
+func listPauses(c *gin.Context, list func(uuid.UUID) ([]models.TemplatePause, error)) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	pauses, err := list(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, pauses)
+}
+
+// DeletePause remove uma pausa; as datas dela voltam a ser geradas na próxima atualização
+func DeletePause(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	pause := models.TemplatePause{ID: id}
+	if err := pause.Delete(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pausa não encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// CreateAwayPeriod registra uma ausência do usuário; no período, o morador não recebe tarefas
+func CreateAwayPeriod(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var input periodInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start, end, ok := input.parse(c)
+	if !ok {
+		return
+	}
+
+	user := models.User{ID: id}
+	if err := user.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuário não encontrado"})
+		return
+	}
+
+	away := models.AwayPeriod{UserID: id, StartDate: start, EndDate: end, Reason: input.Reason}
+	removed, err := away.Create(actor(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"away_period": away, "removed_occurrences": removed})
+}
+
+// ListAwayPeriods lista as ausências da casa, com filtros opcionais de usuário e período
+func ListAwayPeriods(c *gin.Context) {
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	periods, err := models.ListAwayPeriods(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, periods)
+}
+
+// DeleteAwayPeriod remove uma ausência
+func DeleteAwayPeriod(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	away := models.AwayPeriod{ID: id}
+	if err := away.Delete(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ausência não encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
\ No newline at end of file
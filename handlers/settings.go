@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pobruno/casa360/models"
+)
+
+// GetSettings retorna as configurações da casa
+func GetSettings(c *gin.Context) {
+	settings, err := models.GetHouseholdSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings altera as configurações da casa. As ocorrências já geradas mantêm as suas datas;
+// o novo fuso vale para as próximas gerações e para o que é considerado hoje.
+func UpdateSettings(c *gin.Context) {
+	var settings models.HouseholdSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := settings.Update(); err != nil {
+		if errors.Is(err, models.ErrInvalidTimezone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
\ No newline at end of file
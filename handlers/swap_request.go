@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+// CreateSwapRequest pede a troca de uma ocorrência de tarefa com outro morador
+func CreateSwapRequest(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var input struct {
+		RequesterID        uuid.UUID  `json:"requester_id" binding:"required"`
+		TargetUserID       uuid.UUID  `json:"target_user_id" binding:"required"`
+		TargetOccurrenceID *uuid.UUID `json:"target_occurrence_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	target := models.User{ID: input.TargetUserID}
+	if err := target.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuário de destino não encontrado"})
+		return
+	}
+
+	request := models.TaskSwapRequest{
+		TaskOccurrenceID:   id,
+		RequesterID:        input.RequesterID,
+		TargetUserID:       input.TargetUserID,
+		TargetOccurrenceID: input.TargetOccurrenceID,
+	}
+	if err := request.Create(); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+		case errors.Is(err, models.ErrSwapSameUser):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, models.ErrSwapNotAssignee), errors.Is(err, models.ErrSwapOccurrenceDone):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case strings.Contains(err.Error(), "duplicate key value violates unique constraint"):
+			c.JSON(http.StatusConflict, gin.H{"error": "Já existe um pedido de troca pendente para esta ocorrência"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, request)
+}
+
+// ListSwapRequests lista os pedidos de troca, filtrando por user_id e status
+func ListSwapRequests(c *gin.Context) {
+	var userID *uuid.UUID
+	if value := c.Query("user_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido em user_id"})
+			return
+		}
+		userID = &id
+	}
+
+	status := c.Query("status")
+	switch status {
+	case "", models.SwapPending, models.SwapAccepted, models.SwapRejected, models.SwapCancelled:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status inválido, use pending, accepted, rejected ou cancelled"})
+		return
+	}
+
+	requests, err := models.ListSwapRequests(userID, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// AcceptSwapRequest aceita o pedido e troca os responsáveis das ocorrências
+func AcceptSwapRequest(c *gin.Context) {
+	resolveSwapRequest(c, (*models.TaskSwapRequest).Accept)
+}
+
+// RejectSwapRequest recusa o pedido de troca
+func RejectSwapRequest(c *gin.Context) {
+	resolveSwapRequest(c, (*models.TaskSwapRequest).Reject)
+}
+
+// CancelSwapRequest cancela o pedido de troca
+func CancelSwapRequest(c *gin.Context) {
+	resolveSwapRequest(c, (*models.TaskSwapRequest).Cancel)
+}
+
+func resolveSwapRequest(c *gin.Context, resolve func(*models.TaskSwapRequest, *uuid.UUID) error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	request := models.TaskSwapRequest{ID: id}
+	if err := resolve(&request, actor(c)); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pedido de troca não encontrado"})
+		case errors.Is(err, models.ErrSwapNotPending), errors.Is(err, models.ErrSwapNotAssignee), errors.Is(err, models.ErrSwapOccurrenceDone):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
\ No newline at end of file
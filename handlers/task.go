@@ -1,10 +1,9 @@
 package handlers
 
 import (
-	"encoding/json"
-	"io"
+	"database/sql"
+	"errors"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,11 +13,12 @@ import (
 )
 
 func CreateTask(c *gin.Context) {
-	var task models.TaskInstallment
-	if err := c.ShouldBindJSON(&task); err != nil {
+	var req taskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	task := req.TaskInstallment
 
 	// Validar expressão CRON
 	if _, err := cron.ParseStandard(task.RecurrenceCron); err != nil {
@@ -26,7 +26,23 @@ func CreateTask(c *gin.Context) {
 		return
 	}
 
-	if err := task.Create(); err != nil {
+	if !validateSubtasks(c, task.Subtasks, true) {
+		return
+	}
+
+	if !validateRotation(c, &task) {
+		return
+	}
+
+	if !validateEffort(c, &task, req.EffortPoints) {
+		return
+	}
+
+	if !validateSeriesLimits(c, task.StartDate, task.EndDate, task.MaxOccurrences) {
+		return
+	}
+
+	if err := task.Create(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -35,7 +51,12 @@ func CreateTask(c *gin.Context) {
 }
 
 func ListTasks(c *gin.Context) {
-	tasks, err := models.ListTasks()
+	archived, ok := parseArchived(c)
+	if !ok {
+		return
+	}
+
+	tasks, err := models.ListTasks(archived)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -67,11 +88,12 @@ func UpdateTask(c *gin.Context) {
 		return
 	}
 
-	var task models.TaskInstallment
-	if err := c.ShouldBindJSON(&task); err != nil {
+	var req taskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	task := req.TaskInstallment
 
 	// Validar expressão CRON
 	if _, err := cron.ParseStandard(task.RecurrenceCron); err != nil {
@@ -79,90 +101,55 @@ func UpdateTask(c *gin.Context) {
 		return
 	}
 
-	task.ID = id
-	if err := task.Update(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !validateSubtasks(c, task.Subtasks, true) {
 		return
 	}
 
-	c.JSON(http.StatusOK, task)
-}
-
-func DeleteTask(c *gin.Context) {
-	id, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+	if !validateRotation(c, &task) {
 		return
 	}
 
-	task := models.TaskInstallment{ID: id}
-	if err := task.Delete(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !validateEffort(c, &task, req.EffortPoints) {
 		return
 	}
 
-	c.Status(http.StatusNoContent)
-}
-
-func UpdateTaskOccurrences(c *gin.Context) {
-	tasks, err := models.ListTasks()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !validateSeriesLimits(c, task.StartDate, task.EndDate, task.MaxOccurrences) {
 		return
 	}
 
-	now := time.Now()
-	totalOcorrencias := 0
-	parser := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+	applyTo, occurrenceID, ok := parseApplyTo(c)
+	if !ok {
+		return
+	}
 
-	c.Stream(func(w io.Writer) bool {
-		for _, task := range tasks {
-			c.SSEvent("log", "Processando tarefa: "+task.Title+" (ID: "+task.ID.String()+")")
-			
-			schedule, err := parser.Parse(task.RecurrenceCron)
-			if err != nil {
-				c.SSEvent("error", "Erro ao parsear expressão CRON para tarefa "+task.ID.String()+": "+err.Error())
-				continue
-			}
+	task.ID = id
+	if applyTo != "" {
+		result, err := task.UpdateApplying(applyTo, occurrenceID, actor(c))
+		if err != nil {
+			respondApplyError(c, err, "Tarefa não encontrada")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"task": task, "occurrences": result})
+		return
+	}
 
-			// Começar da data de início da tarefa
-			nextTime := task.StartDate
-
-			// Gerar todas as ocorrências até a data atual
-			for nextTime.Before(now) || nextTime.Equal(now) {
-				c.SSEvent("log", "Verificando data: "+nextTime.Format("2006-01-02")+" para tarefa: "+task.Title)
-				
-				occurrence := models.TaskOccurrence{
-					TaskID:       task.ID,
-					Date:         nextTime,
-					Status:      false,
-					UserID:      task.UserID,
-					PayerGroupID: task.PayerGroupID,
-					Subtasks:    task.Subtasks,
-				}
+	if err := task.Update(actor(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-				err := occurrence.Create()
-				if err != nil {
-					if !strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
-						c.SSEvent("error", "Erro ao criar ocorrência: "+err.Error())
-					} else {
-						c.SSEvent("log", "Ocorrência já existe para data: "+nextTime.Format("2006-01-02"))
-					}
-				} else {
-					totalOcorrencias++
-					c.SSEvent("success", "Ocorrência criada para data: "+nextTime.Format("2006-01-02"))
-				}
+	c.JSON(http.StatusOK, task)
+}
 
-				nextTime = schedule.Next(nextTime)
-			}
-		}
+// DeleteTask arquiva a tarefa (soft delete), mantendo o histórico de ocorrências
+func DeleteTask(c *gin.Context) {
+	archiveRecord(c, taskRecord, "Tarefa não encontrada", true)
+}
 
-		c.SSEvent("complete", gin.H{
-			"message": "Processamento concluído",
-			"total_ocorrencias": totalOcorrencias,
-		})
-		return false
-	})
+// UpdateTaskOccurrences enfileira a geração das ocorrências das tarefas até hoje; o progresso é acompanhado
+// em GET /jobs/:id ou, opcionalmente, pelo próprio stream da resposta
+func UpdateTaskOccurrences(c *gin.Context) {
+	submitJob(c, models.JobTaskOccurrences)
 }
 
 // CreateTaskOccurrence cria uma nova ocorrência de tarefa
@@ -173,7 +160,19 @@ func CreateTaskOccurrence(c *gin.Context) {
 		return
 	}
 
-	if err := occurrence.Create(); err != nil {
+	if !validateSubtasks(c, occurrence.Subtasks, false) {
+		return
+	}
+	if occurrence.State != "" && !models.ValidTaskState(occurrence.State) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Estado inválido"})
+		return
+	}
+	if !validateTiming(c, occurrence.DurationMinutes, occurrence.ScheduledAt, occurrence.DueAt) {
+		return
+	}
+	occurrence.SyncStatus()
+
+	if err := occurrence.Create(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -197,34 +196,109 @@ func UpdateTaskOccurrence(c *gin.Context) {
 	}
 
 	// Em seguida, vincular apenas os campos que foram enviados
-	var updateData map[string]interface{}
-	if err := c.ShouldBindJSON(&updateData); err != nil {
+	var input struct {
+		Status          *bool            `json:"status"`
+		UserID          *uuid.UUID       `json:"user_id"`
+		PayerGroupID    *uuid.UUID       `json:"payer_group_id"`
+		Subtasks        *models.Subtasks `json:"subtasks"`
+		CompletedBy     *uuid.UUID       `json:"completed_by"` // quem concluiu; padrão: responsável pela ocorrência
+		State           *string          `json:"state"`
+		ChangedBy       *uuid.UUID       `json:"changed_by"` // quem alterou o estado
+		ScheduledAt     *time.Time       `json:"scheduled_at"`
+		DurationMinutes *int             `json:"duration_minutes"`
+		DueAt           *time.Time       `json:"due_at"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Atualizar apenas os campos que foram enviados
-	if status, exists := updateData["status"]; exists {
-		existingOccurrence.Status = status.(bool)
+	// Novo início ou duração sem prazo informado recalculam o prazo
+	if input.ScheduledAt != nil || input.DurationMinutes != nil {
+		if input.ScheduledAt != nil {
+			existingOccurrence.ScheduledAt = input.ScheduledAt
+		}
+		if input.DurationMinutes != nil {
+			existingOccurrence.DurationMinutes = input.DurationMinutes
+		}
+		if input.DueAt == nil && existingOccurrence.DurationMinutes != nil {
+			existingOccurrence.DueAt = nil
+			existingOccurrence.FillDueAt()
+		}
+	}
+	if input.DueAt != nil {
+		existingOccurrence.DueAt = input.DueAt
 	}
-	if userID, exists := updateData["user_id"]; exists && userID != nil {
-		id, err := uuid.Parse(userID.(string))
-		if err == nil {
-			existingOccurrence.UserID = id
+	if !validateTiming(c, existingOccurrence.DurationMinutes, existingOccurrence.ScheduledAt, existingOccurrence.DueAt) {
+		return
+	}
+
+	for _, userID := range []*uuid.UUID{input.CompletedBy, input.ChangedBy} {
+		if userID == nil {
+			continue
 		}
+		user := models.User{ID: *userID}
+		if err := user.Get(); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Usuário não encontrado"})
+			return
+		}
+	}
+	if input.State != nil && !models.ValidTaskState(*input.State) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Estado inválido"})
+		return
+	}
+
+	// Atualizar apenas os campos que foram enviados
+	wasDone := existingOccurrence.Status
+	if input.UserID != nil {
+		existingOccurrence.UserID = *input.UserID
 	}
-	if payerGroupID, exists := updateData["payer_group_id"]; exists && payerGroupID != nil {
-		id, err := uuid.Parse(payerGroupID.(string))
-		if err == nil {
-			existingOccurrence.PayerGroupID = id
+	if input.PayerGroupID != nil {
+		existingOccurrence.PayerGroupID = *input.PayerGroupID
+	}
+	if input.Subtasks != nil {
+		if !validateSubtasks(c, *input.Subtasks, false) {
+			return
 		}
+		existingOccurrence.Subtasks = *input.Subtasks
+	}
+	// Quem alterou o estado (sem changed_by, vale quem concluiu) e quem recebe o crédito de uma
+	// nova conclusão (sem completed_by, vale quem alterou e, por fim, o responsável)
+	changedBy := input.ChangedBy
+	if changedBy == nil {
+		changedBy = input.CompletedBy
+	}
+	completer := input.CompletedBy
+	if completer == nil && !wasDone {
+		completer = changedBy
 	}
-	if subtasks, exists := updateData["subtasks"]; exists && subtasks != nil {
-		existingOccurrence.Subtasks = subtasks.(json.RawMessage)
+	if input.State != nil {
+		// O estado prevalece sobre o status, que passa a ser derivado dele
+		if !changeState(c, &existingOccurrence, *input.State, completer) {
+			return
+		}
+	} else if input.Status != nil {
+		existingOccurrence.Status = *input.Status
+		// Com subtarefas, concluir a ocorrência conclui as pendentes; reabrir exige desmarcar uma delas
+		if len(existingOccurrence.Subtasks) > 0 {
+			if *input.Status {
+				by := completer
+				if by == nil {
+					by = &existingOccurrence.UserID
+				}
+				existingOccurrence.Subtasks.Complete(by)
+			} else if existingOccurrence.Subtasks.AllDone() {
+				c.JSON(http.StatusConflict, gin.H{"error": "Todas as subtarefas estão concluídas; desmarque uma delas para reabrir a ocorrência"})
+				return
+			}
+		}
 	}
+	existingOccurrence.SyncStatus()
+	existingOccurrence.TrackCompletion(wasDone, completer)
+	existingOccurrence.StateChangedBy = changedBy
 
 	// Agora atualizar a ocorrência
-	if err := existingOccurrence.Update(); err != nil {
+	if err := existingOccurrence.Update(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -232,6 +306,227 @@ func UpdateTaskOccurrence(c *gin.Context) {
 	c.JSON(http.StatusOK, existingOccurrence)
 }
 
+// ToggleTaskSubtask marca ou desmarca uma subtarefa de uma ocorrência. Sem "done" no corpo,
+// o estado atual é invertido; o status da ocorrência é recalculado a partir das subtarefas.
+func ToggleTaskSubtask(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+	subtaskID, err := uuid.Parse(c.Param("subtask_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de subtarefa inválido"})
+		return
+	}
+
+	var input struct {
+		Done   *bool      `json:"done"`
+		UserID *uuid.UUID `json:"user_id"` // quem concluiu; padrão: o autor da requisição (X-User-ID) ou o responsável
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	occurrence := models.TaskOccurrence{ID: id}
+	if err := occurrence.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+		return
+	}
+
+	completedBy := input.UserID
+	if completedBy == nil {
+		completedBy = actor(c)
+	}
+	if completedBy == nil {
+		completedBy = &occurrence.UserID
+	} else {
+		user := models.User{ID: *completedBy}
+		if err := user.Get(); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Usuário não encontrado"})
+			return
+		}
+	}
+
+	subtask, err := occurrence.ToggleSubtask(subtaskID, input.Done, completedBy, actor(c))
+	if err != nil {
+		if errors.Is(err, models.ErrSubtaskNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subtarefa não encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subtask":    subtask,
+		"occurrence": occurrence,
+	})
+}
+
+// ChangeTaskOccurrenceState aplica uma transição de estado (pending, in_progress, done, skipped, overdue, cancelled)
+func ChangeTaskOccurrenceState(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var input struct {
+		State  string     `json:"state" binding:"required"`
+		UserID *uuid.UUID `json:"user_id"` // quem alterou (padrão: X-User-ID); na conclusão, recebe os pontos de esforço
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.ValidTaskState(input.State) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Estado inválido"})
+		return
+	}
+	if input.UserID != nil {
+		user := models.User{ID: *input.UserID}
+		if err := user.Get(); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Usuário não encontrado"})
+			return
+		}
+	}
+
+	changedBy := input.UserID
+	if changedBy == nil {
+		changedBy = actor(c)
+	}
+
+	occurrence := models.TaskOccurrence{ID: id}
+	if err := occurrence.ChangeState(input.State, changedBy, actor(c)); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+		case errors.Is(err, models.ErrInvalidTransition), errors.Is(err, models.ErrSubtasksAllDone):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "state": occurrence.State})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, occurrence)
+}
+
+// changeState aplica a transição em memória, respondendo 409 quando ela não é permitida
+func changeState(c *gin.Context, occurrence *models.TaskOccurrence, state string, by *uuid.UUID) bool {
+	if err := occurrence.SetState(state, by); err != nil {
+		if errors.Is(err, models.ErrInvalidTransition) || errors.Is(err, models.ErrSubtasksAllDone) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "state": occurrence.State})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// GetTaskOccurrenceHistory retorna o histórico de estados de uma ocorrência
+func GetTaskOccurrenceHistory(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	occurrence := models.TaskOccurrence{ID: id}
+	if err := occurrence.Get(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ocorrência não encontrada"})
+		return
+	}
+
+	history, err := occurrence.StateHistory()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// SweepOverdueTaskOccurrences executa sob demanda a varredura de ocorrências atrasadas
+func SweepOverdueTaskOccurrences(c *gin.Context) {
+	count, err := models.SweepOverdueTaskOccurrences()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"overdue": count})
+}
+
+// validateSubtasks normaliza as subtarefas enviadas e confere se os responsáveis existem
+func validateSubtasks(c *gin.Context, subtasks models.Subtasks, template bool) bool {
+	if err := subtasks.Normalize(template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	for _, userID := range subtasks.Assignees() {
+		user := models.User{ID: userID}
+		if err := user.Get(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Usuário da subtarefa não encontrado: " + userID.String()})
+			return false
+		}
+	}
+	return true
+}
+
+// validateRotation confere a estratégia de rodízio; qualquer rodízio exige um grupo de pagadores
+func validateRotation(c *gin.Context, task *models.TaskInstallment) bool {
+	if task.Rotation == "" {
+		task.Rotation = models.RotationNone
+	}
+	if !models.ValidRotation(task.Rotation) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Estratégia de rodízio inválida"})
+		return false
+	}
+	if task.Rotation != models.RotationNone && task.PayerGroupID == uuid.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "O rodízio exige um grupo de pagadores"})
+		return false
+	}
+	return true
+}
+
+// taskRequest é o corpo de criação e edição de uma tarefa; effort_points é ponteiro para distinguir 0 de ausente
+type taskRequest struct {
+	models.TaskInstallment
+	EffortPoints *int `json:"effort_points"`
+}
+
+// validateEffort confere os pontos de esforço e o tempo estimado; sem pontos informados, a tarefa vale 1
+func validateEffort(c *gin.Context, task *models.TaskInstallment, points *int) bool {
+	task.EffortPoints = 1
+	if points != nil {
+		task.EffortPoints = *points
+	}
+	if task.EffortPoints < 0 || task.EstimatedMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pontos de esforço e tempo estimado não podem ser negativos"})
+		return false
+	}
+	return validateTiming(c, task.DurationMinutes, nil, nil)
+}
+
+// validateTiming confere a duração e o prazo de uma tarefa ou ocorrência
+func validateTiming(c *gin.Context, durationMinutes *int, scheduledAt, dueAt *time.Time) bool {
+	if durationMinutes != nil && *durationMinutes <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duration_minutes deve ser positivo"})
+		return false
+	}
+	if scheduledAt != nil && dueAt != nil && dueAt.Before(*scheduledAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "O prazo (due_at) não pode ser anterior ao início (scheduled_at)"})
+		return false
+	}
+	return true
+}
+
 // DeleteTaskOccurrence remove uma ocorrência de tarefa
 func DeleteTaskOccurrence(c *gin.Context) {
 	id, err := uuid.Parse(c.Param("id"))
@@ -241,7 +536,7 @@ func DeleteTaskOccurrence(c *gin.Context) {
 	}
 
 	occurrence := models.TaskOccurrence{ID: id}
-	if err := occurrence.Delete(); err != nil {
+	if err := occurrence.Delete(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -264,6 +559,14 @@ func GenerateTaskOccurrences(c *gin.Context) {
 	}
 
 	if err := task.GenerateOccurrences(); err != nil {
+		if errors.Is(err, models.ErrRotationWithoutMembers) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrArchived) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -15,7 +15,11 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
-	if err := user.Create(); err != nil {
+	if !validatePixKey(c, user.PixKey) {
+		return
+	}
+
+	if err := user.Create(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -24,7 +28,12 @@ func CreateUser(c *gin.Context) {
 }
 
 func ListUsers(c *gin.Context) {
-	users, err := models.ListUsers()
+	archived, ok := parseArchived(c)
+	if !ok {
+		return
+	}
+
+	users, err := models.ListUsers(archived)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -62,8 +71,12 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
+	if !validatePixKey(c, user.PixKey) {
+		return
+	}
+
 	user.ID = id
-	if err := user.Update(); err != nil {
+	if err := user.Update(actor(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -71,18 +84,7 @@ func UpdateUser(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
+// DeleteUser arquiva o usuário (soft delete); o histórico que o referencia é preservado
 func DeleteUser(c *gin.Context) {
-	id, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
-		return
-	}
-
-	user := models.User{ID: id}
-	if err := user.Delete(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.Status(http.StatusNoContent)
+	archiveRecord(c, userRecord, "Usuário não encontrado", true)
 } 
\ No newline at end of file
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/models"
+)
+
+// webhookInput são os campos aceitos na criação e na alteração de uma assinatura
+type webhookInput struct {
+	URL         *string  `json:"url"`
+	Secret      *string  `json:"secret"`
+	Events      []string `json:"events"`
+	Description *string  `json:"description"`
+	Enabled     *bool    `json:"enabled"`
+}
+
+// apply copia os campos enviados para a assinatura e a valida
+func (input webhookInput) apply(c *gin.Context, subscription *models.WebhookSubscription) bool {
+	if input.URL != nil {
+		subscription.URL = strings.TrimSpace(*input.URL)
+	}
+	if input.Secret != nil {
+		subscription.Secret = *input.Secret
+	}
+	if input.Events != nil {
+		subscription.Events = input.Events
+	}
+	if input.Description != nil {
+		subscription.Description = input.Description
+	}
+	if input.Enabled != nil {
+		subscription.Enabled = *input.Enabled
+	}
+
+	if u, err := url.Parse(subscription.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url deve ser um endereço http ou https"})
+		return false
+	}
+	for _, event := range subscription.Events {
+		if !models.ValidWebhookEvent(event) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Evento desconhecido em events: " + event})
+			return false
+		}
+	}
+	return true
+}
+
+// CreateWebhook cria uma assinatura de webhook. Sem secret, um segredo aleatório é gerado e devolvido.
+func CreateWebhook(c *gin.Context) {
+	var input webhookInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subscription := models.WebhookSubscription{Enabled: true}
+	if !input.apply(c, &subscription) {
+		return
+	}
+	if err := subscription.Create(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, subscription)
+}
+
+// ListWebhooks lista as assinaturas de webhook
+func ListWebhooks(c *gin.Context) {
+	subscriptions, err := models.ListWebhookSubscriptions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+// loadWebhook busca a assinatura da rota, respondendo 400 ou 404 quando não encontra
+func loadWebhook(c *gin.Context) (*models.WebhookSubscription, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return nil, false
+	}
+	subscription := &models.WebhookSubscription{ID: id}
+	if err := subscription.Get(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook não encontrado"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return subscription, true
+}
+
+// GetWebhook retorna uma assinatura de webhook
+func GetWebhook(c *gin.Context) {
+	subscription, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+// UpdateWebhook altera os campos enviados da assinatura
+func UpdateWebhook(c *gin.Context) {
+	subscription, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	var input webhookInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Secret != nil && *input.Secret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "secret não pode ser vazio"})
+		return
+	}
+	if !input.apply(c, subscription) {
+		return
+	}
+
+	if err := subscription.Update(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+// DeleteWebhook remove a assinatura e o seu log de entregas
+func DeleteWebhook(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	subscription := models.WebhookSubscription{ID: id}
+	if err := subscription.Delete(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook não encontrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries retorna o log de entregas da assinatura (filtros: status, from e to)
+func ListWebhookDeliveries(c *gin.Context) {
+	subscription, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	status := c.Query("status")
+	if status != "" && !models.ValidDeliveryStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status deve ser pending, delivered ou failed"})
+		return
+	}
+	filter, ok := parseListFilter(c)
+	if !ok {
+		return
+	}
+
+	deliveries, err := models.ListWebhookDeliveries(subscription.ID, status, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// RetryWebhookDelivery agenda uma nova tentativa imediata da entrega, inclusive das que já falharam
+// ou foram entregues
+func RetryWebhookDelivery(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	delivery := models.WebhookDelivery{ID: id}
+	if err := delivery.Retry(); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Entrega não encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// DispatchWebhooks distribui os eventos pendentes e tenta as entregas vencidas, sem esperar o job
+func DispatchWebhooks(c *gin.Context) {
+	result, err := models.DispatchWebhooks(c.Request.Context(), nil)
+	if err != nil {
+		if errors.Is(err, models.ErrWebhookRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
\ No newline at end of file
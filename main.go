@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/pobruno/casa360/config"
 	"github.com/pobruno/casa360/handlers"
+	"github.com/pobruno/casa360/models"
+	"github.com/pobruno/casa360/notify"
+	"github.com/pobruno/casa360/storage"
+	"github.com/robfig/cron/v3"
 )
 
 func main() {
@@ -19,6 +30,34 @@ func main() {
 	// Inicializa o banco de dados
 	config.InitDB()
 
+	// Comandos de linha (backup e restore) não iniciam o servidor
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Valida o armazenamento dos anexos antes de aceitar uploads
+	if _, err := storage.Default(); err != nil {
+		log.Fatal("Erro ao configurar o armazenamento de anexos: ", err)
+	}
+
+	// Inicia as rotinas em segundo plano
+	jobs, err := startJobs()
+	if err != nil {
+		log.Fatal("Erro ao agendar rotinas: ", err)
+	}
+	defer jobs.Stop()
+
+	// Escuta as alterações publicadas pelo banco para o stream GET /events
+	models.Changes()
+
+	// Marca como falhos os jobs que ficaram pela metade quando o servidor parou
+	if err := models.RecoverJobs(); err != nil {
+		log.Printf("Erro ao recuperar jobs interrompidos: %v", err)
+	}
+
 	// Inicializa o router
 	r := gin.Default()
 
@@ -37,7 +76,263 @@ func main() {
 	}
 }
 
+// timezoneCheck é o intervalo em que o fuso da casa é conferido, para seguir alterações feitas por outra
+// réplica ou por uma restauração de backup
+const timezoneCheck = time.Minute
+
+// scheduledJobs mantém o agendador das rotinas periódicas no fuso da casa. Quando o fuso muda, o agendador
+// é recriado no novo fuso.
+type scheduledJobs struct {
+	mu        sync.Mutex
+	scheduler *cron.Cron
+	loc       *time.Location
+}
+
+// startJobs agenda as rotinas periódicas no fuso da casa (expressões com CRON_TZ= usam o próprio fuso) e
+// passa a acompanhar as alterações do fuso. A varredura de atrasadas roda também na inicialização, para
+// cobrir os dias em que o servidor esteve parado.
+func startJobs() (*scheduledJobs, error) {
+	loc, err := models.Location()
+	if err != nil {
+		log.Printf("Erro ao ler o fuso da casa, usando o fuso do servidor: %v", err)
+		loc = time.Local
+	}
+
+	scheduler, err := newScheduler(loc)
+	if err != nil {
+		return nil, err
+	}
+	go sweepOverdue()
+	scheduler.Start()
+
+	jobs := &scheduledJobs{scheduler: scheduler, loc: loc}
+	go jobs.watchTimezone()
+	return jobs, nil
+}
+
+// Stop interrompe o agendador atual
+func (j *scheduledJobs) Stop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.scheduler.Stop()
+}
+
+// watchTimezone recria o agendador quando PUT /settings altera o fuso e, a cada timezoneCheck, confere se
+// ele mudou por outro caminho
+func (j *scheduledJobs) watchTimezone() {
+	ticker := time.NewTicker(timezoneCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-models.SettingsUpdated():
+		case <-ticker.C:
+		}
+
+		loc, err := models.Location()
+		if err != nil {
+			log.Printf("Erro ao ler o fuso da casa: %v", err)
+			continue
+		}
+		if err := j.reschedule(loc); err != nil {
+			log.Printf("Erro ao reagendar rotinas: %v", err)
+		}
+	}
+}
+
+// reschedule troca o agendador por um no fuso informado, se ele for diferente do atual. A varredura de
+// atrasadas roda em seguida, já que o "hoje" da casa pode ter mudado.
+func (j *scheduledJobs) reschedule(loc *time.Location) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if loc.String() == j.loc.String() {
+		return nil
+	}
+
+	scheduler, err := newScheduler(loc)
+	if err != nil {
+		return err
+	}
+	j.scheduler.Stop()
+	scheduler.Start()
+	j.scheduler, j.loc = scheduler, loc
+	log.Printf("Rotinas reagendadas no fuso %s", loc)
+	go sweepOverdue()
+	return nil
+}
+
+// newScheduler cria o agendador das rotinas no fuso informado, sem iniciá-lo. O envio de notificações roda a
+// cada NOTIFY_CRON, a entrega de webhooks, a cada WEBHOOK_CRON, e a remoção dos arquivos de anexos
+// apagados, a cada ATTACHMENT_PURGE_CRON.
+func newScheduler(loc *time.Location) (*cron.Cron, error) {
+	sweepCron := os.Getenv("OVERDUE_SWEEP_CRON")
+	if sweepCron == "" {
+		sweepCron = "5 0 * * *" // todo dia às 00:05
+	}
+	notifyCron := os.Getenv("NOTIFY_CRON")
+	if notifyCron == "" {
+		notifyCron = "*/5 * * * *" // a cada 5 minutos
+	}
+	webhookCron := os.Getenv("WEBHOOK_CRON")
+	if webhookCron == "" {
+		webhookCron = "@every 30s"
+	}
+	purgeCron := os.Getenv("ATTACHMENT_PURGE_CRON")
+	if purgeCron == "" {
+		purgeCron = "@hourly"
+	}
+
+	scheduler := cron.New(cron.WithLocation(loc))
+	if _, err := scheduler.AddFunc(sweepCron, sweepOverdue); err != nil {
+		return nil, fmt.Errorf("OVERDUE_SWEEP_CRON inválida: %v", err)
+	}
+	if _, err := scheduler.AddFunc(notifyCron, dispatchNotifications); err != nil {
+		return nil, fmt.Errorf("NOTIFY_CRON inválida: %v", err)
+	}
+	if _, err := scheduler.AddFunc(webhookCron, dispatchWebhooks); err != nil {
+		return nil, fmt.Errorf("WEBHOOK_CRON inválida: %v", err)
+	}
+	if _, err := scheduler.AddFunc(purgeCron, purgeAttachments); err != nil {
+		return nil, fmt.Errorf("ATTACHMENT_PURGE_CRON inválida: %v", err)
+	}
+	return scheduler, nil
+}
+
+func sweepOverdue() {
+	count, err := models.SweepOverdueTaskOccurrences()
+	if err != nil {
+		log.Printf("Erro na varredura de ocorrências atrasadas: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("%d ocorrências de tarefas marcadas como atrasadas", count)
+	}
+}
+
+func dispatchNotifications() {
+	result, err := models.DispatchNotifications(context.Background(), notify.Default())
+	if err != nil {
+		log.Printf("Erro no envio de notificações: %v", err)
+		return
+	}
+	if result.Sent > 0 || result.Failed > 0 {
+		log.Printf("Notificações: %d enviadas, %d com falha, %d adiadas", result.Sent, result.Failed, result.Deferred)
+	}
+}
+
+func dispatchWebhooks() {
+	result, err := models.DispatchWebhooks(context.Background(), nil)
+	if err != nil {
+		if !errors.Is(err, models.ErrWebhookRunning) {
+			log.Printf("Erro na entrega de webhooks: %v", err)
+		}
+		return
+	}
+	if result.Delivered > 0 || result.Retrying > 0 || result.Failed > 0 {
+		log.Printf("Webhooks: %d entregues, %d a repetir, %d com falha", result.Delivered, result.Retrying, result.Failed)
+	}
+}
+
+func purgeAttachments() {
+	store, err := storage.Default()
+	if err != nil {
+		log.Printf("Erro ao remover arquivos de anexos: %v", err)
+		return
+	}
+	count, err := models.PurgeAttachmentBlobs(context.Background(), store)
+	if err != nil {
+		log.Printf("Erro ao remover arquivos de anexos: %v", err)
+	}
+	if count > 0 {
+		log.Printf("%d arquivos de anexos removidos do armazenamento", count)
+	}
+}
+
+// runCommand executa "backup [arquivo]" (padrão: saída padrão), "restore <arquivo>", "sweep-overdue", "notify",
+// "webhooks" ou "purge-attachments"
+func runCommand(args []string) error {
+	switch args[0] {
+	case "backup":
+		backup, err := models.ExportBackup()
+		if err != nil {
+			return err
+		}
+
+		var out io.Writer = os.Stdout
+		if len(args) > 1 {
+			file, err := os.Create(args[1])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(backup)
+	case "restore":
+		if len(args) < 2 {
+			return fmt.Errorf("uso: restore <arquivo>")
+		}
+		file, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		var backup models.Backup
+		if err := json.NewDecoder(file).Decode(&backup); err != nil {
+			return err
+		}
+		result, err := models.RestoreBackup(&backup)
+		if err != nil {
+			return err
+		}
+		for table, count := range result.Counts {
+			log.Printf("%s: %d registros restaurados", table, count)
+		}
+		return nil
+	case "sweep-overdue":
+		count, err := models.SweepOverdueTaskOccurrences()
+		if err != nil {
+			return err
+		}
+		log.Printf("%d ocorrências de tarefas marcadas como atrasadas", count)
+		return nil
+	case "notify":
+		result, err := models.DispatchNotifications(context.Background(), notify.Default())
+		if err != nil {
+			return err
+		}
+		log.Printf("Notificações: %d enviadas, %d com falha, %d adiadas", result.Sent, result.Failed, result.Deferred)
+		return nil
+	case "webhooks":
+		result, err := models.DispatchWebhooks(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		log.Printf("Webhooks: %d eventos, %d entregues, %d a repetir, %d com falha", result.Events, result.Delivered, result.Retrying, result.Failed)
+		return nil
+	case "purge-attachments":
+		store, err := storage.Default()
+		if err != nil {
+			return err
+		}
+		count, err := models.PurgeAttachmentBlobs(context.Background(), store)
+		log.Printf("%d arquivos de anexos removidos do armazenamento", count)
+		return err
+	default:
+		return fmt.Errorf("comando desconhecido: %s (use backup, restore, sweep-overdue, notify, webhooks ou purge-attachments)", args[0])
+	}
+}
+
 func setupRoutes(r *gin.Engine) {
+	// Autor das alterações (cabeçalho X-User-ID), registrado no log de auditoria
+	r.Use(handlers.Actor())
+
+	// Grupo de rotas para configurações da casa
+	setupSettingsRoutes(r)
+
 	// Grupo de rotas para usuários
 	setupUserRoutes(r)
 
@@ -53,11 +348,66 @@ func setupRoutes(r *gin.Engine) {
 	// Grupo de rotas para tarefas
 	setupTaskRoutes(r)
 
+	// Grupo de rotas para pedidos de troca de tarefas
+	setupSwapRequestRoutes(r)
+
 	// Grupo de rotas para finanças
 	setupFinanceRoutes(r)
 
+	// Grupo de rotas para pular, adiar e remarcar ocorrências
+	setupRescheduleRoutes(r)
+
+	// Grupo de rotas para pausas de modelos e ausências de moradores
+	setupScheduleRoutes(r)
+
 	// Grupo de rotas para dashboard e carteiras
 	setupDashboardRoutes(r)
+
+	// Grupo de rotas para importação de extratos bancários
+	setupBankImportRoutes(r)
+
+	// Grupo de rotas para Pix e acertos entre moradores
+	setupPixRoutes(r)
+
+	// Grupo de rotas para exportação em CSV e XLSX
+	setupExportRoutes(r)
+
+	// Grupo de rotas para assinatura de calendários (ICS)
+	setupCalendarRoutes(r)
+
+	// Grupo de rotas para notificações
+	setupNotificationRoutes(r)
+
+	// Grupo de rotas para webhooks de eventos
+	setupWebhookRoutes(r)
+
+	// Grupo de rotas para o stream de alterações (SSE)
+	setupEventRoutes(r)
+
+	// Grupo de rotas para jobs em segundo plano
+	setupJobRoutes(r)
+
+	// Grupo de rotas para o log de auditoria
+	setupAuditRoutes(r)
+
+	// Grupo de rotas para anexos (comprovantes, notas fiscais e fotos)
+	setupAttachmentRoutes(r)
+
+	// Grupo de rotas para importação de notas fiscais (NF-e e NFC-e)
+	setupInvoiceRoutes(r)
+
+	// Grupo de rotas para backup e restauração
+	setupBackupRoutes(r)
+}
+
+func setupSettingsRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.GET("/settings", handlers.GetSettings)
+	r.PUT("/settings", handlers.UpdateSettings)
+
+	// Rotas com barra final
+	r.GET("/settings/", handlers.GetSettings)
+	r.PUT("/settings/", handlers.UpdateSettings)
 }
 
 func setupUserRoutes(r *gin.Engine) {
@@ -67,6 +417,8 @@ func setupUserRoutes(r *gin.Engine) {
 	r.GET("/users/:id", handlers.GetUser)
 	r.PUT("/users/:id", handlers.UpdateUser)
 	r.DELETE("/users/:id", handlers.DeleteUser)
+	r.POST("/users/:id/archive", handlers.ArchiveUser)
+	r.POST("/users/:id/restore", handlers.RestoreUser)
 
 	// Rotas com barra final
 	r.POST("/users/", handlers.CreateUser)
@@ -74,6 +426,8 @@ func setupUserRoutes(r *gin.Engine) {
 	r.GET("/users/:id/", handlers.GetUser)
 	r.PUT("/users/:id/", handlers.UpdateUser)
 	r.DELETE("/users/:id/", handlers.DeleteUser)
+	r.POST("/users/:id/archive/", handlers.ArchiveUser)
+	r.POST("/users/:id/restore/", handlers.RestoreUser)
 }
 
 func setupPayerGroupRoutes(r *gin.Engine) {
@@ -83,9 +437,12 @@ func setupPayerGroupRoutes(r *gin.Engine) {
 	r.GET("/payer-groups/:id", handlers.GetPayerGroup)
 	r.PUT("/payer-groups/:id", handlers.UpdatePayerGroup)
 	r.DELETE("/payer-groups/:id", handlers.DeletePayerGroup)
+	r.POST("/payer-groups/:id/archive", handlers.ArchivePayerGroup)
+	r.POST("/payer-groups/:id/restore", handlers.RestorePayerGroup)
 	r.POST("/payer-groups/:id/members", handlers.CreatePayerGroupMember)
 	r.GET("/payer-groups/:id/members", handlers.ListPayerGroupMembers)
 	r.DELETE("/payer-groups/:id/members/:member_id", handlers.DeletePayerGroupMember)
+	r.GET("/payer-groups/:id/fairness", handlers.GetPayerGroupFairness)
 
 	// Rotas com barra final
 	r.POST("/payer-groups/", handlers.CreatePayerGroup)
@@ -93,29 +450,44 @@ func setupPayerGroupRoutes(r *gin.Engine) {
 	r.GET("/payer-groups/:id/", handlers.GetPayerGroup)
 	r.PUT("/payer-groups/:id/", handlers.UpdatePayerGroup)
 	r.DELETE("/payer-groups/:id/", handlers.DeletePayerGroup)
+	r.POST("/payer-groups/:id/archive/", handlers.ArchivePayerGroup)
+	r.POST("/payer-groups/:id/restore/", handlers.RestorePayerGroup)
 	r.POST("/payer-groups/:id/members/", handlers.CreatePayerGroupMember)
 	r.GET("/payer-groups/:id/members/", handlers.ListPayerGroupMembers)
 	r.DELETE("/payer-groups/:id/members/:member_id/", handlers.DeletePayerGroupMember)
+	r.GET("/payer-groups/:id/fairness/", handlers.GetPayerGroupFairness)
 }
 
 func setupFinanceCCRoutes(r *gin.Engine) {
 	// Rotas sem barra final
 	r.POST("/finance-cc", handlers.CreateFinanceCC)
 	r.GET("/finance-cc", handlers.ListFinanceCCs)
+	r.DELETE("/finance-cc/:id", handlers.DeleteFinanceCC)
+	r.POST("/finance-cc/:id/archive", handlers.ArchiveFinanceCC)
+	r.POST("/finance-cc/:id/restore", handlers.RestoreFinanceCC)
 
 	// Rotas com barra final
 	r.POST("/finance-cc/", handlers.CreateFinanceCC)
 	r.GET("/finance-cc/", handlers.ListFinanceCCs)
+	r.DELETE("/finance-cc/:id/", handlers.DeleteFinanceCC)
+	r.POST("/finance-cc/:id/archive/", handlers.ArchiveFinanceCC)
+	r.POST("/finance-cc/:id/restore/", handlers.RestoreFinanceCC)
 }
 
 func setupCurrencyRoutes(r *gin.Engine) {
 	// Rotas sem barra final
 	r.POST("/currencies", handlers.CreateFinanceCurrency)
 	r.GET("/currencies", handlers.ListFinanceCurrencies)
+	r.DELETE("/currencies/:id", handlers.DeleteFinanceCurrency)
+	r.POST("/currencies/:id/archive", handlers.ArchiveFinanceCurrency)
+	r.POST("/currencies/:id/restore", handlers.RestoreFinanceCurrency)
 
 	// Rotas com barra final
 	r.POST("/currencies/", handlers.CreateFinanceCurrency)
 	r.GET("/currencies/", handlers.ListFinanceCurrencies)
+	r.DELETE("/currencies/:id/", handlers.DeleteFinanceCurrency)
+	r.POST("/currencies/:id/archive/", handlers.ArchiveFinanceCurrency)
+	r.POST("/currencies/:id/restore/", handlers.RestoreFinanceCurrency)
 }
 
 func setupTaskRoutes(r *gin.Engine) {
@@ -125,6 +497,8 @@ func setupTaskRoutes(r *gin.Engine) {
 	r.GET("/tasks/:id", handlers.GetTask)
 	r.PUT("/tasks/:id", handlers.UpdateTask)
 	r.DELETE("/tasks/:id", handlers.DeleteTask)
+	r.POST("/tasks/:id/archive", handlers.ArchiveTask)
+	r.POST("/tasks/:id/restore", handlers.RestoreTask)
 	r.POST("/tasks/update-occurrences", handlers.UpdateTaskOccurrences)
 
 	// Ocorrências de tarefas
@@ -133,6 +507,10 @@ func setupTaskRoutes(r *gin.Engine) {
 	r.GET("/task-occurrences", handlers.ListTaskOccurrences)
 	r.PUT("/task-occurrences/:id", handlers.UpdateTaskOccurrence)
 	r.DELETE("/task-occurrences/:id", handlers.DeleteTaskOccurrence)
+	r.POST("/task-occurrences/:id/subtasks/:subtask_id/toggle", handlers.ToggleTaskSubtask)
+	r.POST("/task-occurrences/:id/state", handlers.ChangeTaskOccurrenceState)
+	r.GET("/task-occurrences/:id/history", handlers.GetTaskOccurrenceHistory)
+	r.POST("/task-occurrences/sweep-overdue", handlers.SweepOverdueTaskOccurrences)
 
 	// Rotas com barra final
 	r.POST("/tasks/", handlers.CreateTask)
@@ -140,6 +518,8 @@ func setupTaskRoutes(r *gin.Engine) {
 	r.GET("/tasks/:id/", handlers.GetTask)
 	r.PUT("/tasks/:id/", handlers.UpdateTask)
 	r.DELETE("/tasks/:id/", handlers.DeleteTask)
+	r.POST("/tasks/:id/archive/", handlers.ArchiveTask)
+	r.POST("/tasks/:id/restore/", handlers.RestoreTask)
 	r.POST("/tasks/update-occurrences/", handlers.UpdateTaskOccurrences)
 
 	// Ocorrências de tarefas com barra final
@@ -148,6 +528,172 @@ func setupTaskRoutes(r *gin.Engine) {
 	r.GET("/task-occurrences/", handlers.ListTaskOccurrences)
 	r.PUT("/task-occurrences/:id/", handlers.UpdateTaskOccurrence)
 	r.DELETE("/task-occurrences/:id/", handlers.DeleteTaskOccurrence)
+	r.POST("/task-occurrences/:id/subtasks/:subtask_id/toggle/", handlers.ToggleTaskSubtask)
+	r.POST("/task-occurrences/:id/state/", handlers.ChangeTaskOccurrenceState)
+	r.GET("/task-occurrences/:id/history/", handlers.GetTaskOccurrenceHistory)
+	r.POST("/task-occurrences/sweep-overdue/", handlers.SweepOverdueTaskOccurrences)
+}
+
+func setupSwapRequestRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.POST("/task-occurrences/:id/swap-requests", handlers.CreateSwapRequest)
+	r.GET("/swap-requests", handlers.ListSwapRequests)
+	r.POST("/swap-requests/:id/accept", handlers.AcceptSwapRequest)
+	r.POST("/swap-requests/:id/reject", handlers.RejectSwapRequest)
+	r.POST("/swap-requests/:id/cancel", handlers.CancelSwapRequest)
+
+	// Rotas com barra final
+	r.POST("/task-occurrences/:id/swap-requests/", handlers.CreateSwapRequest)
+	r.GET("/swap-requests/", handlers.ListSwapRequests)
+	r.POST("/swap-requests/:id/accept/", handlers.AcceptSwapRequest)
+	r.POST("/swap-requests/:id/reject/", handlers.RejectSwapRequest)
+	r.POST("/swap-requests/:id/cancel/", handlers.CancelSwapRequest)
+}
+
+func setupRescheduleRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.POST("/task-occurrences/:id/skip", handlers.SkipTaskOccurrence)
+	r.POST("/task-occurrences/:id/snooze", handlers.SnoozeTaskOccurrence)
+	r.POST("/task-occurrences/:id/reschedule", handlers.RescheduleTaskOccurrence)
+	r.POST("/finance-occurrences/:id/skip", handlers.SkipFinanceOccurrence)
+	r.POST("/finance-occurrences/:id/snooze", handlers.SnoozeFinanceOccurrence)
+	r.POST("/finance-occurrences/:id/reschedule", handlers.RescheduleFinanceOccurrence)
+	r.GET("/tasks/:id/exceptions", handlers.ListTaskExceptions)
+	r.GET("/finances/:id/exceptions", handlers.ListFinanceExceptions)
+	r.DELETE("/occurrence-exceptions/:id", handlers.DeleteOccurrenceException)
+
+	// Rotas com barra final
+	r.POST("/task-occurrences/:id/skip/", handlers.SkipTaskOccurrence)
+	r.POST("/task-occurrences/:id/snooze/", handlers.SnoozeTaskOccurrence)
+	r.POST("/task-occurrences/:id/reschedule/", handlers.RescheduleTaskOccurrence)
+	r.POST("/finance-occurrences/:id/skip/", handlers.SkipFinanceOccurrence)
+	r.POST("/finance-occurrences/:id/snooze/", handlers.SnoozeFinanceOccurrence)
+	r.POST("/finance-occurrences/:id/reschedule/", handlers.RescheduleFinanceOccurrence)
+	r.GET("/tasks/:id/exceptions/", handlers.ListTaskExceptions)
+	r.GET("/finances/:id/exceptions/", handlers.ListFinanceExceptions)
+	r.DELETE("/occurrence-exceptions/:id/", handlers.DeleteOccurrenceException)
+}
+
+func setupScheduleRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.POST("/tasks/:id/pauses", handlers.CreateTaskPause)
+	r.GET("/tasks/:id/pauses", handlers.ListTaskPauses)
+	r.POST("/finances/:id/pauses", handlers.CreateFinancePause)
+	r.GET("/finances/:id/pauses", handlers.ListFinancePauses)
+	r.DELETE("/pauses/:id", handlers.DeletePause)
+	r.POST("/users/:id/away", handlers.CreateAwayPeriod)
+	r.GET("/away-periods", handlers.ListAwayPeriods)
+	r.DELETE("/away-periods/:id", handlers.DeleteAwayPeriod)
+
+	// Rotas com barra final
+	r.POST("/tasks/:id/pauses/", handlers.CreateTaskPause)
+	r.GET("/tasks/:id/pauses/", handlers.ListTaskPauses)
+	r.POST("/finances/:id/pauses/", handlers.CreateFinancePause)
+	r.GET("/finances/:id/pauses/", handlers.ListFinancePauses)
+	r.DELETE("/pauses/:id/", handlers.DeletePause)
+	r.POST("/users/:id/away/", handlers.CreateAwayPeriod)
+	r.GET("/away-periods/", handlers.ListAwayPeriods)
+	r.DELETE("/away-periods/:id/", handlers.DeleteAwayPeriod)
+}
+
+func setupCalendarRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.POST("/users/:id/calendar-feeds", handlers.CreateUserCalendarFeed)
+	r.GET("/users/:id/calendar-feeds", handlers.ListUserCalendarFeeds)
+	r.POST("/payer-groups/:id/calendar-feeds", handlers.CreatePayerGroupCalendarFeed)
+	r.GET("/payer-groups/:id/calendar-feeds", handlers.ListPayerGroupCalendarFeeds)
+	r.DELETE("/calendar-feeds/:id", handlers.DeleteCalendarFeed)
+	r.GET("/calendar/:token", handlers.GetCalendar)
+	r.POST("/calendar/import", handlers.ImportCalendar)
+
+	// Rotas com barra final
+	r.POST("/users/:id/calendar-feeds/", handlers.CreateUserCalendarFeed)
+	r.GET("/users/:id/calendar-feeds/", handlers.ListUserCalendarFeeds)
+	r.POST("/payer-groups/:id/calendar-feeds/", handlers.CreatePayerGroupCalendarFeed)
+	r.GET("/payer-groups/:id/calendar-feeds/", handlers.ListPayerGroupCalendarFeeds)
+	r.DELETE("/calendar-feeds/:id/", handlers.DeleteCalendarFeed)
+	r.GET("/calendar/:token/", handlers.GetCalendar)
+	r.POST("/calendar/import/", handlers.ImportCalendar)
+}
+
+func setupNotificationRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.GET("/users/:id/notification-settings", handlers.GetNotificationSettings)
+	r.PUT("/users/:id/notification-settings", handlers.UpdateNotificationSettings)
+	r.POST("/users/:id/notification-channels", handlers.CreateNotificationChannel)
+	r.GET("/users/:id/notification-channels", handlers.ListNotificationChannels)
+	r.PUT("/notification-channels/:id", handlers.UpdateNotificationChannel)
+	r.DELETE("/notification-channels/:id", handlers.DeleteNotificationChannel)
+	r.POST("/notification-channels/:id/test", handlers.TestNotificationChannel)
+	r.POST("/notification-rules", handlers.CreateNotificationRule)
+	r.GET("/notification-rules", handlers.ListNotificationRules)
+	r.PUT("/notification-rules/:id", handlers.UpdateNotificationRule)
+	r.DELETE("/notification-rules/:id", handlers.DeleteNotificationRule)
+	r.GET("/notifications", handlers.ListNotifications)
+	r.POST("/notifications/dispatch", handlers.DispatchNotifications)
+
+	// Rotas com barra final
+	r.GET("/users/:id/notification-settings/", handlers.GetNotificationSettings)
+	r.PUT("/users/:id/notification-settings/", handlers.UpdateNotificationSettings)
+	r.POST("/users/:id/notification-channels/", handlers.CreateNotificationChannel)
+	r.GET("/users/:id/notification-channels/", handlers.ListNotificationChannels)
+	r.PUT("/notification-channels/:id/", handlers.UpdateNotificationChannel)
+	r.DELETE("/notification-channels/:id/", handlers.DeleteNotificationChannel)
+	r.POST("/notification-channels/:id/test/", handlers.TestNotificationChannel)
+	r.POST("/notification-rules/", handlers.CreateNotificationRule)
+	r.GET("/notification-rules/", handlers.ListNotificationRules)
+	r.PUT("/notification-rules/:id/", handlers.UpdateNotificationRule)
+	r.DELETE("/notification-rules/:id/", handlers.DeleteNotificationRule)
+	r.GET("/notifications/", handlers.ListNotifications)
+	r.POST("/notifications/dispatch/", handlers.DispatchNotifications)
+}
+
+func setupWebhookRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.POST("/webhooks", handlers.CreateWebhook)
+	r.GET("/webhooks", handlers.ListWebhooks)
+	r.POST("/webhooks/dispatch", handlers.DispatchWebhooks)
+	r.GET("/webhooks/:id", handlers.GetWebhook)
+	r.PUT("/webhooks/:id", handlers.UpdateWebhook)
+	r.DELETE("/webhooks/:id", handlers.DeleteWebhook)
+	r.GET("/webhooks/:id/deliveries", handlers.ListWebhookDeliveries)
+	r.POST("/webhook-deliveries/:id/retry", handlers.RetryWebhookDelivery)
+
+	// Rotas com barra final
+	r.POST("/webhooks/", handlers.CreateWebhook)
+	r.GET("/webhooks/", handlers.ListWebhooks)
+	r.POST("/webhooks/dispatch/", handlers.DispatchWebhooks)
+	r.GET("/webhooks/:id/", handlers.GetWebhook)
+	r.PUT("/webhooks/:id/", handlers.UpdateWebhook)
+	r.DELETE("/webhooks/:id/", handlers.DeleteWebhook)
+	r.GET("/webhooks/:id/deliveries/", handlers.ListWebhookDeliveries)
+	r.POST("/webhook-deliveries/:id/retry/", handlers.RetryWebhookDelivery)
+}
+
+func setupEventRoutes(r *gin.Engine) {
+	// Stream de alterações em tarefas, ocorrências e carteiras
+	r.GET("/events", handlers.StreamEvents)
+	r.GET("/events/", handlers.StreamEvents)
+}
+
+func setupJobRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.GET("/jobs", handlers.ListJobs)
+	r.GET("/jobs/:id", handlers.GetJob)
+	r.POST("/jobs/:id/cancel", handlers.CancelJob)
+	r.GET("/jobs/:id/events", handlers.StreamJob)
+
+	// Rotas com barra final
+	r.GET("/jobs/", handlers.ListJobs)
+	r.GET("/jobs/:id/", handlers.GetJob)
+	r.POST("/jobs/:id/cancel/", handlers.CancelJob)
+	r.GET("/jobs/:id/events/", handlers.StreamJob)
+}
+
+func setupAuditRoutes(r *gin.Engine) {
+	// Alterações em usuários, grupos, finanças, tarefas e ocorrências, com autor e antes/depois
+	r.GET("/audit", handlers.ListAudit)
+	r.GET("/audit/", handlers.ListAudit)
 }
 
 func setupFinanceRoutes(r *gin.Engine) {
@@ -157,6 +703,8 @@ func setupFinanceRoutes(r *gin.Engine) {
 	r.GET("/finances/:id", handlers.GetFinance)
 	r.PUT("/finances/:id", handlers.UpdateFinance)
 	r.DELETE("/finances/:id", handlers.DeleteFinance)
+	r.POST("/finances/:id/archive", handlers.ArchiveFinance)
+	r.POST("/finances/:id/restore", handlers.RestoreFinance)
 	r.POST("/finances/update-occurrences", handlers.UpdateFinanceOccurrences)
 
 	// Ocorrências financeiras
@@ -166,12 +714,28 @@ func setupFinanceRoutes(r *gin.Engine) {
 	r.PUT("/finance-occurrences/:id", handlers.UpdateFinanceOccurrence)
 	r.DELETE("/finance-occurrences/:id", handlers.DeleteFinanceOccurrence)
 
+	// Pix
+	r.PUT("/finances/:id/pix", handlers.SetFinancePix)
+	r.DELETE("/finances/:id/pix", handlers.DeleteFinancePix)
+	r.GET("/finance-occurrences/:id/pix", handlers.GetFinanceOccurrencePix)
+	r.GET("/finance-occurrences/:id/pix/qr.png", handlers.GetFinanceOccurrencePixQR)
+	r.PUT("/finance-occurrences/:id/pix", handlers.SetFinanceOccurrencePix)
+	r.DELETE("/finance-occurrences/:id/pix", handlers.DeleteFinanceOccurrencePix)
+
+	// Boletos
+	r.GET("/finance-occurrences/:id/boleto", handlers.GetFinanceOccurrenceBoleto)
+	r.PUT("/finance-occurrences/:id/boleto", handlers.SetFinanceOccurrenceBoleto)
+	r.DELETE("/finance-occurrences/:id/boleto", handlers.DeleteFinanceOccurrenceBoleto)
+	r.POST("/boletos/parse", handlers.ParseBoletoCode)
+
 	// Rotas com barra final
 	r.POST("/finances/", handlers.CreateFinance)
 	r.GET("/finances/", handlers.ListFinances)
 	r.GET("/finances/:id/", handlers.GetFinance)
 	r.PUT("/finances/:id/", handlers.UpdateFinance)
 	r.DELETE("/finances/:id/", handlers.DeleteFinance)
+	r.POST("/finances/:id/archive/", handlers.ArchiveFinance)
+	r.POST("/finances/:id/restore/", handlers.RestoreFinance)
 	r.POST("/finances/update-occurrences/", handlers.UpdateFinanceOccurrences)
 
 	// Ocorrências financeiras com barra final
@@ -180,6 +744,20 @@ func setupFinanceRoutes(r *gin.Engine) {
 	r.GET("/finance-occurrences/", handlers.ListFinanceOccurrences)
 	r.PUT("/finance-occurrences/:id/", handlers.UpdateFinanceOccurrence)
 	r.DELETE("/finance-occurrences/:id/", handlers.DeleteFinanceOccurrence)
+
+	// Pix com barra final
+	r.PUT("/finances/:id/pix/", handlers.SetFinancePix)
+	r.DELETE("/finances/:id/pix/", handlers.DeleteFinancePix)
+	r.GET("/finance-occurrences/:id/pix/", handlers.GetFinanceOccurrencePix)
+	r.GET("/finance-occurrences/:id/pix/qr.png/", handlers.GetFinanceOccurrencePixQR)
+	r.PUT("/finance-occurrences/:id/pix/", handlers.SetFinanceOccurrencePix)
+	r.DELETE("/finance-occurrences/:id/pix/", handlers.DeleteFinanceOccurrencePix)
+
+	// Boletos com barra final
+	r.GET("/finance-occurrences/:id/boleto/", handlers.GetFinanceOccurrenceBoleto)
+	r.PUT("/finance-occurrences/:id/boleto/", handlers.SetFinanceOccurrenceBoleto)
+	r.DELETE("/finance-occurrences/:id/boleto/", handlers.DeleteFinanceOccurrenceBoleto)
+	r.POST("/boletos/parse/", handlers.ParseBoletoCode)
 }
 
 func setupDashboardRoutes(r *gin.Engine) {
@@ -188,10 +766,97 @@ func setupDashboardRoutes(r *gin.Engine) {
 	r.GET("/occurrences/dashboard/", handlers.ListOccurrencesDashboard)
 
 	// Carteiras
+	r.GET("/wallets", handlers.ListWallets)
+	r.GET("/wallets/", handlers.ListWallets)
 	r.GET("/wallets/:user_id", handlers.GetLastWallet)
 	r.GET("/wallets/:user_id/", handlers.GetLastWallet)
 
 	// Transações
+	r.GET("/transactions", handlers.ListAllTransactions)
+	r.GET("/transactions/", handlers.ListAllTransactions)
 	r.GET("/transactions/:occurrence_id", handlers.ListTransactions)
 	r.GET("/transactions/:occurrence_id/", handlers.ListTransactions)
-} 
\ No newline at end of file
+} 
+
+func setupBankImportRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.POST("/bank-imports", handlers.CreateBankImport)
+	r.GET("/bank-imports", handlers.ListBankImports)
+	r.GET("/bank-imports/:id", handlers.GetBankImport)
+	r.POST("/bank-imports/:id/match", handlers.MatchBankImport)
+	r.POST("/bank-lines/:id/confirm", handlers.ConfirmBankLine)
+	r.POST("/bank-lines/:id/finance", handlers.CreateFinanceFromBankLine)
+
+	// Rotas com barra final
+	r.POST("/bank-imports/", handlers.CreateBankImport)
+	r.GET("/bank-imports/", handlers.ListBankImports)
+	r.GET("/bank-imports/:id/", handlers.GetBankImport)
+	r.POST("/bank-imports/:id/match/", handlers.MatchBankImport)
+	r.POST("/bank-lines/:id/confirm/", handlers.ConfirmBankLine)
+	r.POST("/bank-lines/:id/finance/", handlers.CreateFinanceFromBankLine)
+}
+
+func setupPixRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.POST("/pix/parse", handlers.ParsePixCode)
+	r.POST("/settlements/pix", handlers.CreatePixSettlement)
+	r.GET("/settlements/pix/qr.png", handlers.GetPixSettlementQR)
+
+	// Rotas com barra final
+	r.POST("/pix/parse/", handlers.ParsePixCode)
+	r.POST("/settlements/pix/", handlers.CreatePixSettlement)
+	r.GET("/settlements/pix/qr.png/", handlers.GetPixSettlementQR)
+}
+
+func setupExportRoutes(r *gin.Engine) {
+	r.GET("/export/:resource", handlers.ExportResource)
+	r.GET("/export/:resource/", handlers.ExportResource)
+}
+
+func setupAttachmentRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.POST("/finance-occurrences/:id/attachments", handlers.UploadFinanceOccurrenceAttachment)
+	r.GET("/finance-occurrences/:id/attachments", handlers.ListFinanceOccurrenceAttachments)
+	r.POST("/finance-occurrences/:id/transactions/:transaction_id/attachments", handlers.UploadTransactionAttachment)
+	r.GET("/finance-occurrences/:id/transactions/:transaction_id/attachments", handlers.ListTransactionAttachments)
+	r.POST("/task-occurrences/:id/attachments", handlers.UploadTaskOccurrenceAttachment)
+	r.GET("/task-occurrences/:id/attachments", handlers.ListTaskOccurrenceAttachments)
+	r.GET("/attachments/:id", handlers.GetAttachment)
+	r.GET("/attachments/:id/download", handlers.DownloadAttachment)
+	r.DELETE("/attachments/:id", handlers.DeleteAttachment)
+
+	// Rotas com barra final
+	r.POST("/finance-occurrences/:id/attachments/", handlers.UploadFinanceOccurrenceAttachment)
+	r.GET("/finance-occurrences/:id/attachments/", handlers.ListFinanceOccurrenceAttachments)
+	r.POST("/finance-occurrences/:id/transactions/:transaction_id/attachments/", handlers.UploadTransactionAttachment)
+	r.GET("/finance-occurrences/:id/transactions/:transaction_id/attachments/", handlers.ListTransactionAttachments)
+	r.POST("/task-occurrences/:id/attachments/", handlers.UploadTaskOccurrenceAttachment)
+	r.GET("/task-occurrences/:id/attachments/", handlers.ListTaskOccurrenceAttachments)
+	r.GET("/attachments/:id/", handlers.GetAttachment)
+	r.GET("/attachments/:id/download/", handlers.DownloadAttachment)
+	r.DELETE("/attachments/:id/", handlers.DeleteAttachment)
+}
+
+func setupInvoiceRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.POST("/invoices/parse", handlers.ParseInvoice)
+	r.POST("/invoices/import", handlers.ImportInvoice)
+	r.GET("/invoices/imports/:id", handlers.GetInvoiceImport)
+	r.DELETE("/invoices/imports/:id", handlers.UndoInvoiceImport)
+
+	// Rotas com barra final
+	r.POST("/invoices/parse/", handlers.ParseInvoice)
+	r.POST("/invoices/import/", handlers.ImportInvoice)
+	r.GET("/invoices/imports/:id/", handlers.GetInvoiceImport)
+	r.DELETE("/invoices/imports/:id/", handlers.UndoInvoiceImport)
+}
+
+func setupBackupRoutes(r *gin.Engine) {
+	// Rotas sem barra final
+	r.GET("/backup", handlers.ExportBackup)
+	r.POST("/backup/restore", handlers.RestoreBackup)
+
+	// Rotas com barra final
+	r.GET("/backup/", handlers.ExportBackup)
+	r.POST("/backup/restore/", handlers.RestoreBackup)
+}
\ No newline at end of file
@@ -0,0 +1,283 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Filtros de arquivamento das listagens (parâmetro archived)
+const (
+	ArchivedExclude = ""     // apenas os registros ativos (padrão)
+	ArchivedOnly    = "true" // apenas os arquivados
+	ArchivedAll     = "all"  // ativos e arquivados
+)
+
+var (
+	ErrNotArchived = errors.New("o registro não está arquivado")
+	ErrArchived    = errors.New("o registro está arquivado; restaure-o antes de gerar ocorrências")
+	// ErrOccurrencePaid impede remover uma ocorrência paga, que tem transações e faz parte do histórico
+	ErrOccurrencePaid = errors.New("ocorrência paga não pode ser removida")
+)
+
+// ValidArchived indica se o filtro de arquivamento é conhecido
+func ValidArchived(archived string) bool {
+	switch archived {
+	case ArchivedExclude, ArchivedOnly, ArchivedAll:
+		return true
+	}
+	return false
+}
+
+// archivedCondition devolve a condição sobre a coluna deleted_at para o filtro, ou "" para todos
+func archivedCondition(column, archived string) string {
+	switch archived {
+	case ArchivedOnly:
+		return column + " IS NOT NULL"
+	case ArchivedAll:
+		return ""
+	}
+	return column + " IS NULL"
+}
+
+// archivedWhere monta a cláusula WHERE das listagens que filtram apenas pelo arquivamento
+func archivedWhere(archived string) string {
+	if condition := archivedCondition("deleted_at", archived); condition != "" {
+		return "WHERE " + condition
+	}
+	return ""
+}
+
+// ArchiveConflictError lista o que ainda depende do registro e impede o arquivamento
+type ArchiveConflictError struct {
+	Reasons []string
+}
+
+func (e *ArchiveConflictError) Error() string {
+	return "o registro ainda está em uso: " + strings.Join(e.Reasons, "; ")
+}
+
+// ArchiveResult resume o que o arquivamento fez com os registros dependentes
+type ArchiveResult struct {
+	RemovedOccurrences    int `json:"removed_occurrences"`     // ocorrências futuras em aberto removidas
+	CancelledOccurrences  int `json:"cancelled_occurrences"`   // ocorrências de tarefa vencidas em aberto canceladas
+	ReleasedBankLines     int `json:"released_bank_lines"`     // lançamentos bancários sugeridos que voltaram a ficar sem correspondência
+	CancelledSwapRequests int `json:"cancelled_swap_requests"` // pedidos de troca pendentes cancelados
+}
+
+// usage conta os registros ativos que dependem do registro a arquivar ($1 é o ID dele)
+type usage struct {
+	query   string
+	message string // formato com o total, ex.: "%d tarefa(s) ativa(s)"
+}
+
+// archiveRecord arquiva (soft delete) o registro da tabela: bloqueia a linha, recusa o arquivamento
+// se algum uso ainda existir, aplica a política de cascata e marca deleted_at, tudo na mesma transação.
+// Arquivar um registro já arquivado não faz nada. by é o autor registrado no log de auditoria.
+func archiveRecord(table string, id uuid.UUID, by *uuid.UUID, usages []usage, cascade func(*sql.Tx, *ArchiveResult) error) (*ArchiveResult, error) {
+	tx, err := begin(by)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &ArchiveResult{}
+	var deletedAt *time.Time
+	if err := tx.QueryRow(`SELECT deleted_at FROM `+table+` WHERE id = $1 FOR UPDATE`, id).Scan(&deletedAt); err != nil {
+		return nil, err
+	}
+	if deletedAt != nil {
+		return result, nil
+	}
+
+	var reasons []string
+	for _, u := range usages {
+		var count int
+		if err := tx.QueryRow(u.query, id).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			reasons = append(reasons, fmt.Sprintf(u.message, count))
+		}
+	}
+	if len(reasons) > 0 {
+		return nil, &ArchiveConflictError{Reasons: reasons}
+	}
+
+	if cascade != nil {
+		if err := cascade(tx, result); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE `+table+` SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+	return result, tx.Commit()
+}
+
+// restoreRecord desfaz o arquivamento. Os registros dependentes removidos na cascata não voltam;
+// as ocorrências futuras são recriadas na próxima geração.
+func restoreRecord(table string, id uuid.UUID, by *uuid.UUID) error {
+	return audited(by, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE `+table+` SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`, id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM `+table+` WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		return ErrNotArchived
+	})
+}
+
+// affected soma ao contador as linhas alteradas pelo comando
+func affected(res sql.Result, counter *int) error {
+	n, err := res.RowsAffected()
+	*counter += int(n)
+	return err
+}
+
+// releaseBankLines devolve à conciliação os lançamentos bancários sugeridos para as ocorrências
+// financeiras selecionadas por where, antes que elas sejam removidas
+func releaseBankLines(tx *sql.Tx, where string, args ...interface{}) (int, error) {
+	res, err := tx.Exec(`
+		UPDATE bank_lines
+		SET status = 'unmatched', finance_occurrence_id = NULL
+		WHERE status = 'matched' AND finance_occurrence_id IN (SELECT id FROM finance_occurrences WHERE `+where+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	released, err := res.RowsAffected()
+	return int(released), err
+}
+
+// Archive arquiva o usuário. Ele não pode participar de grupos ativos nem ser responsável por tarefas,
+// finanças ou ocorrências em aberto; os pedidos de troca pendentes em que aparece são cancelados.
+func (u *User) Archive(by *uuid.UUID) (*ArchiveResult, error) {
+	return archiveRecord("users", u.ID, by, []usage{
+		{`SELECT COUNT(*) FROM payer_group_members m JOIN payer_groups pg ON pg.id = m.payer_group_id
+			WHERE m.user_id = $1 AND pg.deleted_at IS NULL`, "participa de %d grupo(s) de pagadores ativo(s)"},
+		{`SELECT COUNT(*) FROM task_installments WHERE user_id = $1 AND deleted_at IS NULL`, "é responsável por %d tarefa(s) ativa(s)"},
+		{`SELECT COUNT(*) FROM finance_installments WHERE user_id = $1 AND deleted_at IS NULL`, "é responsável por %d finança(s) ativa(s)"},
+		{`SELECT COUNT(*) FROM task_occurrences WHERE user_id = $1 AND state IN ('pending', 'in_progress', 'overdue')`,
+			"tem %d ocorrência(s) de tarefa em aberto atribuída(s)"},
+	}, func(tx *sql.Tx, result *ArchiveResult) error {
+		res, err := tx.Exec(`
+			UPDATE task_swap_requests
+			SET status = 'cancelled', resolved_at = CURRENT_TIMESTAMP
+			WHERE status = 'pending' AND (requester_id = $1 OR target_user_id = $1)`, u.ID)
+		if err != nil {
+			return err
+		}
+		return affected(res, &result.CancelledSwapRequests)
+	})
+}
+
+// Restore desfaz o arquivamento do usuário
+func (u *User) Restore(by *uuid.UUID) error {
+	return restoreRecord("users", u.ID, by)
+}
+
+// Archive arquiva o grupo de pagadores, desde que nenhuma tarefa ou finança ativa o use
+func (pg *PayerGroup) Archive(by *uuid.UUID) (*ArchiveResult, error) {
+	return archiveRecord("payer_groups", pg.ID, by, []usage{
+		{`SELECT COUNT(*) FROM task_installments WHERE payer_group_id = $1 AND deleted_at IS NULL`, "usado por %d tarefa(s) ativa(s)"},
+		{`SELECT COUNT(*) FROM finance_installments WHERE payer_group_id = $1 AND deleted_at IS NULL`, "usado por %d finança(s) ativa(s)"},
+	}, nil)
+}
+
+// Restore desfaz o arquivamento do grupo de pagadores
+func (pg *PayerGroup) Restore(by *uuid.UUID) error {
+	return restoreRecord("payer_groups", pg.ID, by)
+}
+
+// Archive arquiva o centro de custo, desde que nenhuma finança ativa nem centro filho ativo dependa dele
+func (fc *FinanceCC) Archive(by *uuid.UUID) (*ArchiveResult, error) {
+	return archiveRecord("finance_cc", fc.ID, by, []usage{
+		{`SELECT COUNT(*) FROM finance_installments WHERE finance_cc_id = $1 AND deleted_at IS NULL`, "usado por %d finança(s) ativa(s)"},
+		{`SELECT COUNT(*) FROM finance_cc WHERE parent_id = $1 AND deleted_at IS NULL`, "pai de %d centro(s) de custo ativo(s)"},
+	}, nil)
+}
+
+// Restore desfaz o arquivamento do centro de custo
+func (fc *FinanceCC) Restore(by *uuid.UUID) error {
+	return restoreRecord("finance_cc", fc.ID, by)
+}
+
+// Archive arquiva a moeda, desde que nenhuma finança ativa a use
+func (fc *FinanceCurrency) Archive(by *uuid.UUID) (*ArchiveResult, error) {
+	return archiveRecord("finance_currency", fc.ID, by, []usage{
+		{`SELECT COUNT(*) FROM finance_installments WHERE currency_id = $1 AND deleted_at IS NULL`, "usada por %d finança(s) ativa(s)"},
+	}, nil)
+}
+
+// Restore desfaz o arquivamento da moeda
+func (fc *FinanceCurrency) Restore(by *uuid.UUID) error {
+	return restoreRecord("finance_currency", fc.ID, by)
+}
+
+// Archive arquiva a tarefa. As ocorrências concluídas, puladas e canceladas ficam como histórico;
+// as futuras em aberto são removidas e as vencidas em aberto são canceladas.
+func (t *TaskInstallment) Archive(by *uuid.UUID) (*ArchiveResult, error) {
+	return archiveRecord("task_installments", t.ID, by, nil, func(tx *sql.Tx, result *ArchiveResult) error {
+		res, err := tx.Exec(`
+			DELETE FROM task_occurrences
+			WHERE task_id = $1 AND state IN ('pending', 'in_progress') AND date >= household_today()`, t.ID)
+		if err != nil {
+			return err
+		}
+		if err := affected(res, &result.RemovedOccurrences); err != nil {
+			return err
+		}
+
+		res, err = tx.Exec(`
+			UPDATE task_occurrences
+			SET state = 'cancelled', status = false, state_changed_by = NULL
+			WHERE task_id = $1 AND state IN ('pending', 'in_progress', 'overdue')`, t.ID)
+		if err != nil {
+			return err
+		}
+		return affected(res, &result.CancelledOccurrences)
+	})
+}
+
+// Restore desfaz o arquivamento da tarefa
+func (t *TaskInstallment) Restore(by *uuid.UUID) error {
+	return restoreRecord("task_installments", t.ID, by)
+}
+
+// Archive arquiva a finança. As ocorrências pagas e as vencidas em aberto ficam como histórico;
+// as futuras não pagas são removidas, liberando os lançamentos bancários sugeridos para elas.
+func (fi *FinanceInstallment) Archive(by *uuid.UUID) (*ArchiveResult, error) {
+	return archiveRecord("finance_installments", fi.ID, by, nil, func(tx *sql.Tx, result *ArchiveResult) error {
+		const where = `finance_id = $1 AND status = false AND date >= household_today() AND ` + notInvoiceEntry
+		released, err := releaseBankLines(tx, where, fi.ID)
+		if err != nil {
+			return err
+		}
+		result.ReleasedBankLines = released
+
+		res, err := tx.Exec(`DELETE FROM finance_occurrences WHERE `+where, fi.ID)
+		if err != nil {
+			return err
+		}
+		return affected(res, &result.RemovedOccurrences)
+	})
+}
+
+// Restore desfaz o arquivamento da finança
+func (fi *FinanceInstallment) Restore(by *uuid.UUID) error {
+	return restoreRecord("finance_installments", fi.ID, by)
+}
\ No newline at end of file
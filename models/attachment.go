@@ -0,0 +1,215 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+	"github.com/pobruno/casa360/storage"
+)
+
+// attachmentPurgeBatch é quantos arquivos cada passagem de PurgeAttachmentBlobs apaga do armazenamento
+const attachmentPurgeBatch = 500
+
+// Attachment é um comprovante, nota fiscal ou foto anexado a uma ocorrência financeira, a uma transação
+// ou a uma ocorrência de tarefa (apenas um deles). O arquivo fica no armazenamento, em StorageKey.
+type Attachment struct {
+	ID                  uuid.UUID  `json:"id"`
+	FinanceOccurrenceID *uuid.UUID `json:"finance_occurrence_id,omitempty"`
+	TransactionID       *uuid.UUID `json:"transaction_id,omitempty"`
+	TaskOccurrenceID    *uuid.UUID `json:"task_occurrence_id,omitempty"`
+	StorageKey          string     `json:"-"`
+	Filename            string     `json:"filename"`
+	ContentType         string     `json:"content_type"`
+	Size                int64      `json:"size"`   // bytes
+	SHA256              string     `json:"sha256"` // hash do conteúdo, em hexadecimal
+	UploadedBy          *uuid.UUID `json:"uploaded_by,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+const attachmentColumns = `id, finance_occurrence_id, transaction_id, task_occurrence_id, storage_key, filename,
+	content_type, size, sha256, uploaded_by, created_at`
+
+func (a *Attachment) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&a.ID, &a.FinanceOccurrenceID, &a.TransactionID, &a.TaskOccurrenceID, &a.StorageKey, &a.Filename,
+		&a.ContentType, &a.Size, &a.SHA256, &a.UploadedBy, &a.CreatedAt)
+}
+
+// attachmentTypes são os tipos aceitos nos anexos: imagens, PDF e XML (notas fiscais eletrônicas)
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/heic":      true,
+	"application/pdf": true,
+	"text/xml":        true,
+}
+
+// SniffAttachment identifica o tipo do arquivo pelos primeiros bytes do conteúdo (não pela extensão nem
+// pelo tipo informado pelo cliente) e informa se ele é aceito como anexo
+func SniffAttachment(head []byte) (string, bool) {
+	contentType := http.DetectContentType(head)
+	switch {
+	// Fotos de celulares (HEIC) não são reconhecidas pela detecção padrão
+	case contentType == "application/octet-stream" && len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		switch string(head[8:12]) {
+		case "heic", "heix", "mif1", "msf1":
+			contentType = "image/heic"
+		}
+	// XML sem a declaração <?xml ...?>, comum em notas fiscais exportadas por alguns sistemas
+	case strings.HasPrefix(contentType, "text/plain"):
+		text := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+		if bytes.HasPrefix(text, []byte("<")) {
+			contentType = "text/xml"
+		}
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	return contentType, attachmentTypes[contentType]
+}
+
+// Create grava o conteúdo de body no armazenamento e registra o anexo. Size, ContentType e Filename devem
+// vir preenchidos; o hash é calculado durante a gravação. Se o registro falhar, o arquivo é apagado.
+func (a *Attachment) Create(ctx context.Context, store storage.BlobStore, body io.Reader) error {
+	a.ID = uuid.New()
+	a.StorageKey = "attachments/" + a.ID.String()
+
+	hash := sha256.New()
+	if err := store.Put(ctx, a.StorageKey, io.TeeReader(body, hash), a.Size, a.ContentType); err != nil {
+		return err
+	}
+	a.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	err := audited(a.UploadedBy, func(tx *sql.Tx) error {
+		return a.scan(tx.QueryRow(`
+			INSERT INTO attachments (id, finance_occurrence_id, transaction_id, task_occurrence_id, storage_key,
+				filename, content_type, size, sha256, uploaded_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING `+attachmentColumns, a.ID, a.FinanceOccurrenceID, a.TransactionID, a.TaskOccurrenceID,
+			a.StorageKey, a.Filename, a.ContentType, a.Size, a.SHA256, a.UploadedBy))
+	})
+	if err != nil {
+		if deleteErr := store.Delete(context.Background(), a.StorageKey); deleteErr != nil {
+			log.Printf("Erro ao apagar o arquivo %s do anexo não registrado: %v", a.StorageKey, deleteErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Get busca um anexo pelo ID
+func (a *Attachment) Get() error {
+	return a.scan(config.GetDB().QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id = $1`, a.ID))
+}
+
+// Delete remove o anexo e apaga o arquivo do armazenamento. O arquivo entra na fila de remoção junto com o
+// registro, então uma falha ao apagá-lo agora é repetida por PurgeAttachmentBlobs.
+func (a *Attachment) Delete(ctx context.Context, store storage.BlobStore, by *uuid.UUID) error {
+	err := audited(by, func(tx *sql.Tx) error {
+		result, err := tx.Exec(`DELETE FROM attachments WHERE id = $1`, a.ID)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rows == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := PurgeAttachmentBlobs(ctx, store); err != nil {
+		log.Printf("Erro ao apagar o arquivo do anexo %s: %v", a.ID, err)
+	}
+	return nil
+}
+
+// ListFinanceOccurrenceAttachments lista os anexos de uma ocorrência financeira, dos mais antigos para os mais novos
+func ListFinanceOccurrenceAttachments(occurrenceID uuid.UUID) ([]Attachment, error) {
+	return listAttachments("finance_occurrence_id", occurrenceID)
+}
+
+// ListTransactionAttachments lista os anexos de uma transação
+func ListTransactionAttachments(transactionID uuid.UUID) ([]Attachment, error) {
+	return listAttachments("transaction_id", transactionID)
+}
+
+// ListTaskOccurrenceAttachments lista os anexos de uma ocorrência de tarefa
+func ListTaskOccurrenceAttachments(occurrenceID uuid.UUID) ([]Attachment, error) {
+	return listAttachments("task_occurrence_id", occurrenceID)
+}
+
+func listAttachments(column string, id uuid.UUID) ([]Attachment, error) {
+	rows, err := config.GetDB().Query(`
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE `+column+` = $1
+		ORDER BY created_at, id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		var a Attachment
+		if err := a.scan(rows); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// PurgeAttachmentBlobs apaga do armazenamento os arquivos dos anexos removidos (fila attachment_purges) e
+// retorna quantos foram apagados. Os que falharem continuam na fila para a próxima passagem.
+func PurgeAttachmentBlobs(ctx context.Context, store storage.BlobStore) (int, error) {
+	db := config.GetDB()
+	rows, err := db.Query(`SELECT storage_key FROM attachment_purges ORDER BY created_at LIMIT $1`, attachmentPurgeBatch)
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var firstErr error
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if _, err := db.Exec(`DELETE FROM attachment_purges WHERE storage_key = $1`, key); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, firstErr
+}
\ No newline at end of file
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+)
+
+// Entidades registradas no log de auditoria
+const (
+	AuditUser              = "user"
+	AuditPayerGroup        = "payer_group"
+	AuditPayerGroupMember  = "payer_group_member"
+	AuditFinance           = "finance"
+	AuditFinanceOccurrence = "finance_occurrence"
+	AuditTask              = "task"
+	AuditTaskOccurrence    = "task_occurrence"
+	AuditAttachment        = "attachment"
+)
+
+const (
+	// AuditDefaultLimit e AuditMaxLimit limitam quantos registros uma consulta ao log retorna
+	AuditDefaultLimit = 100
+	AuditMaxLimit     = 1000
+)
+
+// ValidAuditEntity informa se a entidade é registrada no log de auditoria
+func ValidAuditEntity(entity string) bool {
+	switch entity {
+	case AuditUser, AuditPayerGroup, AuditPayerGroupMember, AuditFinance, AuditFinanceOccurrence, AuditTask, AuditTaskOccurrence,
+		AuditAttachment:
+		return true
+	}
+	return false
+}
+
+// AuditEntry é uma alteração registrada pelo trigger audit_change, com o registro antes e depois dela
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Entity    string          `json:"entity"`
+	EntityID  uuid.UUID       `json:"entity_id"`
+	Action    string          `json:"action"`   // insert, update ou delete
+	ActorID   *uuid.UUID      `json:"actor_id"` // nil = alteração feita pelo sistema
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// AuditFilter reúne os filtros da consulta ao log. BeforeID pagina a consulta: só entram os registros
+// anteriores a ele.
+type AuditFilter struct {
+	Entity   string
+	EntityID *uuid.UUID
+	ActorID  *uuid.UUID
+	BeforeID int64
+	Limit    int
+}
+
+// ListAudit lista o log de auditoria, do registro mais recente para o mais antigo. O período do filtro
+// se aplica ao momento da alteração.
+func ListAudit(f AuditFilter, filter ListFilter) ([]AuditEntry, error) {
+	var where whereClause
+	if f.Entity != "" {
+		where.add("entity = ?", f.Entity)
+	}
+	if f.EntityID != nil {
+		where.add("entity_id = ?", *f.EntityID)
+	}
+	if f.ActorID != nil {
+		where.add("actor_id = ?", *f.ActorID)
+	}
+	if f.BeforeID > 0 {
+		where.add("id < ?", f.BeforeID)
+	}
+	where.addTimestampRange("created_at", filter)
+
+	limit := f.Limit
+	if limit <= 0 || limit > AuditMaxLimit {
+		limit = AuditDefaultLimit
+	}
+	where.args = append(where.args, limit)
+
+	rows, err := config.GetDB().Query(`
+		SELECT id, entity, entity_id, action, actor_id, before, after, created_at
+		FROM audit_log
+		`+where.String()+`
+		ORDER BY id DESC
+		LIMIT $`+strconv.Itoa(len(where.args)), where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var before, after []byte
+		if err := rows.Scan(&e.ID, &e.Entity, &e.EntityID, &e.Action, &e.ActorID, &before, &after, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Before, e.After = json.RawMessage(before), json.RawMessage(after)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// begin abre uma transação com by registrado como autor das alterações no log de auditoria. Sem by,
+// as alterações ficam registradas como feitas pelo sistema.
+func begin(by *uuid.UUID) (*sql.Tx, error) {
+	tx, err := config.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := setActor(tx, by); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// setActor define o autor das alterações da transação (casa360.actor, lido pelo trigger de auditoria).
+// O pool de conexões é compartilhado, então o valor vale só até o fim da transação.
+func setActor(tx *sql.Tx, by *uuid.UUID) error {
+	if by == nil {
+		return nil
+	}
+	_, err := tx.Exec(`SELECT set_config('casa360.actor', $1, true)`, by.String())
+	return err
+}
+
+// audited executa fn em uma transação aberta por begin e a confirma se fn não falhar
+func audited(by *uuid.UUID, fn func(tx *sql.Tx) error) error {
+	tx, err := begin(by)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
\ No newline at end of file
@@ -0,0 +1,1065 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pobruno/casa360/config"
+)
+
+// BackupVersion é a versão atual do formato do arquivo de backup
+const BackupVersion = 1
+
+var (
+	ErrBackupVersion    = errors.New("versão de backup não suportada")
+	ErrDatabaseNotEmpty = errors.New("o banco de dados não está vazio")
+)
+
+// BackupIntegrityError lista as inconsistências encontradas em um arquivo de backup
+type BackupIntegrityError struct {
+	Problems []string
+}
+
+func (e *BackupIntegrityError) Error() string {
+	return "backup inconsistente: " + strings.Join(e.Problems, "; ")
+}
+
+// BackupPayerGroup é um grupo de pagadores com seus membros
+type BackupPayerGroup struct {
+	PayerGroup
+	Members []PayerGroupMember `json:"members"`
+}
+
+// BackupTask é uma tarefa no backup; effort_points ausente (backups anteriores aos pontos de esforço) vale 1
+type BackupTask struct {
+	TaskInstallment
+	EffortPoints *int `json:"effort_points"`
+}
+
+// BackupInvoiceImport é uma nota fiscal importada, com os lançamentos criados pela importação
+type BackupInvoiceImport struct {
+	ID         uuid.UUID            `json:"id"`
+	Invoice    *Invoice             `json:"invoice"`
+	ImportedBy *uuid.UUID           `json:"imported_by,omitempty"`
+	CreatedAt  time.Time            `json:"created_at"`
+	Entries    []BackupInvoiceEntry `json:"entries"` // na ordem da importação; o primeiro é o principal
+}
+
+// BackupInvoiceEntry é um lançamento de uma nota importada: a finança e a ocorrência de um centro de custo
+type BackupInvoiceEntry struct {
+	ID                  uuid.UUID `json:"id"`
+	FinanceID           uuid.UUID `json:"finance_id"`
+	FinanceOccurrenceID uuid.UUID `json:"finance_occurrence_id"`
+	CreatedFinance      bool      `json:"created_finance"`
+	Items               []int     `json:"items"`
+	Amount              float64   `json:"amount"`
+}
+
+// Backup é o arquivo JSON versionado com todos os dados de uma casa. Os anexos ficam de fora: os arquivos
+// estão no armazenamento configurado (pasta local ou bucket S3), que tem o seu próprio backup.
+type Backup struct {
+	Version            int                   `json:"version"`
+	CreatedAt          time.Time             `json:"created_at"`
+	Settings           *HouseholdSettings    `json:"settings,omitempty"`
+	Users              []User                `json:"users"`
+	PayerGroups        []BackupPayerGroup    `json:"payer_groups"`
+	CostCenters        []FinanceCC           `json:"cost_centers"`
+	Currencies         []FinanceCurrency     `json:"currencies"`
+	Finances           []FinanceInstallment  `json:"finances"`
+	FinanceOccurrences []FinanceOccurrence   `json:"finance_occurrences"`
+	Transactions       []Transaction         `json:"transactions"`
+	Wallets            []FinanceWallet       `json:"wallets"`
+	Tasks              []BackupTask          `json:"tasks"`
+	TaskOccurrences    []TaskOccurrence      `json:"task_occurrences"`
+	TaskStateHistory   []TaskStateChange     `json:"task_occurrence_states"`
+	SwapRequests       []TaskSwapRequest     `json:"swap_requests"`
+	Exceptions         []OccurrenceException `json:"occurrence_exceptions"`
+	Pauses             []TemplatePause       `json:"template_pauses"`
+	AwayPeriods        []AwayPeriod          `json:"away_periods"`
+	// Preferências de notificação; o histórico de envios não entra no backup
+	NotificationSettings []NotificationSettings `json:"notification_settings"`
+	NotificationChannels []NotificationChannel  `json:"notification_channels"`
+	NotificationRules    []NotificationRule     `json:"notification_rules"`
+	// Configuração da casa: os links de calendário mantêm o token e as assinaturas de webhook, o segredo,
+	// para que calendários e integrações continuem funcionando; o log de entregas não entra no backup
+	CalendarFeeds        []CalendarFeed        `json:"calendar_feeds"`
+	WebhookSubscriptions []WebhookSubscription `json:"webhook_subscriptions"`
+	// Extratos importados com os lançamentos, para manter a conciliação das ocorrências
+	BankImports []BankImport `json:"bank_imports"`
+	// Notas fiscais importadas, para que a mesma nota não seja importada de novo e a importação possa ser desfeita
+	InvoiceImports []BackupInvoiceImport `json:"invoice_imports"`
+}
+
+// RestoreResult resume uma restauração: quantos registros foram criados e o novo ID de cada registro original
+type RestoreResult struct {
+	Counts map[string]int          `json:"counts"`
+	IDMap  map[uuid.UUID]uuid.UUID `json:"id_map"`
+}
+
+// ExportBackup lê todas as tabelas em uma única transação somente leitura, garantindo um retrato consistente
+func ExportBackup() (*Backup, error) {
+	tx, err := config.GetDB().BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := &Backup{Version: BackupVersion, CreatedAt: time.Now(), Settings: &HouseholdSettings{}}
+	if err := tx.QueryRow(`SELECT timezone, updated_at FROM household_settings`).Scan(&b.Settings.Timezone, &b.Settings.UpdatedAt); err != nil {
+		return nil, err
+	}
+	groups, bankImports, invoiceImports := map[uuid.UUID]int{}, map[uuid.UUID]int{}, map[uuid.UUID]int{}
+
+	steps := []struct {
+		query string
+		scan  func(*sql.Rows) error
+	}{
+		{`SELECT id, name, pix_key, pix_city, deleted_at FROM users ORDER BY name, id`, func(rows *sql.Rows) error {
+			var u User
+			if err := rows.Scan(&u.ID, &u.Name, &u.PixKey, &u.PixCity, &u.DeletedAt); err != nil {
+				return err
+			}
+			b.Users = append(b.Users, u)
+			return nil
+		}},
+		{`SELECT id, name, deleted_at FROM payer_groups ORDER BY name, id`, func(rows *sql.Rows) error {
+			var g BackupPayerGroup
+			if err := rows.Scan(&g.ID, &g.Name, &g.DeletedAt); err != nil {
+				return err
+			}
+			g.Members = []PayerGroupMember{}
+			groups[g.ID] = len(b.PayerGroups)
+			b.PayerGroups = append(b.PayerGroups, g)
+			return nil
+		}},
+		{`SELECT id, payer_group_id, user_id, percentage FROM payer_group_members ORDER BY percentage DESC, id`, func(rows *sql.Rows) error {
+			var m PayerGroupMember
+			if err := rows.Scan(&m.ID, &m.PayerGroupID, &m.UserID, &m.Percentage); err != nil {
+				return err
+			}
+			i := groups[m.PayerGroupID]
+			b.PayerGroups[i].Members = append(b.PayerGroups[i].Members, m)
+			return nil
+		}},
+		{`SELECT id, name, parent_id, deleted_at FROM finance_cc ORDER BY name, id`, func(rows *sql.Rows) error {
+			var cc FinanceCC
+			if err := rows.Scan(&cc.ID, &cc.Name, &cc.ParentID, &cc.DeletedAt); err != nil {
+				return err
+			}
+			b.CostCenters = append(b.CostCenters, cc)
+			return nil
+		}},
+		{`SELECT id, name, symbol, value, deleted_at FROM finance_currency ORDER BY name, id`, func(rows *sql.Rows) error {
+			var fc FinanceCurrency
+			if err := rows.Scan(&fc.ID, &fc.Name, &fc.Symbol, &fc.Value, &fc.DeletedAt); err != nil {
+				return err
+			}
+			b.Currencies = append(b.Currencies, fc)
+			return nil
+		}},
+		{`SELECT id, title, COALESCE(description, ''), type, start_date, end_date, recurrence_days, amount, user_id, payer_group_id, finance_cc_id, currency_id, pix_code,
+				max_occurrences, deleted_at
+			FROM finance_installments ORDER BY start_date, id`, func(rows *sql.Rows) error {
+			var fi FinanceInstallment
+			if err := fi.scan(rows); err != nil {
+				return err
+			}
+			b.Finances = append(b.Finances, fi)
+			return nil
+		}},
+		{`SELECT ` + financeOccurrenceColumns + ` FROM finance_occurrences ORDER BY date, id`, func(rows *sql.Rows) error {
+			var fo FinanceOccurrence
+			if err := fo.scan(rows); err != nil {
+				return err
+			}
+			b.FinanceOccurrences = append(b.FinanceOccurrences, fo)
+			return nil
+		}},
+		{`SELECT id, finance_occurrence_id, amount, created_at FROM transactions ORDER BY created_at, id`, func(rows *sql.Rows) error {
+			var t Transaction
+			if err := rows.Scan(&t.ID, &t.FinanceOccurrenceID, &t.Amount, &t.CreatedAt); err != nil {
+				return err
+			}
+			b.Transactions = append(b.Transactions, t)
+			return nil
+		}},
+		{`SELECT id, user_id, amount, created_at FROM finance_wallets ORDER BY created_at, id`, func(rows *sql.Rows) error {
+			var w FinanceWallet
+			if err := rows.Scan(&w.ID, &w.UserID, &w.Amount, &w.CreatedAt); err != nil {
+				return err
+			}
+			b.Wallets = append(b.Wallets, w)
+			return nil
+		}},
+		{`SELECT id, title, COALESCE(description, ''), start_date, recurrence_cron, subtasks, user_id, payer_group_id, rotation,
+				effort_points, estimated_minutes, end_date, max_occurrences, duration_minutes, deleted_at
+			FROM task_installments ORDER BY start_date, id`, func(rows *sql.Rows) error {
+			var t TaskInstallment
+			if err := t.scan(rows); err != nil {
+				return err
+			}
+			b.Tasks = append(b.Tasks, BackupTask{TaskInstallment: t, EffortPoints: &t.EffortPoints})
+			return nil
+		}},
+		{`SELECT ` + taskOccurrenceColumns + ` FROM task_occurrences ORDER BY date, id`, func(rows *sql.Rows) error {
+			var o TaskOccurrence
+			if err := o.scan(rows); err != nil {
+				return err
+			}
+			b.TaskOccurrences = append(b.TaskOccurrences, o)
+			return nil
+		}},
+		{`SELECT id, task_occurrence_id, from_state, to_state, changed_by, changed_at FROM task_occurrence_states ORDER BY changed_at, id`, func(rows *sql.Rows) error {
+			var h TaskStateChange
+			if err := rows.Scan(&h.ID, &h.TaskOccurrenceID, &h.FromState, &h.ToState, &h.ChangedBy, &h.ChangedAt); err != nil {
+				return err
+			}
+			b.TaskStateHistory = append(b.TaskStateHistory, h)
+			return nil
+		}},
+		{`SELECT ` + swapRequestColumns + ` FROM task_swap_requests ORDER BY created_at, id`, func(rows *sql.Rows) error {
+			var s TaskSwapRequest
+			if err := s.scan(rows); err != nil {
+				return err
+			}
+			b.SwapRequests = append(b.SwapRequests, s)
+			return nil
+		}},
+		{`SELECT ` + occurrenceExceptionColumns + ` FROM occurrence_exceptions ORDER BY original_date, id`, func(rows *sql.Rows) error {
+			var e OccurrenceException
+			if err := e.scan(rows); err != nil {
+				return err
+			}
+			b.Exceptions = append(b.Exceptions, e)
+			return nil
+		}},
+		{`SELECT ` + templatePauseColumns + ` FROM template_pauses ORDER BY start_date, id`, func(rows *sql.Rows) error {
+			var tp TemplatePause
+			if err := tp.scan(rows); err != nil {
+				return err
+			}
+			b.Pauses = append(b.Pauses, tp)
+			return nil
+		}},
+		{`SELECT ` + awayPeriodColumns + ` FROM away_periods ORDER BY start_date, id`, func(rows *sql.Rows) error {
+			var a AwayPeriod
+			if err := a.scan(rows); err != nil {
+				return err
+			}
+			b.AwayPeriods = append(b.AwayPeriods, a)
+			return nil
+		}},
+		{`SELECT user_id, quiet_hours_start, quiet_hours_end, updated_at FROM notification_settings ORDER BY user_id`, func(rows *sql.Rows) error {
+			var s NotificationSettings
+			if err := rows.Scan(&s.UserID, &s.QuietHoursStart, &s.QuietHoursEnd, &s.UpdatedAt); err != nil {
+				return err
+			}
+			s.QuietHoursStart, s.QuietHoursEnd = clock(s.QuietHoursStart), clock(s.QuietHoursEnd)
+			b.NotificationSettings = append(b.NotificationSettings, s)
+			return nil
+		}},
+		{`SELECT ` + notificationChannelColumns + ` FROM notification_channels ORDER BY created_at, id`, func(rows *sql.Rows) error {
+			var nc NotificationChannel
+			if err := nc.scan(rows); err != nil {
+				return err
+			}
+			b.NotificationChannels = append(b.NotificationChannels, nc)
+			return nil
+		}},
+		{`SELECT ` + notificationRuleColumns + ` FROM notification_rules ORDER BY created_at, id`, func(rows *sql.Rows) error {
+			var nr NotificationRule
+			if err := nr.scan(rows); err != nil {
+				return err
+			}
+			b.NotificationRules = append(b.NotificationRules, nr)
+			return nil
+		}},
+		{`SELECT ` + calendarFeedColumns + ` FROM calendar_feeds ORDER BY created_at, id`, func(rows *sql.Rows) error {
+			var f CalendarFeed
+			if err := f.scan(rows); err != nil {
+				return err
+			}
+			b.CalendarFeeds = append(b.CalendarFeeds, f)
+			return nil
+		}},
+		{`SELECT ` + webhookSubscriptionColumns + ` FROM webhook_subscriptions ORDER BY created_at, id`, func(rows *sql.Rows) error {
+			var s WebhookSubscription
+			if err := s.scan(rows); err != nil {
+				return err
+			}
+			b.WebhookSubscriptions = append(b.WebhookSubscriptions, s)
+			return nil
+		}},
+		{`SELECT id, format, COALESCE(filename, ''), created_at FROM bank_imports ORDER BY created_at, id`, func(rows *sql.Rows) error {
+			var bi BankImport
+			if err := rows.Scan(&bi.ID, &bi.Format, &bi.Filename, &bi.CreatedAt); err != nil {
+				return err
+			}
+			bi.Lines = []BankLine{}
+			bankImports[bi.ID] = len(b.BankImports)
+			b.BankImports = append(b.BankImports, bi)
+			return nil
+		}},
+		{`SELECT id, bank_import_id, date, amount, description, document, status, finance_occurrence_id FROM bank_lines ORDER BY date, id`, func(rows *sql.Rows) error {
+			var l BankLine
+			if err := rows.Scan(&l.ID, &l.BankImportID, &l.Date, &l.Amount, &l.Description, &l.Document, &l.Status, &l.FinanceOccurrenceID); err != nil {
+				return err
+			}
+			i := bankImports[l.BankImportID]
+			b.BankImports[i].Lines = append(b.BankImports[i].Lines, l)
+			return nil
+		}},
+		{`SELECT id, invoice, imported_by, created_at FROM invoice_imports ORDER BY created_at, id`, func(rows *sql.Rows) error {
+			var ii BackupInvoiceImport
+			var invoice []byte
+			if err := rows.Scan(&ii.ID, &invoice, &ii.ImportedBy, &ii.CreatedAt); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(invoice, &ii.Invoice); err != nil {
+				return err
+			}
+			ii.Entries = []BackupInvoiceEntry{}
+			invoiceImports[ii.ID] = len(b.InvoiceImports)
+			b.InvoiceImports = append(b.InvoiceImports, ii)
+			return nil
+		}},
+		{`SELECT id, invoice_import_id, finance_id, finance_occurrence_id, created_finance, items, amount FROM invoice_import_entries ORDER BY invoice_import_id, position`, func(rows *sql.Rows) error {
+			var e BackupInvoiceEntry
+			var importID uuid.UUID
+			var items pq.Int64Array
+			if err := rows.Scan(&e.ID, &importID, &e.FinanceID, &e.FinanceOccurrenceID, &e.CreatedFinance, &items, &e.Amount); err != nil {
+				return err
+			}
+			e.Items = make([]int, len(items))
+			for i, number := range items {
+				e.Items[i] = int(number)
+			}
+			i := invoiceImports[importID]
+			b.InvoiceImports[i].Entries = append(b.InvoiceImports[i].Entries, e)
+			return nil
+		}},
+	}
+
+	for _, step := range steps {
+		rows, err := tx.Query(step.query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			if err := step.scan(rows); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, tx.Commit()
+}
+
+// Validate confere a versão e a integridade referencial do arquivo antes de qualquer escrita
+func (b *Backup) Validate() error {
+	if b.Version != BackupVersion {
+		return fmt.Errorf("%w: %d (suportada: %d)", ErrBackupVersion, b.Version, BackupVersion)
+	}
+
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// Os IDs precisam ser únicos em todo o arquivo, já que o remapeamento usa um único mapa
+	seen := map[uuid.UUID]bool{}
+	ids := func(kind string, id uuid.UUID) {
+		if id == uuid.Nil {
+			report("%s sem ID", kind)
+		} else if seen[id] {
+			report("ID duplicado: %s (%s)", id, kind)
+		}
+		seen[id] = true
+	}
+	users, groups, ccs, currencies := map[uuid.UUID]bool{}, map[uuid.UUID]bool{}, map[uuid.UUID]bool{}, map[uuid.UUID]bool{}
+	finances, occurrences, tasks, taskOccurrences := map[uuid.UUID]bool{}, map[uuid.UUID]bool{}, map[uuid.UUID]bool{}, map[uuid.UUID]bool{}
+
+	for _, u := range b.Users {
+		ids("usuário", u.ID)
+		users[u.ID] = true
+	}
+	for _, g := range b.PayerGroups {
+		ids("grupo de pagadores", g.ID)
+		groups[g.ID] = true
+		total := 0.0
+		for _, m := range g.Members {
+			ids("membro de grupo", m.ID)
+			if !users[m.UserID] {
+				report("membro %s referencia usuário inexistente %s", m.ID, m.UserID)
+			}
+			if m.Percentage <= 0 || m.Percentage > 100 {
+				report("membro %s com percentual inválido", m.ID)
+			}
+			total += m.Percentage
+		}
+		if total > 100.0001 {
+			report("grupo %s com percentuais somando mais de 100%%", g.ID)
+		}
+	}
+	for _, cc := range b.CostCenters {
+		ids("centro de custo", cc.ID)
+		ccs[cc.ID] = true
+	}
+	for _, cc := range b.CostCenters {
+		if cc.ParentID != nil && !ccs[*cc.ParentID] {
+			report("centro de custo %s referencia pai inexistente %s", cc.ID, *cc.ParentID)
+		}
+	}
+	if _, err := b.costCentersInOrder(); err != nil {
+		report("%s", err.Error())
+	}
+	for _, fc := range b.Currencies {
+		ids("moeda", fc.ID)
+		currencies[fc.ID] = true
+	}
+
+	optional := func(kind string, owner, ref uuid.UUID, set map[uuid.UUID]bool) {
+		if ref != uuid.Nil && !set[ref] {
+			report("%s %s referencia registro inexistente %s", kind, owner, ref)
+		}
+	}
+	for _, fi := range b.Finances {
+		ids("finança", fi.ID)
+		finances[fi.ID] = true
+		optional("finança", fi.ID, fi.UserID, users)
+		optional("finança", fi.ID, fi.PayerGroupID, groups)
+		optional("finança", fi.ID, fi.FinanceCCID, ccs)
+		optional("finança", fi.ID, fi.CurrencyID, currencies)
+	}
+	for _, fo := range b.FinanceOccurrences {
+		ids("ocorrência financeira", fo.ID)
+		occurrences[fo.ID] = true
+		if !finances[fo.FinanceID] {
+			report("ocorrência financeira %s referencia finança inexistente %s", fo.ID, fo.FinanceID)
+		}
+	}
+	for _, t := range b.Transactions {
+		ids("transação", t.ID)
+		if !occurrences[t.FinanceOccurrenceID] {
+			report("transação %s referencia ocorrência inexistente %s", t.ID, t.FinanceOccurrenceID)
+		}
+	}
+	for _, w := range b.Wallets {
+		ids("carteira", w.ID)
+		if !users[w.UserID] {
+			report("carteira %s referencia usuário inexistente %s", w.ID, w.UserID)
+		}
+	}
+	for _, t := range b.Tasks {
+		ids("tarefa", t.ID)
+		tasks[t.ID] = true
+		optional("tarefa", t.ID, t.UserID, users)
+		optional("tarefa", t.ID, t.PayerGroupID, groups)
+	}
+	for _, o := range b.TaskOccurrences {
+		ids("ocorrência de tarefa", o.ID)
+		taskOccurrences[o.ID] = true
+		if !tasks[o.TaskID] {
+			report("ocorrência de tarefa %s referencia tarefa inexistente %s", o.ID, o.TaskID)
+		}
+		optional("ocorrência de tarefa", o.ID, o.UserID, users)
+		for _, userID := range []*uuid.UUID{o.CompletedBy, o.StateChangedBy} {
+			if userID != nil {
+				optional("ocorrência de tarefa", o.ID, *userID, users)
+			}
+		}
+		if o.State != "" && !ValidTaskState(o.State) {
+			report("ocorrência de tarefa %s com estado inválido %q", o.ID, o.State)
+		}
+		optional("ocorrência de tarefa", o.ID, o.PayerGroupID, groups)
+	}
+	for _, h := range b.TaskStateHistory {
+		ids("mudança de estado", h.ID)
+		if !taskOccurrences[h.TaskOccurrenceID] {
+			report("mudança de estado %s referencia ocorrência de tarefa inexistente %s", h.ID, h.TaskOccurrenceID)
+		}
+		if !ValidTaskState(h.ToState) || (h.FromState != nil && !ValidTaskState(*h.FromState)) {
+			report("mudança de estado %s com estado inválido", h.ID)
+		}
+		if h.ChangedBy != nil {
+			optional("mudança de estado", h.ID, *h.ChangedBy, users)
+		}
+	}
+	for _, s := range b.SwapRequests {
+		ids("pedido de troca", s.ID)
+		references := []uuid.UUID{s.TaskOccurrenceID}
+		if s.TargetOccurrenceID != nil {
+			references = append(references, *s.TargetOccurrenceID)
+		}
+		for _, id := range references {
+			if !taskOccurrences[id] {
+				report("pedido de troca %s referencia ocorrência de tarefa inexistente %s", s.ID, id)
+			}
+		}
+		for _, id := range []uuid.UUID{s.RequesterID, s.TargetUserID} {
+			if !users[id] {
+				report("pedido de troca %s referencia usuário inexistente %s", s.ID, id)
+			}
+		}
+	}
+	for _, e := range b.Exceptions {
+		ids("exceção de recorrência", e.ID)
+		switch {
+		case (e.TaskID == nil) == (e.FinanceID == nil):
+			report("exceção de recorrência %s deve referenciar uma tarefa ou uma finança", e.ID)
+		case e.TaskID != nil && !tasks[*e.TaskID]:
+			report("exceção de recorrência %s referencia tarefa inexistente %s", e.ID, *e.TaskID)
+		case e.FinanceID != nil && !finances[*e.FinanceID]:
+			report("exceção de recorrência %s referencia finança inexistente %s", e.ID, *e.FinanceID)
+		}
+		if (e.Action == ExceptionSkipped) != (e.NewDate == nil) {
+			report("exceção de recorrência %s com ação %q e nova data incoerentes", e.ID, e.Action)
+		}
+		if e.CreatedBy != nil {
+			optional("exceção de recorrência", e.ID, *e.CreatedBy, users)
+		}
+	}
+	for _, tp := range b.Pauses {
+		ids("pausa", tp.ID)
+		switch {
+		case (tp.TaskID == nil) == (tp.FinanceID == nil):
+			report("pausa %s deve referenciar uma tarefa ou uma finança", tp.ID)
+		case tp.TaskID != nil && !tasks[*tp.TaskID]:
+			report("pausa %s referencia tarefa inexistente %s", tp.ID, *tp.TaskID)
+		case tp.FinanceID != nil && !finances[*tp.FinanceID]:
+			report("pausa %s referencia finança inexistente %s", tp.ID, *tp.FinanceID)
+		}
+		if tp.EndDate.Before(tp.StartDate) {
+			report("pausa %s com data final anterior à inicial", tp.ID)
+		}
+	}
+	for _, a := range b.AwayPeriods {
+		ids("ausência", a.ID)
+		if !users[a.UserID] {
+			report("ausência %s referencia usuário inexistente %s", a.ID, a.UserID)
+		}
+		if a.EndDate.Before(a.StartDate) {
+			report("ausência %s com data final anterior à inicial", a.ID)
+		}
+	}
+	for _, s := range b.NotificationSettings {
+		if !users[s.UserID] {
+			report("preferências de notificação referenciam usuário inexistente %s", s.UserID)
+		}
+		if (s.QuietHoursStart == nil) != (s.QuietHoursEnd == nil) {
+			report("preferências de notificação do usuário %s com horário de silêncio incompleto", s.UserID)
+		}
+	}
+	for _, nc := range b.NotificationChannels {
+		ids("canal de notificação", nc.ID)
+		if !users[nc.UserID] {
+			report("canal de notificação %s referencia usuário inexistente %s", nc.ID, nc.UserID)
+		}
+		if !ValidNotificationChannel(nc.Channel) {
+			report("canal de notificação %s com canal inválido %q", nc.ID, nc.Channel)
+		}
+	}
+	for _, nr := range b.NotificationRules {
+		ids("regra de notificação", nr.ID)
+		if nr.UserID != nil && !users[*nr.UserID] {
+			report("regra de notificação %s referencia usuário inexistente %s", nr.ID, *nr.UserID)
+		}
+		if !ValidNotificationEvent(nr.Event) || nr.OffsetMinutes < 0 {
+			report("regra de notificação %s com evento %q ou antecedência inválidos", nr.ID, nr.Event)
+		}
+	}
+	tokens := map[string]bool{}
+	for _, f := range b.CalendarFeeds {
+		ids("link de calendário", f.ID)
+		switch {
+		case (f.UserID == nil) == (f.PayerGroupID == nil):
+			report("link de calendário %s deve referenciar um usuário ou um grupo de pagadores", f.ID)
+		case f.UserID != nil && !users[*f.UserID]:
+			report("link de calendário %s referencia usuário inexistente %s", f.ID, *f.UserID)
+		case f.PayerGroupID != nil && !groups[*f.PayerGroupID]:
+			report("link de calendário %s referencia grupo de pagadores inexistente %s", f.ID, *f.PayerGroupID)
+		}
+		if f.Token == "" || tokens[f.Token] {
+			report("link de calendário %s sem token ou com token repetido", f.ID)
+		}
+		tokens[f.Token] = true
+	}
+	for _, s := range b.WebhookSubscriptions {
+		ids("assinatura de webhook", s.ID)
+		if s.URL == "" || s.Secret == "" {
+			report("assinatura de webhook %s sem URL ou segredo", s.ID)
+		}
+		for _, event := range s.Events {
+			if !ValidWebhookEvent(event) {
+				report("assinatura de webhook %s com evento inválido %q", s.ID, event)
+			}
+		}
+	}
+	confirmed := map[uuid.UUID]bool{}
+	for _, bi := range b.BankImports {
+		ids("extrato importado", bi.ID)
+		switch bi.Format {
+		case BankFormatOFX, BankFormatCSV, BankFormatCNAB240, BankFormatCNAB400:
+		default:
+			report("extrato importado %s com formato inválido %q", bi.ID, bi.Format)
+		}
+		for _, l := range bi.Lines {
+			ids("lançamento bancário", l.ID)
+			switch {
+			case l.BankImportID != bi.ID:
+				report("lançamento bancário %s fora do extrato %s", l.ID, bi.ID)
+			case l.Status != BankLineUnmatched && l.Status != BankLineMatched && l.Status != BankLineConfirmed:
+				report("lançamento bancário %s com situação inválida %q", l.ID, l.Status)
+			case (l.Status == BankLineUnmatched) != (l.FinanceOccurrenceID == nil):
+				report("lançamento bancário %s com situação %q e ocorrência incoerentes", l.ID, l.Status)
+			case l.FinanceOccurrenceID != nil && !occurrences[*l.FinanceOccurrenceID]:
+				report("lançamento bancário %s referencia ocorrência inexistente %s", l.ID, *l.FinanceOccurrenceID)
+			case l.Status == BankLineConfirmed && confirmed[*l.FinanceOccurrenceID]:
+				report("ocorrência %s conciliada com mais de um lançamento bancário", *l.FinanceOccurrenceID)
+			case l.Status == BankLineConfirmed:
+				confirmed[*l.FinanceOccurrenceID] = true
+			}
+		}
+	}
+	occurrenceFinance := map[uuid.UUID]uuid.UUID{}
+	for _, fo := range b.FinanceOccurrences {
+		occurrenceFinance[fo.ID] = fo.FinanceID
+	}
+	accessKeys, invoiced := map[string]bool{}, map[uuid.UUID]bool{}
+	for _, ii := range b.InvoiceImports {
+		ids("nota importada", ii.ID)
+		switch {
+		case ii.Invoice == nil || ii.Invoice.AccessKey == "":
+			report("nota importada %s sem a nota ou sem chave de acesso", ii.ID)
+		case ii.Invoice.Model != "55" && ii.Invoice.Model != "65":
+			report("nota importada %s com modelo inválido %q", ii.ID, ii.Invoice.Model)
+		case accessKeys[ii.Invoice.AccessKey]:
+			report("nota %s importada mais de uma vez", ii.Invoice.AccessKey)
+		default:
+			accessKeys[ii.Invoice.AccessKey] = true
+		}
+		if ii.ImportedBy != nil && !users[*ii.ImportedBy] {
+			report("nota importada %s referencia usuário inexistente %s", ii.ID, *ii.ImportedBy)
+		}
+		if len(ii.Entries) == 0 {
+			report("nota importada %s sem lançamentos", ii.ID)
+		}
+		for _, e := range ii.Entries {
+			ids("lançamento de nota", e.ID)
+			switch {
+			case !finances[e.FinanceID]:
+				report("lançamento de nota %s referencia finança inexistente %s", e.ID, e.FinanceID)
+			case !occurrences[e.FinanceOccurrenceID]:
+				report("lançamento de nota %s referencia ocorrência inexistente %s", e.ID, e.FinanceOccurrenceID)
+			case occurrenceFinance[e.FinanceOccurrenceID] != e.FinanceID:
+				report("lançamento de nota %s com ocorrência %s de outra finança", e.ID, e.FinanceOccurrenceID)
+			case invoiced[e.FinanceOccurrenceID]:
+				report("ocorrência %s em mais de um lançamento de nota", e.FinanceOccurrenceID)
+			default:
+				invoiced[e.FinanceOccurrenceID] = true
+			}
+		}
+	}
+
+	if b.Settings != nil {
+		if _, err := time.LoadLocation(b.Settings.Timezone); err != nil || b.Settings.Timezone == "" {
+			report("fuso da casa desconhecido: %q", b.Settings.Timezone)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &BackupIntegrityError{Problems: problems}
+	}
+	return nil
+}
+
+// costCentersInOrder ordena os centros de custo de forma que cada pai venha antes dos filhos
+func (b *Backup) costCentersInOrder() ([]FinanceCC, error) {
+	ordered := make([]FinanceCC, 0, len(b.CostCenters))
+	placed := map[uuid.UUID]bool{}
+	pending := b.CostCenters
+	for len(pending) > 0 {
+		var next []FinanceCC
+		for _, cc := range pending {
+			if cc.ParentID == nil || placed[*cc.ParentID] {
+				ordered = append(ordered, cc)
+				placed[cc.ID] = true
+			} else {
+				next = append(next, cc)
+			}
+		}
+		if len(next) == len(pending) {
+			return nil, fmt.Errorf("centros de custo com hierarquia circular ou pai ausente")
+		}
+		pending = next
+	}
+	return ordered, nil
+}
+
+// RestoreBackup grava o backup em um banco vazio, gerando novos IDs para todos os registros.
+// Tudo acontece em uma única transação: qualquer erro desfaz a restauração inteira.
+func RestoreBackup(b *Backup) (*RestoreResult, error) {
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := config.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM users) + (SELECT COUNT(*) FROM payer_groups) + (SELECT COUNT(*) FROM finance_cc)
+			+ (SELECT COUNT(*) FROM finance_currency) + (SELECT COUNT(*) FROM finance_installments) + (SELECT COUNT(*) FROM task_installments)
+			+ (SELECT COUNT(*) FROM notification_rules) + (SELECT COUNT(*) FROM webhook_subscriptions) + (SELECT COUNT(*) FROM bank_imports)
+			+ (SELECT COUNT(*) FROM invoice_imports)
+	`).Scan(&existing)
+	if err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, ErrDatabaseNotEmpty
+	}
+
+	// As transações e carteiras vêm do próprio backup; o trigger que as gera ao pagar
+	// uma ocorrência duplicaria os lançamentos
+	if _, err := tx.Exec(`ALTER TABLE finance_occurrences DISABLE TRIGGER process_finance_occurrence_trigger`); err != nil {
+		return nil, err
+	}
+	// Os registros restaurados não são eventos novos: a caixa de saída dos webhooks, o feed de alterações
+	// (GET /events) e o log de auditoria ficam desligados nesta transação
+	if _, err := tx.Exec(`SET LOCAL casa360.outbox = 'off'`); err != nil {
+		return nil, err
+	}
+
+	// Backups anteriores ao fuso da casa mantêm o fuso padrão
+	if b.Settings != nil {
+		if _, err := tx.Exec(`UPDATE household_settings SET timezone = $1, updated_at = CURRENT_TIMESTAMP`, b.Settings.Timezone); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := map[uuid.UUID]uuid.UUID{}
+	remap := func(old uuid.UUID) uuid.UUID {
+		id := uuid.New()
+		ids[old] = id
+		return id
+	}
+	// ref traduz uma referência opcional; o ID nulo vira NULL no banco
+	ref := func(old uuid.UUID) *uuid.UUID {
+		if old == uuid.Nil {
+			return nil
+		}
+		id := ids[old]
+		return &id
+	}
+
+	refOptional := func(old *uuid.UUID) *uuid.UUID {
+		if old == nil {
+			return nil
+		}
+		return ref(*old)
+	}
+
+	exec := func(query string, args ...interface{}) error {
+		_, err := tx.Exec(query, args...)
+		return err
+	}
+
+	for _, u := range b.Users {
+		if err := exec(`INSERT INTO users (id, name, pix_key, pix_city, deleted_at) VALUES ($1, $2, $3, $4, $5)`,
+			remap(u.ID), u.Name, u.PixKey, u.PixCity, u.DeletedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range b.PayerGroups {
+		if err := exec(`INSERT INTO payer_groups (id, name, deleted_at) VALUES ($1, $2, $3)`, remap(g.ID), g.Name, g.DeletedAt); err != nil {
+			return nil, err
+		}
+		for _, m := range g.Members {
+			if err := exec(`INSERT INTO payer_group_members (id, payer_group_id, user_id, percentage) VALUES ($1, $2, $3, $4)`,
+				remap(m.ID), ids[g.ID], ids[m.UserID], m.Percentage); err != nil {
+				return nil, err
+			}
+		}
+	}
+	costCenters, err := b.costCentersInOrder()
+	if err != nil {
+		return nil, err
+	}
+	for _, cc := range costCenters {
+		var parentID *uuid.UUID
+		if cc.ParentID != nil {
+			parentID = ref(*cc.ParentID)
+		}
+		if err := exec(`INSERT INTO finance_cc (id, name, parent_id, deleted_at) VALUES ($1, $2, $3, $4)`, remap(cc.ID), cc.Name, parentID, cc.DeletedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, fc := range b.Currencies {
+		if err := exec(`INSERT INTO finance_currency (id, name, symbol, value, deleted_at) VALUES ($1, $2, $3, $4, $5)`,
+			remap(fc.ID), fc.Name, fc.Symbol, fc.Value, fc.DeletedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, fi := range b.Finances {
+		if err := exec(`
+			INSERT INTO finance_installments (id, title, description, type, start_date, end_date, recurrence_days, amount, user_id, payer_group_id, finance_cc_id, currency_id, pix_code,
+				max_occurrences, deleted_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+			remap(fi.ID), fi.Title, fi.Description, fi.Type, fi.StartDate, fi.EndDate, fi.RecurrenceDays, fi.Amount,
+			ref(fi.UserID), ref(fi.PayerGroupID), ref(fi.FinanceCCID), ref(fi.CurrencyID), fi.PixCode, fi.MaxOccurrences, fi.DeletedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, fo := range b.FinanceOccurrences {
+		if err := exec(`
+			INSERT INTO finance_occurrences (id, finance_id, date, amount, status, pix_code, boleto_code, original_date)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			remap(fo.ID), ids[fo.FinanceID], fo.Date, fo.Amount, fo.Status, fo.PixCode, fo.BoletoCode, fo.OriginalDate); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range b.Transactions {
+		if err := exec(`INSERT INTO transactions (id, finance_occurrence_id, amount, created_at) VALUES ($1, $2, $3, $4)`,
+			remap(t.ID), ids[t.FinanceOccurrenceID], t.Amount, t.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, w := range b.Wallets {
+		if err := exec(`INSERT INTO finance_wallets (id, user_id, amount, created_at) VALUES ($1, $2, $3, $4)`,
+			remap(w.ID), ids[w.UserID], w.Amount, w.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range b.Tasks {
+		// Backups anteriores aos pontos de esforço valem 1 ponto por ocorrência, como o padrão da API
+		t.TaskInstallment.EffortPoints = 1
+		if t.EffortPoints != nil {
+			t.TaskInstallment.EffortPoints = *t.EffortPoints
+		}
+		if err := exec(`
+			INSERT INTO task_installments (id, title, description, start_date, recurrence_cron, subtasks, user_id, payer_group_id, rotation,
+				effort_points, estimated_minutes, end_date, max_occurrences, duration_minutes, deleted_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+			remap(t.ID), t.Title, t.Description, t.StartDate, t.RecurrenceCron, t.Subtasks, ref(t.UserID), ref(t.PayerGroupID), t.Rotation,
+			t.TaskInstallment.EffortPoints, t.EstimatedMinutes, t.EndDate, t.MaxOccurrences, t.DurationMinutes, t.DeletedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, o := range b.TaskOccurrences {
+		// Backups anteriores aos estados só têm o status
+		if o.State == "" {
+			o.syncState(nil)
+		}
+		if err := exec(`
+			INSERT INTO task_occurrences (id, task_id, date, status, user_id, payer_group_id, subtasks, completed_by, completed_at, effort_points,
+				state, state_changed_at, state_changed_by, original_date, scheduled_at, duration_minutes, due_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+			remap(o.ID), ids[o.TaskID], o.Date, o.Status, ref(o.UserID), ref(o.PayerGroupID), o.Subtasks,
+			refOptional(o.CompletedBy), o.CompletedAt, o.EffortPoints, o.State, o.StateChangedAt, refOptional(o.StateChangedBy),
+			o.OriginalDate, o.ScheduledAt, o.DurationMinutes, o.DueAt); err != nil {
+			return nil, err
+		}
+	}
+	// Backups anteriores ao histórico começam com o estado atual de cada ocorrência
+	stateHistory := len(b.TaskStateHistory)
+	if b.TaskStateHistory == nil {
+		if err := exec(`
+			INSERT INTO task_occurrence_states (task_occurrence_id, from_state, to_state, changed_by, changed_at)
+			SELECT id, NULL, state, state_changed_by, state_changed_at FROM task_occurrences`); err != nil {
+			return nil, err
+		}
+		stateHistory = len(b.TaskOccurrences)
+	}
+	for _, h := range b.TaskStateHistory {
+		if err := exec(`
+			INSERT INTO task_occurrence_states (id, task_occurrence_id, from_state, to_state, changed_by, changed_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			remap(h.ID), ids[h.TaskOccurrenceID], h.FromState, h.ToState, refOptional(h.ChangedBy), h.ChangedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range b.SwapRequests {
+		if err := exec(`
+			INSERT INTO task_swap_requests (id, task_occurrence_id, requester_id, target_user_id, target_occurrence_id, status, created_at, resolved_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			remap(s.ID), ids[s.TaskOccurrenceID], ids[s.RequesterID], ids[s.TargetUserID], refOptional(s.TargetOccurrenceID), s.Status, s.CreatedAt, s.ResolvedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range b.Exceptions {
+		if err := exec(`
+			INSERT INTO occurrence_exceptions (id, task_id, finance_id, original_date, action, new_date, created_by, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			remap(e.ID), refOptional(e.TaskID), refOptional(e.FinanceID), e.OriginalDate, e.Action, e.NewDate, refOptional(e.CreatedBy), e.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, tp := range b.Pauses {
+		if err := exec(`
+			INSERT INTO template_pauses (id, task_id, finance_id, start_date, end_date, reason, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			remap(tp.ID), refOptional(tp.TaskID), refOptional(tp.FinanceID), tp.StartDate, tp.EndDate, tp.Reason, tp.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range b.AwayPeriods {
+		if err := exec(`
+			INSERT INTO away_periods (id, user_id, start_date, end_date, reason, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			remap(a.ID), ids[a.UserID], a.StartDate, a.EndDate, a.Reason, a.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range b.NotificationSettings {
+		if err := exec(`
+			INSERT INTO notification_settings (user_id, quiet_hours_start, quiet_hours_end, updated_at)
+			VALUES ($1, $2, $3, COALESCE($4, CURRENT_TIMESTAMP))`,
+			ids[s.UserID], s.QuietHoursStart, s.QuietHoursEnd, s.UpdatedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, nc := range b.NotificationChannels {
+		if err := exec(`
+			INSERT INTO notification_channels (id, user_id, channel, address, enabled, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			remap(nc.ID), ids[nc.UserID], nc.Channel, nc.Address, nc.Enabled, nc.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, nr := range b.NotificationRules {
+		if err := exec(`
+			INSERT INTO notification_rules (id, event, offset_minutes, user_id, enabled, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			remap(nr.ID), nr.Event, nr.OffsetMinutes, refOptional(nr.UserID), nr.Enabled, nr.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range b.CalendarFeeds {
+		if err := exec(`INSERT INTO calendar_feeds (id, token, user_id, payer_group_id, created_at) VALUES ($1, $2, $3, $4, $5)`,
+			remap(f.ID), f.Token, refOptional(f.UserID), refOptional(f.PayerGroupID), f.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range b.WebhookSubscriptions {
+		if err := exec(`
+			INSERT INTO webhook_subscriptions (id, url, secret, events, description, enabled, created_at)
+			VALUES ($1, $2, $3, COALESCE($4::TEXT[], '{}'), $5, $6, $7)`,
+			remap(s.ID), s.URL, s.Secret, pq.Array(s.Events), s.Description, s.Enabled, s.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	bankLines := 0
+	for _, bi := range b.BankImports {
+		if err := exec(`INSERT INTO bank_imports (id, format, filename, created_at) VALUES ($1, $2, $3, $4)`,
+			remap(bi.ID), bi.Format, bi.Filename, bi.CreatedAt); err != nil {
+			return nil, err
+		}
+		for _, l := range bi.Lines {
+			if err := exec(`
+				INSERT INTO bank_lines (id, bank_import_id, date, amount, description, document, status, finance_occurrence_id)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				remap(l.ID), ids[bi.ID], l.Date, l.Amount, l.Description, l.Document, l.Status, refOptional(l.FinanceOccurrenceID)); err != nil {
+				return nil, err
+			}
+		}
+		bankLines += len(bi.Lines)
+	}
+	invoiceEntries := 0
+	for _, ii := range b.InvoiceImports {
+		invoice, err := json.Marshal(ii.Invoice)
+		if err != nil {
+			return nil, err
+		}
+		inv := ii.Invoice
+		if err := exec(`
+			INSERT INTO invoice_imports (id, access_key, model, series, number, issuer_document, issuer_name, issued_at, total, invoice, imported_by, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			remap(ii.ID), inv.AccessKey, inv.Model, inv.Series, inv.Number, inv.Issuer.Document, inv.Issuer.Name, inv.IssuedAt, inv.Total,
+			invoice, refOptional(ii.ImportedBy), ii.CreatedAt); err != nil {
+			return nil, err
+		}
+		for position, e := range ii.Entries {
+			if err := exec(`
+				INSERT INTO invoice_import_entries (id, invoice_import_id, position, finance_id, finance_occurrence_id, created_finance, items, amount)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				remap(e.ID), ids[ii.ID], position, ids[e.FinanceID], ids[e.FinanceOccurrenceID], e.CreatedFinance,
+				pq.Array(invoiceItemNumbers(e.Items)), e.Amount); err != nil {
+				return nil, err
+			}
+		}
+		invoiceEntries += len(ii.Entries)
+	}
+
+	if _, err := tx.Exec(`ALTER TABLE finance_occurrences ENABLE TRIGGER process_finance_occurrence_trigger`); err != nil {
+		return nil, err
+	}
+
+	members := 0
+	for _, g := range b.PayerGroups {
+		members += len(g.Members)
+	}
+	result := &RestoreResult{
+		Counts: map[string]int{
+			"users":                  len(b.Users),
+			"payer_groups":           len(b.PayerGroups),
+			"payer_group_members":    members,
+			"finance_cc":             len(b.CostCenters),
+			"finance_currency":       len(b.Currencies),
+			"finance_installments":   len(b.Finances),
+			"finance_occurrences":    len(b.FinanceOccurrences),
+			"transactions":           len(b.Transactions),
+			"finance_wallets":        len(b.Wallets),
+			"task_installments":      len(b.Tasks),
+			"task_occurrences":       len(b.TaskOccurrences),
+			"task_occurrence_states": stateHistory,
+			"task_swap_requests":     len(b.SwapRequests),
+			"occurrence_exceptions":  len(b.Exceptions),
+			"template_pauses":        len(b.Pauses),
+			"away_periods":           len(b.AwayPeriods),
+			"notification_settings":  len(b.NotificationSettings),
+			"notification_channels":  len(b.NotificationChannels),
+			"notification_rules":     len(b.NotificationRules),
+			"calendar_feeds":         len(b.CalendarFeeds),
+			"webhook_subscriptions":  len(b.WebhookSubscriptions),
+			"bank_imports":           len(b.BankImports),
+			"bank_lines":             bankLines,
+			"invoice_imports":        len(b.InvoiceImports),
+			"invoice_import_entries": invoiceEntries,
+		},
+		IDMap: ids,
+	}
+
+	// Confere se cada tabela terminou com exatamente os registros do backup
+	for table, expected := range result.Counts {
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count != expected {
+			return nil, &BackupIntegrityError{Problems: []string{
+				fmt.Sprintf("tabela %s com %d registros após a restauração, esperado %d", table, count, expected),
+			}}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
\ No newline at end of file
@@ -0,0 +1,481 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+)
+
+// Formatos de arquivo aceitos na importação de extratos
+const (
+	BankFormatOFX     = "ofx"
+	BankFormatCSV     = "csv"
+	BankFormatCNAB240 = "cnab240"
+	BankFormatCNAB400 = "cnab400"
+)
+
+// Situações de um lançamento importado
+const (
+	BankLineUnmatched = "unmatched"
+	BankLineMatched   = "matched"
+	BankLineConfirmed = "confirmed"
+)
+
+// DefaultMatchWindowDays é a tolerância padrão, em dias, entre a data do lançamento e a da ocorrência
+const DefaultMatchWindowDays = 5
+
+var (
+	ErrBankLineConfirmed = errors.New("lançamento já conciliado")
+	ErrBankLineNoMatch   = errors.New("lançamento sem ocorrência associada")
+	ErrOccurrenceNotOpen = errors.New("ocorrência não encontrada ou já paga")
+	// ErrOccurrenceConfirmed impede conciliar com um segundo lançamento uma ocorrência que voltou a ficar em aberto
+	ErrOccurrenceConfirmed = errors.New("ocorrência já conciliada com outro lançamento")
+)
+
+type BankImport struct {
+	ID        uuid.UUID  `json:"id"`
+	Format    string     `json:"format"`
+	Filename  string     `json:"filename"`
+	CreatedAt time.Time  `json:"created_at"`
+	Lines     []BankLine `json:"lines,omitempty"`
+}
+
+type BankLine struct {
+	ID                  uuid.UUID  `json:"id"`
+	BankImportID        uuid.UUID  `json:"bank_import_id"`
+	Date                time.Time  `json:"date"`
+	Amount              float64    `json:"amount"`
+	Description         string     `json:"description"`
+	Document            string     `json:"document"`
+	Status              string     `json:"status"`
+	FinanceOccurrenceID *uuid.UUID `json:"finance_occurrence_id,omitempty"`
+}
+
+// Create grava a importação e seus lançamentos e executa a conciliação automática em uma única transação:
+// se a conciliação falhar, nada é gravado e o arquivo pode ser enviado de novo sem duplicar o extrato
+func (bi *BankImport) Create(parsed []ParsedBankLine, windowDays int) error {
+	tx, err := config.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO bank_imports (id, format, filename)
+		VALUES ($1, $2, $3)
+		RETURNING id, format, filename, created_at
+	`
+	if err := tx.QueryRow(query, uuid.New(), bi.Format, bi.Filename).
+		Scan(&bi.ID, &bi.Format, &bi.Filename, &bi.CreatedAt); err != nil {
+		return err
+	}
+
+	lineQuery := `
+		INSERT INTO bank_lines (id, bank_import_id, date, amount, description, document, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	for _, p := range parsed {
+		if _, err := tx.Exec(lineQuery, uuid.New(), bi.ID, p.Date, p.Amount, p.Description, p.Document, BankLineUnmatched); err != nil {
+			return err
+		}
+	}
+
+	if _, err := matchBankImport(tx, bi.ID, windowDays); err != nil {
+		return err
+	}
+	if bi.Lines, err = listBankLines(tx, bi.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get busca uma importação e seus lançamentos
+func (bi *BankImport) Get() error {
+	query := `
+		SELECT id, format, filename, created_at
+		FROM bank_imports
+		WHERE id = $1
+	`
+	if err := config.GetDB().QueryRow(query, bi.ID).
+		Scan(&bi.ID, &bi.Format, &bi.Filename, &bi.CreatedAt); err != nil {
+		return err
+	}
+
+	lines, err := ListBankLinesByImportID(bi.ID)
+	if err != nil {
+		return err
+	}
+	bi.Lines = lines
+	return nil
+}
+
+func ListBankImports() ([]BankImport, error) {
+	query := `
+		SELECT id, format, filename, created_at
+		FROM bank_imports
+		ORDER BY created_at DESC
+	`
+	rows, err := config.GetDB().Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var imports []BankImport
+	for rows.Next() {
+		var bi BankImport
+		if err := rows.Scan(&bi.ID, &bi.Format, &bi.Filename, &bi.CreatedAt); err != nil {
+			return nil, err
+		}
+		imports = append(imports, bi)
+	}
+	return imports, nil
+}
+
+func ListBankLinesByImportID(importID uuid.UUID) ([]BankLine, error) {
+	return listBankLines(config.GetDB(), importID)
+}
+
+func listBankLines(q queryer, importID uuid.UUID) ([]BankLine, error) {
+	query := `
+		SELECT id, bank_import_id, date, amount, description, document, status, finance_occurrence_id
+		FROM bank_lines
+		WHERE bank_import_id = $1
+		ORDER BY date, amount
+	`
+	rows, err := q.Query(query, importID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []BankLine
+	for rows.Next() {
+		var bl BankLine
+		if err := rows.Scan(&bl.ID, &bl.BankImportID, &bl.Date, &bl.Amount, &bl.Description, &bl.Document, &bl.Status, &bl.FinanceOccurrenceID); err != nil {
+			return nil, err
+		}
+		lines = append(lines, bl)
+	}
+	return lines, nil
+}
+
+// Get busca um lançamento importado pelo ID
+func (bl *BankLine) Get() error {
+	query := `
+		SELECT id, bank_import_id, date, amount, description, document, status, finance_occurrence_id
+		FROM bank_lines
+		WHERE id = $1
+	`
+	return config.GetDB().QueryRow(query, bl.ID).
+		Scan(&bl.ID, &bl.BankImportID, &bl.Date, &bl.Amount, &bl.Description, &bl.Document, &bl.Status, &bl.FinanceOccurrenceID)
+}
+
+// matchCandidate é uma ocorrência financeira em aberto que pode corresponder a um lançamento
+type matchCandidate struct {
+	ID          uuid.UUID
+	Date        time.Time
+	Amount      float64 // valor já convertido pela taxa da moeda
+	Type        bool
+	Title       string
+	Description string
+}
+
+// MatchBankImport associa os lançamentos ainda não conciliados de uma importação às ocorrências
+// financeiras em aberto com o mesmo valor, data dentro da janela e descrição semelhante.
+// Retorna a quantidade de lançamentos associados.
+func MatchBankImport(importID uuid.UUID, windowDays int) (int, error) {
+	tx, err := config.GetDB().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	matched, err := matchBankImport(tx, importID, windowDays)
+	if err != nil {
+		return 0, err
+	}
+	return matched, tx.Commit()
+}
+
+func matchBankImport(tx queryer, importID uuid.UUID, windowDays int) (int, error) {
+	if windowDays < 0 {
+		windowDays = DefaultMatchWindowDays
+	}
+
+	lines, err := listBankLines(tx, importID)
+	if err != nil {
+		return 0, err
+	}
+
+	var pending []BankLine
+	var minDate, maxDate time.Time
+	for _, bl := range lines {
+		if bl.Status != BankLineUnmatched {
+			continue
+		}
+		if len(pending) == 0 || bl.Date.Before(minDate) {
+			minDate = bl.Date
+		}
+		if len(pending) == 0 || bl.Date.After(maxDate) {
+			maxDate = bl.Date
+		}
+		pending = append(pending, bl)
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	candidates, err := listMatchCandidates(tx, minDate.AddDate(0, 0, -windowDays), maxDate.AddDate(0, 0, windowDays))
+	if err != nil {
+		return 0, err
+	}
+
+	type pair struct {
+		line      int
+		candidate int
+		score     float64
+	}
+	var pairs []pair
+	for i, bl := range pending {
+		for j, cand := range candidates {
+			// Débitos correspondem a despesas e créditos a receitas
+			if (bl.Amount < 0) != cand.Type {
+				continue
+			}
+			if math.Abs(math.Abs(bl.Amount)-cand.Amount) >= 0.005 {
+				continue
+			}
+			days := math.Abs(bl.Date.Sub(cand.Date).Hours() / 24)
+			if days > float64(windowDays) {
+				continue
+			}
+			similarity := textSimilarity(cand.Title+" "+cand.Description, bl.Description)
+			pairs = append(pairs, pair{i, j, days - similarity*float64(windowDays+1)})
+		}
+	}
+
+	// Associação gulosa: os pares mais próximos são escolhidos primeiro
+	sort.SliceStable(pairs, func(a, b int) bool { return pairs[a].score < pairs[b].score })
+
+	usedLines := map[int]bool{}
+	usedCandidates := map[int]bool{}
+	matched := 0
+	for _, p := range pairs {
+		if usedLines[p.line] || usedCandidates[p.candidate] {
+			continue
+		}
+		usedLines[p.line] = true
+		usedCandidates[p.candidate] = true
+
+		_, err := tx.Exec(`
+			UPDATE bank_lines
+			SET status = $1, finance_occurrence_id = $2
+			WHERE id = $3
+		`, BankLineMatched, candidates[p.candidate].ID, pending[p.line].ID)
+		if err != nil {
+			return 0, err
+		}
+		matched++
+	}
+
+	return matched, nil
+}
+
+func listMatchCandidates(q queryer, from, to time.Time) ([]matchCandidate, error) {
+	query := `
+		SELECT fo.id, fo.date, fo.amount * COALESCE(fc.value, 1), fi.type, fi.title, COALESCE(fi.description, '')
+		FROM finance_occurrences fo
+		INNER JOIN finance_installments fi ON fo.finance_id = fi.id
+		LEFT JOIN finance_currency fc ON fi.currency_id = fc.id
+		WHERE fo.status = false
+		  AND fo.date BETWEEN $1 AND $2
+		  AND NOT EXISTS (SELECT 1 FROM bank_lines bl WHERE bl.finance_occurrence_id = fo.id)
+	`
+	rows, err := q.Query(query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []matchCandidate
+	for rows.Next() {
+		var mc matchCandidate
+		if err := rows.Scan(&mc.ID, &mc.Date, &mc.Amount, &mc.Type, &mc.Title, &mc.Description); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, mc)
+	}
+	return candidates, nil
+}
+
+// Confirm confirma a associação do lançamento, marcando a ocorrência como paga. Se occurrenceID
+// for informado, ele substitui a sugestão feita pela conciliação automática.
+func (bl *BankLine) Confirm(occurrenceID *uuid.UUID, by *uuid.UUID) error {
+	tx, err := begin(by)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := bl.lock(tx); err != nil {
+		return err
+	}
+	if bl.Status == BankLineConfirmed {
+		return ErrBankLineConfirmed
+	}
+	if occurrenceID == nil {
+		occurrenceID = bl.FinanceOccurrenceID
+	}
+	if occurrenceID == nil {
+		return ErrBankLineNoMatch
+	}
+
+	var confirmed bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM bank_lines WHERE finance_occurrence_id = $1 AND status = $2 AND id <> $3)
+	`, *occurrenceID, BankLineConfirmed, bl.ID).Scan(&confirmed)
+	if err != nil {
+		return err
+	}
+	if confirmed {
+		return ErrOccurrenceConfirmed
+	}
+
+	// A trigger process_finance_occurrence registra a transação e atualiza as carteiras
+	result, err := tx.Exec(`
+		UPDATE finance_occurrences
+		SET status = true
+		WHERE id = $1 AND status = false
+	`, *occurrenceID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrOccurrenceNotOpen
+	}
+
+	if err := bl.markConfirmed(tx, *occurrenceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateFinance cria uma finança avulsa (ocorrência única, já paga) a partir de um lançamento
+// sem correspondência. Título, usuário, grupo, centro de custo e moeda vêm de fi.
+func (bl *BankLine) CreateFinance(fi *FinanceInstallment, by *uuid.UUID) (*FinanceOccurrence, error) {
+	tx, err := begin(by)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := bl.lock(tx); err != nil {
+		return nil, err
+	}
+	if bl.Status == BankLineConfirmed {
+		return nil, ErrBankLineConfirmed
+	}
+
+	date := bl.Date
+	fi.Type = bl.Amount < 0
+	fi.StartDate = date
+	fi.EndDate = &date
+	fi.RecurrenceDays = 1
+	fi.Amount = math.Abs(bl.Amount)
+	if fi.Title == "" {
+		fi.Title = bl.Description
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO finance_installments (id, title, description, type, start_date, end_date, recurrence_days, amount, user_id, payer_group_id, finance_cc_id, currency_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, title, description, type, start_date, end_date, recurrence_days, amount, user_id, payer_group_id, finance_cc_id, currency_id
+	`, uuid.New(), fi.Title, fi.Description, fi.Type, fi.StartDate, fi.EndDate, fi.RecurrenceDays, fi.Amount, fi.UserID, fi.PayerGroupID, fi.FinanceCCID, fi.CurrencyID).
+		Scan(&fi.ID, &fi.Title, &fi.Description, &fi.Type, &fi.StartDate, &fi.EndDate, &fi.RecurrenceDays, &fi.Amount, &fi.UserID, &fi.PayerGroupID, &fi.FinanceCCID, &fi.CurrencyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var fo FinanceOccurrence
+	err = tx.QueryRow(`
+		INSERT INTO finance_occurrences (id, finance_id, date, amount, status)
+		VALUES ($1, $2, $3, $4, true)
+		RETURNING id, finance_id, date, amount, status
+	`, uuid.New(), fi.ID, date, fi.Amount).
+		Scan(&fo.ID, &fo.FinanceID, &fo.Date, &fo.Amount, &fo.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bl.markConfirmed(tx, fo.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &fo, nil
+}
+
+func (bl *BankLine) lock(tx *sql.Tx) error {
+	query := `
+		SELECT id, bank_import_id, date, amount, description, document, status, finance_occurrence_id
+		FROM bank_lines
+		WHERE id = $1
+		FOR UPDATE
+	`
+	return tx.QueryRow(query, bl.ID).
+		Scan(&bl.ID, &bl.BankImportID, &bl.Date, &bl.Amount, &bl.Description, &bl.Document, &bl.Status, &bl.FinanceOccurrenceID)
+}
+
+func (bl *BankLine) markConfirmed(tx *sql.Tx, occurrenceID uuid.UUID) error {
+	query := `
+		UPDATE bank_lines
+		SET status = $1, finance_occurrence_id = $2
+		WHERE id = $3
+		RETURNING status, finance_occurrence_id
+	`
+	return tx.QueryRow(query, BankLineConfirmed, occurrenceID, bl.ID).
+		Scan(&bl.Status, &bl.FinanceOccurrenceID)
+}
+
+// textSimilarity retorna a fração (0 a 1) das palavras de reference encontradas em text
+func textSimilarity(reference, text string) float64 {
+	words := strings.Fields(normalizeText(reference))
+	haystack := normalizeText(text)
+
+	total, found := 0, 0
+	for _, w := range words {
+		if len(w) < 3 {
+			continue
+		}
+		total++
+		if strings.Contains(haystack, w) {
+			found++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(found) / float64(total)
+}
+
+var accentReplacer = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+	"é", "e", "ê", "e", "è", "e",
+	"í", "i", "î", "i",
+	"ó", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ú", "u", "ü", "u",
+	"ç", "c",
+)
+
+func normalizeText(s string) string {
+	return accentReplacer.Replace(strings.ToLower(s))
+}
\ No newline at end of file
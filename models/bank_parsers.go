@@ -0,0 +1,421 @@
+package models
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParsedBankLine representa um lançamento lido de um arquivo bancário antes de ser persistido
+type ParsedBankLine struct {
+	Date        time.Time
+	Amount      float64 // negativo = débito, positivo = crédito
+	Description string
+	Document    string
+}
+
+// CSVMapping descreve como as colunas de um CSV genérico são mapeadas para um lançamento.
+// As colunas podem ser informadas pelo nome do cabeçalho ou pelo índice (começando em 0).
+type CSVMapping struct {
+	Delimiter         string `json:"delimiter"`
+	HasHeader         bool   `json:"has_header"`
+	DateColumn        string `json:"date_column"`
+	AmountColumn      string `json:"amount_column"`
+	DescriptionColumn string `json:"description_column"`
+	DocumentColumn    string `json:"document_column"`
+	DateFormat        string `json:"date_format"`
+	DecimalComma      *bool  `json:"decimal_comma,omitempty"` // sem ele, o separador é detectado em cada valor
+}
+
+// ParseBankFile lê um arquivo bancário no formato informado
+func ParseBankFile(format string, r io.Reader, mapping *CSVMapping) ([]ParsedBankLine, error) {
+	switch format {
+	case BankFormatOFX:
+		return ParseOFX(r)
+	case BankFormatCSV:
+		if mapping == nil {
+			return nil, fmt.Errorf("mapeamento de colunas obrigatório para CSV")
+		}
+		return ParseCSV(r, *mapping)
+	case BankFormatCNAB240:
+		return ParseCNAB240(r)
+	case BankFormatCNAB400:
+		return ParseCNAB400(r)
+	default:
+		return nil, fmt.Errorf("formato de arquivo não suportado: %s", format)
+	}
+}
+
+// ParseOFX lê as transações (STMTTRN) de um extrato OFX, tanto no formato SGML (1.x) quanto XML (2.x)
+func ParseOFX(r io.Reader) ([]ParsedBankLine, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []ParsedBankLine
+	var current map[string]string
+
+	// Cada token tem o formato "TAG>valor", pois o SGML do OFX não exige tags de fechamento
+	for _, token := range strings.Split(string(data), "<")[1:] {
+		parts := strings.SplitN(token, ">", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		tag := strings.ToUpper(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch tag {
+		case "STMTTRN":
+			current = map[string]string{}
+		case "/STMTTRN":
+			if current == nil {
+				continue
+			}
+			line, err := ofxTransaction(current)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, line)
+			current = nil
+		default:
+			if current != nil && !strings.HasPrefix(tag, "/") {
+				current[tag] = value
+			}
+		}
+	}
+
+	return lines, nil
+}
+
+func ofxTransaction(fields map[string]string) (ParsedBankLine, error) {
+	posted := fields["DTPOSTED"]
+	if len(posted) < 8 {
+		return ParsedBankLine{}, fmt.Errorf("data inválida no OFX: %q", posted)
+	}
+	date, err := time.Parse("20060102", posted[:8])
+	if err != nil {
+		return ParsedBankLine{}, fmt.Errorf("data inválida no OFX: %q", posted)
+	}
+
+	amount, err := parseDecimal(fields["TRNAMT"], decimalCommaIn(fields["TRNAMT"]))
+	if err != nil {
+		return ParsedBankLine{}, fmt.Errorf("valor inválido no OFX: %q", fields["TRNAMT"])
+	}
+
+	description := fields["MEMO"]
+	if name := fields["NAME"]; name != "" && name != description {
+		description = strings.TrimSpace(name + " " + description)
+	}
+
+	document := fields["CHECKNUM"]
+	if document == "" {
+		document = fields["FITID"]
+	}
+
+	return ParsedBankLine{
+		Date:        date,
+		Amount:      amount,
+		Description: description,
+		Document:    document,
+	}, nil
+}
+
+// ParseCSV lê um extrato CSV genérico usando o mapeamento de colunas informado
+func ParseCSV(r io.Reader, mapping CSVMapping) ([]ParsedBankLine, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if mapping.Delimiter != "" {
+		reader.Comma = []rune(mapping.Delimiter)[0]
+	}
+
+	dateFormat := mapping.DateFormat
+	if dateFormat == "" {
+		dateFormat = "2006-01-02"
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var header []string
+	if mapping.HasHeader && len(records) > 0 {
+		header = records[0]
+		records = records[1:]
+	}
+
+	dateIdx, err := csvColumnIndex(header, mapping.DateColumn)
+	if err != nil {
+		return nil, err
+	}
+	amountIdx, err := csvColumnIndex(header, mapping.AmountColumn)
+	if err != nil {
+		return nil, err
+	}
+	descriptionIdx := -1
+	if mapping.DescriptionColumn != "" {
+		if descriptionIdx, err = csvColumnIndex(header, mapping.DescriptionColumn); err != nil {
+			return nil, err
+		}
+	}
+	documentIdx := -1
+	if mapping.DocumentColumn != "" {
+		if documentIdx, err = csvColumnIndex(header, mapping.DocumentColumn); err != nil {
+			return nil, err
+		}
+	}
+
+	var lines []ParsedBankLine
+	for i, record := range records {
+		if len(record) == 0 || (len(record) == 1 && strings.TrimSpace(record[0]) == "") {
+			continue
+		}
+		if dateIdx >= len(record) || amountIdx >= len(record) {
+			return nil, fmt.Errorf("linha %d: colunas insuficientes", i+1)
+		}
+
+		date, err := time.Parse(dateFormat, strings.TrimSpace(record[dateIdx]))
+		if err != nil {
+			return nil, fmt.Errorf("linha %d: data inválida: %q", i+1, record[dateIdx])
+		}
+		decimalComma := decimalCommaIn(record[amountIdx])
+		if mapping.DecimalComma != nil {
+			decimalComma = *mapping.DecimalComma
+		}
+		amount, err := parseDecimal(record[amountIdx], decimalComma)
+		if err != nil {
+			return nil, fmt.Errorf("linha %d: valor inválido: %q", i+1, record[amountIdx])
+		}
+
+		line := ParsedBankLine{Date: date, Amount: amount}
+		if descriptionIdx >= 0 && descriptionIdx < len(record) {
+			line.Description = strings.TrimSpace(record[descriptionIdx])
+		}
+		if documentIdx >= 0 && documentIdx < len(record) {
+			line.Document = strings.TrimSpace(record[documentIdx])
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+func csvColumnIndex(header []string, column string) (int, error) {
+	if column == "" {
+		return 0, fmt.Errorf("coluna obrigatória não informada no mapeamento")
+	}
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			return i, nil
+		}
+	}
+	idx, err := strconv.Atoi(column)
+	if err != nil || idx < 0 {
+		return 0, fmt.Errorf("coluna não encontrada: %s", column)
+	}
+	return idx, nil
+}
+
+// ParseCNAB240 lê um arquivo de retorno CNAB 240 (FEBRABAN). São suportados os segmentos
+// A e J (pagamentos), T/U (cobrança) e E (extrato)
+func ParseCNAB240(r io.Reader) ([]ParsedBankLine, error) {
+	var lines []ParsedBankLine
+	var pendingT *ParsedBankLine
+
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		record := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(record) == "" {
+			continue
+		}
+		if len(record) < 240 {
+			return nil, fmt.Errorf("linha %d: registro CNAB 240 com tamanho inválido (%d)", n, len(record))
+		}
+		// Apenas registros de detalhe (tipo 3) carregam lançamentos
+		if cnabField(record, 8, 8) != "3" {
+			continue
+		}
+
+		switch cnabField(record, 14, 14) {
+		case "A":
+			date, err := cnabDate(cnabField(record, 155, 162))
+			if err != nil {
+				// Sem data real de efetivação, usa a data de pagamento agendada
+				if date, err = cnabDate(cnabField(record, 94, 101)); err != nil {
+					return nil, fmt.Errorf("linha %d: %v", n, err)
+				}
+			}
+			amount := cnabAmount(cnabField(record, 163, 177))
+			if amount == 0 {
+				amount = cnabAmount(cnabField(record, 120, 134))
+			}
+			lines = append(lines, ParsedBankLine{
+				Date:        date,
+				Amount:      -amount,
+				Description: cnabField(record, 44, 73),
+				Document:    cnabField(record, 74, 93),
+			})
+		case "J":
+			date, err := cnabDate(cnabField(record, 145, 152))
+			if err != nil {
+				return nil, fmt.Errorf("linha %d: %v", n, err)
+			}
+			lines = append(lines, ParsedBankLine{
+				Date:        date,
+				Amount:      -cnabAmount(cnabField(record, 153, 167)),
+				Description: cnabField(record, 62, 91),
+				Document:    cnabField(record, 18, 61),
+			})
+		case "T":
+			date, err := cnabDate(cnabField(record, 74, 81))
+			if err != nil {
+				return nil, fmt.Errorf("linha %d: %v", n, err)
+			}
+			pendingT = &ParsedBankLine{
+				Date:        date,
+				Amount:      cnabAmount(cnabField(record, 82, 96)),
+				Description: cnabField(record, 149, 188),
+				Document:    cnabField(record, 59, 73),
+			}
+		case "U":
+			if pendingT == nil {
+				return nil, fmt.Errorf("linha %d: segmento U sem segmento T correspondente", n)
+			}
+			// O segmento U traz o valor efetivamente pago e a data da ocorrência
+			if paid := cnabAmount(cnabField(record, 78, 92)); paid > 0 {
+				pendingT.Amount = paid
+			}
+			if date, err := cnabDate(cnabField(record, 138, 145)); err == nil {
+				pendingT.Date = date
+			}
+			lines = append(lines, *pendingT)
+			pendingT = nil
+		case "E":
+			date, err := cnabDate(cnabField(record, 143, 150))
+			if err != nil {
+				return nil, fmt.Errorf("linha %d: %v", n, err)
+			}
+			amount := cnabAmount(cnabField(record, 151, 168))
+			if cnabField(record, 169, 169) == "D" {
+				amount = -amount
+			}
+			lines = append(lines, ParsedBankLine{
+				Date:        date,
+				Amount:      amount,
+				Description: cnabField(record, 177, 201),
+				Document:    cnabField(record, 202, 240),
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// ParseCNAB400 lê um arquivo de retorno de cobrança CNAB 400 no leiaute mais comum
+// (Bradesco/Itaú), considerando os registros de detalhe (tipo 1)
+func ParseCNAB400(r io.Reader) ([]ParsedBankLine, error) {
+	var lines []ParsedBankLine
+
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		record := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(record) == "" {
+			continue
+		}
+		if len(record) < 400 {
+			return nil, fmt.Errorf("linha %d: registro CNAB 400 com tamanho inválido (%d)", n, len(record))
+		}
+		if cnabField(record, 1, 1) != "1" {
+			continue
+		}
+
+		date, err := cnabShortDate(cnabField(record, 111, 116))
+		if err != nil {
+			return nil, fmt.Errorf("linha %d: %v", n, err)
+		}
+		amount := cnabAmount(cnabField(record, 254, 266))
+		if amount == 0 {
+			amount = cnabAmount(cnabField(record, 153, 165))
+		}
+
+		lines = append(lines, ParsedBankLine{
+			Date:        date,
+			Amount:      amount,
+			Description: "Ocorrência " + cnabField(record, 109, 110),
+			Document:    cnabField(record, 117, 126),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// cnabField retorna o campo entre as posições inicial e final (base 1, inclusivas) do leiaute
+func cnabField(record string, start, end int) string {
+	return strings.TrimSpace(record[start-1 : end])
+}
+
+func cnabDate(value string) (time.Time, error) {
+	date, err := time.Parse("02012006", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("data inválida no CNAB: %q", value)
+	}
+	return date, nil
+}
+
+func cnabShortDate(value string) (time.Time, error) {
+	date, err := time.Parse("020106", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("data inválida no CNAB: %q", value)
+	}
+	return date, nil
+}
+
+// cnabAmount converte um valor numérico do CNAB, que sempre tem duas casas decimais implícitas
+func cnabAmount(value string) float64 {
+	cents, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return float64(cents) / 100
+}
+
+// parseDecimal converte valores como "1.234,56" (decimalComma) ou "1,234.56"
+func parseDecimal(value string, decimalComma bool) (float64, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimPrefix(value, "R$")
+	value = strings.ReplaceAll(value, " ", "")
+	if decimalComma {
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.ReplaceAll(value, ",", ".")
+	} else {
+		value = strings.ReplaceAll(value, ",", "")
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return math.Round(amount*100) / 100, nil
+}
+
+// decimalCommaIn detecta o separador decimal pelo último separador do valor: em "1.234,56" é a vírgula,
+// em "1,234.56" é o ponto
+func decimalCommaIn(value string) bool {
+	return strings.LastIndex(value, ",") > strings.LastIndex(value, ".")
+}
\ No newline at end of file
@@ -0,0 +1,268 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tipos de boleto
+const (
+	BoletoBancario    = "bancario"    // boletos de cobrança bancária (47 dígitos)
+	BoletoArrecadacao = "arrecadacao" // contas de consumo e tributos (48 dígitos, iniciados por 8)
+)
+
+// Datas base do fator de vencimento. O fator voltou a 1000 em 22/02/2025, quando o ciclo iniciado
+// em 07/10/1997 chegou a 9999.
+var (
+	boletoFactorBase       = time.Date(1997, 10, 7, 0, 0, 0, 0, time.UTC)
+	boletoFactorSecondBase = time.Date(2025, 2, 22, 0, 0, 0, 0, time.UTC)
+)
+
+// Boleto representa uma linha digitável (ou código de barras) já validada
+type Boleto struct {
+	Type          string     `json:"type"`
+	DigitableLine string     `json:"digitable_line"`
+	Barcode       string     `json:"barcode"`
+	BankCode      string     `json:"bank_code,omitempty"`
+	CurrencyCode  string     `json:"currency_code,omitempty"`
+	SegmentCode   string     `json:"segment_code,omitempty"`
+	DueDateFactor int        `json:"due_date_factor,omitempty"`
+	DueDate       *time.Time `json:"due_date,omitempty"`
+	Amount        *float64   `json:"amount,omitempty"`
+	FreeField     string     `json:"free_field,omitempty"`
+	CompanyID     string     `json:"company_id,omitempty"`
+}
+
+// ParseBoleto valida uma linha digitável ou código de barras e extrai banco, vencimento e valor.
+// reference é usada para escolher o ciclo correto do fator de vencimento.
+func ParseBoleto(code string, reference time.Time) (*Boleto, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, code)
+
+	switch {
+	case len(digits) == 47:
+		return parseBancarioLine(digits, reference)
+	case len(digits) == 48:
+		return parseArrecadacaoLine(digits)
+	case len(digits) == 44 && digits[0] == '8':
+		return parseArrecadacaoBarcode(digits)
+	case len(digits) == 44:
+		return parseBancarioBarcode(digits, reference)
+	default:
+		return nil, fmt.Errorf("boleto deve ter 44, 47 ou 48 dígitos, recebido %d", len(digits))
+	}
+}
+
+func parseBancarioLine(line string, reference time.Time) (*Boleto, error) {
+	fields := []struct{ value, dv string }{
+		{line[0:9], line[9:10]},
+		{line[10:20], line[20:21]},
+		{line[21:31], line[31:32]},
+	}
+	for i, f := range fields {
+		if strconv.Itoa(mod10(f.value)) != f.dv {
+			return nil, fmt.Errorf("dígito verificador do campo %d inválido", i+1)
+		}
+	}
+
+	barcode := line[0:4] + line[32:33] + line[33:47] + line[4:9] + line[10:20] + line[21:31]
+	b, err := parseBancarioBarcode(barcode, reference)
+	if err != nil {
+		return nil, err
+	}
+	b.DigitableLine = line
+	return b, nil
+}
+
+func parseBancarioBarcode(barcode string, reference time.Time) (*Boleto, error) {
+	if strconv.Itoa(mod11Bancario(barcode[:4]+barcode[5:])) != barcode[4:5] {
+		return nil, fmt.Errorf("dígito verificador geral inválido")
+	}
+
+	b := &Boleto{
+		Type:         BoletoBancario,
+		Barcode:      barcode,
+		BankCode:     barcode[0:3],
+		CurrencyCode: barcode[3:4],
+		FreeField:    barcode[19:44],
+	}
+	if b.CurrencyCode != "9" {
+		return nil, fmt.Errorf("moeda do boleto não suportada: %s", b.CurrencyCode)
+	}
+
+	factor, _ := strconv.Atoi(barcode[5:9])
+	b.DueDateFactor = factor
+	if factor > 0 {
+		dueDate := boletoDueDate(factor, reference)
+		b.DueDate = &dueDate
+	}
+
+	if cents, _ := strconv.ParseInt(barcode[9:19], 10, 64); cents > 0 {
+		amount := float64(cents) / 100
+		b.Amount = &amount
+	}
+
+	if b.DigitableLine == "" {
+		b.DigitableLine = bancarioDigitableLine(barcode)
+	}
+	return b, nil
+}
+
+func parseArrecadacaoLine(line string) (*Boleto, error) {
+	useMod10 := line[2] == '6' || line[2] == '7'
+
+	var barcode strings.Builder
+	for i := 0; i < 4; i++ {
+		block := line[i*12 : i*12+11]
+		dv := line[i*12+11 : i*12+12]
+
+		expected := mod11Arrecadacao(block)
+		if useMod10 {
+			expected = mod10(block)
+		}
+		if strconv.Itoa(expected) != dv {
+			return nil, fmt.Errorf("dígito verificador do bloco %d inválido", i+1)
+		}
+		barcode.WriteString(block)
+	}
+
+	b, err := parseArrecadacaoBarcode(barcode.String())
+	if err != nil {
+		return nil, err
+	}
+	b.DigitableLine = line
+	return b, nil
+}
+
+func parseArrecadacaoBarcode(barcode string) (*Boleto, error) {
+	if barcode[0] != '8' {
+		return nil, fmt.Errorf("código de arrecadação deve iniciar com 8")
+	}
+
+	// O terceiro dígito indica o módulo do DV e se o campo de valor é efetivo (6 e 8) ou referência (7 e 9)
+	valueID := barcode[2]
+	var expected int
+	switch valueID {
+	case '6', '7':
+		expected = mod10(barcode[:3] + barcode[4:])
+	case '8', '9':
+		expected = mod11Arrecadacao(barcode[:3] + barcode[4:])
+	default:
+		return nil, fmt.Errorf("identificador de valor inválido: %c", valueID)
+	}
+	if strconv.Itoa(expected) != barcode[3:4] {
+		return nil, fmt.Errorf("dígito verificador geral inválido")
+	}
+
+	b := &Boleto{
+		Type:        BoletoArrecadacao,
+		Barcode:     barcode,
+		SegmentCode: barcode[1:2],
+		CompanyID:   barcode[15:19],
+		FreeField:   barcode[19:44],
+	}
+	if valueID == '6' || valueID == '8' {
+		if cents, _ := strconv.ParseInt(barcode[4:15], 10, 64); cents > 0 {
+			amount := float64(cents) / 100
+			b.Amount = &amount
+		}
+	}
+
+	if b.DigitableLine == "" {
+		var line strings.Builder
+		for i := 0; i < 4; i++ {
+			block := barcode[i*11 : i*11+11]
+			dv := mod11Arrecadacao(block)
+			if valueID == '6' || valueID == '7' {
+				dv = mod10(block)
+			}
+			line.WriteString(block + strconv.Itoa(dv))
+		}
+		b.DigitableLine = line.String()
+	}
+	return b, nil
+}
+
+func bancarioDigitableLine(barcode string) string {
+	f1 := barcode[0:4] + barcode[19:24]
+	f2 := barcode[24:34]
+	f3 := barcode[34:44]
+	return f1 + strconv.Itoa(mod10(f1)) +
+		f2 + strconv.Itoa(mod10(f2)) +
+		f3 + strconv.Itoa(mod10(f3)) +
+		barcode[4:5] + barcode[5:19]
+}
+
+// boletoDueDate converte o fator de vencimento, escolhendo o ciclo mais próximo da data de referência
+func boletoDueDate(factor int, reference time.Time) time.Time {
+	first := boletoFactorBase.AddDate(0, 0, factor)
+	if factor < 1000 {
+		return first
+	}
+	second := boletoFactorSecondBase.AddDate(0, 0, factor-1000)
+	if absDuration(second.Sub(reference)) < absDuration(first.Sub(reference)) {
+		return second
+	}
+	return first
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// mod10 calcula o DV módulo 10 com pesos 2 e 1 alternados a partir da direita
+func mod10(digits string) int {
+	sum := 0
+	weight := 2
+	for i := len(digits) - 1; i >= 0; i-- {
+		product := int(digits[i]-'0') * weight
+		sum += product/10 + product%10
+		if weight == 2 {
+			weight = 1
+		} else {
+			weight = 2
+		}
+	}
+	return (10 - sum%10) % 10
+}
+
+// mod11Weighted soma os dígitos com pesos de 2 a 9 a partir da direita e retorna o resto da divisão por 11
+func mod11Weighted(digits string) int {
+	sum := 0
+	weight := 2
+	for i := len(digits) - 1; i >= 0; i-- {
+		sum += int(digits[i]-'0') * weight
+		weight++
+		if weight > 9 {
+			weight = 2
+		}
+	}
+	return sum % 11
+}
+
+// mod11Bancario calcula o DV geral do código de barras bancário (resultados 0, 10 e 11 viram 1)
+func mod11Bancario(digits string) int {
+	dv := 11 - mod11Weighted(digits)
+	if dv == 0 || dv >= 10 {
+		return 1
+	}
+	return dv
+}
+
+// mod11Arrecadacao calcula o DV módulo 11 das contas de arrecadação (restos 0 e 1 viram 0)
+func mod11Arrecadacao(digits string) int {
+	dv := 11 - mod11Weighted(digits)
+	if dv >= 10 {
+		return 0
+	}
+	return dv
+}
\ No newline at end of file
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+)
+
+// CalendarPastDays é quantos dias para trás o calendário publica, para que atrasos recentes continuem visíveis
+const CalendarPastDays = 30
+
+// CalendarFeed é um link de assinatura do calendário de um morador ou de um grupo de pagadores.
+// O token vai na URL, que pode ser assinada por aplicativos de calendário sem autenticação.
+type CalendarFeed struct {
+	ID           uuid.UUID  `json:"id"`
+	Token        string     `json:"token"`
+	UserID       *uuid.UUID `json:"user_id,omitempty"`
+	PayerGroupID *uuid.UUID `json:"payer_group_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+const calendarFeedColumns = `id, token, user_id, payer_group_id, created_at`
+
+func (f *CalendarFeed) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&f.ID, &f.Token, &f.UserID, &f.PayerGroupID, &f.CreatedAt)
+}
+
+// Create gera um token aleatório e grava o link
+func (f *CalendarFeed) Create() error {
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO calendar_feeds (token, user_id, payer_group_id)
+		VALUES ($1, $2, $3)
+		RETURNING ` + calendarFeedColumns
+	return f.scan(config.GetDB().QueryRow(query, base64.RawURLEncoding.EncodeToString(token), f.UserID, f.PayerGroupID))
+}
+
+// GetByToken busca o link pelo token da URL
+func (f *CalendarFeed) GetByToken() error {
+	return f.scan(config.GetDB().QueryRow(`SELECT `+calendarFeedColumns+` FROM calendar_feeds WHERE token = $1`, f.Token))
+}
+
+// Delete revoga o link; aplicativos que o assinaram deixam de receber o calendário
+func (f *CalendarFeed) Delete() error {
+	res, err := config.GetDB().Exec(`DELETE FROM calendar_feeds WHERE id = $1`, f.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// Filter é o filtro do dashboard com as ocorrências publicadas pelo link: as do morador ou do grupo,
+// dos últimos CalendarPastDays dias em diante
+func (f *CalendarFeed) Filter() (ListFilter, error) {
+	today, err := Today()
+	if err != nil {
+		return ListFilter{}, err
+	}
+	from := today.AddDate(0, 0, -CalendarPastDays)
+	return ListFilter{From: &from, UserID: f.UserID, PayerGroupID: f.PayerGroupID}, nil
+}
+
+// ListUserCalendarFeeds lista os links de calendário de um morador
+func ListUserCalendarFeeds(userID uuid.UUID) ([]CalendarFeed, error) {
+	return listCalendarFeeds("user_id", userID)
+}
+
+// ListPayerGroupCalendarFeeds lista os links de calendário de um grupo de pagadores
+func ListPayerGroupCalendarFeeds(payerGroupID uuid.UUID) ([]CalendarFeed, error) {
+	return listCalendarFeeds("payer_group_id", payerGroupID)
+}
+
+func listCalendarFeeds(column string, id uuid.UUID) ([]CalendarFeed, error) {
+	rows, err := config.GetDB().Query(`
+		SELECT `+calendarFeedColumns+`
+		FROM calendar_feeds
+		WHERE `+column+` = $1
+		ORDER BY created_at, id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feeds := []CalendarFeed{}
+	for rows.Next() {
+		var f CalendarFeed
+		if err := f.scan(rows); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+	return feeds, rows.Err()
+}
\ No newline at end of file
@@ -0,0 +1,259 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pobruno/casa360/config"
+)
+
+// Entidades publicadas no feed de alterações
+const (
+	ChangeTask              = "task"
+	ChangeTaskOccurrence    = "task_occurrence"
+	ChangeFinanceOccurrence = "finance_occurrence"
+	ChangeWallet            = "wallet"
+)
+
+const (
+	// ChangeRetention é por quanto tempo as alterações ficam disponíveis para retomar o stream
+	ChangeRetention = 24 * time.Hour
+
+	changeChannel = "casa360_changes"
+	changeBatch   = 500
+	// Uma transação mais antiga pode confirmar depois de uma mais nova; o seq que ficou para trás é
+	// procurado de novo por este tempo
+	changeGapWait = time.Minute
+	changeMaxGaps = 1000
+)
+
+var ErrChangeToken = errors.New("posição do stream inválida")
+
+// ValidChangeEntity informa se a entidade é publicada no feed
+func ValidChangeEntity(entity string) bool {
+	switch entity {
+	case ChangeTask, ChangeTaskOccurrence, ChangeFinanceOccurrence, ChangeWallet:
+		return true
+	}
+	return false
+}
+
+// ChangeEvent é uma alteração em uma tarefa, ocorrência ou carteira, com o registro resultante
+type ChangeEvent struct {
+	Seq       int64           `json:"seq"`
+	Entity    string          `json:"entity"`
+	Action    string          `json:"action"` // insert, update ou delete
+	ID        uuid.UUID       `json:"id"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+	Token     string          `json:"-"` // posição para retomar o stream depois deste evento (veja ChangeCursor.Token)
+}
+
+// ChangeCursor acompanha o que um cliente do stream já recebeu
+type ChangeCursor struct {
+	Last     int64
+	Entities []string // vazio = todas
+	gaps     map[int64]time.Time
+}
+
+// ParseChangeToken lê a posição enviada pelo cliente ao retomar o stream (veja ChangeCursor.Token)
+func ParseChangeToken(token string) (lastSeq, gapSeq int64, err error) {
+	last, gap, hasGap := strings.Cut(token, ":")
+	if lastSeq, err = strconv.ParseInt(last, 10, 64); err != nil || lastSeq < 0 {
+		return 0, 0, ErrChangeToken
+	}
+	if hasGap {
+		if gapSeq, err = strconv.ParseInt(gap, 10, 64); err != nil || gapSeq <= 0 || gapSeq > lastSeq {
+			return 0, 0, ErrChangeToken
+		}
+	}
+	return lastSeq, gapSeq, nil
+}
+
+// NewChangeCursor posiciona o cursor depois de lastSeq ou, com gapSeq, a partir desse seq que ainda podia
+// chegar atrasado, reenviando o que veio depois dele. Sem lastSeq, começa nas alterações feitas a partir
+// de agora. reset indica que lastSeq já saiu do feed (ChangeRetention) e o cliente precisa recarregar
+// os dados.
+func NewChangeCursor(lastSeq, gapSeq int64, entities []string) (cursor *ChangeCursor, reset bool, err error) {
+	db := config.GetDB()
+	cursor = &ChangeCursor{Last: lastSeq, Entities: entities, gaps: map[int64]time.Time{}}
+	if lastSeq > 0 {
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM change_events WHERE seq = $1)`, lastSeq).Scan(&exists); err != nil {
+			return nil, false, err
+		}
+		if exists {
+			if gapSeq > 0 {
+				cursor.Last = gapSeq - 1
+			}
+			return cursor, false, nil
+		}
+		reset = true
+	}
+	if err := db.QueryRow(`SELECT COALESCE(MAX(seq), 0) FROM change_events`).Scan(&cursor.Last); err != nil {
+		return nil, false, err
+	}
+	return cursor, reset, nil
+}
+
+// Fetch retorna as alterações seguintes, em ordem, e avança o cursor
+func (c *ChangeCursor) Fetch() ([]ChangeEvent, error) {
+	now := time.Now()
+	gaps := make([]int64, 0, len(c.gaps))
+	for seq, seen := range c.gaps {
+		if now.Sub(seen) > changeGapWait {
+			delete(c.gaps, seq)
+			continue
+		}
+		gaps = append(gaps, seq)
+	}
+
+	query := `
+		SELECT seq, entity, action, entity_id, data, created_at
+		FROM change_events
+		WHERE (seq > $1 OR seq = ANY($2))`
+	args := []interface{}{c.Last, pq.Array(gaps)}
+	if len(c.Entities) > 0 {
+		query += ` AND entity = ANY($3)`
+		args = append(args, pq.Array(c.Entities))
+	}
+	rows, err := config.GetDB().Query(query+` ORDER BY seq LIMIT `+strconv.Itoa(changeBatch), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []ChangeEvent{}
+	for rows.Next() {
+		var e ChangeEvent
+		var data []byte
+		if err := rows.Scan(&e.Seq, &e.Entity, &e.Action, &e.ID, &data, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Data = json.RawMessage(data)
+
+		if _, ok := c.gaps[e.Seq]; ok {
+			delete(c.gaps, e.Seq)
+		} else {
+			// Os seqs pulados são de transações ainda em andamento (ou desfeitas, ou de entidades filtradas)
+			if e.Seq-c.Last <= changeMaxGaps {
+				for seq := c.Last + 1; seq < e.Seq && len(c.gaps) < changeMaxGaps; seq++ {
+					c.gaps[seq] = now
+				}
+			}
+			c.Last = e.Seq
+		}
+		e.Token = c.Token()
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Token é a posição para retomar o stream: o último seq enviado e, enquanto algum seq anterior ainda puder
+// chegar atrasado, o mais antigo deles ("1042:1038"). Ao retomar, o stream volta a esse seq, então uma
+// alteração confirmada fora de ordem durante a reconexão não se perde; as já enviadas depois dele chegam
+// de novo, na mesma ordem.
+func (c *ChangeCursor) Token() string {
+	var oldest int64
+	for seq := range c.gaps {
+		if oldest == 0 || seq < oldest {
+			oldest = seq
+		}
+	}
+	if oldest == 0 {
+		return strconv.FormatInt(c.Last, 10)
+	}
+	return strconv.FormatInt(c.Last, 10) + ":" + strconv.FormatInt(oldest, 10)
+}
+
+// ChangeHub escuta o NOTIFY casa360_changes em uma conexão dedicada e acorda os clientes do stream.
+// Como o aviso passa pelo Postgres, alterações feitas por qualquer réplica chegam a todas.
+type ChangeHub struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+var (
+	changeHub     *ChangeHub
+	changeHubOnce sync.Once
+)
+
+// Changes retorna o hub do processo, iniciando a escuta na primeira chamada
+func Changes() *ChangeHub {
+	changeHubOnce.Do(func() {
+		changeHub = &ChangeHub{subs: map[chan struct{}]struct{}{}}
+		go changeHub.listen(config.ConnString())
+	})
+	return changeHub
+}
+
+// Subscribe registra um cliente. O canal recebe um aviso (sem conteúdo) quando há alterações novas;
+// avisos seguidos se acumulam em um só. A função retornada cancela o registro.
+func (h *ChangeHub) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+func (h *ChangeHub) broadcast() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// listen mantém a escuta, reconectando quando a conexão cai, e remove as alterações antigas
+func (h *ChangeHub) listen(connString string) {
+	listener := pq.NewListener(connString, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Feed de alterações: %v", err)
+		}
+		// Os avisos enviados enquanto a conexão estava fora se perderam; os clientes conferem o banco
+		if event == pq.ListenerEventReconnected {
+			h.broadcast()
+		}
+	})
+	if err := listener.Listen(changeChannel); err != nil {
+		log.Printf("Erro ao escutar %s: %v", changeChannel, err)
+	}
+
+	ping := time.NewTicker(90 * time.Second)
+	cleanup := time.NewTicker(time.Hour)
+	defer ping.Stop()
+	defer cleanup.Stop()
+	for {
+		select {
+		case <-listener.Notify:
+			h.broadcast()
+		case <-ping.C:
+			go listener.Ping()
+		case <-cleanup.C:
+			if err := purgeChanges(config.GetDB()); err != nil {
+				log.Printf("Erro ao limpar o feed de alterações: %v", err)
+			}
+		}
+	}
+}
+
+// purgeChanges remove as alterações mais antigas que ChangeRetention
+func purgeChanges(db queryer) error {
+	_, err := db.Exec(`DELETE FROM change_events WHERE created_at < $1`, time.Now().Add(-ChangeRetention))
+	return err
+}
\ No newline at end of file
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// Um seq que ainda pode chegar atrasado fica na posição enviada ao cliente, para que a retomada volte a ele
+func TestChangeToken(t *testing.T) {
+	cursor := &ChangeCursor{Last: 1042, gaps: map[int64]time.Time{1040: time.Now(), 1038: time.Now()}}
+	token := cursor.Token()
+	if token != "1042:1038" {
+		t.Fatalf("Token = %q, esperado 1042:1038", token)
+	}
+	last, gap, err := ParseChangeToken(token)
+	if err != nil || last != 1042 || gap != 1038 {
+		t.Fatalf("ParseChangeToken(%q) = %d, %d, %v", token, last, gap, err)
+	}
+
+	cursor.gaps = map[int64]time.Time{}
+	if token := cursor.Token(); token != "1042" {
+		t.Fatalf("Token sem seqs pendentes = %q, esperado 1042", token)
+	}
+	if last, gap, err := ParseChangeToken("1042"); err != nil || last != 1042 || gap != 0 {
+		t.Fatalf("ParseChangeToken(1042) = %d, %d, %v", last, gap, err)
+	}
+
+	for _, token := range []string{"", "-1", "abc", "10:", "10:0", "10:11", "10:x"} {
+		if _, _, err := ParseChangeToken(token); err == nil {
+			t.Errorf("ParseChangeToken(%q) deveria recusar a posição", token)
+		}
+	}
+}
\ No newline at end of file
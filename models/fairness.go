@@ -0,0 +1,102 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+)
+
+// FairnessMember compara o esforço concluído por um morador com a parte esperada dele no grupo
+type FairnessMember struct {
+	UserID           uuid.UUID `json:"user_id"`
+	Name             string    `json:"name"`
+	Percentage       float64   `json:"percentage"` // 0 para quem concluiu tarefas do grupo sem ser membro
+	Completed        int       `json:"completed"`
+	EffortPoints     int       `json:"effort_points"`
+	EstimatedMinutes int       `json:"estimated_minutes"`
+	ExpectedPoints   float64   `json:"expected_points"`
+	Balance          float64   `json:"balance"` // pontos concluídos menos os esperados; negativo = abaixo da parte
+	Share            float64   `json:"share"`   // percentual do esforço total do período
+}
+
+// FairnessReport é o placar de esforço de um grupo de pagadores em um período
+type FairnessReport struct {
+	PayerGroupID uuid.UUID        `json:"payer_group_id"`
+	From         *time.Time       `json:"from,omitempty"`
+	To           *time.Time       `json:"to,omitempty"`
+	TotalPoints  int              `json:"total_points"`
+	TotalMinutes int              `json:"total_minutes"`
+	Members      []FairnessMember `json:"members"`
+}
+
+// GetFairnessReport soma, por quem concluiu, o esforço das ocorrências do grupo no período.
+// A parte esperada de cada membro é o total do período dividido conforme os percentuais do grupo.
+func GetFairnessReport(payerGroupID uuid.UUID, filter ListFilter) (*FairnessReport, error) {
+	members, err := ListPayerGroupMembers(payerGroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FairnessReport{PayerGroupID: payerGroupID, From: filter.From, To: filter.To, Members: []FairnessMember{}}
+	index := map[uuid.UUID]int{}
+	totalPercentage := 0.0
+	for _, m := range members {
+		index[m.UserID] = len(report.Members)
+		report.Members = append(report.Members, FairnessMember{UserID: m.UserID, Percentage: m.Percentage})
+		totalPercentage += m.Percentage
+	}
+
+	var where whereClause
+	where.add("o.payer_group_id = ?", payerGroupID)
+	where.conditions = append(where.conditions, "o.status = true", "o.completed_by IS NOT NULL")
+	where.addDateRange("o.date", filter)
+
+	rows, err := config.GetDB().Query(`
+		SELECT o.completed_by, COUNT(*), COALESCE(SUM(o.effort_points), 0), COALESCE(SUM(ti.estimated_minutes), 0)
+		FROM task_occurrences o
+		INNER JOIN task_installments ti ON o.task_id = ti.id
+		`+where.String()+`
+		GROUP BY o.completed_by`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID uuid.UUID
+		var completed, points, minutes int
+		if err := rows.Scan(&userID, &completed, &points, &minutes); err != nil {
+			return nil, err
+		}
+		i, ok := index[userID]
+		if !ok {
+			i = len(report.Members)
+			index[userID] = i
+			report.Members = append(report.Members, FairnessMember{UserID: userID})
+		}
+		m := &report.Members[i]
+		m.Completed, m.EffortPoints, m.EstimatedMinutes = completed, points, minutes
+		report.TotalPoints += points
+		report.TotalMinutes += minutes
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range report.Members {
+		m := &report.Members[i]
+		user := User{ID: m.UserID}
+		if err := user.Get(); err == nil {
+			m.Name = user.Name
+		}
+		if totalPercentage > 0 {
+			m.ExpectedPoints = float64(report.TotalPoints) * m.Percentage / totalPercentage
+		}
+		m.Balance = float64(m.EffortPoints) - m.ExpectedPoints
+		if report.TotalPoints > 0 {
+			m.Share = float64(m.EffortPoints) * 100 / float64(report.TotalPoints)
+		}
+	}
+	return report, nil
+}
\ No newline at end of file
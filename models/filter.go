@@ -0,0 +1,98 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ListFilter reúne os filtros opcionais das listagens e exportações.
+// Cada listagem aplica apenas os campos que fazem sentido para ela.
+type ListFilter struct {
+	From           *time.Time // data inicial (inclusive)
+	To             *time.Time // data final (inclusive)
+	Status         *bool
+	FinanceType    *bool  // false = receita, true = despesa
+	OccurrenceType string // "finance" ou "task" (apenas no dashboard)
+	FinanceID      *uuid.UUID
+	UserID         *uuid.UUID
+	PayerGroupID   *uuid.UUID
+	FinanceCCID    *uuid.UUID
+	States         []string // estados do ciclo de vida (pending, in_progress, done, skipped, overdue, cancelled)
+	Archived       string   // "" = apenas ativos, "true" = apenas arquivados, "all" = todos (apenas nas finanças)
+}
+
+// whereClause monta a cláusula WHERE com placeholders posicionais ($1, $2, ...)
+type whereClause struct {
+	conditions []string
+	args       []interface{}
+}
+
+// add acrescenta uma condição, trocando cada "?" pelo próximo placeholder (todos recebem o mesmo argumento)
+func (w *whereClause) add(condition string, arg interface{}) {
+	w.args = append(w.args, arg)
+	w.conditions = append(w.conditions, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(w.args))))
+}
+
+// addDateRange filtra uma coluna DATE pelo período do filtro
+func (w *whereClause) addDateRange(column string, f ListFilter) {
+	if f.From != nil {
+		w.add(column+" >= ?", *f.From)
+	}
+	if f.To != nil {
+		w.add(column+" <= ?", *f.To)
+	}
+}
+
+// addTimestampRange filtra uma coluna TIMESTAMP, incluindo o dia final inteiro
+func (w *whereClause) addTimestampRange(column string, f ListFilter) {
+	if f.From != nil {
+		w.add(column+" >= ?", *f.From)
+	}
+	if f.To != nil {
+		w.add(column+" < ?", f.To.AddDate(0, 0, 1))
+	}
+}
+
+// addFinance aplica os filtros referentes à finança (alias fi)
+func (w *whereClause) addFinance(f ListFilter) {
+	if f.FinanceID != nil {
+		w.add("fi.id = ?", *f.FinanceID)
+	}
+	if f.FinanceType != nil {
+		w.add("fi.type = ?", *f.FinanceType)
+	}
+	if f.UserID != nil {
+		w.add("fi.user_id = ?", *f.UserID)
+	}
+	if f.PayerGroupID != nil {
+		w.add("fi.payer_group_id = ?", *f.PayerGroupID)
+	}
+	if f.FinanceCCID != nil {
+		w.add("fi.finance_cc_id = ?", *f.FinanceCCID)
+	}
+}
+
+// addStates filtra a coluna de estado por qualquer um dos estados do filtro
+func (w *whereClause) addStates(column string, f ListFilter) {
+	if len(f.States) > 0 {
+		w.add(column+" = ANY(?)", pq.Array(f.States))
+	}
+}
+
+// addArchived filtra a coluna deleted_at conforme o filtro de arquivamento, sem argumentos
+func (w *whereClause) addArchived(column string, f ListFilter) {
+	if condition := archivedCondition(column, f.Archived); condition != "" {
+		w.conditions = append(w.conditions, condition)
+	}
+}
+
+func (w *whereClause) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(w.conditions, " AND ")
+}
\ No newline at end of file
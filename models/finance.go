@@ -9,9 +9,10 @@ import (
 )
 
 type FinanceCC struct {
-	ID       uuid.UUID  `json:"id"`
-	Name     string     `json:"name"`
-	ParentID *uuid.UUID `json:"parent_id,omitempty"`
+	ID        uuid.UUID  `json:"id"`
+	Name      string     `json:"name"`
+	ParentID  *uuid.UUID `json:"parent_id,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // preenchido quando arquivado
 }
 
 type FinanceCurrency struct {
@@ -19,6 +20,7 @@ type FinanceCurrency struct {
 	Name  string    `json:"name"`
 	Symbol string   `json:"symbol"`
 	Value float64   `json:"value"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // preenchido quando arquivada
 }
 
 type FinanceInstallment struct {
@@ -34,6 +36,9 @@ type FinanceInstallment struct {
 	PayerGroupID   uuid.UUID  `json:"payer_group_id"`
 	FinanceCCID    uuid.UUID  `json:"finance_cc_id"`
 	CurrencyID     uuid.UUID  `json:"currency_id"`
+	PixCode        *string    `json:"pix_code,omitempty"`
+	MaxOccurrences *int       `json:"max_occurrences,omitempty"` // total de datas da recorrência, contadas desde start_date
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"` // preenchido quando arquivada
 }
 
 type FinanceOccurrence struct {
@@ -42,6 +47,9 @@ type FinanceOccurrence struct {
 	Date      time.Time `json:"date"`
 	Amount    float64   `json:"amount"`
 	Status    bool      `json:"status"`
+	PixCode    *string   `json:"pix_code,omitempty"`
+	BoletoCode *string   `json:"boleto_code,omitempty"`
+	OriginalDate *time.Time `json:"original_date,omitempty"` // data prevista pela recorrência, se a ocorrência foi remarcada
 }
 
 type Transaction struct {
@@ -63,6 +71,7 @@ type OccurrenceDashboard struct {
 	ID               uuid.UUID  `json:"id"`
 	Date             time.Time  `json:"date"`
 	Status           bool       `json:"status"`
+	State            string     `json:"state"` // nas finanças: done, overdue (vencida em aberto) ou pending
 	Title            string     `json:"title"`
 	Description      string     `json:"description"`
 	FinanceType      *bool      `json:"finance_type,omitempty"`
@@ -73,6 +82,11 @@ type OccurrenceDashboard struct {
 	CostCenter       *string    `json:"cost_center,omitempty"`
 	PayerGroup       string     `json:"payer_group"`
 	ResponsibleUser  string     `json:"responsible_user"`
+	PayerGroupID     *uuid.UUID `json:"payer_group_id,omitempty"`
+	UserID           *uuid.UUID `json:"user_id,omitempty"`
+	ScheduledAt      *time.Time `json:"scheduled_at,omitempty"`     // só nas tarefas com horário
+	DurationMinutes  *int       `json:"duration_minutes,omitempty"` // só nas tarefas
+	DueAt            *time.Time `json:"due_at,omitempty"`           // só nas tarefas com duração
 }
 
 // FinanceCC methods
@@ -80,30 +94,32 @@ func (fc *FinanceCC) Create() error {
 	query := `
 		INSERT INTO finance_cc (id, name, parent_id)
 		VALUES ($1, $2, $3)
-		RETURNING id, name, parent_id
+		RETURNING id, name, parent_id, deleted_at
 	`
 	var parentID *uuid.UUID
 	if fc.ParentID != nil {
 		parentID = fc.ParentID
 	}
 	return config.GetDB().QueryRow(query, uuid.New(), fc.Name, parentID).
-		Scan(&fc.ID, &fc.Name, &fc.ParentID)
+		Scan(&fc.ID, &fc.Name, &fc.ParentID, &fc.DeletedAt)
 }
 
 func (fc *FinanceCC) Get() error {
 	query := `
-		SELECT id, name, parent_id
+		SELECT id, name, parent_id, deleted_at
 		FROM finance_cc
 		WHERE id = $1
 	`
 	return config.GetDB().QueryRow(query, fc.ID).
-		Scan(&fc.ID, &fc.Name, &fc.ParentID)
+		Scan(&fc.ID, &fc.Name, &fc.ParentID, &fc.DeletedAt)
 }
 
-func ListFinanceCCs() ([]FinanceCC, error) {
+// ListFinanceCCs lista os centros de custo conforme o filtro de arquivamento
+func ListFinanceCCs(archived string) ([]FinanceCC, error) {
 	query := `
-		SELECT id, name, parent_id
+		SELECT id, name, parent_id, deleted_at
 		FROM finance_cc
+		` + archivedWhere(archived) + `
 		ORDER BY name
 	`
 	rows, err := config.GetDB().Query(query)
@@ -115,7 +131,7 @@ func ListFinanceCCs() ([]FinanceCC, error) {
 	var ccs []FinanceCC
 	for rows.Next() {
 		var fc FinanceCC
-		if err := rows.Scan(&fc.ID, &fc.Name, &fc.ParentID); err != nil {
+		if err := rows.Scan(&fc.ID, &fc.Name, &fc.ParentID, &fc.DeletedAt); err != nil {
 			return nil, err
 		}
 		ccs = append(ccs, fc)
@@ -128,26 +144,28 @@ func (fc *FinanceCurrency) Create() error {
 	query := `
 		INSERT INTO finance_currency (id, name, symbol, value)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, name, symbol, value
+		RETURNING id, name, symbol, value, deleted_at
 	`
 	return config.GetDB().QueryRow(query, uuid.New(), fc.Name, fc.Symbol, fc.Value).
-		Scan(&fc.ID, &fc.Name, &fc.Symbol, &fc.Value)
+		Scan(&fc.ID, &fc.Name, &fc.Symbol, &fc.Value, &fc.DeletedAt)
 }
 
 func (fc *FinanceCurrency) Get() error {
 	query := `
-		SELECT id, name, symbol, value
+		SELECT id, name, symbol, value, deleted_at
 		FROM finance_currency
 		WHERE id = $1
 	`
 	return config.GetDB().QueryRow(query, fc.ID).
-		Scan(&fc.ID, &fc.Name, &fc.Symbol, &fc.Value)
+		Scan(&fc.ID, &fc.Name, &fc.Symbol, &fc.Value, &fc.DeletedAt)
 }
 
-func ListFinanceCurrencies() ([]FinanceCurrency, error) {
+// ListFinanceCurrencies lista as moedas conforme o filtro de arquivamento
+func ListFinanceCurrencies(archived string) ([]FinanceCurrency, error) {
 	query := `
-		SELECT id, name, symbol, value
+		SELECT id, name, symbol, value, deleted_at
 		FROM finance_currency
+		` + archivedWhere(archived) + `
 		ORDER BY name
 	`
 	rows, err := config.GetDB().Query(query)
@@ -159,7 +177,7 @@ func ListFinanceCurrencies() ([]FinanceCurrency, error) {
 	var currencies []FinanceCurrency
 	for rows.Next() {
 		var fc FinanceCurrency
-		if err := rows.Scan(&fc.ID, &fc.Name, &fc.Symbol, &fc.Value); err != nil {
+		if err := rows.Scan(&fc.ID, &fc.Name, &fc.Symbol, &fc.Value, &fc.DeletedAt); err != nil {
 			return nil, err
 		}
 		currencies = append(currencies, fc)
@@ -168,125 +186,247 @@ func ListFinanceCurrencies() ([]FinanceCurrency, error) {
 }
 
 // FinanceInstallment methods
-func (fi *FinanceInstallment) Create() error {
+const financeColumns = `id, title, description, type, start_date, end_date, recurrence_days, amount, user_id, payer_group_id, finance_cc_id, currency_id, pix_code,
+	max_occurrences, deleted_at`
+
+func (fi *FinanceInstallment) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&fi.ID, &fi.Title, &fi.Description, &fi.Type, &fi.StartDate, &fi.EndDate, &fi.RecurrenceDays, &fi.Amount, &fi.UserID, &fi.PayerGroupID, &fi.FinanceCCID, &fi.CurrencyID, &fi.PixCode,
+		&fi.MaxOccurrences, &fi.DeletedAt)
+}
+
+func (fi *FinanceInstallment) Create(by *uuid.UUID) error {
+	return audited(by, func(tx *sql.Tx) error { return fi.create(tx) })
+}
+
+func (fi *FinanceInstallment) create(db queryer) error {
 	query := `
-		INSERT INTO finance_installments (id, title, description, type, start_date, end_date, recurrence_days, amount, user_id, payer_group_id, finance_cc_id, currency_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-		RETURNING id, title, description, type, start_date, end_date, recurrence_days, amount, user_id, payer_group_id, finance_cc_id, currency_id
-	`
+		INSERT INTO finance_installments (id, title, description, type, start_date, end_date, recurrence_days, amount, user_id, payer_group_id, finance_cc_id, currency_id, pix_code,
+			max_occurrences)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING ` + financeColumns
 	var endDate *time.Time
 	if fi.EndDate != nil {
 		endDate = fi.EndDate
 	}
-	return config.GetDB().QueryRow(query, uuid.New(), fi.Title, fi.Description, fi.Type, fi.StartDate, endDate, fi.RecurrenceDays, fi.Amount, fi.UserID, fi.PayerGroupID, fi.FinanceCCID, fi.CurrencyID).
-		Scan(&fi.ID, &fi.Title, &fi.Description, &fi.Type, &fi.StartDate, &fi.EndDate, &fi.RecurrenceDays, &fi.Amount, &fi.UserID, &fi.PayerGroupID, &fi.FinanceCCID, &fi.CurrencyID)
+	return fi.scan(db.QueryRow(query, uuid.New(), fi.Title, fi.Description, fi.Type, fi.StartDate, endDate, fi.RecurrenceDays, fi.Amount, fi.UserID, fi.PayerGroupID, fi.FinanceCCID, fi.CurrencyID, fi.PixCode,
+		fi.MaxOccurrences))
 }
 
 func (fi *FinanceInstallment) Get() error {
 	query := `
-		SELECT id, title, description, type, start_date, end_date, recurrence_days, amount, user_id, payer_group_id, finance_cc_id, currency_id
+		SELECT ` + financeColumns + `
 		FROM finance_installments
-		WHERE id = $1
-	`
-	return config.GetDB().QueryRow(query, fi.ID).
-		Scan(&fi.ID, &fi.Title, &fi.Description, &fi.Type, &fi.StartDate, &fi.EndDate, &fi.RecurrenceDays, &fi.Amount, &fi.UserID, &fi.PayerGroupID, &fi.FinanceCCID, &fi.CurrencyID)
+		WHERE id = $1`
+	return fi.scan(config.GetDB().QueryRow(query, fi.ID))
+}
+
+func (fi *FinanceInstallment) Update(by *uuid.UUID) error {
+	return audited(by, func(tx *sql.Tx) error { return fi.update(tx) })
 }
 
-func (fi *FinanceInstallment) Update() error {
+func (fi *FinanceInstallment) update(db queryer) error {
 	query := `
 		UPDATE finance_installments
-		SET title = $1, description = $2, type = $3, start_date = $4, end_date = $5, recurrence_days = $6, amount = $7, user_id = $8, payer_group_id = $9, finance_cc_id = $10, currency_id = $11
-		WHERE id = $12
-		RETURNING id, title, description, type, start_date, end_date, recurrence_days, amount, user_id, payer_group_id, finance_cc_id, currency_id
-	`
+		SET title = $1, description = $2, type = $3, start_date = $4, end_date = $5, recurrence_days = $6, amount = $7, user_id = $8, payer_group_id = $9, finance_cc_id = $10, currency_id = $11,
+			max_occurrences = $12
+		WHERE id = $13
+		RETURNING ` + financeColumns
 	var endDate *time.Time
 	if fi.EndDate != nil {
 		endDate = fi.EndDate
 	}
-	return config.GetDB().QueryRow(query, fi.Title, fi.Description, fi.Type, fi.StartDate, endDate, fi.RecurrenceDays, fi.Amount, fi.UserID, fi.PayerGroupID, fi.FinanceCCID, fi.CurrencyID, fi.ID).
-		Scan(&fi.ID, &fi.Title, &fi.Description, &fi.Type, &fi.StartDate, &fi.EndDate, &fi.RecurrenceDays, &fi.Amount, &fi.UserID, &fi.PayerGroupID, &fi.FinanceCCID, &fi.CurrencyID)
+	return fi.scan(db.QueryRow(query, fi.Title, fi.Description, fi.Type, fi.StartDate, endDate, fi.RecurrenceDays, fi.Amount, fi.UserID, fi.PayerGroupID, fi.FinanceCCID, fi.CurrencyID,
+		fi.MaxOccurrences, fi.ID))
 }
 
-func (fi *FinanceInstallment) Delete() error {
+// SetPixCode anexa (ou remove, se nil) o BR Code Pix usado para pagar a finança
+func (fi *FinanceInstallment) SetPixCode(code *string, by *uuid.UUID) error {
 	query := `
-		DELETE FROM finance_installments
-		WHERE id = $1
+		UPDATE finance_installments
+		SET pix_code = $1
+		WHERE id = $2
+		RETURNING pix_code
 	`
-	_, err := config.GetDB().Exec(query, fi.ID)
-	return err
+	return audited(by, func(tx *sql.Tx) error {
+		return tx.QueryRow(query, code, fi.ID).Scan(&fi.PixCode)
+	})
+}
+
+func ListFinanceInstallments(filter ListFilter) ([]FinanceInstallment, error) {
+	var installments []FinanceInstallment
+	err := EachFinanceInstallment(filter, func(fi FinanceInstallment) error {
+		installments = append(installments, fi)
+		return nil
+	})
+	return installments, err
 }
 
-func ListFinanceInstallments() ([]FinanceInstallment, error) {
+// EachFinanceInstallment percorre as finanças filtradas sem carregá-las todas em memória.
+// O período seleciona as finanças vigentes entre From e To; as arquivadas ficam de fora, salvo pelo filtro Archived.
+func EachFinanceInstallment(filter ListFilter, fn func(FinanceInstallment) error) error {
+	var where whereClause
+	if filter.From != nil {
+		where.add("(fi.end_date IS NULL OR fi.end_date >= ?)", *filter.From)
+	}
+	if filter.To != nil {
+		where.add("fi.start_date <= ?", *filter.To)
+	}
+	where.addFinance(filter)
+	where.addArchived("fi.deleted_at", filter)
+
 	query := `
-		SELECT id, title, description, type, start_date, end_date, recurrence_days, amount, user_id, payer_group_id, finance_cc_id, currency_id
-		FROM finance_installments
-		ORDER BY start_date DESC
+		SELECT ` + financeColumns + `
+		FROM finance_installments fi
+		` + where.String() + `
+		ORDER BY fi.start_date DESC
 	`
-	rows, err := config.GetDB().Query(query)
+	rows, err := config.GetDB().Query(query, where.args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var installments []FinanceInstallment
 	for rows.Next() {
 		var fi FinanceInstallment
-		if err := rows.Scan(&fi.ID, &fi.Title, &fi.Description, &fi.Type, &fi.StartDate, &fi.EndDate, &fi.RecurrenceDays, &fi.Amount, &fi.UserID, &fi.PayerGroupID, &fi.FinanceCCID, &fi.CurrencyID); err != nil {
-			return nil, err
+		if err := fi.scan(rows); err != nil {
+			return err
+		}
+		if err := fn(fi); err != nil {
+			return err
 		}
-		installments = append(installments, fi)
 	}
-	return installments, nil
+	return rows.Err()
+}
+
+const financeOccurrenceColumns = `id, finance_id, date, amount, status, pix_code, boleto_code, original_date`
+
+func (fo *FinanceOccurrence) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&fo.ID, &fo.FinanceID, &fo.Date, &fo.Amount, &fo.Status, &fo.PixCode, &fo.BoletoCode, &fo.OriginalDate)
 }
 
 // FinanceOccurrence methods
-func (fo *FinanceOccurrence) Create() error {
+func (fo *FinanceOccurrence) Create(by *uuid.UUID) error {
+	return audited(by, func(tx *sql.Tx) error { return fo.insert(tx) })
+}
+
+func (fo *FinanceOccurrence) insert(db queryer) error {
 	query := `
-		INSERT INTO finance_occurrences (id, finance_id, date, amount, status)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, finance_id, date, amount, status
-	`
-	return config.GetDB().QueryRow(query, uuid.New(), fo.FinanceID, fo.Date, fo.Amount, fo.Status).
-		Scan(&fo.ID, &fo.FinanceID, &fo.Date, &fo.Amount, &fo.Status)
+		INSERT INTO finance_occurrences (id, finance_id, date, amount, status, pix_code, boleto_code)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + financeOccurrenceColumns
+	return fo.scan(db.QueryRow(query, uuid.New(), fo.FinanceID, fo.Date, fo.Amount, fo.Status, fo.PixCode, fo.BoletoCode))
 }
 
-func (fo *FinanceOccurrence) Update() error {
+func (fo *FinanceOccurrence) Update(by *uuid.UUID) error {
 	query := `
 		UPDATE finance_occurrences
 		SET amount = $1, status = $2
 		WHERE id = $3
-		RETURNING id, finance_id, date, amount, status
-	`
-	return config.GetDB().QueryRow(query, fo.Amount, fo.Status, fo.ID).
-		Scan(&fo.ID, &fo.FinanceID, &fo.Date, &fo.Amount, &fo.Status)
+		RETURNING ` + financeOccurrenceColumns
+	return audited(by, func(tx *sql.Tx) error {
+		return fo.scan(tx.QueryRow(query, fo.Amount, fo.Status, fo.ID))
+	})
 }
 
-func ListFinanceOccurrences() ([]FinanceOccurrence, error) {
+func (fo *FinanceOccurrence) Get() error {
 	query := `
-		SELECT id, finance_id, date, amount, status
+		SELECT ` + financeOccurrenceColumns + `
 		FROM finance_occurrences
-		ORDER BY date DESC
+		WHERE id = $1`
+	return fo.scan(config.GetDB().QueryRow(query, fo.ID))
+}
+
+// SetPixCode anexa (ou remove, se nil) o BR Code Pix usado para pagar a ocorrência
+func (fo *FinanceOccurrence) SetPixCode(code *string, by *uuid.UUID) error {
+	query := `
+		UPDATE finance_occurrences
+		SET pix_code = $1
+		WHERE id = $2
+		RETURNING pix_code
 	`
-	rows, err := config.GetDB().Query(query)
+	return audited(by, func(tx *sql.Tx) error {
+		return tx.QueryRow(query, code, fo.ID).Scan(&fo.PixCode)
+	})
+}
+
+// SetBoleto grava a linha digitável do boleto junto com o valor e a data da ocorrência,
+// que podem ter sido preenchidos a partir do próprio boleto. A troca de data é uma remarcação:
+// a data prevista fica registrada como exceção, como em Reschedule.
+func (fo *FinanceOccurrence) SetBoleto(code *string, by *uuid.UUID) error {
+	amount, date := fo.Amount, fo.Date
+
+	tx, err := begin(by)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
+	if err := fo.lock(tx); err != nil {
+		return err
+	}
+	if !sameDay(date, fo.Date) {
+		if err := fo.moveTo(tx, ExceptionRescheduled, date, by); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE finance_occurrences
+		SET boleto_code = $1, amount = $2
+		WHERE id = $3
+		RETURNING ` + financeOccurrenceColumns
+	if err := fo.scan(tx.QueryRow(query, code, amount, fo.ID)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func ListFinanceOccurrences(filter ListFilter) ([]FinanceOccurrence, error) {
 	var occurrences []FinanceOccurrence
+	err := EachFinanceOccurrence(filter, func(fo FinanceOccurrence) error {
+		occurrences = append(occurrences, fo)
+		return nil
+	})
+	return occurrences, err
+}
+
+// EachFinanceOccurrence percorre as ocorrências financeiras filtradas sem carregá-las todas em memória
+func EachFinanceOccurrence(filter ListFilter, fn func(FinanceOccurrence) error) error {
+	var where whereClause
+	where.addDateRange("fo.date", filter)
+	if filter.Status != nil {
+		where.add("fo.status = ?", *filter.Status)
+	}
+	where.addFinance(filter)
+
+	query := `
+		SELECT fo.id, fo.finance_id, fo.date, fo.amount, fo.status, fo.pix_code, fo.boleto_code, fo.original_date
+		FROM finance_occurrences fo
+		INNER JOIN finance_installments fi ON fo.finance_id = fi.id
+		` + where.String() + `
+		ORDER BY fo.date DESC
+	`
+	rows, err := config.GetDB().Query(query, where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
 	for rows.Next() {
 		var fo FinanceOccurrence
-		if err := rows.Scan(&fo.ID, &fo.FinanceID, &fo.Date, &fo.Amount, &fo.Status); err != nil {
-			return nil, err
+		if err := fo.scan(rows); err != nil {
+			return err
+		}
+		if err := fn(fo); err != nil {
+			return err
 		}
-		occurrences = append(occurrences, fo)
 	}
-	return occurrences, nil
+	return rows.Err()
 }
 
 func ListFinanceOccurrencesByFinanceID(financeID uuid.UUID) ([]FinanceOccurrence, error) {
 	query := `
-		SELECT id, finance_id, date, amount, status
+		SELECT ` + financeOccurrenceColumns + `
 		FROM finance_occurrences
 		WHERE finance_id = $1
 		ORDER BY date DESC
@@ -300,7 +440,7 @@ func ListFinanceOccurrencesByFinanceID(financeID uuid.UUID) ([]FinanceOccurrence
 	var occurrences []FinanceOccurrence
 	for rows.Next() {
 		var fo FinanceOccurrence
-		if err := rows.Scan(&fo.ID, &fo.FinanceID, &fo.Date, &fo.Amount, &fo.Status); err != nil {
+		if err := fo.scan(rows); err != nil {
 			return nil, err
 		}
 		occurrences = append(occurrences, fo)
@@ -308,58 +448,105 @@ func ListFinanceOccurrencesByFinanceID(financeID uuid.UUID) ([]FinanceOccurrence
 	return occurrences, nil
 }
 
-func (fo *FinanceOccurrence) Delete() error {
-	query := `
-		DELETE FROM finance_occurrences
-		WHERE id = $1
-	`
-	_, err := config.GetDB().Exec(query, fo.ID)
-	return err
+// Delete remove uma ocorrência financeira não paga. As pagas têm transações e ficam como histórico;
+// os lançamentos bancários sugeridos para ela voltam a ficar sem correspondência.
+func (fo *FinanceOccurrence) Delete(by *uuid.UUID) error {
+	tx, err := begin(by)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fo.lock(tx); err != nil {
+		return err
+	}
+	if fo.Status {
+		return ErrOccurrencePaid
+	}
+	entry, err := isInvoiceEntry(tx, fo.ID)
+	if err != nil {
+		return err
+	}
+	if entry {
+		return ErrInvoiceEntry
+	}
+	if _, err := releaseBankLines(tx, "id = $1", fo.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM finance_occurrences WHERE id = $1`, fo.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// GenerateOccurrences gera ocorrências para uma finança baseada em sua recorrência
+// GenerateOccurrences gera as ocorrências da finança até a data final ou, sem ela, para 1 ano à frente,
+// a partir da marca d'água
 func (fi *FinanceInstallment) GenerateOccurrences() error {
-	// Define o período de geração
-	now := time.Now()
-	var endDate time.Time
+	if fi.DeletedAt != nil {
+		return ErrArchived
+	}
 
+	// Define o período de geração
+	var until time.Time
 	if fi.EndDate != nil {
-		endDate = *fi.EndDate
+		until = *fi.EndDate
 	} else {
-		endDate = now.AddDate(1, 0, 0) // Se não houver data final, gera para 1 ano à frente
-	}
-
-	// Gera as datas de ocorrência
-	nextDate := fi.StartDate
-	for nextDate.Before(endDate) || nextDate.Equal(endDate) {
-		// Cria a ocorrência
-		occurrence := FinanceOccurrence{
-			FinanceID: fi.ID,
-			Date:      nextDate,
-			Amount:    fi.Amount,
-			Status:    false,
+		today, err := Today()
+		if err != nil {
+			return err
 		}
-
-		// Tenta criar a ocorrência (ignora se já existir devido à constraint UNIQUE)
-		_ = occurrence.Create()
-
-		// Calcular próxima data
-		nextDate = nextDate.AddDate(0, 0, fi.RecurrenceDays)
+		until = today.AddDate(1, 0, 0) // Se não houver data final, gera para 1 ano à frente
 	}
 
-	return nil
+	_, err := fi.Generate(until)
+	return err
 }
 
-// ListOccurrencesDashboard retorna todas as ocorrências do dashboard
-func ListOccurrencesDashboard() ([]OccurrenceDashboard, error) {
-	query := `SELECT * FROM occurrences_dashboard ORDER BY date DESC`
-	rows, err := config.GetDB().Query(query)
+// ListOccurrencesDashboard retorna as ocorrências do dashboard que atendem ao filtro
+func ListOccurrencesDashboard(filter ListFilter) ([]OccurrenceDashboard, error) {
+	var occurrences []OccurrenceDashboard
+	err := EachOccurrenceDashboard(filter, func(o OccurrenceDashboard) error {
+		occurrences = append(occurrences, o)
+		return nil
+	})
+	return occurrences, err
+}
+
+// EachOccurrenceDashboard percorre as ocorrências do dashboard sem carregá-las todas em memória
+func EachOccurrenceDashboard(filter ListFilter, fn func(OccurrenceDashboard) error) error {
+	var where whereClause
+	where.addDateRange("date", filter)
+	if filter.Status != nil {
+		where.add("status = ?", *filter.Status)
+	}
+	if filter.OccurrenceType != "" {
+		where.add("occurrence_type = ?", filter.OccurrenceType)
+	}
+	if filter.FinanceType != nil {
+		where.add("finance_type = ?", *filter.FinanceType)
+	}
+	if filter.UserID != nil {
+		where.add("user_id = ?", *filter.UserID)
+	}
+	if filter.PayerGroupID != nil {
+		where.add("payer_group_id = ?", *filter.PayerGroupID)
+	}
+	where.addStates("state", filter)
+
+	query := `
+		SELECT occurrence_type, id, date, status, state, title, description, finance_type, amount, currency_symbol,
+			currency_value, amount_converted, cost_center, payer_group, responsible_user, payer_group_id, user_id,
+			scheduled_at, duration_minutes, due_at
+		FROM occurrences_dashboard
+		` + where.String() + `
+		ORDER BY date DESC
+	`
+	rows, err := config.GetDB().Query(query, where.args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var occurrences []OccurrenceDashboard
 	for rows.Next() {
 		var o OccurrenceDashboard
 		err := rows.Scan(
@@ -367,6 +554,7 @@ func ListOccurrencesDashboard() ([]OccurrenceDashboard, error) {
 			&o.ID,
 			&o.Date,
 			&o.Status,
+			&o.State,
 			&o.Title,
 			&o.Description,
 			&o.FinanceType,
@@ -377,13 +565,20 @@ func ListOccurrencesDashboard() ([]OccurrenceDashboard, error) {
 			&o.CostCenter,
 			&o.PayerGroup,
 			&o.ResponsibleUser,
+			&o.PayerGroupID,
+			&o.UserID,
+			&o.ScheduledAt,
+			&o.DurationMinutes,
+			&o.DueAt,
 		)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(o); err != nil {
+			return err
 		}
-		occurrences = append(occurrences, o)
 	}
-	return occurrences, nil
+	return rows.Err()
 }
 
 // GetLastWalletByUserID retorna o último registro da carteira de um usuário
@@ -411,6 +606,15 @@ func GetLastWalletByUserID(userID uuid.UUID) (*FinanceWallet, error) {
 	return &wallet, nil
 }
 
+// Get busca uma transação pelo ID
+func (t *Transaction) Get() error {
+	query := `
+		SELECT id, finance_occurrence_id, amount, created_at
+		FROM transactions
+		WHERE id = $1`
+	return config.GetDB().QueryRow(query, t.ID).Scan(&t.ID, &t.FinanceOccurrenceID, &t.Amount, &t.CreatedAt)
+}
+
 // ListTransactionsByOccurrenceID retorna todas as transações de uma ocorrência
 func ListTransactionsByOccurrenceID(occurrenceID uuid.UUID) ([]Transaction, error) {
 	query := `
@@ -440,4 +644,89 @@ func ListTransactionsByOccurrenceID(occurrenceID uuid.UUID) ([]Transaction, erro
 		transactions = append(transactions, t)
 	}
 	return transactions, nil
-} 
\ No newline at end of file
+} 
+
+// ListTransactions retorna as transações que atendem ao filtro
+func ListTransactions(filter ListFilter) ([]Transaction, error) {
+	var transactions []Transaction
+	err := EachTransaction(filter, func(t Transaction) error {
+		transactions = append(transactions, t)
+		return nil
+	})
+	return transactions, err
+}
+
+// EachTransaction percorre as transações filtradas sem carregá-las todas em memória.
+// O período é aplicado sobre a data de criação da transação.
+func EachTransaction(filter ListFilter, fn func(Transaction) error) error {
+	var where whereClause
+	where.addTimestampRange("t.created_at", filter)
+	where.addFinance(filter)
+
+	query := `
+		SELECT t.id, t.finance_occurrence_id, t.amount, t.created_at
+		FROM transactions t
+		INNER JOIN finance_occurrences fo ON t.finance_occurrence_id = fo.id
+		INNER JOIN finance_installments fi ON fo.finance_id = fi.id
+		` + where.String() + `
+		ORDER BY t.created_at DESC
+	`
+	rows, err := config.GetDB().Query(query, where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.FinanceOccurrenceID, &t.Amount, &t.CreatedAt); err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListWallets retorna o histórico das carteiras que atende ao filtro
+func ListWallets(filter ListFilter) ([]FinanceWallet, error) {
+	var wallets []FinanceWallet
+	err := EachWallet(filter, func(w FinanceWallet) error {
+		wallets = append(wallets, w)
+		return nil
+	})
+	return wallets, err
+}
+
+// EachWallet percorre o histórico das carteiras sem carregá-lo todo em memória
+func EachWallet(filter ListFilter, fn func(FinanceWallet) error) error {
+	var where whereClause
+	where.addTimestampRange("created_at", filter)
+	if filter.UserID != nil {
+		where.add("user_id = ?", *filter.UserID)
+	}
+
+	query := `
+		SELECT id, user_id, amount, created_at
+		FROM finance_wallets
+		` + where.String() + `
+		ORDER BY created_at DESC
+	`
+	rows, err := config.GetDB().Query(query, where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var w FinanceWallet
+		if err := rows.Scan(&w.ID, &w.UserID, &w.Amount, &w.CreatedAt); err != nil {
+			return err
+		}
+		if err := fn(w); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
\ No newline at end of file
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FormatDecimalPtBR formata um número como 1.234,56
+func FormatDecimalPtBR(v float64, places int) string {
+	s := strconv.FormatFloat(math.Abs(v), 'f', places, 64)
+	integer, fraction, _ := strings.Cut(s, ".")
+
+	var sb strings.Builder
+	if v < 0 && strings.Trim(s, "0.") != "" {
+		sb.WriteByte('-')
+	}
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteRune(digit)
+	}
+	if fraction != "" {
+		sb.WriteByte(',')
+		sb.WriteString(fraction)
+	}
+	return sb.String()
+}
\ No newline at end of file
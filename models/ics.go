@@ -0,0 +1,304 @@
+package models
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ICSEvent é um VEVENT lido de um arquivo iCalendar
+type ICSEvent struct {
+	UID         string
+	Summary     string
+	Description string
+	Categories  []string
+	Start       time.Time
+	AllDay      bool   // DTSTART só com a data
+	Zone        string // TZID do DTSTART, "UTC" nos horários com Z; vazio nos horários flutuantes (fuso da casa)
+	End         *time.Time
+	Duration    time.Duration
+	RRule       string
+	ExDates     []time.Time
+	Problem     string // motivo pelo qual o evento não pode ser lido
+}
+
+// icsProperty é uma linha de conteúdo já desdobrada: NOME;PARAM=valor:VALOR
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// ParseICS lê os VEVENTs de um arquivo iCalendar. Datas sem fuso (flutuantes) e TZIDs desconhecidos
+// são interpretados em loc.
+func ParseICS(r io.Reader, loc *time.Location) ([]ICSEvent, error) {
+	lines, err := unfoldICS(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, errors.New("arquivo iCalendar inválido: esperado BEGIN:VCALENDAR")
+	}
+
+	var events []ICSEvent
+	var event *ICSEvent
+	depth := 0 // componentes aninhados no evento (ex.: VALARM), ignorados
+	for _, line := range lines {
+		prop := parseICSProperty(line)
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VEVENT") && event == nil:
+			event = &ICSEvent{}
+		case event == nil:
+		case prop.name == "BEGIN":
+			depth++
+		case prop.name == "END" && depth > 0:
+			depth--
+		case prop.name == "END" && strings.EqualFold(prop.value, "VEVENT"):
+			if event.Problem == "" && event.Start.IsZero() {
+				event.Problem = "evento sem DTSTART"
+			}
+			events = append(events, *event)
+			event = nil
+		case depth == 0:
+			event.set(prop, loc)
+		}
+	}
+	if event != nil {
+		return nil, errors.New("arquivo iCalendar inválido: VEVENT sem END")
+	}
+	return events, nil
+}
+
+// unfoldICS junta as linhas dobradas (continuações começam com espaço ou tab) e descarta as vazias
+func unfoldICS(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) > 0 {
+		lines[0] = strings.TrimPrefix(lines[0], "\ufeff")
+	}
+	return lines, nil
+}
+
+// parseICSProperty separa nome, parâmetros e valor; os dois-pontos entre aspas fazem parte do parâmetro
+func parseICSProperty(line string) icsProperty {
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return icsProperty{name: strings.ToUpper(line)}
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	prop := icsProperty{name: strings.ToUpper(parts[0]), params: map[string]string{}, value: line[colon+1:]}
+	for _, param := range parts[1:] {
+		if key, value, ok := strings.Cut(param, "="); ok {
+			prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return prop
+}
+
+func (e *ICSEvent) set(prop icsProperty, loc *time.Location) {
+	var err error
+	switch prop.name {
+	case "UID":
+		e.UID = prop.value
+	case "SUMMARY":
+		e.Summary = unescapeICSText(prop.value)
+	case "DESCRIPTION":
+		e.Description = unescapeICSText(prop.value)
+	case "CATEGORIES":
+		for _, category := range strings.Split(prop.value, ",") {
+			e.Categories = append(e.Categories, unescapeICSText(strings.TrimSpace(category)))
+		}
+	case "DTSTART":
+		e.Start, e.AllDay, e.Zone, err = parseICSTime(prop, loc)
+	case "DTEND":
+		var end time.Time
+		if end, _, _, err = parseICSTime(prop, loc); err == nil {
+			e.End = &end
+		}
+	case "DURATION":
+		e.Duration, err = parseICSDuration(prop.value)
+	case "RRULE":
+		e.RRule = prop.value
+	case "EXDATE":
+		for _, value := range strings.Split(prop.value, ",") {
+			var date time.Time
+			if date, _, _, err = parseICSTime(icsProperty{params: prop.params, value: value}, loc); err != nil {
+				break
+			}
+			e.ExDates = append(e.ExDates, date)
+		}
+	}
+	if err != nil && e.Problem == "" {
+		e.Problem = fmt.Sprintf("%s inválido: %v", prop.name, err)
+	}
+}
+
+// parseICSTime lê uma data (VALUE=DATE) ou data e hora em UTC (Z), no TZID informado ou flutuante.
+// Datas puras voltam à meia-noite UTC, como as colunas DATE.
+func parseICSTime(prop icsProperty, loc *time.Location) (t time.Time, allDay bool, zone string, err error) {
+	value := strings.TrimSpace(prop.value)
+	if prop.params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err = time.Parse("20060102", value)
+		return t, true, "", err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse("20060102T150405Z", value)
+		return t, false, "UTC", err
+	}
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if zoneLoc, zerr := time.LoadLocation(tzid); zerr == nil && tzid != "Local" {
+			loc, zone = zoneLoc, tzid
+		}
+	}
+	t, err = time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, zone, err
+}
+
+// parseICSDuration lê durações como PT1H30M, P1D ou P1W
+func parseICSDuration(value string) (time.Duration, error) {
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(value, "+"), "P")
+	if !ok {
+		return 0, fmt.Errorf("duração %q", value)
+	}
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+	var total time.Duration
+	number := ""
+	for i := 0; i < len(rest); i++ {
+		ch := rest[i]
+		switch {
+		case ch == 'T':
+		case ch >= '0' && ch <= '9':
+			number += string(ch)
+		default:
+			unit, known := units[ch]
+			n, err := strconv.Atoi(number)
+			if !known || err != nil {
+				return 0, fmt.Errorf("duração %q", value)
+			}
+			total += time.Duration(n) * unit
+			number = ""
+		}
+	}
+	if number != "" {
+		return 0, fmt.Errorf("duração %q", value)
+	}
+	return total, nil
+}
+
+func unescapeICSText(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// ICSRule é uma RRULE reduzida ao que pode virar uma recorrência CRON ou em dias
+type ICSRule struct {
+	Freq       string // DAILY, WEEKLY, MONTHLY ou YEARLY
+	Interval   int
+	Count      *int
+	Until      *time.Time // data, no fuso da casa
+	ByDay      []time.Weekday
+	ByMonthDay []int
+	ByMonth    []int
+}
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// ParseICSRule interpreta uma RRULE. Partes sem equivalente (BYSETPOS, BYDAY com posição como 2MO,
+// dias do mês negativos etc.) retornam erro.
+func ParseICSRule(value string, loc *time.Location) (*ICSRule, error) {
+	rule := &ICSRule{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("INTERVAL inválido: %q", val)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("COUNT inválido: %q", val)
+			}
+			rule.Count = &n
+		case "UNTIL":
+			until, allDay, _, err := parseICSTime(icsProperty{value: val}, loc)
+			if err != nil {
+				return nil, fmt.Errorf("UNTIL inválido: %q", val)
+			}
+			if !allDay {
+				until = dateOf(until.In(loc))
+			}
+			rule.Until = &until
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekday, ok := icsWeekdays[strings.ToUpper(day)]
+				if !ok {
+					return nil, fmt.Errorf("BYDAY sem suporte: %q", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "BYMONTHDAY", "BYMONTH":
+			limit := 31
+			if strings.ToUpper(key) == "BYMONTH" {
+				limit = 12
+			}
+			for _, item := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(item)
+				if err != nil || n < 1 || n > limit {
+					return nil, fmt.Errorf("%s sem suporte: %q", key, item)
+				}
+				if limit == 12 {
+					rule.ByMonth = append(rule.ByMonth, n)
+				} else {
+					rule.ByMonthDay = append(rule.ByMonthDay, n)
+				}
+			}
+		case "WKST":
+		default:
+			return nil, fmt.Errorf("%s sem suporte", key)
+		}
+	}
+	switch rule.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, fmt.Errorf("FREQ sem suporte: %q", rule.Freq)
+	}
+	if rule.Count != nil && rule.Until != nil {
+		return nil, errors.New("COUNT e UNTIL não podem ser usados juntos")
+	}
+	return rule, nil
+}
\ No newline at end of file
@@ -0,0 +1,382 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+)
+
+// Tipos de modelo criados pela importação de calendário
+const (
+	ICSKindTask    = "task"
+	ICSKindFinance = "finance"
+)
+
+var ErrICSFinanceDefaults = errors.New("finance_cc_id e currency_id são obrigatórios para importar finanças")
+
+// ICSImportOptions são os dados que o calendário não traz: responsáveis, centro de custo e moeda
+type ICSImportOptions struct {
+	Kind         string // tipo dos eventos sem categoria Tarefa ou Finança; padrão task
+	UserID       uuid.UUID
+	PayerGroupID uuid.UUID
+	FinanceCCID  *uuid.UUID
+	CurrencyID   *uuid.UUID
+	Amount       *float64 // valor das finanças cujo evento não informa um
+	DryRun       bool
+	By           *uuid.UUID // autor da importação no log de auditoria
+}
+
+// ICSImportItem é o resultado de um evento: o modelo criado (ou que seria criado) ou o motivo de ter sido ignorado
+type ICSImportItem struct {
+	UID          string              `json:"uid"`
+	Summary      string              `json:"summary"`
+	Kind         string              `json:"kind,omitempty"`
+	Task         *TaskInstallment    `json:"task,omitempty"`
+	Finance      *FinanceInstallment `json:"finance,omitempty"`
+	SkippedDates []time.Time         `json:"skipped_dates,omitempty"` // EXDATEs, gravadas como datas puladas
+	Warnings     []string            `json:"warnings,omitempty"`
+	Error        string              `json:"error,omitempty"`
+}
+
+// ICSImportResult resume a importação; em dry_run nada é gravado
+type ICSImportResult struct {
+	DryRun  bool            `json:"dry_run"`
+	Created int             `json:"created"`
+	Ignored int             `json:"ignored"`
+	Items   []ICSImportItem `json:"items"`
+}
+
+var (
+	// Valor no formato brasileiro, como "R$ 1.234,56", no fim do título ou após "Valor:", "Despesa:" ou "Receita:"
+	icsSummaryAmount     = regexp.MustCompile(`\s+-\s+\S*\s*(\d{1,3}(?:\.\d{3})*,\d{2}|\d+,\d{2})\s*$`)
+	icsDescriptionAmount = regexp.MustCompile(`(?i)(valor|despesa|receita)\s*:\s*\S*\s*(\d{1,3}(?:\.\d{3})*,\d{2}|\d+,\d{2})`)
+)
+
+// ImportICS converte os eventos recorrentes (com RRULE) em tarefas, com a recorrência em CRON, ou finanças,
+// com a recorrência em dias. Eventos sem RRULE ou com regras sem equivalente são ignorados e listados com
+// o motivo. Tudo é gravado em uma única transação, com as EXDATEs registradas como datas puladas.
+func ImportICS(events []ICSEvent, opts ICSImportOptions) (*ICSImportResult, error) {
+	db := config.GetDB()
+	loc, err := location(db)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ICSImportResult{DryRun: opts.DryRun, Items: []ICSImportItem{}}
+	for _, event := range events {
+		item := icsImportItem(event, opts, loc)
+		if item.Kind == ICSKindFinance && item.Error == "" && (opts.FinanceCCID == nil || opts.CurrencyID == nil) {
+			return nil, ErrICSFinanceDefaults
+		}
+		if item.Error != "" {
+			result.Ignored++
+		}
+		result.Items = append(result.Items, item)
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	tx, err := begin(opts.By)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i := range result.Items {
+		item := &result.Items[i]
+		if item.Error != "" {
+			continue
+		}
+		column, templateID := "task_id", uuid.Nil
+		if item.Task != nil {
+			if err := item.Task.create(tx); err != nil {
+				return nil, err
+			}
+			templateID = item.Task.ID
+		} else {
+			if err := item.Finance.create(tx); err != nil {
+				return nil, err
+			}
+			column, templateID = "finance_id", item.Finance.ID
+		}
+		for _, date := range item.SkippedDates {
+			if err := recordException(tx, column, templateID, date, ExceptionSkipped, nil, nil); err != nil {
+				return nil, err
+			}
+		}
+		result.Created++
+	}
+	return result, tx.Commit()
+}
+
+// icsImportItem monta o modelo de um evento, sem gravá-lo
+func icsImportItem(event ICSEvent, opts ICSImportOptions, loc *time.Location) ICSImportItem {
+	item := ICSImportItem{UID: event.UID, Summary: event.Summary, Kind: icsKind(event, opts.Kind)}
+	switch {
+	case event.Problem != "":
+		item.Error = event.Problem
+		return item
+	case event.RRule == "":
+		item.Error = "evento sem RRULE; apenas eventos recorrentes são importados"
+		return item
+	}
+	rule, err := ParseICSRule(event.RRule, loc)
+	if err != nil {
+		item.Error = "RRULE sem equivalente: " + err.Error()
+		return item
+	}
+
+	// Datas no fuso da casa, como as colunas DATE
+	start := event.Start
+	if !event.AllDay {
+		start = dateOf(event.Start.In(loc))
+	}
+	for _, exdate := range event.ExDates {
+		if !event.AllDay {
+			exdate = dateOf(exdate.In(loc))
+		}
+		if !exdate.Before(start) {
+			item.SkippedDates = append(item.SkippedDates, exdate)
+		}
+	}
+	if rule.Until != nil && rule.Until.Before(start) {
+		item.Error = "UNTIL anterior ao início do evento"
+		return item
+	}
+
+	title, description := strings.TrimSpace(event.Summary), event.Description
+	if title == "" {
+		title = "Evento importado"
+	}
+	if item.Kind == ICSKindTask {
+		item.Task, item.Error = icsTask(event, rule, title, description, start, loc, opts)
+		return item
+	}
+	item.Finance, item.Warnings, item.Error = icsFinance(event, rule, title, description, start, opts)
+	return item
+}
+
+// icsKind decide entre tarefa e finança pela categoria do evento (como no calendário exportado)
+func icsKind(event ICSEvent, fallback string) string {
+	for _, category := range event.Categories {
+		switch normalizeText(category) {
+		case "financa", "financas", "finance", "finances", "bill", "bills", "conta", "contas":
+			return ICSKindFinance
+		case "tarefa", "tarefas", "task", "tasks":
+			return ICSKindTask
+		}
+	}
+	if fallback == ICSKindFinance {
+		return ICSKindFinance
+	}
+	return ICSKindTask
+}
+
+// icsTask converte o evento em tarefa: horário e dias da RRULE viram a expressão CRON, COUNT e UNTIL
+// limitam a série e DTEND ou DURATION definem a duração de cada ocorrência
+func icsTask(event ICSEvent, rule *ICSRule, title, description string, start time.Time, loc *time.Location, opts ICSImportOptions) (*TaskInstallment, string) {
+	minute, hour := 0, 0
+	prefix := ""
+	if !event.AllDay {
+		local := event.Start.In(loc)
+		if event.Zone != "" && event.Zone != "UTC" && event.Zone != loc.String() {
+			// Mantém o horário no fuso do evento, inclusive nas mudanças de horário de verão;
+			// horários em UTC são convertidos para o fuso da casa
+			local = event.Start
+			prefix = "CRON_TZ=" + event.Zone + " "
+		}
+		minute, hour = local.Minute(), local.Hour()
+	}
+	startDay := event.Start
+	if prefix == "" && !event.AllDay {
+		startDay = event.Start.In(loc)
+	}
+
+	// Convertido para o fuso da casa, o horário pode cair no dia anterior ou seguinte ao do evento (ex.:
+	// 01:00 UTC é 22:00 da véspera em São Paulo); os dias da semana da RRULE acompanham a mudança
+	byDay := rule.ByDay
+	if shift := int(math.Round(dateOf(startDay).Sub(dateOf(event.Start)).Hours() / 24)); shift != 0 {
+		if len(rule.ByMonthDay) > 0 {
+			return nil, "BYMONTHDAY só é importada quando o horário do evento cai no mesmo dia no fuso da casa"
+		}
+		byDay = make([]time.Weekday, len(rule.ByDay))
+		for i, day := range rule.ByDay {
+			byDay[i] = time.Weekday((int(day) + shift + 7) % 7)
+		}
+	}
+
+	dom, month, dow := "*", "*", "*"
+	if len(rule.ByMonth) > 0 {
+		month = icsList(rule.ByMonth)
+	}
+	switch rule.Freq {
+	case "DAILY":
+		if rule.Interval > 1 || len(rule.ByMonthDay) > 0 {
+			return nil, "FREQ=DAILY só é importada sem INTERVAL e sem BYMONTHDAY"
+		}
+		if len(byDay) > 0 {
+			dow = icsWeekdayList(byDay)
+		}
+	case "WEEKLY":
+		if rule.Interval > 1 || len(rule.ByMonthDay) > 0 {
+			return nil, "FREQ=WEEKLY só é importada sem INTERVAL e sem BYMONTHDAY"
+		}
+		dow = icsWeekdayList(byDay)
+		if len(byDay) == 0 {
+			dow = strconv.Itoa(int(startDay.Weekday()))
+		}
+	case "MONTHLY":
+		if len(rule.ByDay) > 0 {
+			return nil, "FREQ=MONTHLY só é importada com dias do mês (BYMONTHDAY), não com dias da semana"
+		}
+		dom = icsList(rule.ByMonthDay)
+		if len(rule.ByMonthDay) == 0 {
+			dom = strconv.Itoa(startDay.Day())
+		}
+		if rule.Interval > 1 {
+			// A cada N meses, a partir do mês inicial: só há lista de meses fixa quando N divide 12
+			if 12%rule.Interval != 0 || len(rule.ByMonth) > 0 {
+				return nil, "FREQ=MONTHLY com INTERVAL só é importada quando o intervalo divide 12 meses"
+			}
+			var months []int
+			for m := int(startDay.Month()); len(months) < 12/rule.Interval; m += rule.Interval {
+				months = append(months, (m-1)%12+1)
+			}
+			month = icsList(months)
+		}
+	case "YEARLY":
+		if rule.Interval > 1 || len(rule.ByDay) > 0 {
+			return nil, "FREQ=YEARLY só é importada sem INTERVAL e sem BYDAY"
+		}
+		dom = icsList(rule.ByMonthDay)
+		if len(rule.ByMonthDay) == 0 {
+			dom = strconv.Itoa(startDay.Day())
+		}
+		if len(rule.ByMonth) == 0 {
+			month = strconv.Itoa(int(startDay.Month()))
+		}
+	}
+
+	expr := fmt.Sprintf("%s%d %d %s %s %s", prefix, minute, hour, dom, month, dow)
+	if _, err := ParseRecurrence(expr); err != nil {
+		return nil, err.Error()
+	}
+
+	task := &TaskInstallment{
+		Title:          title,
+		Description:    description,
+		StartDate:      start,
+		RecurrenceCron: expr,
+		UserID:         opts.UserID,
+		PayerGroupID:   opts.PayerGroupID,
+		Rotation:       RotationNone,
+		EffortPoints:   1,
+		EndDate:        rule.Until,
+		MaxOccurrences: rule.Count,
+	}
+	if !event.AllDay {
+		duration := event.Duration
+		if event.End != nil {
+			duration = event.End.Sub(event.Start)
+		}
+		if minutes := int(math.Ceil(duration.Minutes())); minutes > 0 {
+			task.DurationMinutes = &minutes
+			task.EstimatedMinutes = minutes
+		}
+	}
+	return task, ""
+}
+
+// icsFinance converte o evento em finança. A recorrência das finanças é em dias: meses e anos viram
+// 30 e 365 dias, com um aviso. O valor vem do título ou da descrição, ou de opts.Amount.
+func icsFinance(event ICSEvent, rule *ICSRule, title, description string, start time.Time, opts ICSImportOptions) (*FinanceInstallment, []string, string) {
+	var warnings []string
+	if len(rule.ByMonth) > 0 || len(rule.ByDay) > 1 || len(rule.ByMonthDay) > 1 ||
+		len(rule.ByDay) == 1 && rule.ByDay[0] != start.Weekday() ||
+		len(rule.ByMonthDay) == 1 && rule.ByMonthDay[0] != start.Day() {
+		return nil, nil, "finanças só são importadas com recorrência em intervalo fixo a partir da data inicial"
+	}
+
+	days := rule.Interval
+	switch rule.Freq {
+	case "WEEKLY":
+		days = 7 * rule.Interval
+	case "MONTHLY":
+		days = 30 * rule.Interval
+		warnings = append(warnings, fmt.Sprintf("recorrência mensal aproximada para a cada %d dias", days))
+	case "YEARLY":
+		days = 365 * rule.Interval
+		warnings = append(warnings, fmt.Sprintf("recorrência anual aproximada para a cada %d dias", days))
+	}
+
+	expense := true
+	var amount *float64
+	if match := icsSummaryAmount.FindStringSubmatchIndex(title); match != nil {
+		if value, err := parseDecimal(title[match[2]:match[3]], true); err == nil {
+			amount = &value
+			title = strings.TrimSpace(title[:match[0]])
+		}
+	}
+	if match := icsDescriptionAmount.FindStringSubmatch(description); match != nil {
+		if amount == nil {
+			if value, err := parseDecimal(match[2], true); err == nil {
+				amount = &value
+			}
+		}
+		expense = !strings.EqualFold(match[1], "receita")
+	}
+	if amount == nil {
+		if opts.Amount == nil {
+			return nil, nil, "valor não encontrado no evento; informe amount"
+		}
+		amount = opts.Amount
+		warnings = append(warnings, "valor padrão usado")
+	}
+
+	finance := &FinanceInstallment{
+		Title:          title,
+		Description:    description,
+		Type:           expense,
+		StartDate:      start,
+		EndDate:        rule.Until,
+		RecurrenceDays: days,
+		Amount:         *amount,
+		UserID:         opts.UserID,
+		PayerGroupID:   opts.PayerGroupID,
+		MaxOccurrences: rule.Count,
+	}
+	if opts.FinanceCCID != nil {
+		finance.FinanceCCID = *opts.FinanceCCID
+	}
+	if opts.CurrencyID != nil {
+		finance.CurrencyID = *opts.CurrencyID
+	}
+	return finance, warnings, ""
+}
+
+func icsList(values []int) string {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	parts := make([]string, len(sorted))
+	for i, v := range sorted {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func icsWeekdayList(days []time.Weekday) string {
+	values := make([]int, len(days))
+	for i, day := range days {
+		values[i] = int(day)
+	}
+	return icsList(values)
+}
\ No newline at end of file
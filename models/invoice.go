@@ -0,0 +1,318 @@
+package models
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Modelos de nota fiscal eletrônica
+const (
+	InvoiceNFe  = "55" // NF-e, nota fiscal eletrônica
+	InvoiceNFCe = "65" // NFC-e, nota fiscal de consumidor eletrônica (cupom)
+)
+
+// Situações do protocolo de autorização que tornam a nota válida: autorizada (100) e autorizada fora do prazo (150)
+var invoiceAuthorized = map[string]bool{"100": true, "150": true}
+
+var ErrInvoiceNotFound = errors.New("o XML não contém uma NF-e ou NFC-e (elemento infNFe)")
+
+// Invoice é uma NF-e ou NFC-e lida do XML autorizado pela SEFAZ
+type Invoice struct {
+	AccessKey string        `json:"access_key"` // chave de acesso (44 dígitos)
+	Model     string        `json:"model"`      // 55 = NF-e, 65 = NFC-e
+	Series    string        `json:"series"`
+	Number    string        `json:"number"`
+	IssuedAt  time.Time     `json:"issued_at"`
+	Issuer    InvoiceIssuer `json:"issuer"`
+	Items     []InvoiceItem `json:"items"`
+	Discount  float64       `json:"discount"`
+	Total     float64       `json:"total"`              // valor total da nota (vNF)
+	Protocol  string        `json:"protocol,omitempty"` // protocolo de autorização, quando o XML o inclui
+
+	dateOnly bool // leiautes antigos informam só a data de emissão
+}
+
+// InvoiceIssuer é o emitente da nota (a loja)
+type InvoiceIssuer struct {
+	Document  string `json:"document"` // CNPJ ou CPF
+	Name      string `json:"name"`
+	TradeName string `json:"trade_name,omitempty"`
+}
+
+// InvoiceItem é um item da nota. Amount é o valor do item já com desconto, frete, seguro e outras despesas.
+type InvoiceItem struct {
+	Number      int     `json:"number"` // nItem, usado para dividir a nota entre centros de custo
+	Code        string  `json:"code"`
+	Description string  `json:"description"`
+	NCM         string  `json:"ncm,omitempty"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	UnitPrice   float64 `json:"unit_price"`
+	Amount      float64 `json:"amount"`
+}
+
+// Title é o nome do emitente para o título da finança: o nome fantasia ou, sem ele, a razão social
+func (inv *Invoice) Title() string {
+	if inv.Issuer.TradeName != "" {
+		return inv.Issuer.TradeName
+	}
+	return inv.Issuer.Name
+}
+
+// Kind é o nome do modelo da nota (NF-e ou NFC-e)
+func (inv *Invoice) Kind() string {
+	if inv.Model == InvoiceNFCe {
+		return "NFC-e"
+	}
+	return "NF-e"
+}
+
+// Date é a data de emissão no fuso da casa
+func (inv *Invoice) Date(loc *time.Location) time.Time {
+	if inv.dateOnly {
+		return dateOf(inv.IssuedAt)
+	}
+	return dateOf(inv.IssuedAt.In(loc))
+}
+
+// nfeInfNFe é o elemento infNFe do leiaute da NF-e, o mesmo da NFC-e
+type nfeInfNFe struct {
+	ID  string `xml:"Id,attr"`
+	Ide struct {
+		Mod   string `xml:"mod"`
+		Serie string `xml:"serie"`
+		NNF   string `xml:"nNF"`
+		DhEmi string `xml:"dhEmi"`
+		DEmi  string `xml:"dEmi"` // leiautes anteriores à versão 3.10
+	} `xml:"ide"`
+	Emit struct {
+		CNPJ  string `xml:"CNPJ"`
+		CPF   string `xml:"CPF"`
+		XNome string `xml:"xNome"`
+		XFant string `xml:"xFant"`
+	} `xml:"emit"`
+	Det []struct {
+		NItem int `xml:"nItem,attr"`
+		Prod  struct {
+			CProd  string `xml:"cProd"`
+			XProd  string `xml:"xProd"`
+			NCM    string `xml:"NCM"`
+			UCom   string `xml:"uCom"`
+			QCom   string `xml:"qCom"`
+			VUnCom string `xml:"vUnCom"`
+			VProd  string `xml:"vProd"`
+			VDesc  string `xml:"vDesc"`
+			VFrete string `xml:"vFrete"`
+			VSeg   string `xml:"vSeg"`
+			VOutro string `xml:"vOutro"`
+		} `xml:"prod"`
+	} `xml:"det"`
+	Total struct {
+		VDesc string `xml:"ICMSTot>vDesc"`
+		VNF   string `xml:"ICMSTot>vNF"`
+	} `xml:"total"`
+}
+
+// nfeInfProt é o protocolo de autorização (protNFe/infProt)
+type nfeInfProt struct {
+	ChNFe   string `xml:"chNFe"`
+	CStat   string `xml:"cStat"`
+	XMotivo string `xml:"xMotivo"`
+	NProt   string `xml:"nProt"`
+}
+
+// ParseInvoice lê uma NF-e ou NFC-e do XML. Aceita o XML distribuído pela SEFAZ (nfeProc, com o protocolo
+// de autorização), a nota sem protocolo (NFe) e os retornos de consulta que a trazem dentro de outro elemento,
+// como o XML baixado pela consulta do QR Code da NFC-e. Uma nota com protocolo que não seja de autorização
+// é recusada.
+func ParseInvoice(data []byte) (*Invoice, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = invoiceCharsetReader
+
+	var inf *nfeInfNFe
+	var prot *nfeInfProt
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("XML inválido: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch {
+		case start.Name.Local == "infNFe" && inf == nil:
+			inf = &nfeInfNFe{}
+			if err := decoder.DecodeElement(inf, &start); err != nil {
+				return nil, fmt.Errorf("XML inválido: %v", err)
+			}
+		case start.Name.Local == "infProt" && prot == nil:
+			prot = &nfeInfProt{}
+			if err := decoder.DecodeElement(prot, &start); err != nil {
+				return nil, fmt.Errorf("XML inválido: %v", err)
+			}
+		}
+	}
+	if inf == nil {
+		return nil, ErrInvoiceNotFound
+	}
+
+	inv := &Invoice{
+		AccessKey: strings.TrimPrefix(strings.TrimSpace(inf.ID), "NFe"),
+		Model:     strings.TrimSpace(inf.Ide.Mod),
+		Series:    strings.TrimSpace(inf.Ide.Serie),
+		Number:    strings.TrimSpace(inf.Ide.NNF),
+		Issuer: InvoiceIssuer{
+			Document:  strings.TrimSpace(inf.Emit.CNPJ + inf.Emit.CPF),
+			Name:      strings.TrimSpace(inf.Emit.XNome),
+			TradeName: strings.TrimSpace(inf.Emit.XFant),
+		},
+		Items: []InvoiceItem{},
+	}
+	if inv.AccessKey == "" && prot != nil {
+		inv.AccessKey = strings.TrimSpace(prot.ChNFe)
+	}
+	if !validAccessKey(inv.AccessKey) {
+		return nil, fmt.Errorf("chave de acesso inválida: %q", inv.AccessKey)
+	}
+	if inv.Model != InvoiceNFe && inv.Model != InvoiceNFCe {
+		return nil, fmt.Errorf("modelo de nota não suportado: %q (use NF-e, modelo 55, ou NFC-e, modelo 65)", inv.Model)
+	}
+
+	if prot != nil {
+		if chNFe := strings.TrimSpace(prot.ChNFe); chNFe != "" && chNFe != inv.AccessKey {
+			return nil, errors.New("o protocolo de autorização é de outra nota")
+		}
+		if cStat := strings.TrimSpace(prot.CStat); !invoiceAuthorized[cStat] {
+			return nil, fmt.Errorf("nota não autorizada pela SEFAZ (%s: %s)", cStat, strings.TrimSpace(prot.XMotivo))
+		}
+		inv.Protocol = strings.TrimSpace(prot.NProt)
+	}
+
+	var err error
+	switch {
+	case strings.TrimSpace(inf.Ide.DhEmi) != "":
+		inv.IssuedAt, err = time.Parse(time.RFC3339, strings.TrimSpace(inf.Ide.DhEmi))
+	case strings.TrimSpace(inf.Ide.DEmi) != "":
+		inv.IssuedAt, err = time.Parse("2006-01-02", strings.TrimSpace(inf.Ide.DEmi))
+		inv.dateOnly = true
+	default:
+		err = errors.New("não informada")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("data de emissão inválida: %v", err)
+	}
+
+	seen := map[int]bool{}
+	for i, det := range inf.Det {
+		number := det.NItem
+		if number == 0 {
+			number = i + 1
+		}
+		if seen[number] {
+			return nil, fmt.Errorf("item %d repetido na nota", number)
+		}
+		seen[number] = true
+
+		item := InvoiceItem{
+			Number:      number,
+			Code:        strings.TrimSpace(det.Prod.CProd),
+			Description: strings.TrimSpace(det.Prod.XProd),
+			NCM:         strings.TrimSpace(det.Prod.NCM),
+			Unit:        strings.TrimSpace(det.Prod.UCom),
+		}
+		var discount, freight, insurance, other float64
+		values := []struct {
+			name     string
+			value    string
+			target   *float64
+			required bool
+		}{
+			{"qCom", det.Prod.QCom, &item.Quantity, true},
+			{"vUnCom", det.Prod.VUnCom, &item.UnitPrice, true},
+			{"vProd", det.Prod.VProd, &item.Amount, true},
+			{"vDesc", det.Prod.VDesc, &discount, false},
+			{"vFrete", det.Prod.VFrete, &freight, false},
+			{"vSeg", det.Prod.VSeg, &insurance, false},
+			{"vOutro", det.Prod.VOutro, &other, false},
+		}
+		for _, v := range values {
+			if *v.target, err = invoiceDecimal(v.value, v.required); err != nil {
+				return nil, fmt.Errorf("item %d: %s inválido: %v", number, v.name, err)
+			}
+		}
+		item.Amount = roundCents(item.Amount - discount + freight + insurance + other)
+		inv.Items = append(inv.Items, item)
+	}
+	if len(inv.Items) == 0 {
+		return nil, errors.New("a nota não tem itens")
+	}
+
+	if inv.Discount, err = invoiceDecimal(inf.Total.VDesc, false); err != nil {
+		return nil, fmt.Errorf("vDesc inválido: %v", err)
+	}
+	if inv.Total, err = invoiceDecimal(inf.Total.VNF, true); err != nil {
+		return nil, fmt.Errorf("vNF inválido: %v", err)
+	}
+	if inv.Total <= 0 {
+		return nil, errors.New("o valor total da nota deve ser maior que zero")
+	}
+	return inv, nil
+}
+
+// validAccessKey confere o tamanho e o dígito verificador (módulo 11) da chave de acesso
+func validAccessKey(key string) bool {
+	if len(key) != 44 {
+		return false
+	}
+	for _, r := range key {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return strconv.Itoa(mod11Arrecadacao(key[:43])) == key[43:]
+}
+
+// invoiceDecimal lê um valor do XML, que usa ponto como separador decimal
+func invoiceDecimal(value string, required bool) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		if required {
+			return 0, errors.New("não informado")
+		}
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// invoiceCharsetReader aceita os XMLs gravados em ISO-8859-1 por alguns emissores; o padrão da NF-e é UTF-8
+func invoiceCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252":
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 0, len(data))
+		for _, b := range data {
+			buf = utf8.AppendRune(buf, rune(b))
+		}
+		return bytes.NewReader(buf), nil
+	}
+	return nil, fmt.Errorf("codificação não suportada: %s", charset)
+}
\ No newline at end of file
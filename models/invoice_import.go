@@ -0,0 +1,417 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pobruno/casa360/config"
+	"github.com/pobruno/casa360/storage"
+)
+
+var (
+	ErrInvoiceImported         = errors.New("esta nota fiscal já foi importada")
+	ErrInvoiceSplit            = errors.New("divisão da nota inválida")
+	ErrInvoiceOccurrenceExists = errors.New("a finança já tem uma ocorrência na data de emissão da nota")
+	ErrInvoiceIncome           = errors.New("a nota só pode ser lançada em uma finança de despesa")
+	ErrInvoiceEntry            = errors.New("a ocorrência é um lançamento de nota fiscal importada; desfaça a importação da nota")
+)
+
+// InvoiceSplit lança itens da nota em outro centro de custo
+type InvoiceSplit struct {
+	FinanceCCID uuid.UUID `json:"finance_cc_id"`
+	Items       []int     `json:"items"` // números dos itens (nItem)
+}
+
+// InvoiceImportOptions define onde a nota é lançada. Com FinanceID, a nota vira uma ocorrência dessa finança;
+// sem ela, uma finança avulsa (despesa de uma única data) com o título, o morador, o grupo, o centro de custo
+// e a moeda de Finance. Cada divisão vira uma finança avulsa no seu centro de custo, com os mesmos dados.
+type InvoiceImportOptions struct {
+	FinanceID *uuid.UUID
+	Finance   FinanceInstallment
+	Splits    []InvoiceSplit
+	Paid      bool       // cria as ocorrências já pagas, gerando as transações e atualizando as carteiras
+	By        *uuid.UUID // autor da importação no log de auditoria
+}
+
+// InvoiceEntry é um lançamento da nota: a finança e a ocorrência de um centro de custo, com os itens dele
+type InvoiceEntry struct {
+	FinanceCCID    uuid.UUID          `json:"finance_cc_id"`
+	Items          []int              `json:"items"`
+	Amount         float64            `json:"amount"`
+	CreatedFinance bool               `json:"created_finance"` // finança avulsa criada pela importação
+	Finance        FinanceInstallment `json:"finance"`
+	Occurrence     FinanceOccurrence  `json:"occurrence"`
+}
+
+// InvoiceImport é uma nota importada, com os lançamentos criados. O primeiro lançamento é o principal: o da
+// finança informada ou do centro de custo escolhido.
+type InvoiceImport struct {
+	ID         uuid.UUID      `json:"id"`
+	Invoice    *Invoice       `json:"invoice"`
+	Entries    []InvoiceEntry `json:"entries"`
+	Attachment *Attachment    `json:"attachment,omitempty"` // o XML, anexado à ocorrência principal
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// invoiceShare é a parte da nota de um centro de custo (nil = o principal)
+type invoiceShare struct {
+	financeCCID *uuid.UUID
+	items       []int
+	cents       int64
+}
+
+// splitInvoice divide a nota entre os centros de custo. Os itens que não estão em nenhuma divisão ficam no
+// principal, junto com a diferença entre o total da nota e a soma dos itens (impostos cobrados à parte, como
+// IPI e ICMS-ST), para que os lançamentos somem exatamente o total. O principal fica de fora quando todos os
+// itens foram divididos e não sobra valor para ele.
+func splitInvoice(inv *Invoice, splits []InvoiceSplit) ([]invoiceShare, error) {
+	items := map[int]InvoiceItem{}
+	for _, item := range inv.Items {
+		items[item.Number] = item
+	}
+
+	assigned := map[int]bool{}
+	costCenters := map[uuid.UUID]bool{}
+	shares := []invoiceShare{{}}
+	var splitCents int64
+	for _, split := range splits {
+		if costCenters[split.FinanceCCID] {
+			return nil, fmt.Errorf("%w: centro de custo %s repetido", ErrInvoiceSplit, split.FinanceCCID)
+		}
+		costCenters[split.FinanceCCID] = true
+		if len(split.Items) == 0 {
+			return nil, fmt.Errorf("%w: informe os itens do centro de custo %s", ErrInvoiceSplit, split.FinanceCCID)
+		}
+
+		ccID := split.FinanceCCID
+		share := invoiceShare{financeCCID: &ccID, items: []int{}}
+		for _, number := range split.Items {
+			item, ok := items[number]
+			if !ok {
+				return nil, fmt.Errorf("%w: a nota não tem o item %d", ErrInvoiceSplit, number)
+			}
+			if assigned[number] {
+				return nil, fmt.Errorf("%w: item %d em mais de um centro de custo", ErrInvoiceSplit, number)
+			}
+			assigned[number] = true
+			share.items = append(share.items, number)
+			share.cents += cents(item.Amount)
+		}
+		if share.cents <= 0 {
+			return nil, fmt.Errorf("%w: os itens do centro de custo %s não têm valor", ErrInvoiceSplit, split.FinanceCCID)
+		}
+		splitCents += share.cents
+		shares = append(shares, share)
+	}
+
+	main := &shares[0]
+	main.items = []int{}
+	for _, item := range inv.Items {
+		if !assigned[item.Number] {
+			main.items = append(main.items, item.Number)
+		}
+	}
+	main.cents = cents(inv.Total) - splitCents
+	switch {
+	case main.cents < 0:
+		return nil, fmt.Errorf("%w: os itens divididos somam mais que o total da nota", ErrInvoiceSplit)
+	case main.cents == 0 && len(main.items) == 0:
+		return shares[1:], nil
+	case main.cents == 0:
+		return nil, fmt.Errorf("%w: os itens que ficaram no centro de custo principal não têm valor", ErrInvoiceSplit)
+	}
+	return shares, nil
+}
+
+func cents(v float64) int64 {
+	return int64(math.Round(v * 100))
+}
+
+// ImportInvoice lança a nota fiscal como despesa, em uma única transação: uma ocorrência por centro de custo,
+// na data de emissão. Uma nota só pode ser importada uma vez (pela chave de acesso).
+func ImportInvoice(inv *Invoice, opts InvoiceImportOptions) (*InvoiceImport, error) {
+	shares, err := splitInvoice(inv, opts.Splits)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := begin(opts.By)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var imported bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM invoice_imports WHERE access_key = $1)`, inv.AccessKey).Scan(&imported); err != nil {
+		return nil, err
+	}
+	if imported {
+		return nil, ErrInvoiceImported
+	}
+
+	loc, err := location(tx)
+	if err != nil {
+		return nil, err
+	}
+	date := inv.Date(loc)
+
+	// Modelo dos lançamentos: a finança informada ou os dados da finança avulsa
+	template := opts.Finance
+	if opts.FinanceID != nil {
+		template = FinanceInstallment{}
+		if err := template.scan(tx.QueryRow(`SELECT `+financeColumns+` FROM finance_installments WHERE id = $1 FOR SHARE`, *opts.FinanceID)); err != nil {
+			return nil, err
+		}
+		if template.DeletedAt != nil {
+			return nil, ErrArchived
+		}
+		if !template.Type {
+			return nil, ErrInvoiceIncome
+		}
+	}
+	if template.Title == "" {
+		template.Title = inv.Title()
+	}
+	if template.Description == "" {
+		template.Description = fmt.Sprintf("%s %s de %s (chave %s)", inv.Kind(), inv.Number, inv.Issuer.Name, inv.AccessKey)
+	}
+
+	result := &InvoiceImport{Invoice: inv, Entries: []InvoiceEntry{}}
+	for _, share := range shares {
+		entry := InvoiceEntry{Items: share.items, Amount: float64(share.cents) / 100}
+		entry.CreatedFinance = share.financeCCID != nil || opts.FinanceID == nil
+
+		if !entry.CreatedFinance {
+			entry.Finance = template
+		} else {
+			entry.Finance = FinanceInstallment{
+				Title:          template.Title,
+				Description:    template.Description,
+				Type:           true,
+				StartDate:      date,
+				EndDate:        &date,
+				RecurrenceDays: 1,
+				Amount:         entry.Amount,
+				UserID:         template.UserID,
+				PayerGroupID:   template.PayerGroupID,
+				FinanceCCID:    template.FinanceCCID,
+				CurrencyID:     template.CurrencyID,
+			}
+			if share.financeCCID != nil {
+				entry.Finance.FinanceCCID = *share.financeCCID
+			}
+			if err := entry.Finance.create(tx); err != nil {
+				return nil, err
+			}
+		}
+		entry.FinanceCCID = entry.Finance.FinanceCCID
+
+		entry.Occurrence = FinanceOccurrence{FinanceID: entry.Finance.ID, Date: date, Amount: entry.Amount, Status: opts.Paid}
+		if err := entry.Occurrence.insert(tx); err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Constraint == "finance_occurrences_finance_id_date_key" {
+				return nil, ErrInvoiceOccurrenceExists
+			}
+			return nil, err
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.QueryRow(`
+		INSERT INTO invoice_imports (access_key, model, series, number, issuer_document, issuer_name, issued_at, total,
+			invoice, imported_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at`, inv.AccessKey, inv.Model, inv.Series, inv.Number, inv.Issuer.Document, inv.Issuer.Name,
+		inv.IssuedAt, inv.Total, data, opts.By).
+		Scan(&result.ID, &result.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Constraint == "invoice_imports_access_key_key" {
+			return nil, ErrInvoiceImported
+		}
+		return nil, err
+	}
+	for i, entry := range result.Entries {
+		_, err := tx.Exec(`
+			INSERT INTO invoice_import_entries (invoice_import_id, position, finance_id, finance_occurrence_id,
+				created_finance, items, amount)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`, result.ID, i, entry.Finance.ID, entry.Occurrence.ID,
+			entry.CreatedFinance, pq.Array(invoiceItemNumbers(entry.Items)), entry.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetInvoiceImport busca uma nota importada com os seus lançamentos
+func GetInvoiceImport(id uuid.UUID) (*InvoiceImport, error) {
+	return getInvoiceImport(config.GetDB(), id, "")
+}
+
+func getInvoiceImport(db queryer, id uuid.UUID, lock string) (*InvoiceImport, error) {
+	imp := &InvoiceImport{ID: id, Invoice: &Invoice{}, Entries: []InvoiceEntry{}}
+	var data []byte
+	if err := db.QueryRow(`SELECT invoice, created_at FROM invoice_imports WHERE id = $1 `+lock, id).Scan(&data, &imp.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, imp.Invoice); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT finance_id, finance_occurrence_id, created_finance, items, amount
+		FROM invoice_import_entries
+		WHERE invoice_import_id = $1
+		ORDER BY position`, id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var entry InvoiceEntry
+		var items pq.Int64Array
+		if err := rows.Scan(&entry.Finance.ID, &entry.Occurrence.ID, &entry.CreatedFinance, &items, &entry.Amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entry.Items = make([]int, len(items))
+		for i, number := range items {
+			entry.Items[i] = int(number)
+		}
+		imp.Entries = append(imp.Entries, entry)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range imp.Entries {
+		entry := &imp.Entries[i]
+		if err := entry.Finance.scan(db.QueryRow(`SELECT `+financeColumns+` FROM finance_installments WHERE id = $1`, entry.Finance.ID)); err != nil {
+			return nil, err
+		}
+		if err := entry.Occurrence.scan(db.QueryRow(`SELECT `+financeOccurrenceColumns+` FROM finance_occurrences WHERE id = $1`, entry.Occurrence.ID)); err != nil {
+			return nil, err
+		}
+		entry.FinanceCCID = entry.Finance.FinanceCCID
+	}
+
+	var attachment Attachment
+	err = attachment.scan(db.QueryRow(`
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE finance_occurrence_id = $1 AND filename = $2
+		ORDER BY created_at
+		LIMIT 1`, imp.Entries[0].Occurrence.ID, imp.Invoice.AccessKey+".xml"))
+	switch {
+	case err == nil:
+		imp.Attachment = &attachment
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+	return imp, nil
+}
+
+// UndoInvoiceImport desfaz a importação em uma única transação: remove as ocorrências de todos os
+// lançamentos e as finanças avulsas criadas para eles (com as ocorrências que elas tenham ganhado depois),
+// e libera a chave de acesso para uma nova importação. A finança informada em finance_id continua existindo.
+// Lançamentos pagos têm transações e fazem parte do histórico, então uma nota com algum deles pago não pode
+// ser desfeita.
+func UndoInvoiceImport(id uuid.UUID, by *uuid.UUID) error {
+	tx, err := begin(by)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	imp, err := getInvoiceImport(tx, id, "FOR UPDATE")
+	if err != nil {
+		return err
+	}
+	occurrences, finances := []uuid.UUID{}, []uuid.UUID{}
+	for _, entry := range imp.Entries {
+		occurrences = append(occurrences, entry.Occurrence.ID)
+		if entry.CreatedFinance {
+			finances = append(finances, entry.Finance.ID)
+		}
+	}
+
+	const where = `id = ANY($1) OR finance_id = ANY($2)`
+	args := []interface{}{pq.Array(occurrences), pq.Array(finances)}
+	var paid bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM finance_occurrences WHERE status AND (`+where+`))`, args...).Scan(&paid); err != nil {
+		return err
+	}
+	if paid {
+		return ErrOccurrencePaid
+	}
+	if _, err := releaseBankLines(tx, where, args...); err != nil {
+		return err
+	}
+
+	// Os lançamentos saem antes das ocorrências, que eles protegem de remoções avulsas
+	if _, err := tx.Exec(`DELETE FROM invoice_imports WHERE id = $1`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM finance_occurrences WHERE `+where, args...); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM finance_installments WHERE id = ANY($1)`, pq.Array(finances)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// notInvoiceEntry deixa de fora das alterações em lote (propagação de valores, regeneração, arquivamento e
+// pausas) as ocorrências que são lançamentos de notas importadas: elas têm o valor da nota e só saem ao
+// desfazer a importação
+const notInvoiceEntry = `id NOT IN (SELECT finance_occurrence_id FROM invoice_import_entries)`
+
+// isInvoiceEntry indica se a ocorrência é um lançamento de nota importada, que só sai ao desfazer a importação
+func isInvoiceEntry(db queryer, occurrenceID uuid.UUID) (bool, error) {
+	var entry bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM invoice_import_entries WHERE finance_occurrence_id = $1)`, occurrenceID).Scan(&entry)
+	return entry, err
+}
+
+func invoiceItemNumbers(items []int) []int64 {
+	numbers := make([]int64, len(items))
+	for i, number := range items {
+		numbers[i] = int64(number)
+	}
+	return numbers
+}
+// Attach grava o XML da nota como anexo da ocorrência principal
+func (imp *InvoiceImport) Attach(ctx context.Context, store storage.BlobStore, data []byte, by *uuid.UUID) error {
+	contentType, ok := SniffAttachment(data)
+	if !ok {
+		contentType = "text/xml"
+	}
+	attachment := &Attachment{
+		FinanceOccurrenceID: &imp.Entries[0].Occurrence.ID,
+		Filename:            imp.Invoice.AccessKey + ".xml",
+		ContentType:         contentType,
+		Size:                int64(len(data)),
+		UploadedBy:          by,
+	}
+	if err := attachment.Create(ctx, store, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	imp.Attachment = attachment
+	return nil
+}
\ No newline at end of file
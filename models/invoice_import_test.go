@@ -0,0 +1,150 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pobruno/casa360/config"
+)
+
+// testDB conecta ao banco das variáveis DB_* (ex.: o do docker compose, criado a partir de db/init.sql).
+// Sem DB_HOST, os testes que dependem do banco são pulados.
+func testDB(t *testing.T) {
+	t.Helper()
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST não definido; teste de integração com o banco pulado")
+	}
+	config.InitDB()
+	if db := config.GetDB(); db == nil || db.Ping() != nil {
+		t.Fatal("banco de dados indisponível")
+	}
+}
+
+// Uma nota paga e dividida gera, na mesma transação, um registro de carteira por lançamento para cada
+// membro do grupo; os registros do mesmo morador não podem colidir em (user_id, created_at)
+func TestImportPaidSplitInvoice(t *testing.T) {
+	testDB(t)
+	db := config.GetDB()
+	suffix := uuid.NewString()[:8]
+
+	users := []*User{{Name: "Ana " + suffix}, {Name: "Bia " + suffix}}
+	for _, u := range users {
+		if err := u.Create(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	group := PayerGroup{Name: "Casa " + suffix}
+	if err := group.Create(nil); err != nil {
+		t.Fatal(err)
+	}
+	for i, percentage := range []float64{60, 40} {
+		member := PayerGroupMember{PayerGroupID: group.ID, UserID: users[i].ID, Percentage: percentage}
+		if err := member.Create(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	market, pharmacy := FinanceCC{Name: "Mercado " + suffix}, FinanceCC{Name: "Farmácia " + suffix}
+	for _, cc := range []*FinanceCC{&market, &pharmacy} {
+		if err := cc.Create(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	currency := FinanceCurrency{Name: "Real " + suffix, Symbol: "R$", Value: 1}
+	if err := currency.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	accessKey := ""
+	for len(accessKey) < 44 {
+		accessKey += fmt.Sprint(rand.Intn(10))
+	}
+	invoice := &Invoice{
+		AccessKey: accessKey,
+		Model:     "65",
+		Series:    "1",
+		Number:    "123",
+		IssuedAt:  time.Now(),
+		Issuer:    InvoiceIssuer{Document: "00000000000191", Name: "Supermercado " + suffix},
+		Items: []InvoiceItem{
+			{Number: 1, Description: "Arroz", Quantity: 1, UnitPrice: 70, Amount: 70},
+			{Number: 2, Description: "Dipirona", Quantity: 1, UnitPrice: 30, Amount: 30},
+		},
+		Total: 100,
+	}
+	opts := InvoiceImportOptions{
+		Finance: FinanceInstallment{UserID: users[0].ID, PayerGroupID: group.ID, FinanceCCID: market.ID, CurrencyID: currency.ID},
+		Splits:  []InvoiceSplit{{FinanceCCID: pharmacy.ID, Items: []int{2}}},
+		Paid:    true,
+	}
+
+	result, err := ImportInvoice(invoice, opts)
+	if err != nil {
+		t.Fatalf("ImportInvoice: %v", err)
+	}
+	t.Cleanup(func() {
+		financeIDs := make([]uuid.UUID, len(result.Entries))
+		for i, entry := range result.Entries {
+			financeIDs[i] = entry.Finance.ID
+		}
+		userIDs := []uuid.UUID{users[0].ID, users[1].ID}
+		for _, cleanup := range []struct {
+			query string
+			arg   interface{}
+		}{
+			{`DELETE FROM invoice_imports WHERE id = $1`, result.ID},
+			{`DELETE FROM transactions WHERE finance_occurrence_id IN (SELECT id FROM finance_occurrences WHERE finance_id = ANY($1))`, pq.Array(financeIDs)},
+			{`DELETE FROM finance_occurrences WHERE finance_id = ANY($1)`, pq.Array(financeIDs)},
+			{`DELETE FROM finance_installments WHERE id = ANY($1)`, pq.Array(financeIDs)},
+			{`DELETE FROM finance_wallets WHERE user_id = ANY($1)`, pq.Array(userIDs)},
+			{`DELETE FROM payer_group_members WHERE payer_group_id = $1`, group.ID},
+			{`DELETE FROM payer_groups WHERE id = $1`, group.ID},
+			{`DELETE FROM users WHERE id = ANY($1)`, pq.Array(userIDs)},
+			{`DELETE FROM finance_cc WHERE id = ANY($1)`, pq.Array([]uuid.UUID{market.ID, pharmacy.ID})},
+			{`DELETE FROM finance_currency WHERE id = $1`, currency.ID},
+		} {
+			if _, err := db.Exec(cleanup.query, cleanup.arg); err != nil {
+				t.Logf("limpeza: %v", err)
+			}
+		}
+	})
+
+	if len(result.Entries) != 2 {
+		t.Fatalf("%d lançamentos, esperados 2", len(result.Entries))
+	}
+	for _, entry := range result.Entries {
+		if !entry.Occurrence.Status {
+			t.Errorf("lançamento de %.2f não está pago", entry.Amount)
+		}
+	}
+
+	var transactions int
+	occurrenceIDs := []uuid.UUID{result.Entries[0].Occurrence.ID, result.Entries[1].Occurrence.ID}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM transactions WHERE finance_occurrence_id = ANY($1)`, pq.Array(occurrenceIDs)).
+		Scan(&transactions); err != nil {
+		t.Fatal(err)
+	}
+	if transactions != 2 {
+		t.Fatalf("%d transações, esperadas 2", transactions)
+	}
+
+	// Cada morador recebe um registro por lançamento; o último acumula a sua parte da nota inteira
+	for i, expected := range []float64{-60, -40} {
+		var count int
+		var last float64
+		err := db.QueryRow(`
+			SELECT COUNT(*), (SELECT amount FROM finance_wallets WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1)
+			FROM finance_wallets WHERE user_id = $1`, users[i].ID).Scan(&count, &last)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 || math.Abs(last-expected) >= 0.005 {
+			t.Errorf("carteira de %s: %d registros e saldo %.2f, esperados 2 e %.2f", users[i].Name, count, last, expected)
+		}
+	}
+}
\ No newline at end of file
@@ -0,0 +1,346 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+)
+
+// Tipos de job
+const (
+	JobTaskOccurrences    = "task_occurrences"
+	JobFinanceOccurrences = "finance_occurrences"
+)
+
+// Situações de um job
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Níveis das mensagens de progresso (os mesmos eventos do antigo stream de geração)
+const (
+	JobLogInfo    = "log"
+	JobLogError   = "error"
+	JobLogSuccess = "success"
+)
+
+const (
+	// JobRetention é por quanto tempo os jobs terminados e os seus logs ficam disponíveis
+	JobRetention = 30 * 24 * time.Hour
+
+	jobHeartbeat = 30 * time.Second
+	// Um job ativo sem heartbeat por este tempo foi interrompido (o servidor parou no meio)
+	jobStale     = 3 * jobHeartbeat
+	jobLogsBatch = 500
+)
+
+var (
+	ErrJobFinished  = errors.New("o job já terminou")
+	errJobCancelled = errors.New("job cancelado")
+)
+
+// ValidJobKind informa se o tipo de job é conhecido
+func ValidJobKind(kind string) bool {
+	return kind == JobTaskOccurrences || kind == JobFinanceOccurrences
+}
+
+// ValidJobStatus informa se a situação de job é conhecida
+func ValidJobStatus(status string) bool {
+	switch status {
+	case JobQueued, JobRunning, JobSucceeded, JobFailed, JobCancelled:
+		return true
+	}
+	return false
+}
+
+// Job é uma regeneração de ocorrências executada em segundo plano. O progresso é gravado no banco a cada
+// modelo processado, então pode ser consultado (e cancelado) de qualquer réplica.
+type Job struct {
+	ID              uuid.UUID  `json:"id"`
+	Kind            string     `json:"kind"`
+	Status          string     `json:"status"`
+	Total           int        `json:"total"`     // modelos a processar
+	Processed       int        `json:"processed"` // modelos já processados
+	Created         int        `json:"created"`   // ocorrências criadas
+	Errors          int        `json:"errors"`
+	CancelRequested bool       `json:"cancel_requested"`
+	Error           *string    `json:"error,omitempty"` // motivo da falha
+	CreatedAt       time.Time  `json:"created_at"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+}
+
+const jobColumns = `id, kind, status, total, processed, created, errors, cancel_requested, error, created_at, started_at, finished_at`
+
+func (j *Job) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&j.ID, &j.Kind, &j.Status, &j.Total, &j.Processed, &j.Created, &j.Errors,
+		&j.CancelRequested, &j.Error, &j.CreatedAt, &j.StartedAt, &j.FinishedAt)
+}
+
+// Finished informa se o job já terminou, com sucesso ou não
+func (j *Job) Finished() bool {
+	return j.Status != JobQueued && j.Status != JobRunning
+}
+
+// SubmitJob enfileira um job do tipo e o inicia neste processo. Se já houver um job do mesmo tipo em
+// andamento, ele é retornado no lugar de um novo (created = false).
+func SubmitJob(kind string) (job *Job, created bool, err error) {
+	db := config.GetDB()
+	if err := recoverJobs(db); err != nil {
+		return nil, false, err
+	}
+	if _, err := db.Exec(`DELETE FROM jobs WHERE finished_at < $1`, time.Now().Add(-JobRetention)); err != nil {
+		return nil, false, err
+	}
+
+	job = &Job{}
+	// O job ativo pode terminar entre o INSERT e o SELECT; nesse caso, tenta de novo
+	for attempt := 0; attempt < 2; attempt++ {
+		err = job.scan(db.QueryRow(`
+			INSERT INTO jobs (kind) VALUES ($1)
+			ON CONFLICT (kind) WHERE status IN ('queued', 'running') DO NOTHING
+			RETURNING `+jobColumns, kind))
+		if err == nil {
+			go runJob(job.ID, job.Kind)
+			return job, true, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, false, err
+		}
+
+		err = job.scan(db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE kind = $1 AND status IN ('queued', 'running')`, kind))
+		if err == nil {
+			return job, false, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, false, err
+		}
+	}
+	return nil, false, err
+}
+
+// Get busca um job pelo ID
+func (j *Job) Get() error {
+	return j.scan(config.GetDB().QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = $1`, j.ID))
+}
+
+// Cancel pede o cancelamento do job. O job para ao terminar o modelo em processamento; as ocorrências
+// já criadas são mantidas.
+func (j *Job) Cancel() error {
+	db := config.GetDB()
+	if err := recoverJobs(db); err != nil {
+		return err
+	}
+	err := j.scan(db.QueryRow(`
+		UPDATE jobs SET cancel_requested = TRUE
+		WHERE id = $1 AND status IN ('queued', 'running')
+		RETURNING `+jobColumns, j.ID))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err := j.Get(); err != nil {
+		return err
+	}
+	return ErrJobFinished
+}
+
+// ListJobs lista os jobs, dos mais recentes para os mais antigos, com filtros opcionais de tipo,
+// situação e período (data de criação)
+func ListJobs(kind, status string, filter ListFilter) ([]Job, error) {
+	db := config.GetDB()
+	if err := recoverJobs(db); err != nil {
+		return nil, err
+	}
+
+	var where whereClause
+	if kind != "" {
+		where.add("kind = ?", kind)
+	}
+	if status != "" {
+		where.add("status = ?", status)
+	}
+	where.addTimestampRange("created_at", filter)
+
+	rows, err := db.Query(`SELECT `+jobColumns+` FROM jobs `+where.String()+` ORDER BY created_at DESC, id`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		var j Job
+		if err := j.scan(rows); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// RecoverJobs marca como falhos os jobs que ficaram ativos sem heartbeat, interrompidos pela queda do
+// servidor. É chamado na inicialização e antes de enfileirar, cancelar ou listar jobs.
+func RecoverJobs() error {
+	return recoverJobs(config.GetDB())
+}
+
+func recoverJobs(db queryer) error {
+	_, err := db.Exec(`
+		UPDATE jobs
+		SET status = 'failed', error = 'Job interrompido: o servidor parou durante a execução',
+			finished_at = CURRENT_TIMESTAMP
+		WHERE status IN ('queued', 'running') AND heartbeat_at < $1`, time.Now().Add(-jobStale))
+	return err
+}
+
+// JobLog é uma mensagem de progresso de um job
+type JobLog struct {
+	ID        int64     `json:"id"`
+	Level     string    `json:"level"` // log, error ou success
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListJobLogs retorna as mensagens do job depois de afterID, em ordem, em lotes
+func ListJobLogs(jobID uuid.UUID, afterID int64) ([]JobLog, error) {
+	rows, err := config.GetDB().Query(`
+		SELECT id, level, message, created_at
+		FROM job_logs
+		WHERE job_id = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3`, jobID, afterID, jobLogsBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []JobLog{}
+	for rows.Next() {
+		var l JobLog
+		if err := rows.Scan(&l.ID, &l.Level, &l.Message, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
+
+// jobRun é a execução de um job: acumula o progresso e grava as mensagens
+type jobRun struct {
+	db                               *sql.DB
+	id                               uuid.UUID
+	total, processed, created, fails int
+}
+
+// log grava uma mensagem de progresso; uma falha aqui não interrompe o job
+func (r *jobRun) log(level, message string) {
+	if _, err := r.db.Exec(`INSERT INTO job_logs (job_id, level, message) VALUES ($1, $2, $3)`, r.id, level, message); err != nil {
+		log.Printf("Erro ao gravar o log do job %s: %v", r.id, err)
+	}
+}
+
+// fail registra um erro que não impede o restante do job
+func (r *jobRun) fail(message string) {
+	r.fails++
+	r.log(JobLogError, message)
+}
+
+// checkpoint grava o progresso e retorna errJobCancelled se o cancelamento foi pedido
+func (r *jobRun) checkpoint() error {
+	var cancel bool
+	err := r.db.QueryRow(`
+		UPDATE jobs
+		SET total = $1, processed = $2, created = $3, errors = $4, heartbeat_at = CURRENT_TIMESTAMP
+		WHERE id = $5
+		RETURNING cancel_requested`, r.total, r.processed, r.created, r.fails, r.id).Scan(&cancel)
+	if err != nil {
+		return err
+	}
+	if cancel {
+		return errJobCancelled
+	}
+	return nil
+}
+
+// run executa o job do tipo indicado. Um panic vira a falha do job: a goroutine do job não tem o
+// Recovery do gin, e o panic derrubaria o servidor e deixaria o job em execução até ser dado como parado.
+func (r *jobRun) run(kind string) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Panic no job %s: %v\n%s", r.id, p, debug.Stack())
+			err = fmt.Errorf("erro interno: %v", p)
+		}
+	}()
+
+	switch kind {
+	case JobTaskOccurrences:
+		return regenerateTaskOccurrences(r)
+	case JobFinanceOccurrences:
+		return regenerateFinanceOccurrences(r)
+	default:
+		return fmt.Errorf("tipo de job desconhecido: %s", kind)
+	}
+}
+
+// runJob executa o job até o fim, mantendo o heartbeat enquanto ele roda
+func runJob(id uuid.UUID, kind string) {
+	r := &jobRun{db: config.GetDB(), id: id}
+	if _, err := r.db.Exec(`
+		UPDATE jobs SET status = 'running', started_at = CURRENT_TIMESTAMP, heartbeat_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, id); err != nil {
+		log.Printf("Erro ao iniciar o job %s: %v", id, err)
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := r.db.Exec(`UPDATE jobs SET heartbeat_at = CURRENT_TIMESTAMP WHERE id = $1`, id); err != nil {
+					log.Printf("Erro ao atualizar o heartbeat do job %s: %v", id, err)
+				}
+			}
+		}
+	}()
+
+	err := r.run(kind)
+	close(done)
+
+	status := JobSucceeded
+	var message *string
+	switch {
+	case errors.Is(err, errJobCancelled):
+		status = JobCancelled
+		r.log(JobLogInfo, "Job cancelado")
+	case err != nil:
+		status = JobFailed
+		text := err.Error()
+		message = &text
+		r.log(JobLogError, "Job interrompido: "+text)
+	default:
+		r.log(JobLogSuccess, fmt.Sprintf("Processamento concluído: %d ocorrências criadas", r.created))
+	}
+
+	if _, err := r.db.Exec(`
+		UPDATE jobs
+		SET status = $1, error = $2, total = $3, processed = $4, created = $5, errors = $6,
+			finished_at = CURRENT_TIMESTAMP, heartbeat_at = CURRENT_TIMESTAMP
+		WHERE id = $7`, status, message, r.total, r.processed, r.created, r.fails, id); err != nil {
+		log.Printf("Erro ao finalizar o job %s: %v", id, err)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,642 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+	"github.com/pobruno/casa360/notify"
+)
+
+// Eventos das regras de notificação
+const (
+	NotifyTaskDue        = "task_due"        // antes do início da tarefa
+	NotifyTaskOverdue    = "task_overdue"    // depois do prazo, com a tarefa em aberto
+	NotifyFinanceDue     = "finance_due"     // antes do vencimento da finança
+	NotifyFinanceOverdue = "finance_overdue" // depois do vencimento, sem pagamento
+)
+
+// Situações de uma notificação registrada
+const (
+	NotificationSent   = "sent"
+	NotificationFailed = "failed"
+)
+
+const (
+	// NotificationLookback é até quanto tempo depois do momento do lembrete ele ainda é enviado
+	// (ex.: ao fim do horário de silêncio ou depois de o servidor ficar parado)
+	NotificationLookback = 24 * time.Hour
+	// MaxNotificationAttempts é o número de tentativas por canal antes de desistir de um lembrete
+	MaxNotificationAttempts = 3
+)
+
+var ErrDispatchRunning = errors.New("o envio de notificações já está em andamento")
+
+// ValidNotificationEvent informa se o evento de uma regra é conhecido
+func ValidNotificationEvent(event string) bool {
+	switch event {
+	case NotifyTaskDue, NotifyTaskOverdue, NotifyFinanceDue, NotifyFinanceOverdue:
+		return true
+	}
+	return false
+}
+
+// ValidNotificationChannel informa se o canal é conhecido
+func ValidNotificationChannel(channel string) bool {
+	switch channel {
+	case notify.ChannelEmail, notify.ChannelWebhook, notify.ChannelTelegram:
+		return true
+	}
+	return false
+}
+
+// NotificationSettings são as preferências de notificação de um morador. No horário de silêncio (no fuso
+// da casa, podendo atravessar a meia-noite, ex.: 22:00 às 07:00) os lembretes ficam para depois.
+type NotificationSettings struct {
+	UserID          uuid.UUID  `json:"user_id"`
+	QuietHoursStart *string    `json:"quiet_hours_start"` // HH:MM
+	QuietHoursEnd   *string    `json:"quiet_hours_end"`   // HH:MM
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+}
+
+// GetNotificationSettings lê as preferências do morador; sem registro, não há horário de silêncio
+func GetNotificationSettings(userID uuid.UUID) (*NotificationSettings, error) {
+	return getNotificationSettings(config.GetDB(), userID)
+}
+
+func getNotificationSettings(db queryer, userID uuid.UUID) (*NotificationSettings, error) {
+	s := &NotificationSettings{UserID: userID}
+	err := db.QueryRow(`
+		SELECT quiet_hours_start, quiet_hours_end, updated_at
+		FROM notification_settings
+		WHERE user_id = $1`, userID).Scan(&s.QuietHoursStart, &s.QuietHoursEnd, &s.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, nil
+	}
+	s.QuietHoursStart, s.QuietHoursEnd = clock(s.QuietHoursStart), clock(s.QuietHoursEnd)
+	return s, err
+}
+
+// clock reduz o TIME lido do banco (HH:MM:SS) a HH:MM
+func clock(value *string) *string {
+	if value == nil || len(*value) < 5 {
+		return value
+	}
+	short := (*value)[:5]
+	return &short
+}
+
+// Save grava as preferências do morador
+func (s *NotificationSettings) Save() error {
+	err := config.GetDB().QueryRow(`
+		INSERT INTO notification_settings (user_id, quiet_hours_start, quiet_hours_end)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET quiet_hours_start = EXCLUDED.quiet_hours_start, quiet_hours_end = EXCLUDED.quiet_hours_end,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at`, s.UserID, s.QuietHoursStart, s.QuietHoursEnd).Scan(&s.UpdatedAt)
+	return err
+}
+
+// quiet informa se o instante cai no horário de silêncio do morador
+func (s *NotificationSettings) quiet(now time.Time, loc *time.Location) bool {
+	if s.QuietHoursStart == nil || s.QuietHoursEnd == nil {
+		return false
+	}
+	start, err1 := time.Parse("15:04", *s.QuietHoursStart)
+	end, err2 := time.Parse("15:04", *s.QuietHoursEnd)
+	if err1 != nil || err2 != nil || start.Equal(end) {
+		return false
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	from, to := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	if from < to {
+		return minute >= from && minute < to
+	}
+	return minute >= from || minute < to
+}
+
+// NotificationChannel é um canal de notificação do morador
+type NotificationChannel struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Channel   string    `json:"channel"` // email, webhook ou telegram
+	Address   string    `json:"address"` // e-mail, URL do webhook ou chat_id do Telegram
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const notificationChannelColumns = `id, user_id, channel, address, enabled, created_at`
+
+func (nc *NotificationChannel) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&nc.ID, &nc.UserID, &nc.Channel, &nc.Address, &nc.Enabled, &nc.CreatedAt)
+}
+
+// Create grava o canal do morador
+func (nc *NotificationChannel) Create() error {
+	query := `
+		INSERT INTO notification_channels (user_id, channel, address, enabled)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + notificationChannelColumns
+	return nc.scan(config.GetDB().QueryRow(query, nc.UserID, nc.Channel, nc.Address, nc.Enabled))
+}
+
+// Get busca um canal pelo ID
+func (nc *NotificationChannel) Get() error {
+	return nc.scan(config.GetDB().QueryRow(`SELECT `+notificationChannelColumns+` FROM notification_channels WHERE id = $1`, nc.ID))
+}
+
+// Update altera o endereço e a ativação do canal
+func (nc *NotificationChannel) Update() error {
+	query := `
+		UPDATE notification_channels
+		SET address = $1, enabled = $2
+		WHERE id = $3
+		RETURNING ` + notificationChannelColumns
+	return nc.scan(config.GetDB().QueryRow(query, nc.Address, nc.Enabled, nc.ID))
+}
+
+// Delete remove o canal
+func (nc *NotificationChannel) Delete() error {
+	res, err := config.GetDB().Exec(`DELETE FROM notification_channels WHERE id = $1`, nc.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// ListNotificationChannels lista os canais de um morador
+func ListNotificationChannels(userID uuid.UUID) ([]NotificationChannel, error) {
+	return listNotificationChannels(config.GetDB(), userID, false)
+}
+
+func listNotificationChannels(db queryer, userID uuid.UUID, enabledOnly bool) ([]NotificationChannel, error) {
+	query := `SELECT ` + notificationChannelColumns + ` FROM notification_channels WHERE user_id = $1`
+	if enabledOnly {
+		query += ` AND enabled`
+	}
+	rows, err := db.Query(query+` ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []NotificationChannel{}
+	for rows.Next() {
+		var nc NotificationChannel
+		if err := nc.scan(rows); err != nil {
+			return nil, err
+		}
+		channels = append(channels, nc)
+	}
+	return channels, rows.Err()
+}
+
+// SendTest envia uma mensagem de teste pelo canal e registra o resultado
+func (nc *NotificationChannel) SendTest(ctx context.Context, notifiers notify.Notifiers) (*Notification, error) {
+	msg := notify.Message{
+		Subject: "Casa 360: teste de notificação",
+		Body:    "Este canal está pronto para receber os lembretes da casa.",
+		Data:    map[string]interface{}{"event": "test"},
+	}
+	sendErr := notifiers.Send(ctx, nc.Channel, nc.Address, msg)
+
+	n := &Notification{ChannelID: &nc.ID, UserID: nc.UserID, Channel: nc.Channel, Subject: msg.Subject}
+	query := `
+		INSERT INTO notifications (channel_id, user_id, channel, subject, status, error, sent_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + notificationColumns
+	status, errText, sentAt := notificationOutcome(sendErr)
+	if err := n.scan(config.GetDB().QueryRow(query, nc.ID, nc.UserID, nc.Channel, msg.Subject, status, errText, sentAt)); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// NotificationRule define quando lembrar: offset_minutes antes do início da tarefa ou do vencimento da
+// finança (task_due, finance_due) ou depois do prazo com a ocorrência ainda em aberto (task_overdue,
+// finance_overdue). Sem user_id, a regra vale para todos os moradores.
+type NotificationRule struct {
+	ID            uuid.UUID  `json:"id"`
+	Event         string     `json:"event"`
+	OffsetMinutes int        `json:"offset_minutes"`
+	UserID        *uuid.UUID `json:"user_id,omitempty"`
+	Enabled       bool       `json:"enabled"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+const notificationRuleColumns = `id, event, offset_minutes, user_id, enabled, created_at`
+
+func (nr *NotificationRule) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&nr.ID, &nr.Event, &nr.OffsetMinutes, &nr.UserID, &nr.Enabled, &nr.CreatedAt)
+}
+
+// Create grava a regra
+func (nr *NotificationRule) Create() error {
+	query := `
+		INSERT INTO notification_rules (event, offset_minutes, user_id, enabled)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + notificationRuleColumns
+	return nr.scan(config.GetDB().QueryRow(query, nr.Event, nr.OffsetMinutes, nr.UserID, nr.Enabled))
+}
+
+// Get busca uma regra pelo ID
+func (nr *NotificationRule) Get() error {
+	return nr.scan(config.GetDB().QueryRow(`SELECT `+notificationRuleColumns+` FROM notification_rules WHERE id = $1`, nr.ID))
+}
+
+// Update altera a regra
+func (nr *NotificationRule) Update() error {
+	query := `
+		UPDATE notification_rules
+		SET event = $1, offset_minutes = $2, user_id = $3, enabled = $4
+		WHERE id = $5
+		RETURNING ` + notificationRuleColumns
+	return nr.scan(config.GetDB().QueryRow(query, nr.Event, nr.OffsetMinutes, nr.UserID, nr.Enabled, nr.ID))
+}
+
+// Delete remove a regra e o registro dos lembretes enviados por ela
+func (nr *NotificationRule) Delete() error {
+	res, err := config.GetDB().Exec(`DELETE FROM notification_rules WHERE id = $1`, nr.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// ListNotificationRules lista as regras da casa
+func ListNotificationRules() ([]NotificationRule, error) {
+	rows, err := config.GetDB().Query(`SELECT ` + notificationRuleColumns + ` FROM notification_rules ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []NotificationRule{}
+	for rows.Next() {
+		var nr NotificationRule
+		if err := nr.scan(rows); err != nil {
+			return nil, err
+		}
+		rules = append(rules, nr)
+	}
+	return rules, rows.Err()
+}
+
+// Notification é o registro de um envio: o último resultado e quantas tentativas foram feitas
+type Notification struct {
+	ID                  uuid.UUID  `json:"id"`
+	RuleID              *uuid.UUID `json:"rule_id,omitempty"` // vazio nas mensagens de teste
+	ChannelID           *uuid.UUID `json:"channel_id,omitempty"`
+	UserID              uuid.UUID  `json:"user_id"`
+	TaskOccurrenceID    *uuid.UUID `json:"task_occurrence_id,omitempty"`
+	FinanceOccurrenceID *uuid.UUID `json:"finance_occurrence_id,omitempty"`
+	Channel             string     `json:"channel"`
+	Subject             string     `json:"subject"`
+	Status              string     `json:"status"` // sent ou failed
+	Attempts            int        `json:"attempts"`
+	Error               *string    `json:"error,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	SentAt              *time.Time `json:"sent_at,omitempty"`
+}
+
+const notificationColumns = `id, rule_id, channel_id, user_id, task_occurrence_id, finance_occurrence_id, channel, subject,
+	status, attempts, error, created_at, sent_at`
+
+func (n *Notification) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&n.ID, &n.RuleID, &n.ChannelID, &n.UserID, &n.TaskOccurrenceID, &n.FinanceOccurrenceID, &n.Channel, &n.Subject,
+		&n.Status, &n.Attempts, &n.Error, &n.CreatedAt, &n.SentAt)
+}
+
+// ListNotifications lista os envios, dos mais recentes para os mais antigos, por morador e período
+func ListNotifications(filter ListFilter) ([]Notification, error) {
+	var where whereClause
+	where.addTimestampRange("created_at", filter)
+	if filter.UserID != nil {
+		where.add("user_id = ?", *filter.UserID)
+	}
+
+	rows, err := config.GetDB().Query(`
+		SELECT `+notificationColumns+`
+		FROM notifications
+		`+where.String()+`
+		ORDER BY created_at DESC, id`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := n.scan(rows); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// notificationOutcome traduz o resultado do envio para as colunas status, error e sent_at
+func notificationOutcome(sendErr error) (string, *string, *time.Time) {
+	if sendErr != nil {
+		text := sendErr.Error()
+		return NotificationFailed, &text, nil
+	}
+	now := time.Now()
+	return NotificationSent, nil, &now
+}
+
+// DispatchResult resume uma rodada de envio
+type DispatchResult struct {
+	Sent     int `json:"sent"`
+	Failed   int `json:"failed"`
+	Deferred int `json:"deferred"` // lembretes adiados pelo horário de silêncio
+}
+
+// reminder é um lembrete devido: a ocorrência, quem a recebe e os dados da mensagem
+type reminder struct {
+	rule                NotificationRule
+	userID              uuid.UUID
+	taskOccurrenceID    *uuid.UUID
+	financeOccurrenceID *uuid.UUID
+	title               string
+	date                time.Time
+	at                  *time.Time // início da tarefa, quando agendada
+	amount              *float64
+	symbol              *string
+	expense             bool
+}
+
+// reminderKey identifica o envio de um lembrete por um canal
+type reminderKey struct {
+	ruleID, channelID, occurrenceID uuid.UUID
+}
+
+var dispatchMu sync.Mutex
+
+// DispatchNotifications envia os lembretes devidos pelas regras ativas a cada canal ativo do morador.
+// Cada lembrete é enviado uma vez por canal; falhas são repetidas nas próximas rodadas até
+// MaxNotificationAttempts. No horário de silêncio do morador, o envio fica para uma rodada posterior.
+func DispatchNotifications(ctx context.Context, notifiers notify.Notifiers) (*DispatchResult, error) {
+	if !dispatchMu.TryLock() {
+		return nil, ErrDispatchRunning
+	}
+	defer dispatchMu.Unlock()
+
+	db := config.GetDB()
+	loc, err := location(db)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	since := now.Add(-NotificationLookback)
+
+	rules, err := ListNotificationRules()
+	if err != nil {
+		return nil, err
+	}
+	done, err := finishedReminders(db, since)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DispatchResult{}
+	settings := map[uuid.UUID]*NotificationSettings{}
+	channels := map[uuid.UUID][]NotificationChannel{}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		reminders, err := dueReminders(db, rule, since, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range reminders {
+			userChannels, ok := channels[r.userID]
+			if !ok {
+				if userChannels, err = listNotificationChannels(db, r.userID, true); err != nil {
+					return nil, err
+				}
+				channels[r.userID] = userChannels
+			}
+			if len(userChannels) == 0 {
+				continue
+			}
+			userSettings, ok := settings[r.userID]
+			if !ok {
+				if userSettings, err = getNotificationSettings(db, r.userID); err != nil {
+					return nil, err
+				}
+				settings[r.userID] = userSettings
+			}
+			if userSettings.quiet(now, loc) {
+				result.Deferred++
+				continue
+			}
+
+			msg := r.message(loc)
+			for _, channel := range userChannels {
+				key := reminderKey{rule.ID, channel.ID, r.occurrenceID()}
+				if done[key] {
+					continue
+				}
+				sendErr := notifiers.Send(ctx, channel.Channel, channel.Address, msg)
+				if err := r.record(db, channel, msg.Subject, sendErr); err != nil {
+					return nil, err
+				}
+				if sendErr != nil {
+					result.Failed++
+				} else {
+					result.Sent++
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// finishedReminders carrega os envios que não devem ser repetidos: os concluídos e os que esgotaram as tentativas
+func finishedReminders(db queryer, since time.Time) (map[reminderKey]bool, error) {
+	rows, err := db.Query(`
+		SELECT rule_id, channel_id, COALESCE(task_occurrence_id, finance_occurrence_id)
+		FROM notifications
+		WHERE rule_id IS NOT NULL AND created_at > $1 AND (status = $2 OR attempts >= $3)`,
+		since, NotificationSent, MaxNotificationAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[reminderKey]bool{}
+	for rows.Next() {
+		var key reminderKey
+		if err := rows.Scan(&key.ruleID, &key.channelID, &key.occurrenceID); err != nil {
+			return nil, err
+		}
+		done[key] = true
+	}
+	return done, rows.Err()
+}
+
+// dueReminders lista as ocorrências em aberto cujo momento de lembrete, pela regra, caiu entre since e now.
+// Tarefas sem horário e finanças contam a partir da meia-noite da data no fuso da casa; o atraso conta a
+// partir do prazo da tarefa ou do fim do dia.
+func dueReminders(db queryer, rule NotificationRule, since, now time.Time) ([]reminder, error) {
+	var query string
+	switch rule.Event {
+	case NotifyTaskDue, NotifyTaskOverdue:
+		trigger := `COALESCE(o.scheduled_at, o.date::timestamp AT TIME ZONE hs.timezone) - $4 * interval '1 minute'`
+		if rule.Event == NotifyTaskOverdue {
+			trigger = `COALESCE(o.due_at, (o.date + 1)::timestamp AT TIME ZONE hs.timezone) + $4 * interval '1 minute'`
+		}
+		query = `
+			SELECT o.id, o.user_id, ti.title, o.date, o.scheduled_at
+			FROM task_occurrences o
+			JOIN task_installments ti ON ti.id = o.task_id
+			CROSS JOIN household_settings hs
+			WHERE o.state IN ('pending', 'in_progress', 'overdue') AND ti.deleted_at IS NULL
+				AND ($3::uuid IS NULL OR o.user_id = $3)
+				AND ` + trigger + ` > $1 AND ` + trigger + ` <= $2
+			ORDER BY o.date, o.id`
+	case NotifyFinanceDue, NotifyFinanceOverdue:
+		trigger := `fo.date::timestamp AT TIME ZONE hs.timezone - $4 * interval '1 minute'`
+		if rule.Event == NotifyFinanceOverdue {
+			trigger = `(fo.date + 1)::timestamp AT TIME ZONE hs.timezone + $4 * interval '1 minute'`
+		}
+		query = `
+			SELECT fo.id, fi.user_id, fi.title, fo.date, fo.amount, fc.symbol, fi.type
+			FROM finance_occurrences fo
+			JOIN finance_installments fi ON fi.id = fo.finance_id
+			LEFT JOIN finance_currency fc ON fc.id = fi.currency_id
+			CROSS JOIN household_settings hs
+			WHERE NOT fo.status AND fi.deleted_at IS NULL
+				AND ($3::uuid IS NULL OR fi.user_id = $3)
+				AND ` + trigger + ` > $1 AND ` + trigger + ` <= $2
+			ORDER BY fo.date, fo.id`
+	default:
+		return nil, fmt.Errorf("evento de notificação desconhecido: %s", rule.Event)
+	}
+
+	rows, err := db.Query(query, since, now, rule.UserID, rule.OffsetMinutes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []reminder
+	for rows.Next() {
+		r := reminder{rule: rule}
+		var id uuid.UUID
+		if rule.Event == NotifyTaskDue || rule.Event == NotifyTaskOverdue {
+			err = rows.Scan(&id, &r.userID, &r.title, &r.date, &r.at)
+			r.taskOccurrenceID = &id
+		} else {
+			err = rows.Scan(&id, &r.userID, &r.title, &r.date, &r.amount, &r.symbol, &r.expense)
+			r.financeOccurrenceID = &id
+		}
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+	return reminders, rows.Err()
+}
+
+func (r reminder) occurrenceID() uuid.UUID {
+	if r.taskOccurrenceID != nil {
+		return *r.taskOccurrenceID
+	}
+	return *r.financeOccurrenceID
+}
+
+// message monta o texto do lembrete, com datas e horários no fuso da casa
+func (r reminder) message(loc *time.Location) notify.Message {
+	when := r.date.Format("02/01/2006")
+	if r.at != nil {
+		when += " às " + r.at.In(loc).Format("15:04")
+	}
+	data := map[string]interface{}{
+		"event":         r.rule.Event,
+		"rule_id":       r.rule.ID,
+		"occurrence_id": r.occurrenceID(),
+		"date":          r.date.Format("2006-01-02"),
+		"title":         r.title,
+	}
+
+	var subject, body string
+	switch r.rule.Event {
+	case NotifyTaskDue:
+		data["occurrence_type"] = "task"
+		data["scheduled_at"] = r.at
+		subject = "Lembrete: " + r.title
+		body = fmt.Sprintf("A tarefa \"%s\" está marcada para %s.", r.title, when)
+	case NotifyTaskOverdue:
+		data["occurrence_type"] = "task"
+		data["scheduled_at"] = r.at
+		subject = "Tarefa atrasada: " + r.title
+		body = fmt.Sprintf("A tarefa \"%s\" de %s ainda não foi concluída.", r.title, when)
+	default:
+		data["occurrence_type"] = "finance"
+		data["amount"] = r.amount
+		symbol := "R$"
+		if r.symbol != nil {
+			symbol = *r.symbol
+		}
+		amount := ""
+		if r.amount != nil {
+			amount = " de " + symbol + " " + FormatDecimalPtBR(*r.amount, 2)
+		}
+		switch {
+		case r.rule.Event == NotifyFinanceDue && r.expense:
+			subject = "Conta a vencer: " + r.title
+			body = fmt.Sprintf("\"%s\"%s vence em %s.", r.title, amount, when)
+		case r.rule.Event == NotifyFinanceDue:
+			subject = "Receita prevista: " + r.title
+			body = fmt.Sprintf("\"%s\"%s está prevista para %s.", r.title, amount, when)
+		case r.expense:
+			subject = "Conta vencida: " + r.title
+			body = fmt.Sprintf("\"%s\"%s venceu em %s e ainda não foi paga.", r.title, amount, when)
+		default:
+			subject = "Receita em atraso: " + r.title
+			body = fmt.Sprintf("\"%s\"%s estava prevista para %s e ainda não foi recebida.", r.title, amount, when)
+		}
+	}
+	return notify.Message{Subject: subject, Body: body, Data: data}
+}
+
+// record grava o resultado do envio; uma nova tentativa atualiza o registro anterior
+func (r reminder) record(db queryer, channel NotificationChannel, subject string, sendErr error) error {
+	column := "task_occurrence_id"
+	if r.financeOccurrenceID != nil {
+		column = "finance_occurrence_id"
+	}
+	status, errText, sentAt := notificationOutcome(sendErr)
+	_, err := db.Exec(`
+		INSERT INTO notifications (rule_id, channel_id, user_id, `+column+`, channel, subject, status, error, sent_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (rule_id, channel_id, `+column+`) WHERE `+column+` IS NOT NULL
+		DO UPDATE SET status = EXCLUDED.status, error = EXCLUDED.error, sent_at = EXCLUDED.sent_at,
+			subject = EXCLUDED.subject, attempts = notifications.attempts + 1`,
+		r.rule.ID, channel.ID, r.userID, r.occurrenceID(), channel.Channel, strings.TrimSpace(subject), status, errText, sentAt)
+	return err
+}
\ No newline at end of file
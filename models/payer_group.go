@@ -1,13 +1,17 @@
 package models
 
 import (
+	"database/sql"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/pobruno/casa360/config"
 )
 
 type PayerGroup struct {
-	ID   uuid.UUID `json:"id"`
-	Name string    `json:"name"`
+	ID        uuid.UUID  `json:"id"`
+	Name      string     `json:"name"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // preenchido quando arquivado
 }
 
 type PayerGroupMember struct {
@@ -17,47 +21,44 @@ type PayerGroupMember struct {
 	Percentage   float64   `json:"percentage"`
 }
 
-func (pg *PayerGroup) Create() error {
+func (pg *PayerGroup) Create(by *uuid.UUID) error {
 	query := `
 		INSERT INTO payer_groups (id, name)
 		VALUES ($1, $2)
-		RETURNING id, name
+		RETURNING id, name, deleted_at
 	`
-	return config.GetDB().QueryRow(query, uuid.New(), pg.Name).Scan(&pg.ID, &pg.Name)
+	return audited(by, func(tx *sql.Tx) error {
+		return tx.QueryRow(query, uuid.New(), pg.Name).Scan(&pg.ID, &pg.Name, &pg.DeletedAt)
+	})
 }
 
 func (pg *PayerGroup) Get() error {
 	query := `
-		SELECT id, name
+		SELECT id, name, deleted_at
 		FROM payer_groups
 		WHERE id = $1
 	`
-	return config.GetDB().QueryRow(query, pg.ID).Scan(&pg.ID, &pg.Name)
+	return config.GetDB().QueryRow(query, pg.ID).Scan(&pg.ID, &pg.Name, &pg.DeletedAt)
 }
 
-func (pg *PayerGroup) Update() error {
+func (pg *PayerGroup) Update(by *uuid.UUID) error {
 	query := `
 		UPDATE payer_groups
 		SET name = $1
 		WHERE id = $2
-		RETURNING id, name
-	`
-	return config.GetDB().QueryRow(query, pg.Name, pg.ID).Scan(&pg.ID, &pg.Name)
-}
-
-func (pg *PayerGroup) Delete() error {
-	query := `
-		DELETE FROM payer_groups
-		WHERE id = $1
+		RETURNING id, name, deleted_at
 	`
-	_, err := config.GetDB().Exec(query, pg.ID)
-	return err
+	return audited(by, func(tx *sql.Tx) error {
+		return tx.QueryRow(query, pg.Name, pg.ID).Scan(&pg.ID, &pg.Name, &pg.DeletedAt)
+	})
 }
 
-func ListPayerGroups() ([]PayerGroup, error) {
+// ListPayerGroups lista os grupos de pagadores conforme o filtro de arquivamento
+func ListPayerGroups(archived string) ([]PayerGroup, error) {
 	query := `
-		SELECT id, name
+		SELECT id, name, deleted_at
 		FROM payer_groups
+		` + archivedWhere(archived) + `
 		ORDER BY name
 	`
 	rows, err := config.GetDB().Query(query)
@@ -69,7 +70,7 @@ func ListPayerGroups() ([]PayerGroup, error) {
 	var groups []PayerGroup
 	for rows.Next() {
 		var pg PayerGroup
-		if err := rows.Scan(&pg.ID, &pg.Name); err != nil {
+		if err := rows.Scan(&pg.ID, &pg.Name, &pg.DeletedAt); err != nil {
 			return nil, err
 		}
 		groups = append(groups, pg)
@@ -77,23 +78,27 @@ func ListPayerGroups() ([]PayerGroup, error) {
 	return groups, nil
 }
 
-func (pgm *PayerGroupMember) Create() error {
+func (pgm *PayerGroupMember) Create(by *uuid.UUID) error {
 	query := `
 		INSERT INTO payer_group_members (id, payer_group_id, user_id, percentage)
 		VALUES ($1, $2, $3, $4)
 		RETURNING id, payer_group_id, user_id, percentage
 	`
-	return config.GetDB().QueryRow(query, uuid.New(), pgm.PayerGroupID, pgm.UserID, pgm.Percentage).
-		Scan(&pgm.ID, &pgm.PayerGroupID, &pgm.UserID, &pgm.Percentage)
+	return audited(by, func(tx *sql.Tx) error {
+		return tx.QueryRow(query, uuid.New(), pgm.PayerGroupID, pgm.UserID, pgm.Percentage).
+			Scan(&pgm.ID, &pgm.PayerGroupID, &pgm.UserID, &pgm.Percentage)
+	})
 }
 
-func (pgm *PayerGroupMember) Delete() error {
+func (pgm *PayerGroupMember) Delete(by *uuid.UUID) error {
 	query := `
 		DELETE FROM payer_group_members
 		WHERE id = $1
 	`
-	_, err := config.GetDB().Exec(query, pgm.ID)
-	return err
+	return audited(by, func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, pgm.ID)
+		return err
+	})
 }
 
 func ListPayerGroupMembers(payerGroupID uuid.UUID) ([]PayerGroupMember, error) {
@@ -118,4 +123,4 @@ func ListPayerGroupMembers(payerGroupID uuid.UUID) ([]PayerGroupMember, error) {
 		members = append(members, pgm)
 	}
 	return members, nil
-} 
\ No newline at end of file
+}
\ No newline at end of file
@@ -0,0 +1,296 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// Identificadores dos campos do BR Code (padrão EMV-MPM definido pelo Banco Central)
+const (
+	brCodePayloadFormat     = "00"
+	brCodePointOfInitiation = "01"
+	brCodeMerchantAccount   = "26"
+	brCodeMerchantCategory  = "52"
+	brCodeCurrency          = "53"
+	brCodeAmount            = "54"
+	brCodeCountry           = "58"
+	brCodeMerchantName      = "59"
+	brCodeMerchantCity      = "60"
+	brCodeAdditionalData    = "62"
+	brCodeCRC               = "63"
+
+	pixGUI = "br.gov.bcb.pix"
+)
+
+// Tipos de chave Pix
+const (
+	PixKeyCPF   = "cpf"
+	PixKeyCNPJ  = "cnpj"
+	PixKeyEmail = "email"
+	PixKeyPhone = "phone"
+	PixKeyEVP   = "evp"
+)
+
+// BRCode representa um código Pix "copia e cola" já decodificado
+type BRCode struct {
+	Key          string   `json:"key,omitempty"`
+	KeyType      string   `json:"key_type,omitempty"`
+	URL          string   `json:"url,omitempty"` // BR Code dinâmico
+	Description  string   `json:"description,omitempty"`
+	MerchantName string   `json:"merchant_name"`
+	MerchantCity string   `json:"merchant_city"`
+	Amount       *float64 `json:"amount,omitempty"`
+	TxID         string   `json:"txid,omitempty"`
+	Dynamic      bool     `json:"dynamic"`
+}
+
+var (
+	pixEmailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	pixPhoneRegexp = regexp.MustCompile(`^\+[1-9][0-9]{10,13}$`)
+	pixDigits      = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// ParseBRCode decodifica e valida um BR Code Pix, incluindo o CRC16 final
+func ParseBRCode(code string) (*BRCode, error) {
+	code = strings.TrimSpace(code)
+	if len(code) < 8 {
+		return nil, fmt.Errorf("BR Code muito curto")
+	}
+
+	// O CRC cobre todo o payload, inclusive o identificador e o tamanho do próprio campo 63
+	crcIdx := len(code) - 8
+	if code[crcIdx:crcIdx+4] != brCodeCRC+"04" {
+		return nil, fmt.Errorf("BR Code sem campo CRC16")
+	}
+	expected := crc16CCITT(code[:crcIdx+4])
+	if !strings.EqualFold(code[crcIdx+4:], expected) {
+		return nil, fmt.Errorf("CRC16 inválido: esperado %s", expected)
+	}
+
+	fields, err := parseTLV(code[:crcIdx])
+	if err != nil {
+		return nil, err
+	}
+	if fields[brCodePayloadFormat] != "01" {
+		return nil, fmt.Errorf("formato de payload inválido")
+	}
+	if currency, ok := fields[brCodeCurrency]; ok && currency != "986" {
+		return nil, fmt.Errorf("moeda não suportada: %s", currency)
+	}
+
+	br := &BRCode{
+		MerchantName: fields[brCodeMerchantName],
+		MerchantCity: fields[brCodeMerchantCity],
+		Dynamic:      fields[brCodePointOfInitiation] == "12",
+	}
+	if br.MerchantName == "" {
+		return nil, fmt.Errorf("nome do recebedor ausente")
+	}
+	if br.MerchantCity == "" {
+		return nil, fmt.Errorf("cidade do recebedor ausente")
+	}
+
+	// A conta do recebedor pode estar em qualquer campo entre 26 e 51; procuramos o GUI do Pix
+	found := false
+	for id := 26; id <= 51; id++ {
+		value, ok := fields[strconv.Itoa(id)]
+		if !ok {
+			continue
+		}
+		account, err := parseTLV(value)
+		if err != nil || !strings.EqualFold(account["00"], pixGUI) {
+			continue
+		}
+		br.Key = account["01"]
+		br.Description = account["02"]
+		br.URL = account["25"]
+		found = true
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("BR Code não contém um arranjo Pix")
+	}
+	if br.Key == "" && br.URL == "" {
+		return nil, fmt.Errorf("BR Code sem chave Pix")
+	}
+	if br.Key != "" {
+		keyType, err := PixKeyType(br.Key)
+		if err != nil {
+			return nil, err
+		}
+		br.KeyType = keyType
+	}
+
+	if value, ok := fields[brCodeAmount]; ok {
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil || amount <= 0 {
+			return nil, fmt.Errorf("valor inválido no BR Code: %q", value)
+		}
+		br.Amount = &amount
+	}
+
+	if additional, ok := fields[brCodeAdditionalData]; ok {
+		data, err := parseTLV(additional)
+		if err != nil {
+			return nil, err
+		}
+		br.TxID = data["05"]
+	}
+
+	return br, nil
+}
+
+// Encode gera o BR Code estático correspondente, já com o CRC16
+func (br *BRCode) Encode() (string, error) {
+	if br.Key == "" {
+		return "", fmt.Errorf("chave Pix obrigatória")
+	}
+	if _, err := PixKeyType(br.Key); err != nil {
+		return "", err
+	}
+
+	name := brCodeText(br.MerchantName, 25)
+	city := brCodeText(br.MerchantCity, 15)
+	if name == "" || city == "" {
+		return "", fmt.Errorf("nome e cidade do recebedor são obrigatórios")
+	}
+
+	account := tlv("00", pixGUI) + tlv("01", br.Key)
+	if br.Description != "" {
+		// O tamanho total do campo 26 não pode exceder 99 caracteres
+		max := 99 - len(account) - 4
+		if max > 0 {
+			account += tlv("02", brCodeText(br.Description, max))
+		}
+	}
+
+	txid := br.TxID
+	if txid == "" {
+		txid = "***"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(tlv(brCodePayloadFormat, "01"))
+	sb.WriteString(tlv(brCodeMerchantAccount, account))
+	sb.WriteString(tlv(brCodeMerchantCategory, "0000"))
+	sb.WriteString(tlv(brCodeCurrency, "986"))
+	if br.Amount != nil && *br.Amount > 0 {
+		sb.WriteString(tlv(brCodeAmount, strconv.FormatFloat(*br.Amount, 'f', 2, 64)))
+	}
+	sb.WriteString(tlv(brCodeCountry, "BR"))
+	sb.WriteString(tlv(brCodeMerchantName, name))
+	sb.WriteString(tlv(brCodeMerchantCity, city))
+	sb.WriteString(tlv(brCodeAdditionalData, tlv("05", txid)))
+	sb.WriteString(brCodeCRC + "04")
+
+	payload := sb.String()
+	return payload + crc16CCITT(payload), nil
+}
+
+// PixKeyType identifica o tipo de uma chave Pix, retornando erro se ela não for válida
+func PixKeyType(key string) (string, error) {
+	switch {
+	case pixEmailRegexp.MatchString(key) && len(key) <= 77:
+		return PixKeyEmail, nil
+	case pixPhoneRegexp.MatchString(key):
+		return PixKeyPhone, nil
+	case pixDigits.MatchString(key) && len(key) == 11:
+		return PixKeyCPF, nil
+	case pixDigits.MatchString(key) && len(key) == 14:
+		return PixKeyCNPJ, nil
+	}
+	if _, err := uuid.Parse(key); err == nil && len(key) == 36 {
+		return PixKeyEVP, nil
+	}
+	return "", fmt.Errorf("chave Pix inválida: %s", key)
+}
+
+func parseTLV(data string) (map[string]string, error) {
+	fields := map[string]string{}
+	for i := 0; i < len(data); {
+		if i+4 > len(data) {
+			return nil, fmt.Errorf("BR Code malformado na posição %d", i)
+		}
+		id := data[i : i+2]
+		size, err := strconv.Atoi(data[i+2 : i+4])
+		if err != nil || i+4+size > len(data) {
+			return nil, fmt.Errorf("tamanho inválido no campo %s", id)
+		}
+		fields[id] = data[i+4 : i+4+size]
+		i += 4 + size
+	}
+	return fields, nil
+}
+
+func tlv(id, value string) string {
+	return fmt.Sprintf("%s%02d%s", id, len(value), value)
+}
+
+// brCodeText remove acentos e caracteres fora do ASCII e limita o tamanho do texto
+func brCodeText(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(normalizeText(s)))
+	s = strings.TrimSpace(s)
+	if len(s) > max {
+		s = strings.TrimSpace(s[:max])
+	}
+	return s
+}
+
+// crc16CCITT calcula o CRC16-CCITT (polinômio 0x1021, valor inicial 0xFFFF) exigido pelo BR Code
+func crc16CCITT(payload string) string {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(payload); i++ {
+		crc ^= uint16(payload[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%04X", crc)
+}
+
+// NewSettlementBRCode gera o BR Code para o acerto entre moradores, tendo receiver como recebedor
+func NewSettlementBRCode(receiver *User, amount float64, description string) (*BRCode, string, error) {
+	if receiver.PixKey == nil || *receiver.PixKey == "" {
+		return nil, "", fmt.Errorf("usuário %s não possui chave Pix cadastrada", receiver.Name)
+	}
+	if receiver.PixCity == nil || *receiver.PixCity == "" {
+		return nil, "", fmt.Errorf("usuário %s não possui cidade Pix cadastrada", receiver.Name)
+	}
+	if amount <= 0 {
+		return nil, "", fmt.Errorf("valor do acerto deve ser positivo")
+	}
+
+	br := &BRCode{
+		Key:          *receiver.PixKey,
+		Description:  description,
+		MerchantName: receiver.Name,
+		MerchantCity: *receiver.PixCity,
+		Amount:       &amount,
+		TxID:         strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:25]),
+	}
+	code, err := br.Encode()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Decodifica o código gerado para devolver os campos normalizados (nome e cidade sem acentos)
+	parsed, err := ParseBRCode(code)
+	if err != nil {
+		return nil, "", err
+	}
+	return parsed, code, nil
+}
\ No newline at end of file
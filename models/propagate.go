@@ -0,0 +1,426 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// queryer é atendido por *sql.DB e *sql.Tx, para que as mesmas consultas rodem dentro ou fora de uma transação
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Abrangência da alteração de um modelo (tarefa ou finança) sobre as ocorrências já geradas
+const (
+	ApplyTemplate = "template" // padrão: só o modelo; as ocorrências já geradas ficam como estão
+	ApplyThis     = "this"     // só a ocorrência indicada; o modelo não muda
+	ApplyFuture   = "future"   // o modelo e as ocorrências em aberto a partir da indicada (ou de hoje)
+	ApplyUnpaid   = "unpaid"   // o modelo e todas as ocorrências em aberto
+)
+
+var (
+	ErrApplyOccurrenceRequired = errors.New("apply_to=this exige occurrence_id")
+	ErrApplyOccurrenceNotFound = errors.New("ocorrência não encontrada neste modelo")
+	ErrApplyRecurrence         = errors.New("a recorrência não pode ser alterada em uma única ocorrência; use apply_to=future ou unpaid, ou remarque a ocorrência")
+)
+
+// ValidApplyTo indica se a abrangência é conhecida
+func ValidApplyTo(applyTo string) bool {
+	switch applyTo {
+	case ApplyTemplate, ApplyThis, ApplyFuture, ApplyUnpaid:
+		return true
+	}
+	return false
+}
+
+// ApplyResult resume o efeito da alteração de um modelo sobre as ocorrências em aberto
+type ApplyResult struct {
+	ApplyTo   string     `json:"apply_to"`
+	From      *time.Time `json:"from,omitempty"`
+	Updated   int        `json:"updated"`   // ocorrências reescritas com os novos dados
+	Removed   int        `json:"removed"`   // ocorrências removidas porque a recorrência mudou
+	Generated int        `json:"generated"` // ocorrências geradas pela nova recorrência
+}
+
+// applyStart resolve a data a partir da qual a alteração vale: a da ocorrência indicada ou,
+// em future sem ocorrência, hoje. query recebe o ID da ocorrência e retorna o modelo e a data.
+func applyStart(tx *sql.Tx, query string, templateID uuid.UUID, applyTo string, occurrenceID *uuid.UUID) (*time.Time, error) {
+	if occurrenceID == nil {
+		switch applyTo {
+		case ApplyThis:
+			return nil, ErrApplyOccurrenceRequired
+		case ApplyFuture:
+			from, err := Today()
+			if err != nil {
+				return nil, err
+			}
+			return &from, nil
+		}
+		return nil, nil
+	}
+
+	var owner uuid.UUID
+	var date time.Time
+	err := tx.QueryRow(query, *occurrenceID).Scan(&owner, &date)
+	if err == sql.ErrNoRows || (err == nil && owner != templateID) {
+		return nil, ErrApplyOccurrenceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if applyTo != ApplyFuture {
+		return nil, nil
+	}
+	return &date, nil
+}
+
+// openScope monta o filtro das ocorrências em aberto alcançadas pela alteração
+func openScope(open string, templateID uuid.UUID, applyTo string, occurrenceID *uuid.UUID, from *time.Time) (string, []interface{}) {
+	where, args := open, []interface{}{templateID}
+	switch {
+	case applyTo == ApplyThis:
+		where += " AND id = $2"
+		args = append(args, *occurrenceID)
+	case from != nil:
+		where += " AND date >= $2"
+		args = append(args, *from)
+	}
+	return where, args
+}
+
+// dropOrphanExceptions remove as exceções de datas adiadas ou remarcadas cujas ocorrências foram removidas;
+// as datas puladas continuam valendo
+func dropOrphanExceptions(tx *sql.Tx, column, table string, templateID uuid.UUID) error {
+	_, err := tx.Exec(`
+		DELETE FROM occurrence_exceptions e
+		WHERE e.`+column+` = $1 AND e.action <> 'skipped'
+			AND NOT EXISTS (SELECT 1 FROM `+table+` o WHERE o.`+column+` = e.`+column+` AND o.original_date = e.original_date)`, templateID)
+	return err
+}
+
+// existingDates carrega as datas que já têm ocorrência (depois de after, se informado), para que a
+// regeneração não as duplique
+func existingDates(db queryer, table, column string, templateID uuid.UUID, after *time.Time) (DateSet, error) {
+	query, args := `SELECT date FROM `+table+` WHERE `+column+` = $1`, []interface{}{templateID}
+	if after != nil {
+		query += ` AND date > $2`
+		args = append(args, *after)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dates := DateSet{}
+	for rows.Next() {
+		var date time.Time
+		if err := rows.Scan(&date); err != nil {
+			return nil, err
+		}
+		dates[date.Format("2006-01-02")] = true
+	}
+	return dates, rows.Err()
+}
+
+// UpdateApplying grava a tarefa e leva a alteração às ocorrências em aberto (pendentes, em andamento
+// ou atrasadas) conforme applyTo, tudo na mesma transação. Se a recorrência, os limites da série, o rodízio
+// ou o grupo de um rodízio mudaram, as ocorrências alcançadas são removidas e geradas de novo; caso contrário,
+// são reescritas.
+// Ocorrências concluídas, puladas ou canceladas nunca são alteradas.
+func (t *TaskInstallment) UpdateApplying(applyTo string, occurrenceID *uuid.UUID, by *uuid.UUID) (*ApplyResult, error) {
+	tx, err := begin(by)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous TaskInstallment
+	if err := previous.scan(tx.QueryRow(`SELECT `+taskColumns+` FROM task_installments WHERE id = $1 FOR UPDATE`, t.ID)); err != nil {
+		return nil, err
+	}
+
+	from, err := applyStart(tx, `SELECT task_id, date FROM task_occurrences WHERE id = $1`, t.ID, applyTo, occurrenceID)
+	if err != nil {
+		return nil, err
+	}
+	result := &ApplyResult{ApplyTo: applyTo, From: from}
+
+	regenerate := previous.RecurrenceCron != t.RecurrenceCron || !sameDay(previous.StartDate, t.StartDate) ||
+		!sameDate(previous.EndDate, t.EndDate) || !sameInt(previous.MaxOccurrences, t.MaxOccurrences) ||
+		previous.Rotation != t.Rotation || (t.Rotation != RotationNone && previous.PayerGroupID != t.PayerGroupID)
+	if applyTo == ApplyThis {
+		if regenerate {
+			return nil, ErrApplyRecurrence
+		}
+	} else if err := t.update(tx); err != nil {
+		return nil, err
+	}
+
+	where, args := openScope("task_id = $1 AND state IN ('pending', 'in_progress', 'overdue')", t.ID, applyTo, occurrenceID, from)
+	switch {
+	case applyTo == ApplyTemplate:
+	case regenerate:
+		err = t.regenerateOccurrences(tx, where, args, from, result)
+	default:
+		err = t.rewriteOccurrences(tx, &previous, where, args, applyTo, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if applyTo == ApplyThis {
+		*t = previous
+	}
+	return result, tx.Commit()
+}
+
+// rewriteOccurrences leva grupo, responsável, subtarefas e duração da tarefa às ocorrências alcançadas.
+// O responsável só muda onde ainda era o antigo responsável fixo (trocas e rodízios são preservados),
+// exceto em apply_to=this. As subtarefas mantêm o progresso, a menos que isso concluísse o checklist.
+func (t *TaskInstallment) rewriteOccurrences(tx *sql.Tx, previous *TaskInstallment, where string, args []interface{}, applyTo string, result *ApplyResult) error {
+	rows, err := tx.Query(`SELECT `+taskOccurrenceColumns+` FROM task_occurrences WHERE `+where+` FOR UPDATE`, args...)
+	if err != nil {
+		return err
+	}
+	var occurrences []TaskOccurrence
+	for rows.Next() {
+		var o TaskOccurrence
+		if err := o.scan(rows); err != nil {
+			rows.Close()
+			return err
+		}
+		occurrences = append(occurrences, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if applyTo == ApplyThis && len(occurrences) == 0 {
+		return ErrOccurrenceClosed
+	}
+
+	for _, o := range occurrences {
+		if applyTo == ApplyThis || (t.Rotation == RotationNone && o.UserID == previous.UserID) {
+			o.UserID = t.UserID
+		}
+		subtasks := t.Subtasks.Reset().KeepProgress(o.Subtasks)
+		if subtasks.AllDone() {
+			subtasks = t.Subtasks.Reset()
+		}
+		// Uma nova duração recalcula o prazo a partir do início
+		if !sameInt(previous.DurationMinutes, t.DurationMinutes) {
+			o.DurationMinutes, o.DueAt = t.DurationMinutes, nil
+			o.FillDueAt()
+		}
+		if _, err := tx.Exec(`UPDATE task_occurrences SET user_id = $1, payer_group_id = $2, subtasks = $3, duration_minutes = $4, due_at = $5 WHERE id = $6`,
+			o.UserID, t.PayerGroupID, subtasks, o.DurationMinutes, o.DueAt, o.ID); err != nil {
+			return err
+		}
+		result.Updated++
+	}
+	return nil
+}
+
+// regenerateOccurrences remove as ocorrências alcançadas e gera as da nova recorrência no mesmo intervalo,
+// até a data da última ocorrência removida
+func (t *TaskInstallment) regenerateOccurrences(tx *sql.Tx, where string, args []interface{}, from *time.Time, result *ApplyResult) error {
+	var until time.Time
+	rows, err := tx.Query(`DELETE FROM task_occurrences WHERE `+where+` RETURNING date`, args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var date time.Time
+		if err := rows.Scan(&date); err != nil {
+			rows.Close()
+			return err
+		}
+		if date.After(until) {
+			until = date
+		}
+		result.Removed++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if result.Removed == 0 {
+		return nil
+	}
+	if err := dropOrphanExceptions(tx, "task_id", "task_occurrences", t.ID); err != nil {
+		return err
+	}
+
+	plan, err := t.plan(tx)
+	if err != nil {
+		return err
+	}
+	existing, err := existingDates(tx, "task_occurrences", "task_id", t.ID, nil)
+	if err != nil {
+		return err
+	}
+	rotation, err := t.newRotation(tx)
+	if err != nil {
+		return err
+	}
+
+	for next, skip, ok := plan.Next(until); ok; next, skip, ok = plan.Next(until) {
+		if skip != "" || (from != nil && next.Before(*from)) || existing.Has(next) {
+			continue
+		}
+		userID, available := rotation.Pick(next)
+		if !available {
+			continue
+		}
+		occurrence := TaskOccurrence{
+			TaskID:          t.ID,
+			Date:            next,
+			UserID:          userID,
+			PayerGroupID:    t.PayerGroupID,
+			Subtasks:        t.Subtasks.Reset(),
+			ScheduledAt:     plan.At(),
+			DurationMinutes: t.DurationMinutes,
+		}
+		if err := occurrence.insert(tx); err != nil {
+			return err
+		}
+		existing[next.Format("2006-01-02")] = true
+		rotation.Record(occurrence.UserID, next)
+		result.Generated++
+	}
+	return nil
+}
+
+// UpdateApplying grava a finança e leva a alteração às ocorrências não pagas conforme applyTo, na mesma
+// transação. Se início, intervalo, data final ou limite de ocorrências mudaram, as ocorrências alcançadas são removidas e geradas
+// de novo; caso contrário, recebem o novo valor. Ocorrências pagas e lançamentos de notas importadas nunca são alterados.
+func (fi *FinanceInstallment) UpdateApplying(applyTo string, occurrenceID *uuid.UUID, by *uuid.UUID) (*ApplyResult, error) {
+	tx, err := begin(by)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous FinanceInstallment
+	err = tx.QueryRow(`
+		SELECT start_date, end_date, recurrence_days, max_occurrences
+		FROM finance_installments
+		WHERE id = $1
+		FOR UPDATE`, fi.ID).Scan(&previous.StartDate, &previous.EndDate, &previous.RecurrenceDays, &previous.MaxOccurrences)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := applyStart(tx, `SELECT finance_id, date FROM finance_occurrences WHERE id = $1`, fi.ID, applyTo, occurrenceID)
+	if err != nil {
+		return nil, err
+	}
+	result := &ApplyResult{ApplyTo: applyTo, From: from}
+
+	regenerate := !sameDay(previous.StartDate, fi.StartDate) || previous.RecurrenceDays != fi.RecurrenceDays ||
+		!sameDate(previous.EndDate, fi.EndDate) || !sameInt(previous.MaxOccurrences, fi.MaxOccurrences)
+	if applyTo == ApplyThis {
+		if regenerate {
+			return nil, ErrApplyRecurrence
+		}
+	} else if err := fi.update(tx); err != nil {
+		return nil, err
+	}
+
+	where, args := openScope("finance_id = $1 AND status = false AND "+notInvoiceEntry, fi.ID, applyTo, occurrenceID, from)
+	switch {
+	case applyTo == ApplyTemplate:
+	case regenerate:
+		err = fi.regenerateOccurrences(tx, where, args, from, result)
+	default:
+		var updated sql.Result
+		updated, err = tx.Exec(fmt.Sprintf(`UPDATE finance_occurrences SET amount = $%d WHERE %s`, len(args)+1, where), append(args, fi.Amount)...)
+		if err == nil {
+			var count int64
+			count, err = updated.RowsAffected()
+			result.Updated = int(count)
+			if err == nil && applyTo == ApplyThis && count == 0 {
+				err = ErrOccurrenceClosed
+				if entry, _ := isInvoiceEntry(tx, *occurrenceID); entry {
+					err = ErrInvoiceEntry
+				}
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if applyTo == ApplyThis {
+		if err := fi.scan(tx.QueryRow(`SELECT `+financeColumns+` FROM finance_installments WHERE id = $1`, fi.ID)); err != nil {
+			return nil, err
+		}
+	}
+	return result, tx.Commit()
+}
+
+// regenerateOccurrences remove as ocorrências não pagas alcançadas e gera as da nova recorrência no
+// mesmo intervalo, até a última removida e respeitando a data final. Lançamentos bancários sugeridos
+// para as ocorrências removidas voltam a ficar sem correspondência.
+func (fi *FinanceInstallment) regenerateOccurrences(tx *sql.Tx, where string, args []interface{}, from *time.Time, result *ApplyResult) error {
+	if _, err := releaseBankLines(tx, where, args...); err != nil {
+		return err
+	}
+
+	var until time.Time
+	rows, err := tx.Query(`DELETE FROM finance_occurrences WHERE `+where+` RETURNING date`, args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var date time.Time
+		if err := rows.Scan(&date); err != nil {
+			rows.Close()
+			return err
+		}
+		if date.After(until) {
+			until = date
+		}
+		result.Removed++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if result.Removed == 0 || fi.RecurrenceDays <= 0 {
+		return nil
+	}
+	if err := dropOrphanExceptions(tx, "finance_id", "finance_occurrences", fi.ID); err != nil {
+		return err
+	}
+
+	plan, err := fi.plan(tx)
+	if err != nil {
+		return err
+	}
+	existing, err := existingDates(tx, "finance_occurrences", "finance_id", fi.ID, nil)
+	if err != nil {
+		return err
+	}
+
+	for next, skip, ok := plan.Next(until); ok; next, skip, ok = plan.Next(until) {
+		if skip != "" || (from != nil && next.Before(*from)) || existing.Has(next) {
+			continue
+		}
+		occurrence := FinanceOccurrence{
+			FinanceID: fi.ID,
+			Date:      next,
+			Amount:    fi.Amount,
+		}
+		if err := occurrence.insert(tx); err != nil {
+			return err
+		}
+		result.Generated++
+	}
+	return nil
+}
\ No newline at end of file
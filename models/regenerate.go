@@ -0,0 +1,87 @@
+package models
+
+import "fmt"
+
+// logGeneration registra no job as datas ignoradas e as ocorrências criadas de um modelo
+func (r *jobRun) logGeneration(g *Generation) {
+	for _, skipped := range g.Skipped {
+		date := skipped.Date.Format("2006-01-02")
+		switch skipped.Reason {
+		case SkipException:
+			r.log(JobLogInfo, "Data pulada ou remarcada: "+date)
+		case SkipPaused:
+			r.log(JobLogInfo, "Data pausada: "+date)
+		case SkipAway:
+			r.log(JobLogInfo, "Responsável ausente: "+date)
+		}
+	}
+	switch n := len(g.Created); {
+	case n == 1:
+		r.log(JobLogSuccess, "Ocorrência criada para data: "+g.Created[0].Format("2006-01-02"))
+	case n > 1:
+		r.log(JobLogSuccess, fmt.Sprintf("%d ocorrências criadas, de %s a %s", n,
+			g.Created[0].Format("2006-01-02"), g.Created[n-1].Format("2006-01-02")))
+	}
+	r.created += len(g.Created)
+}
+
+// regenerateTaskOccurrences gera as ocorrências das tarefas ativas até hoje, no fuso da casa, a partir da
+// marca d'água de cada uma
+func regenerateTaskOccurrences(r *jobRun) error {
+	tasks, err := ListTasks(ArchivedExclude)
+	if err != nil {
+		return err
+	}
+	now, err := Today()
+	if err != nil {
+		return err
+	}
+
+	r.total = len(tasks)
+	if err := r.checkpoint(); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		r.log(JobLogInfo, "Processando tarefa: "+task.Title+" (ID: "+task.ID.String()+")")
+		if g, err := task.Generate(now); err != nil {
+			r.fail("Erro ao gerar as ocorrências da tarefa " + task.ID.String() + ": " + err.Error())
+		} else {
+			r.logGeneration(g)
+		}
+		r.processed++
+		if err := r.checkpoint(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// regenerateFinanceOccurrences gera as ocorrências das finanças até hoje, no fuso da casa
+func regenerateFinanceOccurrences(r *jobRun) error {
+	finances, err := ListFinanceInstallments(ListFilter{})
+	if err != nil {
+		return err
+	}
+	now, err := Today()
+	if err != nil {
+		return err
+	}
+
+	r.total = len(finances)
+	if err := r.checkpoint(); err != nil {
+		return err
+	}
+	for _, finance := range finances {
+		r.log(JobLogInfo, "Processando finança: "+finance.Title+" (ID: "+finance.ID.String()+")")
+		if g, err := finance.Generate(now); err != nil {
+			r.fail("Erro ao gerar as ocorrências da finança " + finance.ID.String() + ": " + err.Error())
+		} else {
+			r.logGeneration(g)
+		}
+		r.processed++
+		if err := r.checkpoint(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
\ No newline at end of file
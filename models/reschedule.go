@@ -0,0 +1,351 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+)
+
+// Ações registradas nas exceções da recorrência
+const (
+	ExceptionSkipped     = "skipped"
+	ExceptionSnoozed     = "snoozed"
+	ExceptionRescheduled = "rescheduled"
+)
+
+var (
+	ErrOccurrenceClosed    = errors.New("a ocorrência já foi concluída, pulada ou cancelada e não pode ser remarcada")
+	ErrOccurrenceDateTaken = errors.New("já existe uma ocorrência deste modelo na nova data")
+	ErrExceptionNotSkipped = errors.New("apenas datas puladas podem ser restauradas; para as remarcadas, remarque a ocorrência de volta")
+)
+
+// OccurrenceException registra uma data prevista pela recorrência que foi pulada ou remarcada,
+// para que a geração de ocorrências não a recrie
+type OccurrenceException struct {
+	ID           uuid.UUID  `json:"id"`
+	TaskID       *uuid.UUID `json:"task_id,omitempty"`
+	FinanceID    *uuid.UUID `json:"finance_id,omitempty"`
+	OriginalDate time.Time  `json:"original_date"`
+	Action       string     `json:"action"`
+	NewDate      *time.Time `json:"new_date"`
+	CreatedBy    *uuid.UUID `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+const occurrenceExceptionColumns = `id, task_id, finance_id, original_date, action, new_date, created_by, created_at`
+
+func (e *OccurrenceException) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&e.ID, &e.TaskID, &e.FinanceID, &e.OriginalDate, &e.Action, &e.NewDate, &e.CreatedBy, &e.CreatedAt)
+}
+
+// Get busca uma exceção pelo ID
+func (e *OccurrenceException) Get() error {
+	query := `
+		SELECT ` + occurrenceExceptionColumns + `
+		FROM occurrence_exceptions
+		WHERE id = $1`
+	return e.scan(config.GetDB().QueryRow(query, e.ID))
+}
+
+// Delete remove uma exceção de data pulada, permitindo que a geração volte a criar a ocorrência
+func (e *OccurrenceException) Delete() error {
+	if err := e.Get(); err != nil {
+		return err
+	}
+	if e.Action != ExceptionSkipped {
+		return ErrExceptionNotSkipped
+	}
+	_, err := config.GetDB().Exec(`DELETE FROM occurrence_exceptions WHERE id = $1`, e.ID)
+	return err
+}
+
+// DateSet é um conjunto de datas, sem horário
+type DateSet map[string]bool
+
+// Has indica se a data está no conjunto
+func (d DateSet) Has(date time.Time) bool {
+	return d[date.Format("2006-01-02")]
+}
+
+// TaskExceptionDates carrega as datas puladas ou remarcadas de uma tarefa
+func TaskExceptionDates(taskID uuid.UUID) (DateSet, error) {
+	return exceptionDates(config.GetDB(), "task_id", taskID)
+}
+
+// FinanceExceptionDates carrega as datas puladas ou remarcadas de uma finança
+func FinanceExceptionDates(financeID uuid.UUID) (DateSet, error) {
+	return exceptionDates(config.GetDB(), "finance_id", financeID)
+}
+
+func exceptionDates(db queryer, column string, templateID uuid.UUID) (DateSet, error) {
+	rows, err := db.Query(`SELECT original_date FROM occurrence_exceptions WHERE `+column+` = $1`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dates := DateSet{}
+	for rows.Next() {
+		var date time.Time
+		if err := rows.Scan(&date); err != nil {
+			return nil, err
+		}
+		dates[date.Format("2006-01-02")] = true
+	}
+	return dates, rows.Err()
+}
+
+// ListTaskExceptions lista as exceções da recorrência de uma tarefa
+func ListTaskExceptions(taskID uuid.UUID) ([]OccurrenceException, error) {
+	return listExceptions("task_id", taskID)
+}
+
+// ListFinanceExceptions lista as exceções da recorrência de uma finança
+func ListFinanceExceptions(financeID uuid.UUID) ([]OccurrenceException, error) {
+	return listExceptions("finance_id", financeID)
+}
+
+func listExceptions(column string, templateID uuid.UUID) ([]OccurrenceException, error) {
+	rows, err := config.GetDB().Query(`
+		SELECT `+occurrenceExceptionColumns+`
+		FROM occurrence_exceptions
+		WHERE `+column+` = $1
+		ORDER BY original_date`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exceptions := []OccurrenceException{}
+	for rows.Next() {
+		var e OccurrenceException
+		if err := e.scan(rows); err != nil {
+			return nil, err
+		}
+		exceptions = append(exceptions, e)
+	}
+	return exceptions, rows.Err()
+}
+
+// recordException grava (ou substitui) a exceção da data prevista. Remarcar de volta para a
+// própria data prevista desfaz a exceção.
+func recordException(tx *sql.Tx, column string, templateID uuid.UUID, original time.Time, action string, newDate *time.Time, by *uuid.UUID) error {
+	if newDate != nil && sameDay(*newDate, original) {
+		_, err := tx.Exec(`DELETE FROM occurrence_exceptions WHERE `+column+` = $1 AND original_date = $2`, templateID, original)
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO occurrence_exceptions (`+column+`, original_date, action, new_date, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (`+column+`, original_date) WHERE `+column+` IS NOT NULL
+		DO UPDATE SET action = EXCLUDED.action, new_date = EXCLUDED.new_date, created_by = EXCLUDED.created_by,
+			created_at = CURRENT_TIMESTAMP`, templateID, original, action, newDate, by)
+	return err
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
+
+// shiftDays move o instante alguns dias no fuso indicado, preservando o horário local
+func shiftDays(t *time.Time, days int, loc *time.Location) *time.Time {
+	if t == nil {
+		return nil
+	}
+	shifted := t.In(loc).AddDate(0, 0, days)
+	return &shifted
+}
+
+// scheduledDate é a data prevista pela recorrência: a original, se a ocorrência foi remarcada
+func scheduledDate(date time.Time, original *time.Time) time.Time {
+	if original != nil {
+		return *original
+	}
+	return date
+}
+
+// moveError traduz a violação da unicidade (modelo, data) ao mover uma ocorrência
+func moveError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+		return ErrOccurrenceDateTaken
+	}
+	return err
+}
+
+// Skip pula a ocorrência: ela permanece com o estado skipped e a data prevista não é gerada de novo.
+// changedBy é quem pulou (gravado na ocorrência e na exceção); by é o autor no log de auditoria.
+func (to *TaskOccurrence) Skip(changedBy, by *uuid.UUID) error {
+	tx, err := begin(by)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := to.lock(tx); err != nil {
+		return err
+	}
+	if err := to.SetState(TaskSkipped, changedBy); err != nil {
+		return err
+	}
+	if err := to.saveState(tx); err != nil {
+		return err
+	}
+	if err := recordException(tx, "task_id", to.TaskID, scheduledDate(to.Date, to.OriginalDate), ExceptionSkipped, nil, changedBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Snooze adia a ocorrência em alguns dias
+func (to *TaskOccurrence) Snooze(days int, changedBy, by *uuid.UUID) error {
+	return to.move(ExceptionSnoozed, changedBy, by, func(date time.Time) time.Time { return date.AddDate(0, 0, days) })
+}
+
+// Reschedule remarca a ocorrência para outra data
+func (to *TaskOccurrence) Reschedule(date time.Time, changedBy, by *uuid.UUID) error {
+	return to.move(ExceptionRescheduled, changedBy, by, func(time.Time) time.Time { return date })
+}
+
+// move troca a data de uma ocorrência em aberto, guardando a data prevista na própria ocorrência
+// e na exceção. Uma ocorrência atrasada remarcada para hoje ou depois volta a ficar pendente.
+func (to *TaskOccurrence) move(action string, changedBy, by *uuid.UUID, next func(time.Time) time.Time) error {
+	tx, err := begin(by)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := to.lock(tx); err != nil {
+		return err
+	}
+	switch to.State {
+	case TaskDone, TaskSkipped, TaskCancelled:
+		return ErrOccurrenceClosed
+	}
+
+	original := scheduledDate(to.Date, to.OriginalDate)
+	date := next(to.Date)
+	var originalDate *time.Time
+	if !sameDay(date, original) {
+		originalDate = &original
+	}
+
+	// Início e prazo acompanham a nova data, mantendo o horário no fuso da casa
+	loc, err := location(tx)
+	if err != nil {
+		return err
+	}
+	days := int(math.Round(dateOf(date).Sub(dateOf(to.Date)).Hours() / 24))
+	scheduledAt, dueAt := shiftDays(to.ScheduledAt, days, loc), shiftDays(to.DueAt, days, loc)
+
+	query := `
+		UPDATE task_occurrences
+		SET date = $1, original_date = $2,
+			state = CASE WHEN state = 'overdue' AND $1 >= household_today() THEN 'pending' ELSE state END,
+			state_changed_by = CASE WHEN state = 'overdue' AND $1 >= household_today() THEN $3 ELSE state_changed_by END,
+			scheduled_at = $4, due_at = $5
+		WHERE id = $6
+		RETURNING ` + taskOccurrenceColumns
+	if err := to.scan(tx.QueryRow(query, date, originalDate, changedBy, scheduledAt, dueAt, to.ID)); err != nil {
+		return moveError(err)
+	}
+	if err := recordException(tx, "task_id", to.TaskID, original, action, &date, changedBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (fo *FinanceOccurrence) lock(tx *sql.Tx) error {
+	query := `
+		SELECT ` + financeOccurrenceColumns + `
+		FROM finance_occurrences
+		WHERE id = $1
+		FOR UPDATE`
+	return fo.scan(tx.QueryRow(query, fo.ID))
+}
+
+// Skip pula uma ocorrência financeira em aberto: ela é removida e a data prevista não é gerada de novo.
+// changedBy é quem pulou (gravado na exceção); by é o autor no log de auditoria.
+func (fo *FinanceOccurrence) Skip(changedBy, by *uuid.UUID) error {
+	tx, err := begin(by)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fo.lock(tx); err != nil {
+		return err
+	}
+	if fo.Status {
+		return ErrOccurrenceClosed
+	}
+	entry, err := isInvoiceEntry(tx, fo.ID)
+	if err != nil {
+		return err
+	}
+	if entry {
+		return ErrInvoiceEntry
+	}
+	if err := recordException(tx, "finance_id", fo.FinanceID, scheduledDate(fo.Date, fo.OriginalDate), ExceptionSkipped, nil, changedBy); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM finance_occurrences WHERE id = $1`, fo.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Snooze adia o vencimento da ocorrência financeira em alguns dias
+func (fo *FinanceOccurrence) Snooze(days int, changedBy, by *uuid.UUID) error {
+	return fo.move(ExceptionSnoozed, changedBy, by, func(date time.Time) time.Time { return date.AddDate(0, 0, days) })
+}
+
+// Reschedule remarca o vencimento da ocorrência financeira
+func (fo *FinanceOccurrence) Reschedule(date time.Time, changedBy, by *uuid.UUID) error {
+	return fo.move(ExceptionRescheduled, changedBy, by, func(time.Time) time.Time { return date })
+}
+
+func (fo *FinanceOccurrence) move(action string, changedBy, by *uuid.UUID, next func(time.Time) time.Time) error {
+	tx, err := begin(by)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fo.lock(tx); err != nil {
+		return err
+	}
+	if err := fo.moveTo(tx, action, next(fo.Date), changedBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// moveTo troca a data da ocorrência travada por lock, guardando a data prevista na própria ocorrência
+// e na exceção, para que a geração não a recrie na data antiga
+func (fo *FinanceOccurrence) moveTo(tx *sql.Tx, action string, date time.Time, by *uuid.UUID) error {
+	if fo.Status {
+		return ErrOccurrenceClosed
+	}
+
+	original := scheduledDate(fo.Date, fo.OriginalDate)
+	var originalDate *time.Time
+	if !sameDay(date, original) {
+		originalDate = &original
+	}
+
+	query := `
+		UPDATE finance_occurrences
+		SET date = $1, original_date = $2
+		WHERE id = $3
+		RETURNING ` + financeOccurrenceColumns
+	if err := fo.scan(tx.QueryRow(query, date, originalDate, fo.ID)); err != nil {
+		return moveError(err)
+	}
+	return recordException(tx, "finance_id", fo.FinanceID, original, action, &date, by)
+}
\ No newline at end of file
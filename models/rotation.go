@@ -0,0 +1,241 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+)
+
+// Estratégias de rodízio das tarefas entre os membros do grupo de pagadores
+const (
+	RotationNone              = "none"                // sempre o responsável fixo da tarefa
+	RotationRoundRobin        = "round_robin"         // um membro de cada vez, em ordem
+	RotationWeighted          = "weighted"            // proporcional ao percentual de cada membro
+	RotationLeastRecentlyDone = "least_recently_done" // quem concluiu a tarefa há mais tempo
+	RotationFairness          = "fairness"            // quem acumulou menos esforço no grupo
+)
+
+var ErrRotationWithoutMembers = errors.New("rodízio exige um grupo de pagadores com membros")
+
+// ValidRotation indica se a estratégia de rodízio é conhecida
+func ValidRotation(rotation string) bool {
+	switch rotation {
+	case RotationNone, RotationRoundRobin, RotationWeighted, RotationLeastRecentlyDone, RotationFairness:
+		return true
+	}
+	return false
+}
+
+// TaskRotation escolhe o responsável de cada nova ocorrência de uma tarefa
+type TaskRotation struct {
+	strategy string
+	fallback uuid.UUID
+	members  []PayerGroupMember
+	last     *uuid.UUID              // round_robin: responsável da ocorrência mais recente
+	points   float64                 // fairness: esforço de cada nova ocorrência
+	load     map[uuid.UUID]float64   // weighted e fairness: ocorrências atribuídas ou esforço acumulado
+	lastDone map[uuid.UUID]time.Time // least_recently_done: última conclusão de cada membro
+	away     map[uuid.UUID][]Period  // ausências de cada morador, que não recebe tarefas no período
+}
+
+// NewRotation carrega o histórico necessário para a estratégia de rodízio da tarefa
+func (t *TaskInstallment) NewRotation() (*TaskRotation, error) {
+	return t.newRotation(config.GetDB())
+}
+
+func (t *TaskInstallment) newRotation(db queryer) (*TaskRotation, error) {
+	r := &TaskRotation{
+		strategy: t.Rotation,
+		fallback: t.UserID,
+		points:   float64(t.EffortPoints),
+		load:     map[uuid.UUID]float64{},
+		lastDone: map[uuid.UUID]time.Time{},
+	}
+	away, err := awayByUser(db)
+	if err != nil {
+		return nil, err
+	}
+	r.away = away
+	if t.Rotation == "" || t.Rotation == RotationNone {
+		return r, nil
+	}
+
+	members, err := ListPayerGroupMembers(t.PayerGroupID)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, ErrRotationWithoutMembers
+	}
+	// Ordem estável, independente dos percentuais, para o rodízio e os desempates
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].UserID.String() < members[j].UserID.String()
+	})
+	r.members = members
+
+	switch t.Rotation {
+	case RotationRoundRobin:
+		var last uuid.UUID
+		err := db.QueryRow(`
+			SELECT user_id
+			FROM task_occurrences
+			WHERE task_id = $1 AND user_id IS NOT NULL
+			ORDER BY date DESC
+			LIMIT 1`, t.ID).Scan(&last)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if err == nil {
+			r.last = &last
+		}
+	case RotationWeighted:
+		err = r.loadCounts(db, `
+			SELECT user_id, COUNT(*)
+			FROM task_occurrences
+			WHERE task_id = $1 AND user_id IS NOT NULL
+			GROUP BY user_id`, t.ID)
+	case RotationFairness:
+		// Esforço já creditado a quem concluiu, somado ao das ocorrências pendentes já atribuídas
+		err = r.loadCounts(db, `
+			SELECT member, SUM(points)
+			FROM (
+				SELECT o.completed_by AS member, o.effort_points AS points
+				FROM task_occurrences o
+				INNER JOIN task_installments t ON o.task_id = t.id
+				WHERE t.payer_group_id = $1 AND o.status = true AND o.completed_by IS NOT NULL
+				UNION ALL
+				SELECT o.user_id, t.effort_points
+				FROM task_occurrences o
+				INNER JOIN task_installments t ON o.task_id = t.id
+				WHERE t.payer_group_id = $1 AND o.status = false AND o.user_id IS NOT NULL
+			) effort
+			GROUP BY member`, t.PayerGroupID)
+	case RotationLeastRecentlyDone:
+		var rows *sql.Rows
+		rows, err = db.Query(`
+			SELECT completed_by, MAX(date)
+			FROM task_occurrences
+			WHERE task_id = $1 AND status = true AND completed_by IS NOT NULL
+			GROUP BY completed_by`, t.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var userID uuid.UUID
+			var date time.Time
+			if err := rows.Scan(&userID, &date); err != nil {
+				return nil, err
+			}
+			r.lastDone[userID] = date
+		}
+		err = rows.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *TaskRotation) loadCounts(db queryer, query string, arg interface{}) error {
+	rows, err := db.Query(query, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID uuid.UUID
+		var count float64
+		if err := rows.Scan(&userID, &count); err != nil {
+			return err
+		}
+		r.load[userID] = count
+	}
+	return rows.Err()
+}
+
+// isAway indica se o morador está ausente na data
+func (r *TaskRotation) isAway(userID uuid.UUID, date time.Time) bool {
+	for _, p := range r.away[userID] {
+		if p.Contains(date) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pick retorna o responsável da ocorrência de date, sem alterar o estado do rodízio. Moradores
+// ausentes na data são pulados; ok é false se ninguém puder assumir (a ocorrência não é gerada).
+func (r *TaskRotation) Pick(date time.Time) (userID uuid.UUID, ok bool) {
+	if len(r.members) == 0 {
+		return r.fallback, !r.isAway(r.fallback, date)
+	}
+
+	var present []PayerGroupMember
+	for _, m := range r.members {
+		if !r.isAway(m.UserID, date) {
+			present = append(present, m)
+		}
+	}
+	if len(present) == 0 {
+		return uuid.Nil, false
+	}
+
+	best := present[0]
+	switch r.strategy {
+	case RotationRoundRobin:
+		if r.last == nil {
+			return best.UserID, true
+		}
+		// O próximo membro depois do último responsável que não esteja ausente
+		for i, m := range r.members {
+			if m.UserID != *r.last {
+				continue
+			}
+			for step := 1; step <= len(r.members); step++ {
+				next := r.members[(i+step)%len(r.members)]
+				if !r.isAway(next.UserID, date) {
+					return next.UserID, true
+				}
+			}
+		}
+	case RotationWeighted, RotationFairness:
+		// Quem está mais abaixo da sua parte (carga / percentual) assume a próxima;
+		// membros sem percentual só entram se nenhum outro tiver
+		share := func(m PayerGroupMember) float64 {
+			if m.Percentage <= 0 {
+				return math.Inf(1)
+			}
+			return r.load[m.UserID] / m.Percentage
+		}
+		for _, m := range present[1:] {
+			if share(m) < share(best) {
+				best = m
+			}
+		}
+	case RotationLeastRecentlyDone:
+		for _, m := range present[1:] {
+			if r.lastDone[m.UserID].Before(r.lastDone[best.UserID]) {
+				best = m
+			}
+		}
+	}
+	return best.UserID, true
+}
+
+// Record registra que a ocorrência de date foi atribuída a userID
+func (r *TaskRotation) Record(userID uuid.UUID, date time.Time) {
+	r.last = &userID
+	if r.strategy == RotationFairness {
+		r.load[userID] += r.points
+	} else {
+		r.load[userID]++
+	}
+	r.lastDone[userID] = date
+}
\ No newline at end of file
@@ -0,0 +1,396 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+)
+
+// Motivos para uma data prevista pela recorrência não virar ocorrência
+const (
+	SkipException = "exception" // pulada ou remarcada
+	SkipPaused    = "paused"    // dentro de uma pausa do modelo
+)
+
+// Period é um intervalo de datas, inclusive nas duas pontas
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Contains indica se a data (sem horário) está no período
+func (p Period) Contains(date time.Time) bool {
+	day := date.Format("2006-01-02")
+	return day >= p.Start.Format("2006-01-02") && day <= p.End.Format("2006-01-02")
+}
+
+// OccurrencePlan percorre as datas previstas pela recorrência de uma tarefa ou finança, desde a
+// data inicial, respeitando a data final e o número máximo de ocorrências do modelo, e diz quais
+// delas não devem virar ocorrência. Todos os caminhos de geração usam o mesmo plano.
+type OccurrencePlan struct {
+	next       func(time.Time) time.Time
+	cursor     time.Time // instante da recorrência; a data é o dia dele no fuso do cursor
+	timed      bool      // recorrência com horário (tarefas): At informa o início de cada ocorrência
+	at         time.Time
+	end        *time.Time
+	max        int
+	count      int
+	exceptions DateSet
+	pauses     []Period
+}
+
+// Next avança para a próxima data prevista até until (inclusive), como as datas lidas do banco
+// (meia-noite UTC; veja Today). skip traz o motivo para não gerá-la, ou "" para gerar; ok é false
+// quando a série acabou ou passou de until. As datas puladas e pausadas também contam para o número
+// máximo de ocorrências.
+func (p *OccurrencePlan) Next(until time.Time) (date time.Time, skip string, ok bool) {
+	p.at = p.cursor
+	if p.count > 0 {
+		// Só há uma ocorrência por dia: disparos repetidos no mesmo dia ficam com o primeiro
+		previous := dateOf(p.cursor)
+		for p.cursor = p.next(p.cursor); !p.cursor.IsZero() && dateOf(p.cursor).Equal(previous); p.cursor = p.next(p.cursor) {
+		}
+		p.at = p.cursor
+	} else if p.timed {
+		// A data inicial vale mesmo fora da recorrência, no horário do primeiro disparo a partir dela
+		if first := p.next(p.cursor.Add(-time.Nanosecond)).In(p.cursor.Location()); !first.IsZero() {
+			p.at = time.Date(p.cursor.Year(), p.cursor.Month(), p.cursor.Day(), first.Hour(), first.Minute(), 0, 0, p.cursor.Location())
+			if dateOf(first).Equal(dateOf(p.cursor)) {
+				p.cursor = first
+			}
+		}
+	}
+	p.count++
+	date = dateOf(p.cursor)
+	// Expressões que nunca disparam (ex.: 30 de fevereiro) devolvem o instante zero
+	if p.cursor.IsZero() || (p.max > 0 && p.count > p.max) || afterDay(date, until) || (p.end != nil && afterDay(date, *p.end)) {
+		return date, "", false
+	}
+
+	switch {
+	case p.exceptions.Has(date):
+		skip = SkipException
+	case p.paused(date):
+		skip = SkipPaused
+	}
+	return date, skip, true
+}
+
+// At é o início previsto da data devolvida pelo último Next, ou nil nas recorrências sem horário
+func (p *OccurrencePlan) At() *time.Time {
+	if !p.timed {
+		return nil
+	}
+	at := p.at
+	return &at
+}
+
+func (p *OccurrencePlan) paused(date time.Time) bool {
+	for _, pause := range p.pauses {
+		if pause.Contains(date) {
+			return true
+		}
+	}
+	return false
+}
+
+// afterDay indica se a data a é de um dia posterior a b, ignorando o horário
+func afterDay(a, b time.Time) bool {
+	return a.Format("2006-01-02") > b.Format("2006-01-02")
+}
+
+// sameDate compara datas opcionais pelo dia
+func sameDate(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return sameDay(*a, *b)
+}
+
+// sameInt compara inteiros opcionais
+func sameInt(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Plan monta o plano de geração da tarefa. A recorrência parte da meia-noite da data inicial
+// no fuso da casa, e cada ocorrência cai no dia, nesse fuso, em que a expressão CRON dispara.
+func (t *TaskInstallment) Plan() (*OccurrencePlan, error) {
+	return t.plan(config.GetDB())
+}
+
+func (t *TaskInstallment) plan(db queryer) (*OccurrencePlan, error) {
+	schedule, err := ParseRecurrence(t.RecurrenceCron)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := location(db)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Date(t.StartDate.Year(), t.StartDate.Month(), t.StartDate.Day(), 0, 0, 0, 0, loc)
+	p := &OccurrencePlan{next: schedule.Next, cursor: start, timed: true, end: t.EndDate}
+	if t.MaxOccurrences != nil {
+		p.max = *t.MaxOccurrences
+	}
+	return p, p.load(db, "task_id", t.ID)
+}
+
+// Plan monta o plano de geração da finança
+func (fi *FinanceInstallment) Plan() (*OccurrencePlan, error) {
+	return fi.plan(config.GetDB())
+}
+
+func (fi *FinanceInstallment) plan(db queryer) (*OccurrencePlan, error) {
+	if fi.RecurrenceDays <= 0 {
+		return nil, fmt.Errorf("dias de recorrência inválidos: %d", fi.RecurrenceDays)
+	}
+	days := fi.RecurrenceDays
+	p := &OccurrencePlan{next: func(date time.Time) time.Time { return date.AddDate(0, 0, days) }, cursor: fi.StartDate, end: fi.EndDate}
+	if fi.MaxOccurrences != nil {
+		p.max = *fi.MaxOccurrences
+	}
+	return p, p.load(db, "finance_id", fi.ID)
+}
+
+// load carrega as exceções e as pausas do modelo
+func (p *OccurrencePlan) load(db queryer, column string, templateID uuid.UUID) error {
+	var err error
+	if p.exceptions, err = exceptionDates(db, column, templateID); err != nil {
+		return err
+	}
+	pauses, err := listPauses(db, column, templateID)
+	if err != nil {
+		return err
+	}
+	for _, pause := range pauses {
+		p.pauses = append(p.pauses, Period{Start: pause.StartDate, End: pause.EndDate})
+	}
+	return nil
+}
+
+// TemplatePause suspende a geração de ocorrências de uma tarefa ou finança entre duas datas (ex.: férias)
+type TemplatePause struct {
+	ID        uuid.UUID  `json:"id"`
+	TaskID    *uuid.UUID `json:"task_id,omitempty"`
+	FinanceID *uuid.UUID `json:"finance_id,omitempty"`
+	StartDate time.Time  `json:"start_date"`
+	EndDate   time.Time  `json:"end_date"`
+	Reason    *string    `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+const templatePauseColumns = `id, task_id, finance_id, start_date, end_date, reason, created_at`
+
+func (tp *TemplatePause) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&tp.ID, &tp.TaskID, &tp.FinanceID, &tp.StartDate, &tp.EndDate, &tp.Reason, &tp.CreatedAt)
+}
+
+// Create grava a pausa e remove as ocorrências em aberto que caem nela (tarefas pendentes, em andamento
+// ou atrasadas, finanças não pagas), retornando quantas foram removidas. Ao remover a pausa, as datas
+// voltam a ser geradas.
+func (tp *TemplatePause) Create(by *uuid.UUID) (int, error) {
+	tx, err := begin(by)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO template_pauses (task_id, finance_id, start_date, end_date, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + templatePauseColumns
+	if err := tp.scan(tx.QueryRow(query, tp.TaskID, tp.FinanceID, tp.StartDate, tp.EndDate, tp.Reason)); err != nil {
+		return 0, err
+	}
+
+	var res sql.Result
+	if tp.TaskID != nil {
+		res, err = tx.Exec(`
+			DELETE FROM task_occurrences
+			WHERE task_id = $1 AND state IN ('pending', 'in_progress', 'overdue') AND date BETWEEN $2 AND $3`,
+			*tp.TaskID, tp.StartDate, tp.EndDate)
+	} else {
+		const where = `finance_id = $1 AND status = false AND date BETWEEN $2 AND $3 AND ` + notInvoiceEntry
+		if _, err := releaseBankLines(tx, where, *tp.FinanceID, tp.StartDate, tp.EndDate); err != nil {
+			return 0, err
+		}
+		res, err = tx.Exec(`DELETE FROM finance_occurrences WHERE `+where, *tp.FinanceID, tp.StartDate, tp.EndDate)
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	if err := affected(res, &removed); err != nil {
+		return 0, err
+	}
+	return removed, tx.Commit()
+}
+
+// Delete remove a pausa; as datas dela voltam a ser geradas na próxima atualização
+func (tp *TemplatePause) Delete() error {
+	res, err := config.GetDB().Exec(`DELETE FROM template_pauses WHERE id = $1`, tp.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// ListTaskPauses lista as pausas de uma tarefa
+func ListTaskPauses(taskID uuid.UUID) ([]TemplatePause, error) {
+	return listPauses(config.GetDB(), "task_id", taskID)
+}
+
+// ListFinancePauses lista as pausas de uma finança
+func ListFinancePauses(financeID uuid.UUID) ([]TemplatePause, error) {
+	return listPauses(config.GetDB(), "finance_id", financeID)
+}
+
+func listPauses(db queryer, column string, templateID uuid.UUID) ([]TemplatePause, error) {
+	rows, err := db.Query(`
+		SELECT `+templatePauseColumns+`
+		FROM template_pauses
+		WHERE `+column+` = $1
+		ORDER BY start_date`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pauses := []TemplatePause{}
+	for rows.Next() {
+		var tp TemplatePause
+		if err := tp.scan(rows); err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, tp)
+	}
+	return pauses, rows.Err()
+}
+
+// AwayPeriod é uma ausência de um morador (viagem, férias), que no período não recebe tarefas.
+// Nos rodízios, a ocorrência vai para outro membro; nas tarefas de responsável fixo, ela não é gerada.
+type AwayPeriod struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	Reason    *string   `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const awayPeriodColumns = `id, user_id, start_date, end_date, reason, created_at`
+
+func (a *AwayPeriod) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&a.ID, &a.UserID, &a.StartDate, &a.EndDate, &a.Reason, &a.CreatedAt)
+}
+
+// Create grava a ausência e remove as ocorrências de tarefa em aberto (pendentes, em andamento ou atrasadas)
+// do morador no período, retornando quantas foram removidas; a próxima geração as recria com outro responsável
+func (a *AwayPeriod) Create(by *uuid.UUID) (int, error) {
+	tx, err := begin(by)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO away_periods (user_id, start_date, end_date, reason)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + awayPeriodColumns
+	if err := a.scan(tx.QueryRow(query, a.UserID, a.StartDate, a.EndDate, a.Reason)); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(`
+		DELETE FROM task_occurrences
+		WHERE user_id = $1 AND state IN ('pending', 'in_progress', 'overdue') AND date BETWEEN $2 AND $3`, a.UserID, a.StartDate, a.EndDate)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	if err := affected(res, &removed); err != nil {
+		return 0, err
+	}
+	return removed, tx.Commit()
+}
+
+// Delete remove a ausência
+func (a *AwayPeriod) Delete() error {
+	res, err := config.GetDB().Exec(`DELETE FROM away_periods WHERE id = $1`, a.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// ListAwayPeriods lista as ausências da casa, opcionalmente de um morador e no período do filtro
+func ListAwayPeriods(filter ListFilter) ([]AwayPeriod, error) {
+	var where whereClause
+	if filter.UserID != nil {
+		where.add("user_id = ?", *filter.UserID)
+	}
+	if filter.From != nil {
+		where.add("end_date >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		where.add("start_date <= ?", *filter.To)
+	}
+
+	rows, err := config.GetDB().Query(`
+		SELECT `+awayPeriodColumns+`
+		FROM away_periods
+		`+where.String()+`
+		ORDER BY start_date, user_id`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	periods := []AwayPeriod{}
+	for rows.Next() {
+		var a AwayPeriod
+		if err := a.scan(rows); err != nil {
+			return nil, err
+		}
+		periods = append(periods, a)
+	}
+	return periods, rows.Err()
+}
+
+// awayByUser carrega as ausências de todos os moradores, agrupadas por morador
+func awayByUser(db queryer) (map[uuid.UUID][]Period, error) {
+	rows, err := db.Query(`SELECT user_id, start_date, end_date FROM away_periods`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	away := map[uuid.UUID][]Period{}
+	for rows.Next() {
+		var userID uuid.UUID
+		var p Period
+		if err := rows.Scan(&userID, &p.Start, &p.End); err != nil {
+			return nil, err
+		}
+		away[userID] = append(away[userID], p)
+	}
+	return away, rows.Err()
+}
\ No newline at end of file
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pobruno/casa360/config"
+	"github.com/robfig/cron/v3"
+)
+
+// DefaultTimezone é o fuso usado enquanto a casa não define outro
+const DefaultTimezone = "America/Sao_Paulo"
+
+var ErrInvalidTimezone = errors.New("fuso horário desconhecido; use um nome IANA, ex.: America/Sao_Paulo")
+
+var settingsUpdated = make(chan struct{}, 1)
+
+// SettingsUpdated recebe um aviso (sem conteúdo) quando as configurações são alteradas por este processo;
+// avisos seguidos se acumulam em um só
+func SettingsUpdated() <-chan struct{} {
+	return settingsUpdated
+}
+
+// HouseholdSettings são as configurações da casa. O fuso define em que dia caem as ocorrências geradas
+// pelas recorrências, o que é "hoje" para atrasos e arquivamentos e o horário das rotinas agendadas.
+type HouseholdSettings struct {
+	Timezone  string    `json:"timezone"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetHouseholdSettings lê as configurações da casa
+func GetHouseholdSettings() (*HouseholdSettings, error) {
+	s := &HouseholdSettings{}
+	err := config.GetDB().QueryRow(`SELECT timezone, updated_at FROM household_settings`).Scan(&s.Timezone, &s.UpdatedAt)
+	return s, err
+}
+
+// Update grava as configurações. O fuso precisa ser conhecido tanto pelo Go quanto pelo banco,
+// que o usa em household_today().
+func (s *HouseholdSettings) Update() error {
+	if _, err := time.LoadLocation(s.Timezone); err != nil || s.Timezone == "" || s.Timezone == "Local" {
+		return ErrInvalidTimezone
+	}
+
+	tx, err := config.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var known bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_timezone_names WHERE name = $1)`, s.Timezone).Scan(&known); err != nil {
+		return err
+	}
+	if !known {
+		return ErrInvalidTimezone
+	}
+
+	err = tx.QueryRow(`
+		UPDATE household_settings
+		SET timezone = $1, updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at`, s.Timezone).Scan(&s.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	select {
+	case settingsUpdated <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Location retorna o fuso da casa
+func Location() (*time.Location, error) {
+	return location(config.GetDB())
+}
+
+func location(db queryer) (*time.Location, error) {
+	var name string
+	if err := db.QueryRow(`SELECT timezone FROM household_settings`).Scan(&name); err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("fuso da casa inválido (%s): %v", name, err)
+	}
+	return loc, nil
+}
+
+// Today é a data atual no fuso da casa, no mesmo formato das colunas DATE lidas do banco
+// (meia-noite UTC), para que comparações e formatações usem o dia certo
+func Today() (time.Time, error) {
+	loc, err := Location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return dateOf(time.Now().In(loc)), nil
+}
+
+// dateOf reduz o instante à data do seu próprio fuso, à meia-noite UTC
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// ParseRecurrence interpreta a expressão CRON de uma tarefa. Sem prefixo CRON_TZ= (ou TZ=), os
+// horários são avaliados no fuso da casa; com ele, no fuso indicado. Em ambos os casos a data da
+// ocorrência é o dia, no fuso da casa, em que a recorrência dispara.
+func ParseRecurrence(expr string) (cron.Schedule, error) {
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao parsear expressão CRON: %v", err)
+	}
+	return schedule, nil
+}
\ No newline at end of file
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tamanho máximo do título de uma subtarefa
+const subtaskTitleMaxLength = 200
+
+var ErrSubtaskNotFound = errors.New("subtarefa não encontrada")
+
+// Subtask é um item de checklist de uma tarefa. Nas tarefas (modelos) só título, ordem e
+// responsável importam; nas ocorrências cada subtarefa registra quando e por quem foi concluída.
+type Subtask struct {
+	ID       uuid.UUID  `json:"id"`
+	Title    string     `json:"title"`
+	Order    int        `json:"order"`
+	Assignee *uuid.UUID `json:"assignee,omitempty"`
+	Done     bool       `json:"done"`
+	DoneAt   *time.Time `json:"done_at,omitempty"`
+	DoneBy   *uuid.UUID `json:"done_by,omitempty"`
+}
+
+// Subtasks é gravada como JSONB nas colunas subtasks
+type Subtasks []Subtask
+
+// UnmarshalJSON aceita também o formato antigo, em que cada subtarefa era apenas um texto
+func (st *Subtask) UnmarshalJSON(data []byte) error {
+	var title string
+	if err := json.Unmarshal(data, &title); err == nil {
+		*st = Subtask{Title: title}
+		return nil
+	}
+
+	type subtask Subtask
+	var s subtask
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*st = Subtask(s)
+	return nil
+}
+
+// Value implementa driver.Valuer; uma lista vazia é gravada como NULL
+func (s Subtasks) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implementa sql.Scanner
+func (s *Subtasks) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("tipo incompatível para subtarefas: %T", src)
+	}
+	if string(data) == "null" {
+		*s = nil
+		return nil
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return err
+	}
+
+	// Subtarefas gravadas no formato antigo não têm ID nem ordem; o ID é derivado da posição e do
+	// título para continuar o mesmo a cada leitura
+	for i := range *s {
+		st := &(*s)[i]
+		if st.ID == uuid.Nil {
+			st.ID = uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprintf("subtask:%d:%s", i, st.Title)))
+		}
+		if st.Order == 0 {
+			st.Order = i + 1
+		}
+	}
+	return nil
+}
+
+// Normalize valida as subtarefas, gera IDs para as novas e renumera a ordem a partir de 1.
+// Em modelos de tarefa (template = true) o estado de conclusão é descartado.
+func (s Subtasks) Normalize(template bool) error {
+	seen := map[uuid.UUID]bool{}
+	for i := range s {
+		st := &s[i]
+		st.Title = strings.TrimSpace(st.Title)
+		if st.Title == "" {
+			return fmt.Errorf("subtarefa %d sem título", i+1)
+		}
+		if len(st.Title) > subtaskTitleMaxLength {
+			return fmt.Errorf("título da subtarefa %d excede %d caracteres", i+1, subtaskTitleMaxLength)
+		}
+		if st.Order < 0 {
+			return fmt.Errorf("ordem da subtarefa %d não pode ser negativa", i+1)
+		}
+		if st.ID == uuid.Nil {
+			st.ID = uuid.New()
+		}
+		if seen[st.ID] {
+			return fmt.Errorf("subtarefa %s repetida", st.ID)
+		}
+		seen[st.ID] = true
+
+		switch {
+		case template || !st.Done:
+			st.Done, st.DoneAt, st.DoneBy = false, nil, nil
+		case st.DoneAt == nil:
+			now := time.Now()
+			st.DoneAt = &now
+		}
+	}
+
+	// Subtarefas sem ordem (0) mantêm a posição em que foram enviadas, após as ordenadas
+	sort.SliceStable(s, func(i, j int) bool {
+		if s[i].Order == 0 || s[j].Order == 0 {
+			return s[i].Order != 0 && s[j].Order == 0
+		}
+		return s[i].Order < s[j].Order
+	})
+	for i := range s {
+		s[i].Order = i + 1
+	}
+	return nil
+}
+
+// Reset devolve uma cópia com todas as subtarefas pendentes, usada ao gerar ocorrências
+func (s Subtasks) Reset() Subtasks {
+	if s == nil {
+		return nil
+	}
+	reset := make(Subtasks, len(s))
+	for i, st := range s {
+		st.Done, st.DoneAt, st.DoneBy = false, nil, nil
+		reset[i] = st
+	}
+	return reset
+}
+
+// KeepProgress copia, das subtarefas de uma ocorrência, a conclusão das que continuam na lista (mesmo ID)
+func (s Subtasks) KeepProgress(previous Subtasks) Subtasks {
+	done := map[uuid.UUID]Subtask{}
+	for _, st := range previous {
+		if st.Done {
+			done[st.ID] = st
+		}
+	}
+	for i := range s {
+		if st, ok := done[s[i].ID]; ok {
+			s[i].Done, s[i].DoneAt, s[i].DoneBy = true, st.DoneAt, st.DoneBy
+		}
+	}
+	return s
+}
+
+// AllDone indica se existe ao menos uma subtarefa e todas estão concluídas
+func (s Subtasks) AllDone() bool {
+	for _, st := range s {
+		if !st.Done {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// Complete conclui as subtarefas pendentes em nome de by
+func (s Subtasks) Complete(by *uuid.UUID) {
+	now := time.Now()
+	for i := range s {
+		if !s[i].Done {
+			s[i].Done, s[i].DoneAt, s[i].DoneBy = true, &now, by
+		}
+	}
+}
+
+// Set marca ou desmarca uma subtarefa; done nil inverte o estado atual
+func (s Subtasks) Set(id uuid.UUID, done *bool, by *uuid.UUID) (*Subtask, error) {
+	for i := range s {
+		if s[i].ID != id {
+			continue
+		}
+		value := !s[i].Done
+		if done != nil {
+			value = *done
+		}
+		if value && !s[i].Done {
+			now := time.Now()
+			s[i].Done, s[i].DoneAt, s[i].DoneBy = true, &now, by
+		} else if !value {
+			s[i].Done, s[i].DoneAt, s[i].DoneBy = false, nil, nil
+		}
+		return &s[i], nil
+	}
+	return nil, ErrSubtaskNotFound
+}
+
+// Assignees retorna os responsáveis distintos citados nas subtarefas
+func (s Subtasks) Assignees() []uuid.UUID {
+	var ids []uuid.UUID
+	seen := map[uuid.UUID]bool{}
+	for _, st := range s {
+		for _, id := range []*uuid.UUID{st.Assignee, st.DoneBy} {
+			if id != nil && !seen[*id] {
+				seen[*id] = true
+				ids = append(ids, *id)
+			}
+		}
+	}
+	return ids
+}
\ No newline at end of file
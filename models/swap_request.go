@@ -0,0 +1,195 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+)
+
+// Situações de um pedido de troca
+const (
+	SwapPending   = "pending"
+	SwapAccepted  = "accepted"
+	SwapRejected  = "rejected"
+	SwapCancelled = "cancelled"
+)
+
+var (
+	ErrSwapNotAssignee    = errors.New("a ocorrência não está atribuída ao solicitante")
+	ErrSwapOccurrenceDone = errors.New("ocorrência já concluída não pode ser trocada")
+	ErrSwapSameUser       = errors.New("o solicitante não pode trocar consigo mesmo")
+	ErrSwapNotPending     = errors.New("pedido de troca já resolvido")
+)
+
+// TaskSwapRequest é o pedido de um morador para passar uma ocorrência a outro morador.
+// Com TargetOccurrenceID a troca é recíproca: o solicitante assume a ocorrência do outro.
+type TaskSwapRequest struct {
+	ID                 uuid.UUID  `json:"id"`
+	TaskOccurrenceID   uuid.UUID  `json:"task_occurrence_id"`
+	RequesterID        uuid.UUID  `json:"requester_id"`
+	TargetUserID       uuid.UUID  `json:"target_user_id"`
+	TargetOccurrenceID *uuid.UUID `json:"target_occurrence_id,omitempty"`
+	Status             string     `json:"status"`
+	CreatedAt          time.Time  `json:"created_at"`
+	ResolvedAt         *time.Time `json:"resolved_at,omitempty"`
+}
+
+const swapRequestColumns = `id, task_occurrence_id, requester_id, target_user_id, target_occurrence_id, status, created_at, resolved_at`
+
+func (s *TaskSwapRequest) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&s.ID, &s.TaskOccurrenceID, &s.RequesterID, &s.TargetUserID, &s.TargetOccurrenceID,
+		&s.Status, &s.CreatedAt, &s.ResolvedAt)
+}
+
+// lockSwapOccurrence bloqueia a ocorrência e confere se ela ainda pertence a userID e está pendente
+func lockSwapOccurrence(tx *sql.Tx, id, userID uuid.UUID) error {
+	var assignee *uuid.UUID
+	var done bool
+	err := tx.QueryRow(`SELECT user_id, status FROM task_occurrences WHERE id = $1 FOR UPDATE`, id).Scan(&assignee, &done)
+	if err != nil {
+		return err
+	}
+	if assignee == nil || *assignee != userID {
+		return ErrSwapNotAssignee
+	}
+	if done {
+		return ErrSwapOccurrenceDone
+	}
+	return nil
+}
+
+// Create registra o pedido após conferir que as ocorrências envolvidas podem ser trocadas
+func (s *TaskSwapRequest) Create() error {
+	if s.RequesterID == s.TargetUserID {
+		return ErrSwapSameUser
+	}
+
+	tx, err := config.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockSwapOccurrence(tx, s.TaskOccurrenceID, s.RequesterID); err != nil {
+		return err
+	}
+	if s.TargetOccurrenceID != nil {
+		if err := lockSwapOccurrence(tx, *s.TargetOccurrenceID, s.TargetUserID); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO task_swap_requests (id, task_occurrence_id, requester_id, target_user_id, target_occurrence_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + swapRequestColumns
+	if err := s.scan(tx.QueryRow(query, uuid.New(), s.TaskOccurrenceID, s.RequesterID, s.TargetUserID, s.TargetOccurrenceID)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *TaskSwapRequest) Get() error {
+	query := `SELECT ` + swapRequestColumns + ` FROM task_swap_requests WHERE id = $1`
+	return s.scan(config.GetDB().QueryRow(query, s.ID))
+}
+
+// Accept efetiva a troca: a ocorrência passa ao destinatário e, na troca recíproca, a ocorrência
+// dele passa ao solicitante. As atribuições são conferidas de novo, pois podem ter mudado desde o pedido.
+func (s *TaskSwapRequest) Accept(by *uuid.UUID) error {
+	return s.resolve(SwapAccepted, by, func(tx *sql.Tx) error {
+		if err := lockSwapOccurrence(tx, s.TaskOccurrenceID, s.RequesterID); err != nil {
+			return err
+		}
+		if s.TargetOccurrenceID != nil {
+			if err := lockSwapOccurrence(tx, *s.TargetOccurrenceID, s.TargetUserID); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Exec(`UPDATE task_occurrences SET user_id = $1 WHERE id = $2`, s.TargetUserID, s.TaskOccurrenceID); err != nil {
+			return err
+		}
+		if s.TargetOccurrenceID != nil {
+			if _, err := tx.Exec(`UPDATE task_occurrences SET user_id = $1 WHERE id = $2`, s.RequesterID, *s.TargetOccurrenceID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Reject recusa o pedido (resposta do destinatário)
+func (s *TaskSwapRequest) Reject(by *uuid.UUID) error {
+	return s.resolve(SwapRejected, by, nil)
+}
+
+// Cancel retira o pedido (ação do solicitante)
+func (s *TaskSwapRequest) Cancel(by *uuid.UUID) error {
+	return s.resolve(SwapCancelled, by, nil)
+}
+
+// resolve encerra um pedido pendente com a situação informada, executando apply na mesma transação;
+// by é o autor no log de auditoria
+func (s *TaskSwapRequest) resolve(status string, by *uuid.UUID, apply func(tx *sql.Tx) error) error {
+	tx, err := begin(by)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + swapRequestColumns + ` FROM task_swap_requests WHERE id = $1 FOR UPDATE`
+	if err := s.scan(tx.QueryRow(query, s.ID)); err != nil {
+		return err
+	}
+	if s.Status != SwapPending {
+		return ErrSwapNotPending
+	}
+
+	if apply != nil {
+		if err := apply(tx); err != nil {
+			return err
+		}
+	}
+
+	query = `
+		UPDATE task_swap_requests
+		SET status = $1, resolved_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		RETURNING ` + swapRequestColumns
+	if err := s.scan(tx.QueryRow(query, status, s.ID)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListSwapRequests lista os pedidos de troca, opcionalmente de um usuário (como solicitante ou destinatário)
+// e de uma situação
+func ListSwapRequests(userID *uuid.UUID, status string) ([]TaskSwapRequest, error) {
+	var where whereClause
+	if userID != nil {
+		where.add("(requester_id = ? OR target_user_id = ?)", *userID)
+	}
+	if status != "" {
+		where.add("status = ?", status)
+	}
+
+	rows, err := config.GetDB().Query(`SELECT `+swapRequestColumns+` FROM task_swap_requests `+where.String()+` ORDER BY created_at DESC`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []TaskSwapRequest{}
+	for rows.Next() {
+		var s TaskSwapRequest
+		if err := s.scan(rows); err != nil {
+			return nil, err
+		}
+		requests = append(requests, s)
+	}
+	return requests, rows.Err()
+}
\ No newline at end of file
@@ -1,13 +1,11 @@
 package models
 
 import (
-	"encoding/json"
-	"fmt"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pobruno/casa360/config"
-	"github.com/robfig/cron"
 )
 
 type TaskInstallment struct {
@@ -16,9 +14,16 @@ type TaskInstallment struct {
 	Description    string         `json:"description"`
 	StartDate      time.Time      `json:"start_date"`
 	RecurrenceCron string         `json:"recurrence_cron"`
-	Subtasks       json.RawMessage `json:"subtasks"`
+	Subtasks       Subtasks       `json:"subtasks"`
 	UserID         uuid.UUID      `json:"user_id"`
 	PayerGroupID   uuid.UUID      `json:"payer_group_id"`
+	Rotation       string         `json:"rotation"` // none, round_robin, weighted, least_recently_done ou fairness
+	EffortPoints     int          `json:"effort_points"`     // pontos creditados a quem conclui cada ocorrência
+	EstimatedMinutes int          `json:"estimated_minutes"` // tempo estimado de cada ocorrência
+	EndDate          *time.Time   `json:"end_date,omitempty"`        // última data em que a recorrência gera ocorrências
+	MaxOccurrences   *int         `json:"max_occurrences,omitempty"` // total de datas da recorrência, contadas desde start_date
+	DurationMinutes  *int         `json:"duration_minutes,omitempty"` // duração de cada ocorrência na agenda
+	DeletedAt        *time.Time   `json:"deleted_at,omitempty"` // preenchido quando arquivada
 }
 
 type TaskOccurrence struct {
@@ -28,54 +33,73 @@ type TaskOccurrence struct {
 	Status       bool           `json:"status"`
 	UserID       uuid.UUID      `json:"user_id"`
 	PayerGroupID uuid.UUID      `json:"payer_group_id"`
-	Subtasks     json.RawMessage `json:"subtasks"`
+	Subtasks     Subtasks       `json:"subtasks"`
+	CompletedBy  *uuid.UUID     `json:"completed_by,omitempty"` // quem de fato concluiu, que recebe os pontos
+	CompletedAt  *time.Time     `json:"completed_at,omitempty"`
+	EffortPoints int            `json:"effort_points"` // pontos creditados na conclusão (0 enquanto pendente)
+	State          string     `json:"state"` // pending, in_progress, done, skipped, overdue ou cancelled
+	StateChangedAt *time.Time `json:"state_changed_at,omitempty"`
+	StateChangedBy *uuid.UUID `json:"state_changed_by,omitempty"` // vazio quando a mudança foi feita pelo sistema
+	OriginalDate   *time.Time `json:"original_date,omitempty"`    // data prevista pela recorrência, se a ocorrência foi remarcada
+	ScheduledAt     *time.Time `json:"scheduled_at,omitempty"`     // início previsto, no horário da expressão CRON
+	DurationMinutes *int       `json:"duration_minutes,omitempty"` // duração prevista
+	DueAt           *time.Time `json:"due_at,omitempty"`           // prazo; padrão: início + duração
+}
+
+const taskColumns = `id, title, description, start_date, recurrence_cron, subtasks, user_id, payer_group_id, rotation,
+	effort_points, estimated_minutes, end_date, max_occurrences, duration_minutes, deleted_at`
+
+func (t *TaskInstallment) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&t.ID, &t.Title, &t.Description, &t.StartDate, &t.RecurrenceCron, &t.Subtasks, &t.UserID, &t.PayerGroupID, &t.Rotation,
+		&t.EffortPoints, &t.EstimatedMinutes, &t.EndDate, &t.MaxOccurrences, &t.DurationMinutes, &t.DeletedAt)
 }
 
 // Create insere uma nova tarefa no banco de dados
-func (t *TaskInstallment) Create() error {
+func (t *TaskInstallment) Create(by *uuid.UUID) error {
+	return audited(by, func(tx *sql.Tx) error { return t.create(tx) })
+}
+
+func (t *TaskInstallment) create(db queryer) error {
 	query := `
-		INSERT INTO task_installments (id, title, description, start_date, recurrence_cron, subtasks, user_id, payer_group_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id, title, description, start_date, recurrence_cron, subtasks, user_id, payer_group_id`
-	return config.GetDB().QueryRow(query, uuid.New(), t.Title, t.Description, t.StartDate, t.RecurrenceCron, t.Subtasks, t.UserID, t.PayerGroupID).
-		Scan(&t.ID, &t.Title, &t.Description, &t.StartDate, &t.RecurrenceCron, &t.Subtasks, &t.UserID, &t.PayerGroupID)
+		INSERT INTO task_installments (id, title, description, start_date, recurrence_cron, subtasks, user_id, payer_group_id, rotation,
+			effort_points, estimated_minutes, end_date, max_occurrences, duration_minutes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING ` + taskColumns
+	return t.scan(db.QueryRow(query, uuid.New(), t.Title, t.Description, t.StartDate, t.RecurrenceCron, t.Subtasks, t.UserID, t.PayerGroupID, t.Rotation,
+		t.EffortPoints, t.EstimatedMinutes, t.EndDate, t.MaxOccurrences, t.DurationMinutes))
 }
 
 // Get busca uma tarefa pelo ID
 func (t *TaskInstallment) Get() error {
 	query := `
-		SELECT id, title, description, start_date, recurrence_cron, subtasks, user_id, payer_group_id
+		SELECT ` + taskColumns + `
 		FROM task_installments
 		WHERE id = $1`
-	return config.GetDB().QueryRow(query, t.ID).
-		Scan(&t.ID, &t.Title, &t.Description, &t.StartDate, &t.RecurrenceCron, &t.Subtasks, &t.UserID, &t.PayerGroupID)
+	return t.scan(config.GetDB().QueryRow(query, t.ID))
 }
 
 // Update atualiza os dados de uma tarefa
-func (t *TaskInstallment) Update() error {
-	query := `
-		UPDATE task_installments
-		SET title = $1, description = $2, start_date = $3, recurrence_cron = $4, subtasks = $5, user_id = $6, payer_group_id = $7
-		WHERE id = $8
-		RETURNING id, title, description, start_date, recurrence_cron, subtasks, user_id, payer_group_id`
-	return config.GetDB().QueryRow(query, t.Title, t.Description, t.StartDate, t.RecurrenceCron, t.Subtasks, t.UserID, t.PayerGroupID, t.ID).
-		Scan(&t.ID, &t.Title, &t.Description, &t.StartDate, &t.RecurrenceCron, &t.Subtasks, &t.UserID, &t.PayerGroupID)
+func (t *TaskInstallment) Update(by *uuid.UUID) error {
+	return audited(by, func(tx *sql.Tx) error { return t.update(tx) })
 }
 
-// Delete remove uma tarefa do banco de dados
-func (t *TaskInstallment) Delete() error {
+func (t *TaskInstallment) update(db queryer) error {
 	query := `
-		DELETE FROM task_installments
-		WHERE id = $1`
-	_, err := config.GetDB().Exec(query, t.ID)
-	return err
+		UPDATE task_installments
+		SET title = $1, description = $2, start_date = $3, recurrence_cron = $4, subtasks = $5, user_id = $6, payer_group_id = $7, rotation = $8,
+			effort_points = $9, estimated_minutes = $10, end_date = $11, max_occurrences = $12, duration_minutes = $13
+		WHERE id = $14
+		RETURNING ` + taskColumns
+	return t.scan(db.QueryRow(query, t.Title, t.Description, t.StartDate, t.RecurrenceCron, t.Subtasks, t.UserID, t.PayerGroupID, t.Rotation,
+		t.EffortPoints, t.EstimatedMinutes, t.EndDate, t.MaxOccurrences, t.DurationMinutes, t.ID))
 }
 
-// ListTasks retorna todas as tarefas
-func ListTasks() ([]TaskInstallment, error) {
+// ListTasks retorna as tarefas conforme o filtro de arquivamento
+func ListTasks(archived string) ([]TaskInstallment, error) {
 	query := `
-		SELECT id, title, description, start_date, recurrence_cron, subtasks, user_id, payer_group_id
-		FROM task_installments`
+		SELECT ` + taskColumns + `
+		FROM task_installments
+		` + archivedWhere(archived)
 	rows, err := config.GetDB().Query(query)
 	if err != nil {
 		return nil, err
@@ -85,7 +109,7 @@ func ListTasks() ([]TaskInstallment, error) {
 	var tasks []TaskInstallment
 	for rows.Next() {
 		var t TaskInstallment
-		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.StartDate, &t.RecurrenceCron, &t.Subtasks, &t.UserID, &t.PayerGroupID); err != nil {
+		if err := t.scan(rows); err != nil {
 			return nil, err
 		}
 		tasks = append(tasks, t)
@@ -93,52 +117,170 @@ func ListTasks() ([]TaskInstallment, error) {
 	return tasks, nil
 }
 
+const taskOccurrenceColumns = `id, task_id, date, status, user_id, payer_group_id, subtasks, completed_by, completed_at, effort_points,
+	state, state_changed_at, state_changed_by, original_date, scheduled_at, duration_minutes, due_at`
+
+func (to *TaskOccurrence) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(&to.ID, &to.TaskID, &to.Date, &to.Status, &to.UserID, &to.PayerGroupID, &to.Subtasks,
+		&to.CompletedBy, &to.CompletedAt, &to.EffortPoints, &to.State, &to.StateChangedAt, &to.StateChangedBy, &to.OriginalDate,
+		&to.ScheduledAt, &to.DurationMinutes, &to.DueAt)
+}
+
+// FillDueAt preenche o prazo com o início mais a duração, quando o prazo não foi informado
+func (to *TaskOccurrence) FillDueAt() {
+	if to.DueAt == nil && to.ScheduledAt != nil && to.DurationMinutes != nil {
+		due := to.ScheduledAt.Add(time.Duration(*to.DurationMinutes) * time.Minute)
+		to.DueAt = &due
+	}
+}
+
+// creditedEffort calcula os pontos da ocorrência conforme o novo status ($1): a conclusão credita os
+// pontos atuais da tarefa, uma ocorrência já concluída mantém os seus e a reabertura os zera
+const creditedEffort = `CASE WHEN NOT $1 THEN 0 WHEN status THEN effort_points
+	ELSE (SELECT ti.effort_points FROM task_installments ti WHERE ti.id = task_id) END`
+
 // Create insere uma nova ocorrência de tarefa no banco de dados
-func (to *TaskOccurrence) Create() error {
+func (to *TaskOccurrence) Create(by *uuid.UUID) error {
+	return audited(by, func(tx *sql.Tx) error { return to.insert(tx) })
+}
+
+func (to *TaskOccurrence) insert(db queryer) error {
+	to.TrackCompletion(false, to.CompletedBy)
+	if to.State == "" || (to.State == TaskDone) != to.Status {
+		to.State = ""
+		to.syncState(to.CompletedBy)
+	}
+	to.FillDueAt()
 	query := `
-		INSERT INTO task_occurrences (id, task_id, date, status, user_id, payer_group_id, subtasks)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, task_id, date, status, user_id, payer_group_id, subtasks`
-	return config.GetDB().QueryRow(query, uuid.New(), to.TaskID, to.Date, to.Status, to.UserID, to.PayerGroupID, to.Subtasks).
-		Scan(&to.ID, &to.TaskID, &to.Date, &to.Status, &to.UserID, &to.PayerGroupID, &to.Subtasks)
+		INSERT INTO task_occurrences (id, task_id, date, status, user_id, payer_group_id, subtasks, completed_by, completed_at, effort_points,
+			state, state_changed_by, scheduled_at, duration_minutes, due_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9,
+			CASE WHEN $4 THEN (SELECT effort_points FROM task_installments WHERE id = $2) ELSE 0 END, $10, $11, $12, $13, $14)
+		RETURNING ` + taskOccurrenceColumns
+	return to.scan(db.QueryRow(query, uuid.New(), to.TaskID, to.Date, to.Status, to.UserID, to.PayerGroupID, to.Subtasks,
+		to.CompletedBy, to.CompletedAt, to.State, to.StateChangedBy, to.ScheduledAt, to.DurationMinutes, to.DueAt))
 }
 
 // Get busca uma ocorrência de tarefa pelo ID
 func (to *TaskOccurrence) Get() error {
 	query := `
-		SELECT id, task_id, date, status, user_id, payer_group_id, subtasks
+		SELECT ` + taskOccurrenceColumns + `
 		FROM task_occurrences
 		WHERE id = $1`
-	return config.GetDB().QueryRow(query, to.ID).
-		Scan(&to.ID, &to.TaskID, &to.Date, &to.Status, &to.UserID, &to.PayerGroupID, &to.Subtasks)
+	return to.scan(config.GetDB().QueryRow(query, to.ID))
 }
 
-// Update atualiza os dados de uma ocorrência de tarefa
-func (to *TaskOccurrence) Update() error {
+// Update atualiza os dados de uma ocorrência de tarefa. Quem chama deve ter registrado a
+// conclusão com TrackCompletion; o estado acompanha o status quando só este foi alterado.
+func (to *TaskOccurrence) Update(by *uuid.UUID) error {
+	to.syncState(to.StateChangedBy)
 	query := `
 		UPDATE task_occurrences
-		SET status = $1, user_id = $2, payer_group_id = $3, subtasks = $4
-		WHERE id = $5
-		RETURNING id, task_id, date, status, user_id, payer_group_id, subtasks`
-	return config.GetDB().QueryRow(query, to.Status, to.UserID, to.PayerGroupID, to.Subtasks, to.ID).
-		Scan(&to.ID, &to.TaskID, &to.Date, &to.Status, &to.UserID, &to.PayerGroupID, &to.Subtasks)
+		SET status = $1, user_id = $2, payer_group_id = $3, subtasks = $4, completed_by = $5, completed_at = $6,
+			effort_points = ` + creditedEffort + `, state = $7, state_changed_by = $8,
+			scheduled_at = $9, duration_minutes = $10, due_at = $11
+		WHERE id = $12
+		RETURNING ` + taskOccurrenceColumns
+	return audited(by, func(tx *sql.Tx) error {
+		return to.scan(tx.QueryRow(query, to.Status, to.UserID, to.PayerGroupID, to.Subtasks, to.CompletedBy, to.CompletedAt,
+			to.State, to.StateChangedBy, to.ScheduledAt, to.DurationMinutes, to.DueAt, to.ID))
+	})
+}
+
+// SyncStatus deriva o status das subtarefas: a ocorrência está concluída quando todas estão
+func (to *TaskOccurrence) SyncStatus() {
+	if len(to.Subtasks) > 0 {
+		to.Status = to.Subtasks.AllDone()
+	}
+}
+
+// TrackCompletion registra quem concluiu e quando, a partir do status anterior (wasDone).
+// Sem by, o crédito vai para o responsável pela ocorrência; ao reabrir, a conclusão é apagada.
+func (to *TaskOccurrence) TrackCompletion(wasDone bool, by *uuid.UUID) {
+	switch {
+	case !to.Status:
+		to.CompletedBy, to.CompletedAt = nil, nil
+	case !wasDone || by != nil:
+		if by == nil {
+			assignee := to.UserID
+			by = &assignee
+		}
+		to.CompletedBy = by
+		if to.CompletedAt == nil || !wasDone {
+			now := time.Now()
+			to.CompletedAt = &now
+		}
+	}
+}
+
+// ToggleSubtask marca ou desmarca uma subtarefa (done nil inverte o estado) e recalcula o status, creditando
+// completedBy; by é o autor no log de auditoria. A linha fica bloqueada durante a alteração para que
+// marcações simultâneas não se percam.
+func (to *TaskOccurrence) ToggleSubtask(subtaskID uuid.UUID, done *bool, completedBy, by *uuid.UUID) (*Subtask, error) {
+	tx, err := begin(by)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := to.lock(tx); err != nil {
+		return nil, err
+	}
+
+	subtask, err := to.Subtasks.Set(subtaskID, done, completedBy)
+	if err != nil {
+		return nil, err
+	}
+	wasDone := to.Status
+	to.SyncStatus()
+	to.syncState(completedBy)
+	// Quem marca a última subtarefa conclui a ocorrência; se ela já estava concluída, o crédito não muda
+	if wasDone {
+		completedBy = nil
+	}
+	to.TrackCompletion(wasDone, completedBy)
+
+	if err := to.saveState(tx); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return subtask, nil
 }
 
 // Delete remove uma ocorrência de tarefa do banco de dados
-func (to *TaskOccurrence) Delete() error {
+func (to *TaskOccurrence) Delete(by *uuid.UUID) error {
 	query := `
 		DELETE FROM task_occurrences
 		WHERE id = $1`
-	_, err := config.GetDB().Exec(query, to.ID)
-	return err
+	return audited(by, func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, to.ID)
+		return err
+	})
 }
 
-// ListTaskOccurrences retorna todas as ocorrências de tarefas
-func ListTaskOccurrences() ([]TaskOccurrence, error) {
+// ListTaskOccurrences retorna as ocorrências de tarefas, com filtros opcionais
+func ListTaskOccurrences(filter ListFilter) ([]TaskOccurrence, error) {
+	var where whereClause
+	where.addDateRange("date", filter)
+	if filter.Status != nil {
+		where.add("status = ?", *filter.Status)
+	}
+	where.addStates("state", filter)
+	if filter.UserID != nil {
+		where.add("user_id = ?", *filter.UserID)
+	}
+	if filter.PayerGroupID != nil {
+		where.add("payer_group_id = ?", *filter.PayerGroupID)
+	}
+
 	query := `
-		SELECT id, task_id, date, status, user_id, payer_group_id, subtasks
-		FROM task_occurrences`
-	rows, err := config.GetDB().Query(query)
+		SELECT ` + taskOccurrenceColumns + `
+		FROM task_occurrences
+		` + where.String() + `
+		ORDER BY date, id`
+	rows, err := config.GetDB().Query(query, where.args...)
 	if err != nil {
 		return nil, err
 	}
@@ -147,7 +289,7 @@ func ListTaskOccurrences() ([]TaskOccurrence, error) {
 	var occurrences []TaskOccurrence
 	for rows.Next() {
 		var o TaskOccurrence
-		if err := rows.Scan(&o.ID, &o.TaskID, &o.Date, &o.Status, &o.UserID, &o.PayerGroupID, &o.Subtasks); err != nil {
+		if err := o.scan(rows); err != nil {
 			return nil, err
 		}
 		occurrences = append(occurrences, o)
@@ -158,7 +300,7 @@ func ListTaskOccurrences() ([]TaskOccurrence, error) {
 // ListTaskOccurrencesByTaskID retorna todas as ocorrências de uma tarefa específica
 func ListTaskOccurrencesByTaskID(taskID uuid.UUID) ([]TaskOccurrence, error) {
 	query := `
-		SELECT id, task_id, date, status, user_id, payer_group_id, subtasks
+		SELECT ` + taskOccurrenceColumns + `
 		FROM task_occurrences
 		WHERE task_id = $1
 		ORDER BY date`
@@ -171,7 +313,7 @@ func ListTaskOccurrencesByTaskID(taskID uuid.UUID) ([]TaskOccurrence, error) {
 	var occurrences []TaskOccurrence
 	for rows.Next() {
 		var o TaskOccurrence
-		if err := rows.Scan(&o.ID, &o.TaskID, &o.Date, &o.Status, &o.UserID, &o.PayerGroupID, &o.Subtasks); err != nil {
+		if err := o.scan(rows); err != nil {
 			return nil, err
 		}
 		occurrences = append(occurrences, o)
@@ -179,37 +321,17 @@ func ListTaskOccurrencesByTaskID(taskID uuid.UUID) ([]TaskOccurrence, error) {
 	return occurrences, nil
 }
 
-// GenerateOccurrences gera ocorrências para uma tarefa baseada em seu cronograma CRON
+// GenerateOccurrences gera as ocorrências da tarefa para 1 ano à frente, a partir da marca d'água
 func (t *TaskInstallment) GenerateOccurrences() error {
-	// Parseia a expressão CRON
-	schedule, err := cron.ParseStandard(t.RecurrenceCron)
-	if err != nil {
-		return fmt.Errorf("erro ao parsear expressão CRON: %v", err)
-	}
-
-	// Define o período de geração
-	now := time.Now()
-	endDate := now.AddDate(1, 0, 0) // Gera ocorrências para 1 ano à frente por padrão
-
-	// Gera as datas de ocorrência
-	nextTime := t.StartDate
-	for nextTime.Before(endDate) {
-		// Cria a ocorrência
-		occurrence := TaskOccurrence{
-			TaskID:       t.ID,
-			Date:         nextTime,
-			Status:       false,
-			UserID:       t.UserID,
-			PayerGroupID: t.PayerGroupID,
-			Subtasks:     t.Subtasks,
-		}
-
-		// Tenta criar a ocorrência (ignora se já existir devido à constraint UNIQUE)
-		_ = occurrence.Create()
-
-		// Calcula a próxima ocorrência
-		nextTime = schedule.Next(nextTime)
+	if t.DeletedAt != nil {
+		return ErrArchived
 	}
 
-	return nil
-} 
\ No newline at end of file
+	// Gera ocorrências para 1 ano à frente por padrão
+	until, err := Today()
+	if err != nil {
+		return err
+	}
+	_, err = t.Generate(until.AddDate(1, 0, 0))
+	return err
+}
\ No newline at end of file
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pobruno/casa360/config"
+)
+
+// Estados do ciclo de vida de uma ocorrência de tarefa
+const (
+	TaskPending    = "pending"
+	TaskInProgress = "in_progress"
+	TaskDone       = "done"
+	TaskSkipped    = "skipped"
+	TaskOverdue    = "overdue"
+	TaskCancelled  = "cancelled"
+)
+
+var (
+	ErrInvalidTransition = errors.New("transição de estado não permitida")
+	ErrSubtasksAllDone   = errors.New("todas as subtarefas estão concluídas; desmarque uma delas para reabrir a ocorrência")
+)
+
+// taskTransitions lista, para cada estado, os estados que podem sucedê-lo.
+// overdue só é atribuído pela varredura de atrasadas, mas reabrir uma concluída também é permitido.
+var taskTransitions = map[string][]string{
+	TaskPending:    {TaskInProgress, TaskDone, TaskSkipped, TaskCancelled, TaskOverdue},
+	TaskInProgress: {TaskPending, TaskDone, TaskSkipped, TaskCancelled, TaskOverdue},
+	TaskOverdue:    {TaskInProgress, TaskDone, TaskSkipped, TaskCancelled},
+	TaskDone:       {TaskPending, TaskInProgress},
+	TaskSkipped:    {TaskPending},
+	TaskCancelled:  {TaskPending},
+}
+
+// ValidTaskState indica se o estado é conhecido
+func ValidTaskState(state string) bool {
+	_, ok := taskTransitions[state]
+	return ok
+}
+
+// TaskStateChange é um registro do histórico de estados de uma ocorrência
+type TaskStateChange struct {
+	ID               uuid.UUID  `json:"id"`
+	TaskOccurrenceID uuid.UUID  `json:"task_occurrence_id"`
+	FromState        *string    `json:"from_state"`
+	ToState          string     `json:"to_state"`
+	ChangedBy        *uuid.UUID `json:"changed_by"`
+	ChangedAt        time.Time  `json:"changed_at"`
+}
+
+// SetState aplica uma transição de estado em memória, mantendo status, subtarefas e conclusão coerentes.
+// Quem chama grava a ocorrência em seguida.
+func (to *TaskOccurrence) SetState(state string, by *uuid.UUID) error {
+	if state == to.State {
+		return nil
+	}
+	allowed := false
+	for _, next := range taskTransitions[to.State] {
+		allowed = allowed || next == state
+	}
+	if !allowed {
+		return ErrInvalidTransition
+	}
+
+	wasDone := to.Status
+	if state == TaskDone {
+		completer := by
+		if completer == nil {
+			completer = &to.UserID
+		}
+		to.Subtasks.Complete(completer)
+	} else if to.Subtasks.AllDone() {
+		return ErrSubtasksAllDone
+	}
+
+	to.State = state
+	to.Status = state == TaskDone
+	to.StateChangedBy = by
+	to.TrackCompletion(wasDone, by)
+	return nil
+}
+
+// syncState acompanha no estado uma mudança feita diretamente no status (PUT ou subtarefas)
+func (to *TaskOccurrence) syncState(by *uuid.UUID) {
+	switch {
+	case to.Status && to.State != TaskDone:
+		to.State, to.StateChangedBy = TaskDone, by
+	case !to.Status && (to.State == TaskDone || to.State == ""):
+		to.State, to.StateChangedBy = TaskPending, by
+	}
+}
+
+// ChangeState aplica a transição com a ocorrência bloqueada, gravando status e estado juntos. changedBy é
+// quem alterou (e recebe o crédito de uma conclusão); by é o autor no log de auditoria.
+func (to *TaskOccurrence) ChangeState(state string, changedBy, by *uuid.UUID) error {
+	tx, err := begin(by)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := to.lock(tx); err != nil {
+		return err
+	}
+	previous := to.State
+	if err := to.SetState(state, changedBy); err != nil {
+		return err
+	}
+	if err := to.saveState(tx); err != nil {
+		return err
+	}
+
+	// Desfazer um pulo libera a data prevista para a geração
+	if previous == TaskSkipped {
+		if _, err := tx.Exec(`
+			DELETE FROM occurrence_exceptions
+			WHERE task_id = $1 AND original_date = $2 AND action = 'skipped'`,
+			to.TaskID, scheduledDate(to.Date, to.OriginalDate)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// lock relê a ocorrência bloqueando-a até o fim da transação
+func (to *TaskOccurrence) lock(tx *sql.Tx) error {
+	query := `
+		SELECT ` + taskOccurrenceColumns + `
+		FROM task_occurrences
+		WHERE id = $1
+		FOR UPDATE`
+	return to.scan(tx.QueryRow(query, to.ID))
+}
+
+// saveState grava status, subtarefas, conclusão e estado alterados por SetState
+func (to *TaskOccurrence) saveState(tx *sql.Tx) error {
+	query := `
+		UPDATE task_occurrences
+		SET status = $1, subtasks = $2, completed_by = $3, completed_at = $4, effort_points = ` + creditedEffort + `,
+			state = $5, state_changed_by = $6
+		WHERE id = $7
+		RETURNING ` + taskOccurrenceColumns
+	return to.scan(tx.QueryRow(query, to.Status, to.Subtasks, to.CompletedBy, to.CompletedAt, to.State, to.StateChangedBy, to.ID))
+}
+
+// StateHistory retorna as mudanças de estado da ocorrência, da mais antiga para a mais recente
+func (to *TaskOccurrence) StateHistory() ([]TaskStateChange, error) {
+	rows, err := config.GetDB().Query(`
+		SELECT id, task_occurrence_id, from_state, to_state, changed_by, changed_at
+		FROM task_occurrence_states
+		WHERE task_occurrence_id = $1
+		ORDER BY changed_at, id`, to.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []TaskStateChange{}
+	for rows.Next() {
+		var h TaskStateChange
+		if err := rows.Scan(&h.ID, &h.TaskOccurrenceID, &h.FromState, &h.ToState, &h.ChangedBy, &h.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+
+// SweepOverdueTaskOccurrences marca como atrasadas as ocorrências pendentes ou em andamento de dias
+// anteriores a hoje. O histórico registra a mudança sem autor (feita pelo sistema).
+func SweepOverdueTaskOccurrences() (int64, error) {
+	result, err := config.GetDB().Exec(`
+		UPDATE task_occurrences
+		SET state = 'overdue', state_changed_by = NULL
+		WHERE state IN ('pending', 'in_progress') AND date < household_today()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
\ No newline at end of file
@@ -1,56 +1,59 @@
 package models
 
 import (
+	"database/sql"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/pobruno/casa360/config"
 )
 
 type User struct {
-	ID   uuid.UUID `json:"id"`
-	Name string    `json:"name"`
+	ID        uuid.UUID  `json:"id"`
+	Name      string     `json:"name"`
+	PixKey    *string    `json:"pix_key,omitempty"`
+	PixCity   *string    `json:"pix_city,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // preenchido quando arquivado
 }
 
-func (u *User) Create() error {
+func (u *User) Create(by *uuid.UUID) error {
 	query := `
-		INSERT INTO users (id, name)
-		VALUES ($1, $2)
-		RETURNING id, name
+		INSERT INTO users (id, name, pix_key, pix_city)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, name, pix_key, pix_city, deleted_at
 	`
-	return config.GetDB().QueryRow(query, uuid.New(), u.Name).Scan(&u.ID, &u.Name)
+	return audited(by, func(tx *sql.Tx) error {
+		return tx.QueryRow(query, uuid.New(), u.Name, u.PixKey, u.PixCity).Scan(&u.ID, &u.Name, &u.PixKey, &u.PixCity, &u.DeletedAt)
+	})
 }
 
 func (u *User) Get() error {
 	query := `
-		SELECT id, name
+		SELECT id, name, pix_key, pix_city, deleted_at
 		FROM users
 		WHERE id = $1
 	`
-	return config.GetDB().QueryRow(query, u.ID).Scan(&u.ID, &u.Name)
+	return config.GetDB().QueryRow(query, u.ID).Scan(&u.ID, &u.Name, &u.PixKey, &u.PixCity, &u.DeletedAt)
 }
 
-func (u *User) Update() error {
+func (u *User) Update(by *uuid.UUID) error {
 	query := `
 		UPDATE users
-		SET name = $1
-		WHERE id = $2
-		RETURNING id, name
-	`
-	return config.GetDB().QueryRow(query, u.Name, u.ID).Scan(&u.ID, &u.Name)
-}
-
-func (u *User) Delete() error {
-	query := `
-		DELETE FROM users
-		WHERE id = $1
+		SET name = $1, pix_key = $2, pix_city = $3
+		WHERE id = $4
+		RETURNING id, name, pix_key, pix_city, deleted_at
 	`
-	_, err := config.GetDB().Exec(query, u.ID)
-	return err
+	return audited(by, func(tx *sql.Tx) error {
+		return tx.QueryRow(query, u.Name, u.PixKey, u.PixCity, u.ID).Scan(&u.ID, &u.Name, &u.PixKey, &u.PixCity, &u.DeletedAt)
+	})
 }
 
-func ListUsers() ([]User, error) {
+// ListUsers lista os usuários conforme o filtro de arquivamento
+func ListUsers(archived string) ([]User, error) {
 	query := `
-		SELECT id, name
+		SELECT id, name, pix_key, pix_city, deleted_at
 		FROM users
+		` + archivedWhere(archived) + `
 		ORDER BY name
 	`
 	rows, err := config.GetDB().Query(query)
@@ -62,7 +65,7 @@ func ListUsers() ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.Name); err != nil {
+		if err := rows.Scan(&u.ID, &u.Name, &u.PixKey, &u.PixCity, &u.DeletedAt); err != nil {
 			return nil, err
 		}
 		users = append(users, u)
@@ -0,0 +1,254 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pobruno/casa360/config"
+)
+
+// SkipAway é o motivo de uma data da tarefa não gerar ocorrência por não haver responsável presente
+const SkipAway = "away"
+
+// watermark é até onde a recorrência de um modelo já foi percorrida pela geração
+type watermark struct {
+	until time.Time // última data percorrida
+	count int       // datas contadas até ela, para o limite de ocorrências
+}
+
+// loadWatermark lê a marca d'água do modelo; nil quando a geração precisa começar do início
+func loadWatermark(db queryer, column string, templateID uuid.UUID) (*watermark, error) {
+	var w watermark
+	err := db.QueryRow(`SELECT generated_until, generated_count FROM occurrence_watermarks WHERE `+column+` = $1`, templateID).
+		Scan(&w.until, &w.count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (w *watermark) save(db queryer, column string, templateID uuid.UUID) error {
+	_, err := db.Exec(`
+		INSERT INTO occurrence_watermarks (`+column+`, generated_until, generated_count)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (`+column+`) DO UPDATE
+		SET generated_until = EXCLUDED.generated_until, generated_count = EXCLUDED.generated_count,
+			updated_at = CURRENT_TIMESTAMP`, templateID, w.until, w.count)
+	return err
+}
+
+// SkippedDate é uma data da recorrência que não gerou ocorrência, com o motivo (SkipException,
+// SkipPaused ou SkipAway)
+type SkippedDate struct {
+	Date   time.Time
+	Reason string
+}
+
+// Generation resume a geração de ocorrências de um modelo
+type Generation struct {
+	Created []time.Time // datas das ocorrências criadas, em ordem
+	Skipped []SkippedDate
+}
+
+// plannedDate é uma data a gerar, com o início previsto nas recorrências com horário
+type plannedDate struct {
+	date time.Time
+	at   *time.Time
+}
+
+// resume posiciona o plano logo depois da marca d'água, com as datas já contadas
+func (p *OccurrencePlan) resume(w *watermark) {
+	p.count = w.count
+	p.cursor = time.Date(w.until.Year(), w.until.Month(), w.until.Day(), 0, 0, 0, 0, p.cursor.Location())
+}
+
+// pending percorre o plano da marca d'água (ou do início) até until e devolve as datas que ainda não têm
+// ocorrência, junto com a nova marca d'água (nil se nenhuma data foi percorrida)
+func (p *OccurrencePlan) pending(mark *watermark, until time.Time, existing DateSet, g *Generation) ([]plannedDate, *watermark) {
+	if mark != nil {
+		p.resume(mark)
+	}
+	var next *watermark
+	var dates []plannedDate
+	for date, skip, ok := p.Next(until); ok; date, skip, ok = p.Next(until) {
+		next = &watermark{until: date, count: p.count}
+		switch {
+		case skip != "":
+			g.Skipped = append(g.Skipped, SkippedDate{Date: date, Reason: skip})
+		case !existing.Has(date):
+			dates = append(dates, plannedDate{date: date, at: p.At()})
+		}
+	}
+	return dates, next
+}
+
+// nullTimestamp converte um instante opcional para um elemento de array do Postgres
+func nullTimestamp(t *time.Time) sql.NullString {
+	if t == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.Format(time.RFC3339Nano), Valid: true}
+}
+
+// Generate cria as ocorrências da tarefa até until (inclusive), em um único INSERT, e avança a marca
+// d'água. Só as datas depois da marca são percorridas; ela é descartada pelo banco quando a recorrência
+// muda ou datas já percorridas podem voltar a gerar ocorrências (veja invalidate_watermark no init.sql).
+func (t *TaskInstallment) Generate(until time.Time) (*Generation, error) {
+	tx, err := config.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Gerações simultâneas da mesma tarefa esperam uma pela outra
+	if _, err := tx.Exec(`SELECT 1 FROM task_installments WHERE id = $1 FOR NO KEY UPDATE`, t.ID); err != nil {
+		return nil, err
+	}
+	mark, err := loadWatermark(tx, "task_id", t.ID)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := t.plan(tx)
+	if err != nil {
+		return nil, err
+	}
+	var after *time.Time
+	if mark != nil {
+		after = &mark.until
+	}
+	existing, err := existingDates(tx, "task_occurrences", "task_id", t.ID, after)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Generation{Created: []time.Time{}, Skipped: []SkippedDate{}}
+	dates, next := plan.pending(mark, until, existing, g)
+	if len(dates) > 0 {
+		rotation, err := t.newRotation(tx)
+		if err != nil {
+			return nil, err
+		}
+
+		var days []string
+		var users []uuid.UUID
+		var starts, dues []sql.NullString
+		for _, d := range dates {
+			userID, available := rotation.Pick(d.date)
+			if !available {
+				g.Skipped = append(g.Skipped, SkippedDate{Date: d.date, Reason: SkipAway})
+				continue
+			}
+			rotation.Record(userID, d.date)
+
+			occurrence := TaskOccurrence{ScheduledAt: d.at, DurationMinutes: t.DurationMinutes}
+			occurrence.FillDueAt()
+			days = append(days, d.date.Format("2006-01-02"))
+			users = append(users, userID)
+			starts = append(starts, nullTimestamp(occurrence.ScheduledAt))
+			dues = append(dues, nullTimestamp(occurrence.DueAt))
+		}
+
+		rows, err := tx.Query(`
+			INSERT INTO task_occurrences (task_id, date, status, user_id, payer_group_id, subtasks, state,
+				scheduled_at, duration_minutes, due_at)
+			SELECT $1::uuid, o.date, false, o.user_id, $2::uuid, $3::jsonb, 'pending', o.scheduled_at, $4::integer, o.due_at
+			FROM unnest($5::date[], $6::uuid[], $7::timestamptz[], $8::timestamptz[]) AS o(date, user_id, scheduled_at, due_at)
+			ORDER BY o.date
+			ON CONFLICT DO NOTHING
+			RETURNING date`, t.ID, t.PayerGroupID, t.Subtasks.Reset(), t.DurationMinutes,
+			pq.Array(days), pq.Array(users), pq.Array(starts), pq.Array(dues))
+		if err != nil {
+			return nil, err
+		}
+		if err := g.scanCreated(rows); err != nil {
+			return nil, err
+		}
+	}
+
+	if next != nil {
+		if err := next.save(tx, "task_id", t.ID); err != nil {
+			return nil, err
+		}
+	}
+	return g, tx.Commit()
+}
+
+// Generate cria as ocorrências da finança até until (inclusive), em um único INSERT, e avança a marca
+// d'água, como na geração das tarefas
+func (fi *FinanceInstallment) Generate(until time.Time) (*Generation, error) {
+	tx, err := config.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT 1 FROM finance_installments WHERE id = $1 FOR NO KEY UPDATE`, fi.ID); err != nil {
+		return nil, err
+	}
+	mark, err := loadWatermark(tx, "finance_id", fi.ID)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := fi.plan(tx)
+	if err != nil {
+		return nil, err
+	}
+	var after *time.Time
+	if mark != nil {
+		after = &mark.until
+	}
+	existing, err := existingDates(tx, "finance_occurrences", "finance_id", fi.ID, after)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Generation{Created: []time.Time{}, Skipped: []SkippedDate{}}
+	dates, next := plan.pending(mark, until, existing, g)
+	if len(dates) > 0 {
+		days := make([]string, len(dates))
+		for i, d := range dates {
+			days[i] = d.date.Format("2006-01-02")
+		}
+		rows, err := tx.Query(`
+			INSERT INTO finance_occurrences (finance_id, date, amount, status)
+			SELECT $1::uuid, d, $2::numeric, false
+			FROM unnest($3::date[]) AS d
+			ORDER BY d
+			ON CONFLICT DO NOTHING
+			RETURNING date`, fi.ID, fi.Amount, pq.Array(days))
+		if err != nil {
+			return nil, err
+		}
+		if err := g.scanCreated(rows); err != nil {
+			return nil, err
+		}
+	}
+
+	if next != nil {
+		if err := next.save(tx, "finance_id", fi.ID); err != nil {
+			return nil, err
+		}
+	}
+	return g, tx.Commit()
+}
+
+// scanCreated lê as datas devolvidas pelo INSERT (as que já existiam ficam de fora)
+func (g *Generation) scanCreated(rows *sql.Rows) error {
+	defer rows.Close()
+	for rows.Next() {
+		var date time.Time
+		if err := rows.Scan(&date); err != nil {
+			return err
+		}
+		g.Created = append(g.Created, date)
+	}
+	sort.Slice(g.Created, func(i, j int) bool { return g.Created[i].Before(g.Created[j]) })
+	return rows.Err()
+}
\ No newline at end of file
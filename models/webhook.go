@@ -0,0 +1,492 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pobruno/casa360/config"
+)
+
+// Eventos do domínio publicados pela caixa de saída (gravados pelos triggers do banco)
+const (
+	EventFinanceOccurrencePaid   = "finance_occurrence.paid"
+	EventTaskOccurrenceCompleted = "task_occurrence.completed"
+	EventWalletChanged           = "wallet.changed"
+	EventMemberAdded             = "member.added"
+	EventMemberRemoved           = "member.removed"
+)
+
+// Situações de uma entrega de webhook
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	// MaxWebhookAttempts é o número de tentativas antes de a entrega ser marcada como failed
+	MaxWebhookAttempts = 8
+	// WebhookRetention é por quanto tempo os eventos já distribuídos e as suas entregas ficam no log
+	WebhookRetention = 30 * 24 * time.Hour
+
+	webhookBaseDelay = 30 * time.Second // espera após a primeira falha, dobrada a cada tentativa
+	webhookMaxDelay  = 6 * time.Hour
+	webhookTimeout   = 15 * time.Second
+	webhookBatch     = 100
+)
+
+var ErrWebhookRunning = errors.New("a entrega de webhooks já está em andamento")
+
+// ValidWebhookEvent informa se o evento pode ser assinado
+func ValidWebhookEvent(event string) bool {
+	switch event {
+	case EventFinanceOccurrencePaid, EventTaskOccurrenceCompleted, EventWalletChanged, EventMemberAdded, EventMemberRemoved:
+		return true
+	}
+	return false
+}
+
+// ValidDeliveryStatus informa se a situação de entrega é conhecida
+func ValidDeliveryStatus(status string) bool {
+	return status == DeliveryPending || status == DeliveryDelivered || status == DeliveryFailed
+}
+
+// WebhookSubscription é uma URL que recebe os eventos do domínio. Cada entrega leva o cabeçalho
+// X-Casa360-Signature: sha256=<HMAC-SHA256 de "<timestamp>.<corpo>" com o segredo>.
+type WebhookSubscription struct {
+	ID          uuid.UUID `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret"`
+	Events      []string  `json:"events"` // vazio = todos os eventos
+	Description *string   `json:"description,omitempty"`
+	Enabled     bool      `json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const webhookSubscriptionColumns = `id, url, secret, events, description, enabled, created_at`
+
+func (s *WebhookSubscription) scan(row interface{ Scan(...interface{}) error }) error {
+	s.Events = nil
+	if err := row.Scan(&s.ID, &s.URL, &s.Secret, pq.Array(&s.Events), &s.Description, &s.Enabled, &s.CreatedAt); err != nil {
+		return err
+	}
+	if s.Events == nil {
+		s.Events = []string{}
+	}
+	return nil
+}
+
+// Create grava a assinatura; sem segredo informado, gera um aleatório
+func (s *WebhookSubscription) Create() error {
+	if s.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		s.Secret = hex.EncodeToString(secret)
+	}
+	if s.Events == nil {
+		s.Events = []string{}
+	}
+
+	query := `
+		INSERT INTO webhook_subscriptions (url, secret, events, description, enabled)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + webhookSubscriptionColumns
+	return s.scan(config.GetDB().QueryRow(query, s.URL, s.Secret, pq.Array(s.Events), s.Description, s.Enabled))
+}
+
+// Get busca uma assinatura pelo ID
+func (s *WebhookSubscription) Get() error {
+	return s.scan(config.GetDB().QueryRow(`SELECT `+webhookSubscriptionColumns+` FROM webhook_subscriptions WHERE id = $1`, s.ID))
+}
+
+// Update altera a assinatura; as entregas já criadas seguem para a URL nova
+func (s *WebhookSubscription) Update() error {
+	if s.Events == nil {
+		s.Events = []string{}
+	}
+	query := `
+		UPDATE webhook_subscriptions
+		SET url = $1, secret = $2, events = $3, description = $4, enabled = $5
+		WHERE id = $6
+		RETURNING ` + webhookSubscriptionColumns
+	return s.scan(config.GetDB().QueryRow(query, s.URL, s.Secret, pq.Array(s.Events), s.Description, s.Enabled, s.ID))
+}
+
+// Delete remove a assinatura e o seu log de entregas
+func (s *WebhookSubscription) Delete() error {
+	res, err := config.GetDB().Exec(`DELETE FROM webhook_subscriptions WHERE id = $1`, s.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// ListWebhookSubscriptions lista as assinaturas
+func ListWebhookSubscriptions() ([]WebhookSubscription, error) {
+	rows, err := config.GetDB().Query(`SELECT ` + webhookSubscriptionColumns + ` FROM webhook_subscriptions ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []WebhookSubscription{}
+	for rows.Next() {
+		var s WebhookSubscription
+		if err := s.scan(rows); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// WebhookDelivery é a entrega de um evento a uma assinatura, com o resultado da última tentativa
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id"`
+	EventID        uuid.UUID       `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"` // apenas nas pendentes
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	Error          *string         `json:"error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	EventCreatedAt time.Time       `json:"event_created_at"`
+}
+
+const webhookDeliveryColumns = `d.id, d.subscription_id, d.event_id, e.event, e.payload, d.status, d.attempts,
+	CASE WHEN d.status = 'pending' THEN d.next_attempt_at END, d.response_status, d.error, d.created_at, d.delivered_at, e.created_at`
+
+func (d *WebhookDelivery) scan(row interface{ Scan(...interface{}) error }) error {
+	var payload []byte
+	err := row.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.Event, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.ResponseStatus, &d.Error, &d.CreatedAt, &d.DeliveredAt, &d.EventCreatedAt)
+	d.Payload = json.RawMessage(payload)
+	return err
+}
+
+// Get busca uma entrega pelo ID
+func (d *WebhookDelivery) Get() error {
+	return d.scan(config.GetDB().QueryRow(`
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries d
+		JOIN outbox_events e ON e.id = d.event_id
+		WHERE d.id = $1`, d.ID))
+}
+
+// Retry coloca a entrega de volta na fila para uma nova tentativa imediata
+func (d *WebhookDelivery) Retry() error {
+	res, err := config.GetDB().Exec(`
+		UPDATE webhook_deliveries
+		SET status = 'pending', next_attempt_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, d.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return d.Get()
+}
+
+// ListWebhookDeliveries lista o log de entregas de uma assinatura, das mais recentes para as mais antigas,
+// opcionalmente por situação e período (data de criação da entrega)
+func ListWebhookDeliveries(subscriptionID uuid.UUID, status string, filter ListFilter) ([]WebhookDelivery, error) {
+	var where whereClause
+	where.add("d.subscription_id = ?", subscriptionID)
+	if status != "" {
+		where.add("d.status = ?", status)
+	}
+	where.addTimestampRange("d.created_at", filter)
+
+	rows, err := config.GetDB().Query(`
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries d
+		JOIN outbox_events e ON e.id = d.event_id
+		`+where.String()+`
+		ORDER BY e.seq DESC`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := d.scan(rows); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// WebhookResult resume uma rodada de entrega
+type WebhookResult struct {
+	Events    int `json:"events"`    // eventos distribuídos às assinaturas
+	Delivered int `json:"delivered"` // entregas concluídas
+	Retrying  int `json:"retrying"`  // entregas que falharam e serão repetidas
+	Failed    int `json:"failed"`    // entregas que esgotaram as tentativas
+}
+
+var webhookMu sync.Mutex
+
+// DispatchWebhooks distribui os eventos novos da caixa de saída às assinaturas ativas e tenta as entregas
+// vencidas. A entrega é pelo menos uma vez: o destino deve ignorar eventos repetidos pelo X-Casa360-Event-ID.
+func DispatchWebhooks(ctx context.Context, client *http.Client) (*WebhookResult, error) {
+	if !webhookMu.TryLock() {
+		return nil, ErrWebhookRunning
+	}
+	defer webhookMu.Unlock()
+
+	if client == nil {
+		client = &http.Client{Timeout: webhookTimeout}
+	}
+	db := config.GetDB()
+	result := &WebhookResult{}
+
+	events, err := fanOutEvents(db)
+	if err != nil {
+		return nil, err
+	}
+	result.Events = events
+
+	for {
+		deliveries, err := claimDeliveries(db)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range deliveries {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			status, sendErr := d.send(ctx, client)
+			outcome, err := d.record(db, status, sendErr)
+			if err != nil {
+				return nil, err
+			}
+			switch outcome {
+			case DeliveryDelivered:
+				result.Delivered++
+			case DeliveryFailed:
+				result.Failed++
+			default:
+				result.Retrying++
+			}
+		}
+		if len(deliveries) < webhookBatch {
+			break
+		}
+	}
+
+	// Limpa o log antigo; as entregas saem junto com os eventos
+	if _, err := db.Exec(`
+		DELETE FROM outbox_events e
+		WHERE e.processed_at < $1
+			AND NOT EXISTS (SELECT 1 FROM webhook_deliveries d WHERE d.event_id = e.id AND d.status = 'pending')`,
+		time.Now().Add(-WebhookRetention)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// fanOutEvents cria as entregas dos eventos ainda não distribuídos, na ordem em que foram gravados.
+// Eventos sem assinatura interessada são apenas marcados como processados.
+func fanOutEvents(db queryer) (int, error) {
+	total := 0
+	for {
+		res, err := db.Exec(`
+			WITH events AS (
+				SELECT id, event
+				FROM outbox_events
+				WHERE processed_at IS NULL
+				ORDER BY seq
+				LIMIT 500
+				FOR UPDATE SKIP LOCKED
+			), deliveries AS (
+				INSERT INTO webhook_deliveries (subscription_id, event_id)
+				SELECT s.id, e.id
+				FROM events e
+				JOIN webhook_subscriptions s ON s.enabled AND (cardinality(s.events) = 0 OR e.event = ANY(s.events))
+				ON CONFLICT (subscription_id, event_id) DO NOTHING
+			)
+			UPDATE outbox_events SET processed_at = CURRENT_TIMESTAMP
+			WHERE id IN (SELECT id FROM events)`)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += int(n)
+		if n < 500 {
+			return total, nil
+		}
+	}
+}
+
+// pendingDelivery é uma entrega reservada para envio
+type pendingDelivery struct {
+	id, eventID uuid.UUID
+	event       string
+	payload     json.RawMessage
+	createdAt   time.Time
+	url, secret string
+	attempts    int
+}
+
+// claimDeliveries reserva as entregas vencidas empurrando a próxima tentativa para depois do tempo limite do
+// envio, de forma que outra rodada (ou outra instância) não as envie ao mesmo tempo
+func claimDeliveries(db queryer) ([]pendingDelivery, error) {
+	rows, err := db.Query(`
+		WITH due AS (
+			SELECT d.id
+			FROM webhook_deliveries d
+			JOIN webhook_subscriptions s ON s.id = d.subscription_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= CURRENT_TIMESTAMP AND s.enabled
+			ORDER BY d.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = CURRENT_TIMESTAMP + $2 * interval '1 second'
+		FROM due, outbox_events e, webhook_subscriptions s
+		WHERE d.id = due.id AND e.id = d.event_id AND s.id = d.subscription_id
+		RETURNING d.id, e.id, e.event, e.payload, e.created_at, s.url, s.secret, d.attempts`,
+		webhookBatch, int(2*webhookTimeout/time.Second))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []pendingDelivery
+	for rows.Next() {
+		var d pendingDelivery
+		var payload []byte
+		if err := rows.Scan(&d.id, &d.eventID, &d.event, &payload, &d.createdAt, &d.url, &d.secret, &d.attempts); err != nil {
+			return nil, err
+		}
+		d.payload = json.RawMessage(payload)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// WebhookSignature calcula a assinatura enviada em X-Casa360-Signature
+func WebhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send faz o POST do evento; qualquer resposta fora da faixa 2xx é falha
+func (d pendingDelivery) send(ctx context.Context, client *http.Client) (int, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":         d.eventID,
+		"event":      d.event,
+		"created_at": d.createdAt,
+		"data":       d.payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "casa360-webhooks")
+	req.Header.Set("X-Casa360-Event", d.event)
+	req.Header.Set("X-Casa360-Event-ID", d.eventID.String())
+	req.Header.Set("X-Casa360-Delivery", d.id.String())
+	req.Header.Set("X-Casa360-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Casa360-Signature", WebhookSignature(d.secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if detail = bytes.TrimSpace(detail); len(detail) == 0 {
+			return resp.StatusCode, fmt.Errorf("resposta %d", resp.StatusCode)
+		}
+		return resp.StatusCode, fmt.Errorf("resposta %d: %s", resp.StatusCode, detail)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookBackoff é a espera antes da próxima tentativa: dobra a cada falha, até webhookMaxDelay
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookBaseDelay
+	for i := 1; i < attempts && delay < webhookMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxDelay {
+		delay = webhookMaxDelay
+	}
+	return delay
+}
+
+// record grava o resultado da tentativa e retorna a nova situação da entrega
+func (d pendingDelivery) record(db queryer, responseStatus int, sendErr error) (string, error) {
+	attempts := d.attempts + 1
+	var code *int
+	if responseStatus != 0 {
+		code = &responseStatus
+	}
+
+	if sendErr == nil {
+		_, err := db.Exec(`
+			UPDATE webhook_deliveries
+			SET status = 'delivered', attempts = $1, response_status = $2, error = NULL, delivered_at = CURRENT_TIMESTAMP
+			WHERE id = $3`, attempts, code, d.id)
+		return DeliveryDelivered, err
+	}
+
+	status := DeliveryPending
+	if attempts >= MaxWebhookAttempts {
+		status = DeliveryFailed
+	}
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, response_status = $3, error = $4, next_attempt_at = $5
+		WHERE id = $6`, status, attempts, code, sendErr.Error(), time.Now().Add(webhookBackoff(attempts)), d.id)
+	return status, err
+}
\ No newline at end of file
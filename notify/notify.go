@@ -0,0 +1,95 @@
+// Package notify envia as notificações da casa pelos canais configurados: e-mail (SMTP), webhook
+// genérico e bot do Telegram. Cada canal só conhece o transporte; quem decide o que enviar e para
+// quem são as regras de notificação em models.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Canais de notificação
+const (
+	ChannelEmail    = "email"
+	ChannelWebhook  = "webhook"
+	ChannelTelegram = "telegram"
+)
+
+// Message é o conteúdo de uma notificação. Data acompanha o corpo nos canais estruturados (webhook).
+type Message struct {
+	Subject string                 `json:"subject"`
+	Body    string                 `json:"body"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// Notifier entrega mensagens por um canal. O endereço depende do canal: e-mail, URL do webhook
+// ou chat_id do Telegram.
+type Notifier interface {
+	Channel() string
+	Send(ctx context.Context, address string, msg Message) error
+}
+
+// Notifiers são os canais disponíveis, pelo nome
+type Notifiers map[string]Notifier
+
+// Send entrega a mensagem pelo canal indicado, com o tempo limite de cada envio
+func (n Notifiers) Send(ctx context.Context, channel, address string, msg Message) error {
+	notifier, ok := n[channel]
+	if !ok {
+		return fmt.Errorf("canal %s não configurado", channel)
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	return notifier.Send(ctx, address, msg)
+}
+
+const sendTimeout = 15 * time.Second
+
+var (
+	defaultNotifiers Notifiers
+	defaultOnce      sync.Once
+)
+
+// Default retorna os canais configurados no ambiente, montados na primeira chamada
+func Default() Notifiers {
+	defaultOnce.Do(func() {
+		defaultNotifiers = FromEnv()
+	})
+	return defaultNotifiers
+}
+
+// FromEnv monta os canais configurados nas variáveis de ambiente. O webhook está sempre disponível;
+// o e-mail exige SMTP_HOST e o Telegram, TELEGRAM_BOT_TOKEN. TELEGRAM_API_URL permite apontar o bot
+// para outro servidor compatível (ex.: um stub local).
+func FromEnv() Notifiers {
+	client := &http.Client{Timeout: sendTimeout}
+	notifiers := Notifiers{ChannelWebhook: &Webhook{Client: client}}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			port = 587
+		}
+		notifiers[ChannelEmail] = &SMTP{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+	}
+
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		notifiers[ChannelTelegram] = &Telegram{
+			BaseURL: os.Getenv("TELEGRAM_API_URL"),
+			Token:   token,
+			Client:  client,
+		}
+	}
+	return notifiers
+}
\ No newline at end of file
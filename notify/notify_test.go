@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// request é uma requisição recebida pelo servidor stub
+type request struct {
+	method, path, contentType string
+	body                      map[string]interface{}
+}
+
+// newStub sobe um servidor que registra as requisições e responde com o status e o corpo informados
+func newStub(t *testing.T, status int, response string) (*httptest.Server, *[]request) {
+	var received []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		req := request{method: r.Method, path: r.URL.Path, contentType: r.Header.Get("Content-Type")}
+		if err := json.Unmarshal(data, &req.body); err != nil {
+			t.Errorf("corpo não é JSON: %q", data)
+		}
+		received = append(received, req)
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return server, &received
+}
+
+func TestWebhookSend(t *testing.T) {
+	server, received := newStub(t, http.StatusNoContent, "")
+	webhook := &Webhook{Client: server.Client()}
+	msg := Message{Subject: "Conta de luz", Body: "Vence amanhã", Data: map[string]interface{}{"amount": 150.5}}
+
+	if err := webhook.Send(context.Background(), server.URL+"/hooks/casa", msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(*received) != 1 {
+		t.Fatalf("%d requisições, esperada 1", len(*received))
+	}
+	req := (*received)[0]
+	if req.method != http.MethodPost || req.path != "/hooks/casa" || req.contentType != "application/json" {
+		t.Fatalf("requisição = %s %s (%s)", req.method, req.path, req.contentType)
+	}
+	if req.body["subject"] != "Conta de luz" || req.body["body"] != "Vence amanhã" {
+		t.Fatalf("corpo = %v", req.body)
+	}
+	if data, _ := req.body["data"].(map[string]interface{}); data["amount"] != 150.5 {
+		t.Fatalf("data = %v", req.body["data"])
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	server, received := newStub(t, http.StatusBadGateway, "  fora do ar\n")
+	webhook := &Webhook{Client: server.Client()}
+
+	err := webhook.Send(context.Background(), server.URL, Message{Body: "x"})
+	if err == nil || err.Error() != "resposta 502: fora do ar" {
+		t.Fatalf("Send = %v, esperado erro com o status e o corpo da resposta", err)
+	}
+
+	for _, address := range []string{"", "ftp://example.com/hook", "http://", "não é url"} {
+		if err := webhook.Send(context.Background(), address, Message{Body: "x"}); err == nil {
+			t.Errorf("Send(%q) deveria recusar a URL", address)
+		}
+	}
+	if len(*received) != 1 {
+		t.Fatalf("%d requisições, esperada só a primeira", len(*received))
+	}
+}
+
+func TestTelegramSend(t *testing.T) {
+	server, received := newStub(t, http.StatusOK, `{"ok":true}`)
+	telegram := &Telegram{BaseURL: server.URL + "/", Token: "123:abc", Client: server.Client()}
+
+	if err := telegram.Send(context.Background(), "42", Message{Subject: "Lixo", Body: "Hoje é a sua vez"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	req := (*received)[0]
+	if req.path != "/bot123:abc/sendMessage" {
+		t.Fatalf("caminho = %s", req.path)
+	}
+	if req.body["chat_id"] != "42" || req.body["text"] != "Lixo\n\nHoje é a sua vez" || req.body["disable_web_page_preview"] != true {
+		t.Fatalf("corpo = %v", req.body)
+	}
+
+	if err := telegram.Send(context.Background(), " ", Message{Body: "x"}); err == nil {
+		t.Fatal("Send deveria exigir o chat_id")
+	}
+}
+
+func TestTelegramHidesToken(t *testing.T) {
+	server, _ := newStub(t, http.StatusOK, "")
+	server.Close()
+	telegram := &Telegram{BaseURL: server.URL, Token: "123:segredo", Client: server.Client()}
+
+	err := telegram.Send(context.Background(), "42", Message{Body: "x"})
+	if err == nil || strings.Contains(err.Error(), "segredo") {
+		t.Fatalf("Send = %v, esperado erro de conexão sem o token", err)
+	}
+}
+
+func TestNotifiersSend(t *testing.T) {
+	server, received := newStub(t, http.StatusOK, "")
+	notifiers := Notifiers{ChannelWebhook: &Webhook{Client: server.Client()}}
+
+	if err := notifiers.Send(context.Background(), ChannelWebhook, server.URL, Message{Body: "x"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := notifiers.Send(context.Background(), ChannelTelegram, "42", Message{Body: "x"}); err == nil {
+		t.Fatal("Send deveria recusar um canal não configurado")
+	}
+	if len(*received) != 1 {
+		t.Fatalf("%d requisições, esperada 1", len(*received))
+	}
+}
\ No newline at end of file
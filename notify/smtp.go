@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP envia notificações por e-mail. Sem usuário, envia sem autenticação (ex.: relay local);
+// com usuário, autentica com PLAIN, que o net/smtp só permite com TLS ou em localhost.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTP) Channel() string {
+	return ChannelEmail
+}
+
+func (s *SMTP) Send(ctx context.Context, address string, msg Message) error {
+	if strings.ContainsAny(address, "\r\n") || !strings.Contains(address, "@") {
+		return fmt.Errorf("e-mail inválido: %q", address)
+	}
+	from := s.From
+	if from == "" {
+		from = s.Username
+	}
+	if from == "" {
+		return errors.New("SMTP_FROM não configurado")
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	body := strings.ReplaceAll(msg.Body, "\n", "\r\n")
+	data := "From: " + from + "\r\n" +
+		"To: " + address + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" + body + "\r\n"
+
+	// smtp.SendMail não aceita contexto; o envio roda à parte e o contexto limita a espera
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(s.Host, strconv.Itoa(s.Port)), auth, from, []string{address}, []byte(data))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
\ No newline at end of file
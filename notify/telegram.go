@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultTelegramAPI é o servidor da Bot API do Telegram
+const defaultTelegramAPI = "https://api.telegram.org"
+
+// Telegram envia a mensagem pelo método sendMessage da Bot API. O endereço é o chat_id do morador,
+// obtido quando o morador inicia a conversa com o bot.
+type Telegram struct {
+	BaseURL string // padrão: https://api.telegram.org
+	Token   string
+	Client  *http.Client
+}
+
+func (t *Telegram) Channel() string {
+	return ChannelTelegram
+}
+
+func (t *Telegram) Send(ctx context.Context, address string, msg Message) error {
+	if strings.TrimSpace(address) == "" {
+		return errors.New("chat_id do Telegram não informado")
+	}
+	baseURL := strings.TrimRight(t.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultTelegramAPI
+	}
+
+	text := msg.Body
+	if msg.Subject != "" {
+		text = msg.Subject + "\n\n" + msg.Body
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"chat_id":                  address,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+	if err := postJSON(ctx, t.Client, baseURL+"/bot"+t.Token+"/sendMessage", payload); err != nil {
+		// Erros de conexão trazem a URL, que contém o token do bot
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	return nil
+}
\ No newline at end of file
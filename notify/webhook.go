@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Webhook envia a mensagem em JSON ({"subject", "body", "data"}) por POST para a URL do morador
+type Webhook struct {
+	Client *http.Client
+}
+
+func (w *Webhook) Channel() string {
+	return ChannelWebhook
+}
+
+func (w *Webhook) Send(ctx context.Context, address string, msg Message) error {
+	if u, err := url.Parse(address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("URL de webhook inválida: %q", address)
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, w.Client, address, payload)
+}
+
+// postJSON faz o POST e trata como erro qualquer resposta fora da faixa 2xx
+func postJSON(ctx context.Context, client *http.Client, address string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "casa360-notify")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("resposta %d: %s", resp.StatusCode, bytes.TrimSpace(detail))
+	}
+	return nil
+}
\ No newline at end of file
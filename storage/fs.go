@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FS guarda os arquivos em um diretório local, um arquivo por chave
+type FS struct {
+	Root string
+}
+
+// path resolve a chave dentro do diretório, recusando chaves que saiam dele
+func (f *FS) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return "", fmt.Errorf("chave de armazenamento inválida: %q", key)
+	}
+	return filepath.Join(f.Root, filepath.FromSlash(key)), nil
+}
+
+// Put grava o arquivo em um temporário no mesmo diretório e o renomeia ao final, para que uma gravação
+// interrompida não deixe um arquivo pela metade na chave
+func (f *FS) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	name, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("tamanho gravado (%d bytes) diferente do esperado (%d bytes)", written, size)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (f *FS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (f *FS) Delete(ctx context.Context, key string) error {
+	name, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
\ No newline at end of file
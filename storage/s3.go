@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+	// unsignedPayload dispensa o hash do corpo na assinatura, para o upload não precisar ler o arquivo duas vezes
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	emptyPayload    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" // SHA-256 de ""
+	amzDateFormat   = "20060102T150405Z"
+	maxLinkExpires  = 7 * 24 * time.Hour // limite do S3 para URLs pré-assinadas
+)
+
+// S3 guarda os arquivos em um bucket compatível com S3, com as requisições assinadas pelo AWS
+// Signature Version 4. Funciona com a AWS e com servidores compatíveis, como o MinIO.
+type S3 struct {
+	Endpoint  string // padrão: https://s3.<região>.amazonaws.com
+	Region    string // padrão: us-east-1
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool // bucket no caminho (endpoint/bucket/chave) em vez do subdomínio (bucket.endpoint/chave)
+	Client    *http.Client
+}
+
+func (s *S3) region() string {
+	if s.Region == "" {
+		return defaultS3Region
+	}
+	return s.Region
+}
+
+func (s *S3) client() *http.Client {
+	if s.Client == nil {
+		return http.DefaultClient
+	}
+	return s.Client
+}
+
+// objectURL monta a URL do objeto, com a chave codificada como o S3 espera na assinatura
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	endpoint := strings.TrimRight(s.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://s3." + s.region() + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT inválido: %s", s.Endpoint)
+	}
+
+	prefix := u.Path
+	if s.PathStyle {
+		prefix += "/" + s.Bucket
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path = prefix + "/" + key
+	u.RawPath = uriEncode(prefix, false) + "/" + uriEncode(key, false)
+	return u, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, unsignedPayload)
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, emptyPayload)
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, emptyPayload)
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// O S3 responde 204 mesmo quando a chave não existe; outros servidores podem responder 404
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// Link gera uma URL pré-assinada para baixar o arquivo por expires, com o nome e o tipo informados
+// nos cabeçalhos da resposta (Content-Disposition conforme Disposition)
+func (s *S3) Link(key, filename, contentType string, expires time.Duration) (string, error) {
+	return s.presign(time.Now().UTC(), key, filename, contentType, expires)
+}
+
+func (s *S3) presign(now time.Time, key, filename, contentType string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > maxLinkExpires {
+		return "", fmt.Errorf("validade do link deve estar entre 1 segundo e %s", maxLinkExpires)
+	}
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if filename != "" {
+		query.Set("response-content-disposition", Disposition(filename, contentType))
+	}
+	if contentType != "" {
+		query.Set("response-content-type", contentType)
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	header := http.Header{}
+	header.Set("Host", u.Host)
+	signature := s.signature(now, http.MethodGet, u, header, unsignedPayload)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// sign assina a requisição com os cabeçalhos Authorization, X-Amz-Date e X-Amz-Content-Sha256
+func (s *S3) sign(req *http.Request, payloadHash string) {
+	now := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	header := req.Header.Clone()
+	header.Set("Host", req.URL.Host)
+	signature := s.signature(now, req.Method, req.URL, header, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, s.scope(now), signedHeaders(header), signature))
+}
+
+func (s *S3) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region() + "/s3/aws4_request"
+}
+
+// signature calcula a assinatura SigV4 da requisição. Entram na assinatura o Host e os cabeçalhos
+// X-Amz-* e Content-Type presentes em header.
+func (s *S3) signature(now time.Time, method string, u *url.URL, header http.Header, payloadHash string) string {
+	canonical := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders(header),
+		signedHeaders(header),
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format(amzDateFormat) + "\n" + s.scope(now) + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region())
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signedNames são os cabeçalhos assinados, em minúsculas e em ordem
+func signedNames(header http.Header) []string {
+	var names []string
+	for name := range header {
+		lower := strings.ToLower(name)
+		if lower == "host" || lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func signedHeaders(header http.Header) string {
+	return strings.Join(signedNames(header), ";")
+}
+
+func canonicalHeaders(header http.Header) string {
+	var b strings.Builder
+	for _, name := range signedNames(header) {
+		b.WriteString(name + ":" + strings.TrimSpace(header.Get(name)) + "\n")
+	}
+	return b.String()
+}
+
+// canonicalQuery ordena e codifica os parâmetros como exige a assinatura
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode codifica tudo que não for letra, dígito ou -_.~ (a "/" só quando encodeSlash)
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// responseError lê o código de erro da resposta XML do S3
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	code := between(string(body), "<Code>", "</Code>")
+	if code == "" {
+		return fmt.Errorf("armazenamento S3 respondeu %s", resp.Status)
+	}
+	return errors.New("armazenamento S3 respondeu " + resp.Status + ": " + code)
+}
+
+func between(s, start, end string) string {
+	i := strings.Index(s, start)
+	if i < 0 {
+		return ""
+	}
+	s = s[i+len(start):]
+	if j := strings.Index(s, end); j >= 0 {
+		return s[:j]
+	}
+	return ""
+}
\ No newline at end of file
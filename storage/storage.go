@@ -0,0 +1,112 @@
+// Package storage guarda os arquivos anexados (comprovantes, notas fiscais e fotos) fora do banco: no
+// sistema de arquivos local ou em um bucket compatível com S3 (AWS, MinIO). O banco guarda só a chave
+// de cada arquivo e os seus metadados.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Drivers de armazenamento
+const (
+	DriverFS = "fs"
+	DriverS3 = "s3"
+)
+
+const (
+	defaultPath    = "data/attachments"
+	defaultMaxSize = 10 // MB
+	requestTimeout = 5 * time.Minute
+)
+
+// ErrNotFound é retornado quando a chave não existe no armazenamento
+var ErrNotFound = errors.New("arquivo não encontrado no armazenamento")
+
+// BlobStore guarda e lê arquivos pela chave. As chaves usam "/" como separador, em qualquer driver.
+type BlobStore interface {
+	// Put grava o conteúdo de body na chave; size é o tamanho em bytes, ou -1 se não for conhecido
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete remove a chave; uma chave que não existe não é erro
+	Delete(ctx context.Context, key string) error
+}
+
+// Linker é implementado pelos armazenamentos que geram links de download temporários, para que o
+// cliente baixe o arquivo direto deles, sem passar pela API
+type Linker interface {
+	Link(key, filename, contentType string, expires time.Duration) (string, error)
+}
+
+var (
+	defaultStore BlobStore
+	defaultErr   error
+	defaultOnce  sync.Once
+)
+
+// Default retorna o armazenamento configurado no ambiente, montado na primeira chamada
+func Default() (BlobStore, error) {
+	defaultOnce.Do(func() {
+		defaultStore, defaultErr = FromEnv()
+	})
+	return defaultStore, defaultErr
+}
+
+// FromEnv monta o armazenamento escolhido em STORAGE_DRIVER: fs (padrão), em STORAGE_PATH, ou s3, no
+// bucket S3_BUCKET de S3_ENDPOINT (padrão: AWS na região S3_REGION). S3_PATH_STYLE=true põe o bucket
+// no caminho da URL, como exigem o MinIO e a maioria dos servidores compatíveis.
+func FromEnv() (BlobStore, error) {
+	switch driver := os.Getenv("STORAGE_DRIVER"); driver {
+	case "", DriverFS:
+		root := os.Getenv("STORAGE_PATH")
+		if root == "" {
+			root = defaultPath
+		}
+		return &FS{Root: root}, nil
+	case DriverS3:
+		s3 := &S3{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Client:    &http.Client{Timeout: requestTimeout},
+		}
+		s3.PathStyle, _ = strconv.ParseBool(os.Getenv("S3_PATH_STYLE"))
+		if s3.Bucket == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+			return nil, errors.New("STORAGE_DRIVER=s3 exige S3_BUCKET, S3_ACCESS_KEY_ID e S3_SECRET_ACCESS_KEY")
+		}
+		return s3, nil
+	default:
+		return nil, fmt.Errorf("STORAGE_DRIVER inválido: %s (use fs ou s3)", driver)
+	}
+}
+
+// Disposition monta o Content-Disposition do download. Só imagens e PDF são exibidos no navegador
+// (inline); os demais tipos, como o XML das notas fiscais, que pode conter XHTML com scripts, são
+// sempre baixados.
+func Disposition(filename, contentType string) string {
+	disposition := "attachment"
+	if (strings.HasPrefix(contentType, "image/") && contentType != "image/svg+xml") || contentType == "application/pdf" {
+		disposition = "inline"
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": filename})
+}
+
+// MaxSize é o tamanho máximo de um anexo em bytes, definido em MB por ATTACHMENT_MAX_SIZE_MB (padrão 10)
+func MaxSize() int64 {
+	mb, err := strconv.ParseInt(os.Getenv("ATTACHMENT_MAX_SIZE_MB"), 10, 64)
+	if err != nil || mb <= 0 {
+		mb = defaultMaxSize
+	}
+	return mb << 20
+}
\ No newline at end of file